@@ -0,0 +1,11 @@
+package dto
+
+import "github.com/fekalegi/multi-tenant-system/internal/domain"
+
+type ListDeadLettersResponse struct {
+	Data []*domain.DeadLetter `json:"data"`
+}
+
+type ReplayDeadLettersResponse struct {
+	Replayed int `json:"replayed" example:"12"`
+}
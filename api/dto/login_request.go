@@ -3,4 +3,8 @@ package dto
 type LoginRequest struct {
 	UserID   string `json:"user_id"`
 	TenantID string `json:"tenant_id"`
+	// PlatformAdmin grants access to every tenant; tenant_id may then be omitted
+	PlatformAdmin bool `json:"platform_admin"`
+	// AdminSecret must match the configured admin secret for a platform admin token
+	AdminSecret string `json:"admin_secret,omitempty"`
 }
@@ -1,10 +1,62 @@
 package dto
 
 import (
+	"time"
+
 	"github.com/fekalegi/multi-tenant-system/internal/domain"
 )
 
 type GetMessagesResponse struct {
 	Data       []*domain.Message `json:"data"`
-	NextCursor string            `json:"next_cursor,omitempty" example:"eyJpZCI6ImYx...YjAifQ=="`
+	NextCursor string            `json:"next_cursor,omitempty" example:"eyJ0IjoiMjAyNC0wMS0wMVQwMDowMDowMFoiLCJpIjoi...In0.Qm9Yx..."`
+	PrevCursor string            `json:"prev_cursor,omitempty" example:"eyJ0IjoiMjAyNC0wMS0wMVQwMDowMDowMFoiLCJpIjoi...IiwiYiI6dHJ1ZX0.c2lnb..."`
+}
+
+type PublishMessageResponse struct {
+	Message   string     `json:"message" example:"message sent successfully"`
+	ID        string     `json:"id" example:"7c9e6679-7425-40de-944b-e07fc1f90ae7"`
+	DeliverAt *time.Time `json:"deliver_at,omitempty" example:"2024-01-01T12:00:00Z"`
+}
+
+type ListScheduledMessagesResponse struct {
+	Data   []*domain.ScheduledMessage `json:"data"`
+	Total  int                        `json:"total" example:"1"`
+	Limit  int                        `json:"limit" example:"20"`
+	Offset int                        `json:"offset" example:"0"`
+}
+
+// Batch item statuses. Rejected items were not valid and never stored.
+const (
+	BatchItemAccepted = "accepted"
+	BatchItemRejected = "rejected"
+)
+
+type BatchItemResult struct {
+	Index      int                      `json:"index" example:"0"`
+	ID         string                   `json:"id,omitempty" example:"7c9e6679-7425-40de-944b-e07fc1f90ae7"`
+	Status     string                   `json:"status" example:"accepted" enums:"accepted,rejected"`
+	Error      string                   `json:"error,omitempty"`
+	Violations []domain.SchemaViolation `json:"violations,omitempty"`
+}
+
+type PublishBatchResponse struct {
+	Accepted int               `json:"accepted" example:"2"`
+	Rejected int               `json:"rejected" example:"0"`
+	Results  []BatchItemResult `json:"results"`
+}
+
+// SchemaValidationErrorResponse lists why a payload does not match the tenant's schema.
+type SchemaValidationErrorResponse struct {
+	Error         string                   `json:"error" example:"payload does not match schema version 2"`
+	SchemaVersion int                      `json:"schema_version" example:"2"`
+	Violations    []domain.SchemaViolation `json:"violations"`
+}
+
+type ListSchemasResponse struct {
+	Data []*domain.MessageSchema `json:"data"`
+}
+
+// ListWebhookDeliveriesResponse lists the webhook delivery attempts of a message.
+type ListWebhookDeliveriesResponse struct {
+	Data []*domain.WebhookDelivery `json:"data"`
 }
@@ -1,5 +1,22 @@
 package dto
 
+import "github.com/fekalegi/multi-tenant-system/internal/domain"
+
+// RetentionPolicy limits how long processed and failed messages are kept. Empty
+// values keep them forever.
+type RetentionPolicy struct {
+	MaxAge  string `json:"max_age,omitempty" example:"720h"`
+	MaxRows int    `json:"max_rows,omitempty" example:"1000000"`
+}
+
 type CreateTenantRequest struct {
-	Name string `json:"name"`
+	Name       string                   `json:"name"`
+	Workers    int                      `json:"workers,omitempty" example:"3"`
+	Prefetch   int                      `json:"prefetch,omitempty" example:"10"`
+	Processors []domain.ProcessorConfig `json:"processors,omitempty"`
+}
+
+// ProcessorChain lists the processors a tenant's messages pass through, in order.
+type ProcessorChain struct {
+	Processors []domain.ProcessorConfig `json:"processors"`
 }
@@ -1,10 +1,56 @@
 package dto
 
+import (
+	"time"
+
+	"github.com/fekalegi/multi-tenant-system/internal/domain"
+	"github.com/fekalegi/multi-tenant-system/internal/processor"
+)
+
 type CreateTenantResponse struct {
 	ID   string `json:"id" example:"a1b2c3d4-e5f6-7890-1234-567890abcdef"`
 	Name string `json:"name" example:"My Awesome Tenant"`
 }
 
+type TenantResponse struct {
+	ID              string                   `json:"id" example:"a1b2c3d4-e5f6-7890-1234-567890abcdef"`
+	Name            string                   `json:"name" example:"My Awesome Tenant"`
+	Workers         int                      `json:"workers" example:"3"`
+	Prefetch        int                      `json:"prefetch" example:"10"`
+	Status          string                   `json:"status" example:"active"`
+	ConsumerRunning bool                     `json:"consumer_running" example:"true"`
+	ActiveWorkers   int                      `json:"active_workers" example:"3"`
+	QueueDepth      int                      `json:"queue_depth" example:"42"`
+	QueueConsumers  int                      `json:"queue_consumers" example:"1"`
+	Retention       RetentionPolicy          `json:"retention"`
+	Processors      []domain.ProcessorConfig `json:"processors"`
+	CreatedAt       time.Time                `json:"created_at"`
+	UpdatedAt       time.Time                `json:"updated_at"`
+}
+
+type ListTenantsResponse struct {
+	Data   []TenantResponse `json:"data"`
+	Total  int              `json:"total" example:"1"`
+	Limit  int              `json:"limit" example:"20"`
+	Offset int              `json:"offset" example:"0"`
+}
+
+type UpdateConcurrencyResponse struct {
+	Message  string `json:"message" example:"concurrency updated successfully"`
+	Workers  int    `json:"workers" example:"5"`
+	Prefetch int    `json:"prefetch" example:"10"`
+}
+
+// RetentionResponse is a tenant's retention policy with the outcome of the retention
+// runs on the instance that answered.
+type RetentionResponse struct {
+	RetentionPolicy
+	LastRunAt    *time.Time `json:"last_run_at,omitempty"`
+	LastRemoved  int64      `json:"last_removed" example:"120"`
+	TotalRemoved int64      `json:"total_removed" example:"5400"`
+	LastError    string     `json:"last_error,omitempty"`
+}
+
 type MessageResponse struct {
 	Message string `json:"message" example:"operation successful"`
 }
@@ -12,3 +58,31 @@ type MessageResponse struct {
 type ErrorResponse struct {
 	Error string `json:"error" example:"resource not found"`
 }
+
+// NewTenantResponse flattens a tenant and its runtime state, without webhook secrets
+func NewTenantResponse(t *domain.TenantInfo) TenantResponse {
+	return TenantResponse{
+		ID:              t.ID.String(),
+		Name:            t.Name,
+		Workers:         t.Workers,
+		Prefetch:        t.Prefetch,
+		Status:          t.Status,
+		ConsumerRunning: t.Runtime.ConsumerRunning,
+		ActiveWorkers:   t.Runtime.ActiveWorkers,
+		QueueDepth:      t.Runtime.QueueDepth,
+		QueueConsumers:  t.Runtime.QueueConsumers,
+		Retention:       NewRetentionPolicy(t.Retention),
+		Processors:      processor.RedactSecrets(t.Processors),
+		CreatedAt:       t.CreatedAt,
+		UpdatedAt:       t.UpdatedAt,
+	}
+}
+
+// NewRetentionPolicy renders a retention policy, leaving out the limits it does not set
+func NewRetentionPolicy(p domain.RetentionPolicy) RetentionPolicy {
+	policy := RetentionPolicy{MaxRows: p.MaxRows}
+	if p.MaxAge > 0 {
+		policy.MaxAge = p.MaxAge.String()
+	}
+	return policy
+}
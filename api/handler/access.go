@@ -0,0 +1,31 @@
+package handler
+
+import (
+	"net/http"
+
+	"github.com/fekalegi/multi-tenant-system/api/dto"
+	"github.com/fekalegi/multi-tenant-system/internal/auth"
+	"github.com/labstack/echo/v4"
+)
+
+// callerClaims returns the claims JWTAuthMiddleware stored for the request.
+// Requests that bypassed the middleware get empty claims, which grant nothing.
+func callerClaims(c echo.Context) *auth.Claims {
+	if claims, ok := c.Get(auth.ContextClaimsKey).(*auth.Claims); ok {
+		return claims
+	}
+	return &auth.Claims{}
+}
+
+// canAccessTenant reports whether the caller may act on the tenant.
+func canAccessTenant(c echo.Context, tenantID string) bool {
+	return callerClaims(c).CanAccessTenant(tenantID)
+}
+
+func forbidden(c echo.Context) error {
+	return c.JSON(http.StatusForbidden, dto.ErrorResponse{Error: "access to tenant denied"})
+}
+
+func adminOnly(c echo.Context) error {
+	return c.JSON(http.StatusForbidden, dto.ErrorResponse{Error: "platform admin required"})
+}
@@ -0,0 +1,172 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/fekalegi/multi-tenant-system/api/dto"
+	"github.com/fekalegi/multi-tenant-system/internal/domain"
+	"github.com/fekalegi/multi-tenant-system/internal/tenant"
+	"github.com/labstack/echo/v4"
+)
+
+// DeadLetterHandler handles inspection and replay of dead-lettered messages
+type DeadLetterHandler struct {
+	manager *tenant.Manager
+}
+
+// NewDeadLetterHandler creates a new DeadLetterHandler instance
+func NewDeadLetterHandler(m *tenant.Manager) *DeadLetterHandler {
+	return &DeadLetterHandler{manager: m}
+}
+
+// RegisterDeadLetterRoutes registers dead-letter related HTTP routes
+func (h *DeadLetterHandler) RegisterDeadLetterRoutes(e *echo.Group) {
+	e.GET("/tenants/:id/dead-letters", h.ListDeadLetters)
+	e.POST("/tenants/:id/dead-letters/replay", h.ReplayDeadLetters)
+	e.GET("/tenants/:id/dead-letters/:message_id", h.GetDeadLetter)
+	e.DELETE("/tenants/:id/dead-letters/:message_id", h.DeleteDeadLetter)
+	e.POST("/tenants/:id/dead-letters/:message_id/replay", h.ReplayDeadLetter)
+}
+
+// ListDeadLetters godoc
+// @Summary List dead-lettered messages
+// @Description Lists the messages parked in a tenant's dead-letter queue, oldest first.
+// @Tags dead-letters
+// @Produce json
+// @Param id path string true "Tenant ID"
+// @Param limit query int false "Maximum number of messages (default 50, max 500)"
+// @Success 200 {object} dto.ListDeadLettersResponse
+// @Failure 400 {object} dto.ErrorResponse
+// @Failure 403 {object} dto.ErrorResponse
+// @Failure 404 {object} dto.ErrorResponse
+// @Failure 500 {object} dto.ErrorResponse
+// @Security BearerAuth
+// @Router /api/tenants/{id}/dead-letters [get]
+func (h *DeadLetterHandler) ListDeadLetters(c echo.Context) error {
+	if !canAccessTenant(c, c.Param("id")) {
+		return forbidden(c)
+	}
+
+	limit := 50
+	if limitStr := c.QueryParam("limit"); limitStr != "" {
+		var err error
+		limit, err = strconv.Atoi(limitStr)
+		if err != nil || limit <= 0 || limit > 500 {
+			return c.JSON(http.StatusBadRequest, dto.ErrorResponse{Error: "invalid 'limit' parameter: must be between 1 and 500"})
+		}
+	}
+
+	letters, err := h.manager.ListDeadLetters(c.Param("id"), limit)
+	if err != nil {
+		return c.JSON(deadLetterErrorStatus(err), dto.ErrorResponse{Error: err.Error()})
+	}
+
+	return c.JSON(http.StatusOK, dto.ListDeadLettersResponse{Data: letters})
+}
+
+// GetDeadLetter godoc
+// @Summary Inspect a dead-lettered message
+// @Description Returns a dead-lettered message with its payload, attempts and last error.
+// @Tags dead-letters
+// @Produce json
+// @Param id path string true "Tenant ID"
+// @Param message_id path string true "Message ID"
+// @Success 200 {object} domain.DeadLetter
+// @Failure 403 {object} dto.ErrorResponse
+// @Failure 404 {object} dto.ErrorResponse
+// @Failure 500 {object} dto.ErrorResponse
+// @Security BearerAuth
+// @Router /api/tenants/{id}/dead-letters/{message_id} [get]
+func (h *DeadLetterHandler) GetDeadLetter(c echo.Context) error {
+	if !canAccessTenant(c, c.Param("id")) {
+		return forbidden(c)
+	}
+
+	letter, err := h.manager.GetDeadLetter(c.Param("id"), c.Param("message_id"))
+	if err != nil {
+		return c.JSON(deadLetterErrorStatus(err), dto.ErrorResponse{Error: err.Error()})
+	}
+
+	return c.JSON(http.StatusOK, letter)
+}
+
+// DeleteDeadLetter godoc
+// @Summary Delete a dead-lettered message
+// @Description Permanently discards a message from a tenant's dead-letter queue.
+// @Tags dead-letters
+// @Param id path string true "Tenant ID"
+// @Param message_id path string true "Message ID"
+// @Success 204 "No Content"
+// @Failure 403 {object} dto.ErrorResponse
+// @Failure 404 {object} dto.ErrorResponse
+// @Failure 500 {object} dto.ErrorResponse
+// @Security BearerAuth
+// @Router /api/tenants/{id}/dead-letters/{message_id} [delete]
+func (h *DeadLetterHandler) DeleteDeadLetter(c echo.Context) error {
+	if !canAccessTenant(c, c.Param("id")) {
+		return forbidden(c)
+	}
+
+	if err := h.manager.DeleteDeadLetter(c.Param("id"), c.Param("message_id")); err != nil {
+		return c.JSON(deadLetterErrorStatus(err), dto.ErrorResponse{Error: err.Error()})
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+// ReplayDeadLetter godoc
+// @Summary Replay a dead-lettered message
+// @Description Moves a dead-lettered message back onto the tenant queue with a fresh attempt count.
+// @Tags dead-letters
+// @Produce json
+// @Param id path string true "Tenant ID"
+// @Param message_id path string true "Message ID"
+// @Success 200 {object} dto.MessageResponse
+// @Failure 403 {object} dto.ErrorResponse
+// @Failure 404 {object} dto.ErrorResponse
+// @Failure 500 {object} dto.ErrorResponse
+// @Security BearerAuth
+// @Router /api/tenants/{id}/dead-letters/{message_id}/replay [post]
+func (h *DeadLetterHandler) ReplayDeadLetter(c echo.Context) error {
+	if !canAccessTenant(c, c.Param("id")) {
+		return forbidden(c)
+	}
+
+	if err := h.manager.ReplayDeadLetter(c.Param("id"), c.Param("message_id")); err != nil {
+		return c.JSON(deadLetterErrorStatus(err), dto.ErrorResponse{Error: err.Error()})
+	}
+	return c.JSON(http.StatusOK, dto.MessageResponse{Message: "message replayed successfully"})
+}
+
+// ReplayDeadLetters godoc
+// @Summary Replay all dead-lettered messages
+// @Description Moves every message of a tenant's dead-letter queue back onto the tenant queue.
+// @Tags dead-letters
+// @Produce json
+// @Param id path string true "Tenant ID"
+// @Success 200 {object} dto.ReplayDeadLettersResponse
+// @Failure 403 {object} dto.ErrorResponse
+// @Failure 404 {object} dto.ErrorResponse
+// @Failure 500 {object} dto.ErrorResponse
+// @Security BearerAuth
+// @Router /api/tenants/{id}/dead-letters/replay [post]
+func (h *DeadLetterHandler) ReplayDeadLetters(c echo.Context) error {
+	if !canAccessTenant(c, c.Param("id")) {
+		return forbidden(c)
+	}
+
+	replayed, err := h.manager.ReplayDeadLetters(c.Param("id"))
+	if err != nil {
+		return c.JSON(deadLetterErrorStatus(err), dto.ErrorResponse{Error: err.Error()})
+	}
+	return c.JSON(http.StatusOK, dto.ReplayDeadLettersResponse{Replayed: replayed})
+}
+
+// deadLetterErrorStatus maps dead-letter errors to HTTP status codes
+func deadLetterErrorStatus(err error) int {
+	if errors.Is(err, domain.ErrDeadLetterNotFound) {
+		return http.StatusNotFound
+	}
+	return tenantErrorStatus(err)
+}
@@ -1,6 +1,7 @@
 package handler
 
 import (
+	"crypto/subtle"
 	"github.com/fekalegi/multi-tenant-system/api/dto"
 	"github.com/fekalegi/multi-tenant-system/internal/auth"
 	"github.com/labstack/echo/v4"
@@ -8,11 +9,14 @@ import (
 )
 
 type LoginHandler struct {
-	jwt *auth.JWTManager
+	jwt         *auth.JWTManager
+	adminSecret string
 }
 
-func NewLoginHandler(jwt *auth.JWTManager) *LoginHandler {
-	return &LoginHandler{jwt: jwt}
+// NewLoginHandler returns the mock login handler. Platform admin tokens are only issued
+// to requests presenting adminSecret, and never when it is empty.
+func NewLoginHandler(jwt *auth.JWTManager, adminSecret string) *LoginHandler {
+	return &LoginHandler{jwt: jwt, adminSecret: adminSecret}
 }
 
 func (h *LoginHandler) RegisterRoutes(e *echo.Group) {
@@ -21,24 +25,33 @@ func (h *LoginHandler) RegisterRoutes(e *echo.Group) {
 
 // Login godoc
 // @Summary Mock login
+// @Description Issues a token scoped to the given tenant. Platform admins may act on every tenant; an admin token requires the configured admin secret.
 // @Tags auth
 // @Accept json
 // @Produce json
 // @Param request body dto.LoginRequest true "Mock user"
 // @Success 200 {object} dto.LoginResponse
 // @Failure 400 {object} map[string]string
+// @Failure 403 {object} map[string]string
 // @Failure 500 {object} map[string]string
 // @Router /api/login [post]
 func (h *LoginHandler) Login(c echo.Context) error {
 	var req dto.LoginRequest
-	if err := c.Bind(&req); err != nil || req.UserID == "" || req.TenantID == "" {
+	if err := c.Bind(&req); err != nil || req.UserID == "" || (req.TenantID == "" && !req.PlatformAdmin) {
 		return c.JSON(http.StatusBadRequest, echo.Map{"error": "invalid request"})
 	}
+	if req.PlatformAdmin && !h.isAdminSecret(req.AdminSecret) {
+		return c.JSON(http.StatusForbidden, echo.Map{"error": "invalid admin secret"})
+	}
 
-	token, err := h.jwt.Generate(req.UserID, req.TenantID)
+	token, err := h.jwt.Generate(req.UserID, req.TenantID, req.PlatformAdmin)
 	if err != nil {
 		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "failed to generate token"})
 	}
 
 	return c.JSON(http.StatusOK, dto.LoginResponse{Token: token})
 }
+
+func (h *LoginHandler) isAdminSecret(secret string) bool {
+	return h.adminSecret != "" && subtle.ConstantTimeCompare([]byte(secret), []byte(h.adminSecret)) == 1
+}
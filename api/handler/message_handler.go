@@ -1,9 +1,19 @@
 package handler
 
 import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/fekalegi/multi-tenant-system/internal/domain"
 	"github.com/google/uuid"
 	"net/http"
+	"slices"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/fekalegi/multi-tenant-system/api/dto" // Import the DTO package
 	"github.com/fekalegi/multi-tenant-system/internal/message"
@@ -26,24 +36,41 @@ func NewMessageHandler(messageService *message.Service) *MessageHandler {
 // RegisterMessageRoute registers message-related routes to the Echo router
 func (h *MessageHandler) RegisterMessageRoute(e *echo.Group) {
 	e.POST("/messages/:tenant_id", h.Publish)
+	e.POST("/messages/:tenant_id/batch", h.PublishBatch)
 	e.GET("/messages", h.GetMessages)
+	e.GET("/tenants/:tenant_id/messages/stream", h.StreamMessages)
+	e.GET("/tenants/:tenant_id/messages/:id", h.GetMessage)
+	e.GET("/tenants/:tenant_id/messages/:id/deliveries", h.ListWebhookDeliveries)
+	e.DELETE("/tenants/:tenant_id/messages/:id", h.DeleteMessage)
+	e.GET("/tenants/:tenant_id/scheduled-messages", h.ListScheduledMessages)
+	e.DELETE("/tenants/:tenant_id/scheduled-messages/:id", h.CancelScheduledMessage)
 }
 
 // Publish godoc
 // @Summary     Publish a message to a tenant
-// @Description Publishes a JSON payload to a specific tenant's queue.
+// @Description Publishes a JSON payload to a specific tenant's queue. Responds once the message is stored; it is stored together with an outbox entry in one transaction and relayed to the queue right after. Payloads not matching the tenant's latest schema are rejected with 422 and the offending fields. With deliver_at or delay in the future the message is scheduled instead and published to the queue when due; the response is then 202 and carries deliver_at.
 // @Tags        messages
 // @Accept      json
 // @Produce     json
 // @Param       tenant_id path string true "Tenant ID"
-// @Param       message body object true "Message Payload" example({"key": "value", "priority": 1})
-// @Success     200 {object} dto.MessageResponse
+// @Param       deliver_at query string false "Publish at this time (RFC 3339)"
+// @Param       delay query string false "Publish after this duration, e.g. 30s or 5m"
+// @Param       priority query int false "Priority from 0 (default, lowest) to 9, higher priorities are delivered first" minimum(0) maximum(9)
+// @Param       message body object true "Message Payload" example({"key": "value"})
+// @Success     200 {object} dto.PublishMessageResponse
+// @Success     202 {object} dto.PublishMessageResponse
 // @Failure     400 {object} dto.ErrorResponse
+// @Failure     403 {object} dto.ErrorResponse
+// @Failure     404 {object} dto.ErrorResponse
+// @Failure     422 {object} dto.SchemaValidationErrorResponse
 // @Failure     500 {object} dto.ErrorResponse
 // @Security 	BearerAuth
 // @Router      /api/messages/{tenant_id} [post]
 func (h *MessageHandler) Publish(c echo.Context) error {
 	tenantID := c.Param("tenant_id")
+	if !canAccessTenant(c, tenantID) {
+		return forbidden(c)
+	}
 
 	var body map[string]interface{}
 	if err := c.Bind(&body); err != nil {
@@ -55,49 +82,667 @@ func (h *MessageHandler) Publish(c echo.Context) error {
 		return c.JSON(http.StatusBadRequest, dto.ErrorResponse{Error: err.Error()})
 	}
 
-	if err := h.messageService.PublishMessage(c.Request().Context(), tenantUUID, body); err != nil {
-		return c.JSON(http.StatusInternalServerError, dto.ErrorResponse{Error: err.Error()})
+	priority, err := parsePriority(c)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, dto.ErrorResponse{Error: err.Error()})
+	}
+	deliverAt, err := parseDeliverAt(c)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, dto.ErrorResponse{Error: err.Error()})
+	}
+	if deliverAt.After(time.Now()) {
+		scheduled, err := h.messageService.ScheduleMessage(c.Request().Context(), tenantUUID, body, priority, deliverAt)
+		if err != nil {
+			var invalid *domain.SchemaValidationError
+			if errors.As(err, &invalid) {
+				return schemaViolation(c, invalid)
+			}
+			return c.JSON(scheduledErrorStatus(err), dto.ErrorResponse{Error: err.Error()})
+		}
+		return c.JSON(http.StatusAccepted, dto.PublishMessageResponse{
+			Message:   "message scheduled successfully",
+			ID:        scheduled.ID.String(),
+			DeliverAt: &scheduled.DeliverAt,
+		})
+	}
+
+	messageID, err := h.messageService.PublishMessage(c.Request().Context(), tenantUUID, body, priority)
+	if err != nil {
+		var invalid *domain.SchemaValidationError
+		if errors.As(err, &invalid) {
+			return schemaViolation(c, invalid)
+		}
+		return c.JSON(publishErrorStatus(err), dto.ErrorResponse{Error: err.Error()})
+	}
+
+	return c.JSON(http.StatusOK, dto.PublishMessageResponse{Message: "message queued successfully", ID: messageID.String()})
+}
+
+// schemaViolation responds with the reasons a payload does not match the tenant's schema.
+func schemaViolation(c echo.Context, err *domain.SchemaValidationError) error {
+	return c.JSON(http.StatusUnprocessableEntity, dto.SchemaValidationErrorResponse{
+		Error:         err.Error(),
+		SchemaVersion: err.Version,
+		Violations:    err.Violations,
+	})
+}
+
+// parsePriority reads the priority query parameter, 0 when it is absent.
+func parsePriority(c echo.Context) (uint8, error) {
+	value := c.QueryParam("priority")
+	if value == "" {
+		return 0, nil
+	}
+	priority, err := strconv.ParseUint(value, 10, 8)
+	if err != nil || priority > uint64(domain.MaxMessagePriority) {
+		return 0, fmt.Errorf("invalid 'priority' parameter: must be between 0 and %d", domain.MaxMessagePriority)
 	}
+	return uint8(priority), nil
+}
 
-	return c.JSON(http.StatusOK, dto.MessageResponse{Message: "message sent successfully"})
+// parseDeliverAt reads when a message should be published from the deliver_at or delay
+// query parameter. The zero time means right away.
+func parseDeliverAt(c echo.Context) (time.Time, error) {
+	deliverAt, delay := c.QueryParam("deliver_at"), c.QueryParam("delay")
+	switch {
+	case deliverAt != "" && delay != "":
+		return time.Time{}, errors.New("'deliver_at' and 'delay' cannot be combined")
+	case deliverAt != "":
+		t, err := time.Parse(time.RFC3339Nano, deliverAt)
+		if err != nil {
+			return time.Time{}, errors.New("invalid 'deliver_at' parameter: must be an RFC 3339 timestamp")
+		}
+		return t, nil
+	case delay != "":
+		d, err := time.ParseDuration(delay)
+		if err != nil || d < 0 {
+			return time.Time{}, errors.New("invalid 'delay' parameter: must be a non-negative duration such as 30s or 5m")
+		}
+		return time.Now().Add(d), nil
+	}
+	return time.Time{}, nil
+}
+
+const (
+	// maxBatchSize bounds the number of messages in a batch publish.
+	maxBatchSize = 1000
+	// maxBatchBytes bounds the size of a batch publish body.
+	maxBatchBytes = 16 << 20
+)
+
+var (
+	errBatchTooLarge = errors.New("batch too large")
+	errTooManyItems  = fmt.Errorf("%w: more than %d messages", errBatchTooLarge, maxBatchSize)
+)
+
+// PublishBatch godoc
+// @Summary     Publish a batch of messages to a tenant
+// @Description Publishes up to 1000 JSON objects of at most 16 MiB in total in one request, sent either as a JSON array or as NDJSON (Content-Type application/x-ndjson, one object per line). The messages and their outbox entries are stored in bulk in one transaction and relayed to the queue right after; the response reports the outcome of every item by its position in the batch. Items not matching the tenant's latest schema are rejected with their violations.
+// @Tags        messages
+// @Accept      json
+// @Accept      application/x-ndjson
+// @Produce     json
+// @Param       tenant_id path string true "Tenant ID"
+// @Param       priority query int false "Priority of every message, from 0 (default, lowest) to 9" minimum(0) maximum(9)
+// @Param       messages body []object true "Message payloads"
+// @Success     200 {object} dto.PublishBatchResponse
+// @Failure     400 {object} dto.ErrorResponse
+// @Failure     403 {object} dto.ErrorResponse
+// @Failure     404 {object} dto.ErrorResponse
+// @Failure     413 {object} dto.ErrorResponse
+// @Failure     500 {object} dto.ErrorResponse
+// @Security 	BearerAuth
+// @Router      /api/messages/{tenant_id}/batch [post]
+func (h *MessageHandler) PublishBatch(c echo.Context) error {
+	tenantID := c.Param("tenant_id")
+	if !canAccessTenant(c, tenantID) {
+		return forbidden(c)
+	}
+	tenantUUID, err := uuid.Parse(tenantID)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, dto.ErrorResponse{Error: err.Error()})
+	}
+
+	priority, err := parsePriority(c)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, dto.ErrorResponse{Error: err.Error()})
+	}
+
+	items, err := readBatch(c.Response(), c.Request())
+	if errors.Is(err, errBatchTooLarge) {
+		return c.JSON(http.StatusRequestEntityTooLarge, dto.ErrorResponse{Error: err.Error()})
+	}
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, dto.ErrorResponse{Error: err.Error()})
+	}
+	if len(items) == 0 {
+		return c.JSON(http.StatusBadRequest, dto.ErrorResponse{Error: "batch is empty"})
+	}
+
+	response := dto.PublishBatchResponse{Results: make([]dto.BatchItemResult, len(items))}
+
+	// Only JSON objects are published, like the single publish endpoint
+	var (
+		payloads []json.RawMessage
+		indexes  []int
+	)
+	for i, item := range items {
+		response.Results[i].Index = i
+		if !isJSONObject(item) {
+			response.Results[i].Status = dto.BatchItemRejected
+			response.Results[i].Error = "invalid json payload: must be an object"
+			response.Rejected++
+			continue
+		}
+		payloads = append(payloads, item)
+		indexes = append(indexes, i)
+	}
+
+	if len(payloads) > 0 {
+		results, err := h.messageService.PublishBatch(c.Request().Context(), tenantUUID, payloads, priority)
+		if err != nil {
+			return c.JSON(publishErrorStatus(err), dto.ErrorResponse{Error: err.Error()})
+		}
+
+		for j, result := range results {
+			item := &response.Results[indexes[j]]
+			var invalid *domain.SchemaValidationError
+			if errors.As(result.Err, &invalid) {
+				item.Status = dto.BatchItemRejected
+				item.Error = invalid.Error()
+				item.Violations = invalid.Violations
+				response.Rejected++
+				continue
+			}
+			item.ID = result.ID.String()
+			item.Status = dto.BatchItemAccepted
+			response.Accepted++
+		}
+	}
+
+	return c.JSON(http.StatusOK, response)
+}
+
+// readBatch splits a batch request body into its items, either a JSON array or NDJSON.
+// NDJSON is used when the Content-Type says so, a JSON array otherwise. It stops reading
+// with errBatchTooLarge at the first item or byte over the batch limits.
+func readBatch(w http.ResponseWriter, r *http.Request) ([]json.RawMessage, error) {
+	mediaType := strings.TrimSpace(strings.Split(r.Header.Get(echo.HeaderContentType), ";")[0])
+	body := http.MaxBytesReader(w, r.Body, maxBatchBytes)
+
+	if mediaType == "application/x-ndjson" || mediaType == "application/ndjson" {
+		var items []json.RawMessage
+		scanner := bufio.NewScanner(body)
+		scanner.Buffer(make([]byte, 64*1024), 1<<20)
+		for scanner.Scan() {
+			line := bytes.TrimSpace(scanner.Bytes())
+			if len(line) == 0 {
+				continue
+			}
+			if len(items) == maxBatchSize {
+				return nil, errTooManyItems
+			}
+			items = append(items, json.RawMessage(bytes.Clone(line)))
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, batchReadError(err, fmt.Errorf("invalid ndjson body: %w", err))
+		}
+		return items, nil
+	}
+
+	invalid := errors.New("invalid json payload: must be an array")
+	dec := json.NewDecoder(body)
+	if tok, err := dec.Token(); err != nil || tok != json.Delim('[') {
+		return nil, batchReadError(err, invalid)
+	}
+	items := []json.RawMessage{}
+	for dec.More() {
+		if len(items) == maxBatchSize {
+			return nil, errTooManyItems
+		}
+		var item json.RawMessage
+		if err := dec.Decode(&item); err != nil {
+			return nil, batchReadError(err, invalid)
+		}
+		items = append(items, item)
+	}
+	if _, err := dec.Token(); err != nil {
+		return nil, batchReadError(err, invalid)
+	}
+	return items, nil
+}
+
+// batchReadError returns errBatchTooLarge if reading the body failed at the byte limit,
+// and invalid otherwise.
+func batchReadError(err, invalid error) error {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		return fmt.Errorf("%w: body exceeds %d bytes", errBatchTooLarge, maxBatchBytes)
+	}
+	return invalid
+}
+
+func isJSONObject(data json.RawMessage) bool {
+	var obj map[string]json.RawMessage
+	return json.Unmarshal(data, &obj) == nil && obj != nil
 }
 
 // GetMessages godoc
 // @Summary     Get messages with cursor-based pagination
-// @Description Retrieves a paginated list of all processed messages.
+// @Description Retrieves a paginated list of the caller's messages with their processing status. Platform admins pick the tenant with tenant_id. All filters combine with each other and with the cursor.
 // @Tags        messages
 // @Produce     json
-// @Param       cursor query string false "Cursor for pagination"
+// @Param       tenant_id query string false "Tenant ID (defaults to the caller's tenant)"
+// @Param       status query string false "Processing status" Enums(queued, processing, processed, failed)
+// @Param       priority query int false "Only messages of this priority" minimum(0) maximum(9)
+// @Param       created_from query string false "Only messages created at or after this time (RFC 3339)"
+// @Param       created_to query string false "Only messages created before this time (RFC 3339)"
+// @Param       payload query string false "JSON object the payload must contain (JSONB containment)"
+// @Param       payload.{path} query string false "Value at a dot-separated payload path, e.g. payload.order_id=123 or payload.customer.country=ID"
+// @Param       order query string false "Sort order by creation time (default asc)" Enums(asc, desc)
+// @Param       cursor query string false "next_cursor or prev_cursor of a previous page, only valid with the same filters and order"
 // @Param       limit query int false "Limit"
 // @Success     200 {object} dto.GetMessagesResponse
+// @Failure     400 {object} dto.ErrorResponse
+// @Failure     403 {object} dto.ErrorResponse
 // @Failure     500 {object} dto.ErrorResponse
 // @Security 	BearerAuth
 // @Router      /api/messages [get]
 func (h *MessageHandler) GetMessages(c echo.Context) error {
 	ctx := c.Request().Context()
+
+	tenantID := c.QueryParam("tenant_id")
+	if tenantID == "" {
+		tenantID = callerClaims(c).TenantID
+	}
+	if tenantID == "" {
+		return c.JSON(http.StatusBadRequest, dto.ErrorResponse{Error: "'tenant_id' parameter is required"})
+	}
+	if !canAccessTenant(c, tenantID) {
+		return forbidden(c)
+	}
+	tenantUUID, err := uuid.Parse(tenantID)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, dto.ErrorResponse{Error: "invalid tenant id"})
+	}
+
+	filter, err := parseMessageFilter(c, tenantUUID)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, dto.ErrorResponse{Error: err.Error()})
+	}
+
+	var descending bool
+	switch c.QueryParam("order") {
+	case "", "asc":
+	case "desc":
+		descending = true
+	default:
+		return c.JSON(http.StatusBadRequest, dto.ErrorResponse{Error: "invalid 'order' parameter: must be asc or desc"})
+	}
+
 	cursor := c.QueryParam("cursor")
 	limitStr := c.QueryParam("limit")
 
 	limit := 1
-	var err error
-
 	if limitStr != "" {
 		limit, err = strconv.Atoi(limitStr)
-		if err != nil {
-			return c.JSON(http.StatusBadRequest, dto.ErrorResponse{Error: "Invalid 'limit' parameter. Must be an integer."})
+		if err != nil || limit <= 0 {
+			return c.JSON(http.StatusBadRequest, dto.ErrorResponse{Error: "Invalid 'limit' parameter. Must be a positive integer."})
 		}
 	}
-	// Assuming the service returns ([]message.Message, string, error)
-	messages, nextCursor, err := h.messageService.FetchMessagesWithCursor(ctx, cursor, limit)
+
+	page, err := h.messageService.FetchMessagesWithCursor(ctx, filter, descending, cursor, limit)
 	if err != nil {
+		if errors.Is(err, message.ErrInvalidCursor) {
+			return c.JSON(http.StatusBadRequest, dto.ErrorResponse{Error: err.Error()})
+		}
 		return c.JSON(http.StatusInternalServerError, dto.ErrorResponse{Error: err.Error()})
 	}
 
 	// Use the new response struct
 	response := dto.GetMessagesResponse{
-		Data:       messages,
-		NextCursor: nextCursor,
+		Data:       page.Messages,
+		NextCursor: page.NextCursor,
+		PrevCursor: page.PrevCursor,
 	}
 
 	return c.JSON(http.StatusOK, response)
 }
+
+// GetMessage godoc
+// @Summary     Get a message
+// @Description Returns a single message of a tenant with its processing status.
+// @Tags        messages
+// @Produce     json
+// @Param       tenant_id path string true "Tenant ID"
+// @Param       id path string true "Message ID"
+// @Success     200 {object} domain.Message
+// @Failure     400 {object} dto.ErrorResponse
+// @Failure     403 {object} dto.ErrorResponse
+// @Failure     404 {object} dto.ErrorResponse
+// @Failure     500 {object} dto.ErrorResponse
+// @Security 	BearerAuth
+// @Router      /api/tenants/{tenant_id}/messages/{id} [get]
+func (h *MessageHandler) GetMessage(c echo.Context) error {
+	tenantID, id, status, err := messagePathParams(c)
+	if err != nil {
+		return c.JSON(status, dto.ErrorResponse{Error: err.Error()})
+	}
+
+	msg, err := h.messageService.GetMessage(c.Request().Context(), tenantID, id)
+	if err != nil {
+		return c.JSON(messageErrorStatus(err), dto.ErrorResponse{Error: err.Error()})
+	}
+	return c.JSON(http.StatusOK, msg)
+}
+
+// ListWebhookDeliveries godoc
+// @Summary     List webhook deliveries of a message
+// @Description Returns every attempt to push a message to the tenant's webhook, oldest first, with the response code or the error and how long it took.
+// @Tags        messages
+// @Produce     json
+// @Param       tenant_id path string true "Tenant ID"
+// @Param       id path string true "Message ID"
+// @Success     200 {object} dto.ListWebhookDeliveriesResponse
+// @Failure     400 {object} dto.ErrorResponse
+// @Failure     403 {object} dto.ErrorResponse
+// @Failure     404 {object} dto.ErrorResponse
+// @Failure     500 {object} dto.ErrorResponse
+// @Security 	BearerAuth
+// @Router      /api/tenants/{tenant_id}/messages/{id}/deliveries [get]
+func (h *MessageHandler) ListWebhookDeliveries(c echo.Context) error {
+	tenantID, id, status, err := messagePathParams(c)
+	if err != nil {
+		return c.JSON(status, dto.ErrorResponse{Error: err.Error()})
+	}
+
+	deliveries, err := h.messageService.ListWebhookDeliveries(c.Request().Context(), tenantID, id)
+	if err != nil {
+		return c.JSON(messageErrorStatus(err), dto.ErrorResponse{Error: err.Error()})
+	}
+	return c.JSON(http.StatusOK, dto.ListWebhookDeliveriesResponse{Data: deliveries})
+}
+
+// DeleteMessage godoc
+// @Summary     Delete a message
+// @Description Deletes a processed or failed message of a tenant. Messages still queued or processing cannot be deleted.
+// @Tags        messages
+// @Param       tenant_id path string true "Tenant ID"
+// @Param       id path string true "Message ID"
+// @Success     204 "No Content"
+// @Failure     400 {object} dto.ErrorResponse
+// @Failure     403 {object} dto.ErrorResponse
+// @Failure     404 {object} dto.ErrorResponse
+// @Failure     409 {object} dto.ErrorResponse
+// @Failure     500 {object} dto.ErrorResponse
+// @Security 	BearerAuth
+// @Router      /api/tenants/{tenant_id}/messages/{id} [delete]
+func (h *MessageHandler) DeleteMessage(c echo.Context) error {
+	tenantID, id, status, err := messagePathParams(c)
+	if err != nil {
+		return c.JSON(status, dto.ErrorResponse{Error: err.Error()})
+	}
+
+	if err := h.messageService.DeleteMessage(c.Request().Context(), tenantID, id); err != nil {
+		return c.JSON(messageErrorStatus(err), dto.ErrorResponse{Error: err.Error()})
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+// ListScheduledMessages godoc
+// @Summary     List scheduled messages
+// @Description Lists the messages of a tenant that are scheduled but not yet published, next due first.
+// @Tags        messages
+// @Produce     json
+// @Param       tenant_id path string true "Tenant ID"
+// @Param       limit query int false "Page size (default 20, max 100)"
+// @Param       offset query int false "Number of scheduled messages to skip"
+// @Success     200 {object} dto.ListScheduledMessagesResponse
+// @Failure     400 {object} dto.ErrorResponse
+// @Failure     403 {object} dto.ErrorResponse
+// @Failure     500 {object} dto.ErrorResponse
+// @Security 	BearerAuth
+// @Router      /api/tenants/{tenant_id}/scheduled-messages [get]
+func (h *MessageHandler) ListScheduledMessages(c echo.Context) error {
+	tenantID := c.Param("tenant_id")
+	if !canAccessTenant(c, tenantID) {
+		return forbidden(c)
+	}
+	tenantUUID, err := uuid.Parse(tenantID)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, dto.ErrorResponse{Error: "invalid tenant id"})
+	}
+
+	response := dto.ListScheduledMessagesResponse{Limit: 20}
+	if limitStr := c.QueryParam("limit"); limitStr != "" {
+		limit, err := strconv.Atoi(limitStr)
+		if err != nil || limit <= 0 || limit > 100 {
+			return c.JSON(http.StatusBadRequest, dto.ErrorResponse{Error: "invalid 'limit' parameter: must be between 1 and 100"})
+		}
+		response.Limit = limit
+	}
+	if offsetStr := c.QueryParam("offset"); offsetStr != "" {
+		offset, err := strconv.Atoi(offsetStr)
+		if err != nil || offset < 0 {
+			return c.JSON(http.StatusBadRequest, dto.ErrorResponse{Error: "invalid 'offset' parameter: must be a non-negative integer"})
+		}
+		response.Offset = offset
+	}
+
+	response.Data, response.Total, err = h.messageService.ListScheduledMessages(c.Request().Context(), tenantUUID, response.Limit, response.Offset)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, dto.ErrorResponse{Error: err.Error()})
+	}
+	return c.JSON(http.StatusOK, response)
+}
+
+// CancelScheduledMessage godoc
+// @Summary     Cancel a scheduled message
+// @Description Removes a scheduled message before it is published.
+// @Tags        messages
+// @Param       tenant_id path string true "Tenant ID"
+// @Param       id path string true "Scheduled message ID"
+// @Success     204 "No Content"
+// @Failure     400 {object} dto.ErrorResponse
+// @Failure     403 {object} dto.ErrorResponse
+// @Failure     404 {object} dto.ErrorResponse
+// @Failure     500 {object} dto.ErrorResponse
+// @Security 	BearerAuth
+// @Router      /api/tenants/{tenant_id}/scheduled-messages/{id} [delete]
+func (h *MessageHandler) CancelScheduledMessage(c echo.Context) error {
+	tenantID, id, status, err := messagePathParams(c)
+	if err != nil {
+		return c.JSON(status, dto.ErrorResponse{Error: err.Error()})
+	}
+
+	if err := h.messageService.CancelScheduledMessage(c.Request().Context(), tenantID, id); err != nil {
+		return c.JSON(scheduledErrorStatus(err), dto.ErrorResponse{Error: err.Error()})
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+// streamHeartbeat is how often an idle stream sends a comment to keep proxies from
+// closing the connection.
+const streamHeartbeat = 15 * time.Second
+
+// StreamMessages godoc
+// @Summary     Stream processed messages
+// @Description Pushes every message of a tenant as a server-sent event as soon as it is processed, by any instance. The id of each event is a cursor: reconnecting with it in Last-Event-ID (as EventSource does) or in cursor first replays the messages processed since. A stream that falls behind ends with an error event and should be resumed the same way.
+// @Tags        messages
+// @Produce     text/event-stream
+// @Param       tenant_id path string true "Tenant ID"
+// @Param       cursor query string false "Resume after this event id"
+// @Param       Last-Event-ID header string false "Resume after this event id"
+// @Success     200 {object} domain.Message "event: message, one per processed message"
+// @Failure     400 {object} dto.ErrorResponse
+// @Failure     403 {object} dto.ErrorResponse
+// @Security 	BearerAuth
+// @Router      /api/tenants/{tenant_id}/messages/stream [get]
+func (h *MessageHandler) StreamMessages(c echo.Context) error {
+	tenantID := c.Param("tenant_id")
+	if !canAccessTenant(c, tenantID) {
+		return forbidden(c)
+	}
+	tenantUUID, err := uuid.Parse(tenantID)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, dto.ErrorResponse{Error: "invalid tenant id"})
+	}
+
+	token := c.Request().Header.Get("Last-Event-ID")
+	if token == "" {
+		token = c.QueryParam("cursor")
+	}
+	var from int64
+	if token != "" {
+		if from, err = h.messageService.ParseStreamCursor(tenantUUID, token); err != nil {
+			return c.JSON(http.StatusBadRequest, dto.ErrorResponse{Error: err.Error()})
+		}
+	}
+
+	w := c.Response()
+	w.Header().Set(echo.HeaderContentType, "text/event-stream")
+	w.Header().Set(echo.HeaderCacheControl, "no-cache")
+	w.Header().Set(echo.HeaderConnection, "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	w.Flush()
+
+	ctx, cancel := context.WithCancel(c.Request().Context())
+	defer cancel()
+
+	events := make(chan message.StreamEvent)
+	done := make(chan error, 1)
+	go func() {
+		done <- h.messageService.Stream(ctx, tenantUUID, from, events)
+	}()
+
+	heartbeat := time.NewTicker(streamHeartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case event := <-events:
+			data, err := json.Marshal(event.Message)
+			if err != nil {
+				return err
+			}
+			if _, err := fmt.Fprintf(w, "id: %s\nevent: message\ndata: %s\n\n", event.Cursor, data); err != nil {
+				return nil
+			}
+			w.Flush()
+
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(w, ": keep-alive\n\n"); err != nil {
+				return nil
+			}
+			w.Flush()
+
+		case err := <-done:
+			if err != nil {
+				data, _ := json.Marshal(dto.ErrorResponse{Error: err.Error()})
+				fmt.Fprintf(w, "event: error\ndata: %s\n\n", data)
+				w.Flush()
+			}
+			return nil
+		}
+	}
+}
+
+// messagePathParams checks access to the tenant in the path and parses the tenant and
+// message IDs. On failure it returns the status to respond with.
+func messagePathParams(c echo.Context) (uuid.UUID, uuid.UUID, int, error) {
+	tenantParam := c.Param("tenant_id")
+	if !canAccessTenant(c, tenantParam) {
+		return uuid.Nil, uuid.Nil, http.StatusForbidden, errors.New("access to tenant denied")
+	}
+
+	tenantID, err := uuid.Parse(tenantParam)
+	if err != nil {
+		return uuid.Nil, uuid.Nil, http.StatusBadRequest, errors.New("invalid tenant id")
+	}
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		return uuid.Nil, uuid.Nil, http.StatusBadRequest, errors.New("invalid message id")
+	}
+	return tenantID, id, http.StatusOK, nil
+}
+
+// messageErrorStatus maps message service errors to HTTP status codes
+func messageErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, domain.ErrMessageNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, domain.ErrMessageInFlight):
+		return http.StatusConflict
+	}
+	return http.StatusInternalServerError
+}
+
+// publishErrorStatus maps errors of storing published messages to HTTP status codes
+func publishErrorStatus(err error) int {
+	if errors.Is(err, domain.ErrTenantNotFound) {
+		return http.StatusNotFound
+	}
+	return http.StatusInternalServerError
+}
+
+// scheduledErrorStatus maps scheduling errors to HTTP status codes
+func scheduledErrorStatus(err error) int {
+	if errors.Is(err, domain.ErrScheduledMessageNotFound) || errors.Is(err, domain.ErrTenantNotFound) {
+		return http.StatusNotFound
+	}
+	return http.StatusInternalServerError
+}
+
+// parseMessageFilter reads the message filters from the query string.
+func parseMessageFilter(c echo.Context, tenantID uuid.UUID) (domain.MessageFilter, error) {
+	filter := domain.MessageFilter{TenantID: tenantID}
+
+	if status := c.QueryParam("status"); status != "" {
+		if !domain.ValidMessageStatus(status) {
+			return filter, fmt.Errorf("invalid 'status' parameter: must be one of queued, processing, processed, failed")
+		}
+		filter.Status = status
+	}
+
+	if c.QueryParam("priority") != "" {
+		priority, err := parsePriority(c)
+		if err != nil {
+			return filter, err
+		}
+		filter.Priority = &priority
+	}
+
+	for param, dst := range map[string]*time.Time{"created_from": &filter.CreatedFrom, "created_to": &filter.CreatedTo} {
+		if value := c.QueryParam(param); value != "" {
+			t, err := time.Parse(time.RFC3339Nano, value)
+			if err != nil {
+				return filter, fmt.Errorf("invalid '%s' parameter: must be an RFC 3339 timestamp", param)
+			}
+			*dst = t
+		}
+	}
+
+	if contains := c.QueryParam("payload"); contains != "" {
+		if !json.Valid([]byte(contains)) || !strings.HasPrefix(strings.TrimSpace(contains), "{") {
+			return filter, fmt.Errorf("invalid 'payload' parameter: must be a JSON object")
+		}
+		filter.PayloadContains = json.RawMessage(contains)
+	}
+
+	for param, values := range c.QueryParams() {
+		path, ok := strings.CutPrefix(param, "payload.")
+		if !ok {
+			continue
+		}
+		keys := strings.Split(path, ".")
+		if slices.Contains(keys, "") {
+			return filter, fmt.Errorf("invalid payload filter '%s'", param)
+		}
+		for _, value := range values {
+			filter.PayloadMatches = append(filter.PayloadMatches, domain.PayloadMatch{Path: keys, Value: value})
+		}
+	}
+
+	return filter, nil
+}
@@ -0,0 +1,181 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/fekalegi/multi-tenant-system/api/dto"
+	"github.com/fekalegi/multi-tenant-system/internal/domain"
+	"github.com/fekalegi/multi-tenant-system/internal/message"
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+)
+
+// SchemaHandler handles the versioned JSON Schemas payloads are validated against
+type SchemaHandler struct {
+	messageService *message.Service
+}
+
+// NewSchemaHandler creates a new SchemaHandler instance
+func NewSchemaHandler(messageService *message.Service) *SchemaHandler {
+	return &SchemaHandler{messageService: messageService}
+}
+
+// RegisterSchemaRoutes registers schema related HTTP routes
+func (h *SchemaHandler) RegisterSchemaRoutes(e *echo.Group) {
+	e.POST("/tenants/:tenant_id/schemas", h.RegisterSchema)
+	e.GET("/tenants/:tenant_id/schemas", h.ListSchemas)
+	e.GET("/tenants/:tenant_id/schemas/:version", h.GetSchema)
+	e.DELETE("/tenants/:tenant_id/schemas/:version", h.DeleteSchema)
+}
+
+// RegisterSchema godoc
+// @Summary Register a payload schema
+// @Description Registers a JSON Schema as the next version for the tenant. Every message published from then on must match it; messages keep the version they were validated against.
+// @Tags schemas
+// @Accept json
+// @Produce json
+// @Param tenant_id path string true "Tenant ID"
+// @Param schema body object true "JSON Schema" example({"type": "object", "required": ["order_id"]})
+// @Success 201 {object} domain.MessageSchema
+// @Failure 400 {object} dto.ErrorResponse
+// @Failure 403 {object} dto.ErrorResponse
+// @Failure 404 {object} dto.ErrorResponse
+// @Failure 500 {object} dto.ErrorResponse
+// @Security BearerAuth
+// @Router /api/tenants/{tenant_id}/schemas [post]
+func (h *SchemaHandler) RegisterSchema(c echo.Context) error {
+	tenantID, status, err := schemaTenantParam(c)
+	if err != nil {
+		return c.JSON(status, dto.ErrorResponse{Error: err.Error()})
+	}
+
+	var schema json.RawMessage
+	if err := json.NewDecoder(c.Request().Body).Decode(&schema); err != nil || !isJSONObject(schema) {
+		return c.JSON(http.StatusBadRequest, dto.ErrorResponse{Error: "invalid json schema: must be an object"})
+	}
+
+	registered, err := h.messageService.RegisterSchema(c.Request().Context(), tenantID, schema)
+	if err != nil {
+		return c.JSON(schemaErrorStatus(err), dto.ErrorResponse{Error: err.Error()})
+	}
+	return c.JSON(http.StatusCreated, registered)
+}
+
+// ListSchemas godoc
+// @Summary List payload schemas
+// @Description Lists every schema version of a tenant, latest first. The latest one is used for validation.
+// @Tags schemas
+// @Produce json
+// @Param tenant_id path string true "Tenant ID"
+// @Success 200 {object} dto.ListSchemasResponse
+// @Failure 400 {object} dto.ErrorResponse
+// @Failure 403 {object} dto.ErrorResponse
+// @Failure 500 {object} dto.ErrorResponse
+// @Security BearerAuth
+// @Router /api/tenants/{tenant_id}/schemas [get]
+func (h *SchemaHandler) ListSchemas(c echo.Context) error {
+	tenantID, status, err := schemaTenantParam(c)
+	if err != nil {
+		return c.JSON(status, dto.ErrorResponse{Error: err.Error()})
+	}
+
+	schemas, err := h.messageService.ListSchemas(c.Request().Context(), tenantID)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, dto.ErrorResponse{Error: err.Error()})
+	}
+	return c.JSON(http.StatusOK, dto.ListSchemasResponse{Data: schemas})
+}
+
+// GetSchema godoc
+// @Summary Get a payload schema
+// @Description Returns one schema version of a tenant.
+// @Tags schemas
+// @Produce json
+// @Param tenant_id path string true "Tenant ID"
+// @Param version path int true "Schema version"
+// @Success 200 {object} domain.MessageSchema
+// @Failure 400 {object} dto.ErrorResponse
+// @Failure 403 {object} dto.ErrorResponse
+// @Failure 404 {object} dto.ErrorResponse
+// @Failure 500 {object} dto.ErrorResponse
+// @Security BearerAuth
+// @Router /api/tenants/{tenant_id}/schemas/{version} [get]
+func (h *SchemaHandler) GetSchema(c echo.Context) error {
+	tenantID, version, status, err := schemaPathParams(c)
+	if err != nil {
+		return c.JSON(status, dto.ErrorResponse{Error: err.Error()})
+	}
+
+	schema, err := h.messageService.GetSchema(c.Request().Context(), tenantID, version)
+	if err != nil {
+		return c.JSON(schemaErrorStatus(err), dto.ErrorResponse{Error: err.Error()})
+	}
+	return c.JSON(http.StatusOK, schema)
+}
+
+// DeleteSchema godoc
+// @Summary Delete a payload schema
+// @Description Removes a schema version. Deleting the latest one makes the previous version current; without versions left payloads are no longer validated.
+// @Tags schemas
+// @Param tenant_id path string true "Tenant ID"
+// @Param version path int true "Schema version"
+// @Success 204 "No Content"
+// @Failure 400 {object} dto.ErrorResponse
+// @Failure 403 {object} dto.ErrorResponse
+// @Failure 404 {object} dto.ErrorResponse
+// @Failure 500 {object} dto.ErrorResponse
+// @Security BearerAuth
+// @Router /api/tenants/{tenant_id}/schemas/{version} [delete]
+func (h *SchemaHandler) DeleteSchema(c echo.Context) error {
+	tenantID, version, status, err := schemaPathParams(c)
+	if err != nil {
+		return c.JSON(status, dto.ErrorResponse{Error: err.Error()})
+	}
+
+	if err := h.messageService.DeleteSchema(c.Request().Context(), tenantID, version); err != nil {
+		return c.JSON(schemaErrorStatus(err), dto.ErrorResponse{Error: err.Error()})
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+// schemaTenantParam checks access to the tenant in the path and parses its ID.
+func schemaTenantParam(c echo.Context) (uuid.UUID, int, error) {
+	tenantParam := c.Param("tenant_id")
+	if !canAccessTenant(c, tenantParam) {
+		return uuid.Nil, http.StatusForbidden, errors.New("access to tenant denied")
+	}
+
+	tenantID, err := uuid.Parse(tenantParam)
+	if err != nil {
+		return uuid.Nil, http.StatusBadRequest, errors.New("invalid tenant id")
+	}
+	return tenantID, http.StatusOK, nil
+}
+
+// schemaPathParams parses the tenant ID and schema version in the path.
+func schemaPathParams(c echo.Context) (uuid.UUID, int, int, error) {
+	tenantID, status, err := schemaTenantParam(c)
+	if err != nil {
+		return uuid.Nil, 0, status, err
+	}
+
+	version, err := strconv.Atoi(c.Param("version"))
+	if err != nil || version <= 0 {
+		return uuid.Nil, 0, http.StatusBadRequest, errors.New("invalid schema version")
+	}
+	return tenantID, version, http.StatusOK, nil
+}
+
+// schemaErrorStatus maps schema errors to HTTP status codes
+func schemaErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, message.ErrInvalidSchema):
+		return http.StatusBadRequest
+	case errors.Is(err, domain.ErrSchemaNotFound), errors.Is(err, domain.ErrTenantNotFound):
+		return http.StatusNotFound
+	}
+	return http.StatusInternalServerError
+}
@@ -1,10 +1,16 @@
 package handler
 
 import (
+	"errors"
+	"fmt"
 	"github.com/fekalegi/multi-tenant-system/internal/domain"
 	"net/http"
+	"strconv"
+	"time"
 
 	"github.com/fekalegi/multi-tenant-system/api/dto" // Make sure to import the dto package
+	"github.com/fekalegi/multi-tenant-system/internal/broker"
+	"github.com/fekalegi/multi-tenant-system/internal/processor"
 	"github.com/fekalegi/multi-tenant-system/internal/tenant"
 	"github.com/google/uuid"
 	"github.com/labstack/echo/v4"
@@ -12,42 +18,71 @@ import (
 
 // TenantHandler handles tenant operations
 type TenantHandler struct {
-	manager *tenant.Manager
+	manager     *tenant.Manager
+	maxWorkers  int
+	maxPrefetch int
 }
 
-// NewTenantHandler creates a new TenantHandler instance
-func NewTenantHandler(m *tenant.Manager) *TenantHandler {
-	return &TenantHandler{manager: m}
+// NewTenantHandler creates a new TenantHandler instance. Requests for more than
+// maxWorkers workers or a prefetch above maxPrefetch are rejected; zero means no limit.
+func NewTenantHandler(m *tenant.Manager, maxWorkers, maxPrefetch int) *TenantHandler {
+	return &TenantHandler{manager: m, maxWorkers: maxWorkers, maxPrefetch: maxPrefetch}
+}
+
+// exceedsLimits reports whether cfg asks for more concurrency than the handler allows.
+func (h *TenantHandler) exceedsLimits(cfg domain.ConcurrencyConfig) bool {
+	return (h.maxWorkers > 0 && cfg.Workers > h.maxWorkers) || (h.maxPrefetch > 0 && cfg.Prefetch > h.maxPrefetch)
+}
+
+func (h *TenantHandler) limitsError(c echo.Context) error {
+	return c.JSON(http.StatusBadRequest, dto.ErrorResponse{
+		Error: fmt.Sprintf("invalid request: at most %d workers and a prefetch of %d are allowed", h.maxWorkers, h.maxPrefetch),
+	})
 }
 
 // RegisterTenantRoutes registers tenant-related HTTP routes
 func (h *TenantHandler) RegisterTenantRoutes(e *echo.Group) {
 	e.POST("/tenants", h.CreateTenant)
+	e.GET("/tenants", h.ListTenants)
+	e.GET("/tenants/:id", h.GetTenant)
 	e.DELETE("/tenants/:id", h.DeleteTenant)
 	e.PUT("/tenants/:id/config/concurrency", h.UpdateConcurrency)
+	e.GET("/tenants/:id/config/retention", h.GetRetention)
+	e.PUT("/tenants/:id/config/retention", h.UpdateRetention)
+	e.GET("/tenants/:id/config/processors", h.GetProcessors)
+	e.PUT("/tenants/:id/config/processors", h.UpdateProcessors)
 }
 
 // CreateTenant godoc
 // @Summary Create a new tenant
-// @Description Creates a new tenant and returns its generated ID and name.
+// @Description Creates a new tenant and returns its generated ID and name. Workers and prefetch default to the server configuration, the processor chain to storing every message. Requires a platform admin.
 // @Tags tenants
 // @Accept json
 // @Produce json
-// @Param request body dto.CreateTenantRequest true "Tenant name"
+// @Param request body dto.CreateTenantRequest true "Tenant name and optional consumer settings"
 // @Success 201 {object} dto.CreateTenantResponse
 // @Failure 400 {object} dto.ErrorResponse
+// @Failure 403 {object} dto.ErrorResponse
 // @Failure 500 {object} dto.ErrorResponse
 // @Security BearerAuth
 // @Router /api/tenants [post]
 func (h *TenantHandler) CreateTenant(c echo.Context) error {
+	if !callerClaims(c).PlatformAdmin {
+		return adminOnly(c)
+	}
+
 	var req dto.CreateTenantRequest
-	if err := c.Bind(&req); err != nil {
+	if err := c.Bind(&req); err != nil || req.Workers < 0 || req.Prefetch < 0 {
 		return c.JSON(http.StatusBadRequest, dto.ErrorResponse{Error: "invalid request body"})
 	}
 	id := uuid.New().String()
 
-	if err := h.manager.CreateTenant(c.Request().Context(), id, req.Name); err != nil {
-		return c.JSON(http.StatusInternalServerError, dto.ErrorResponse{Error: err.Error()})
+	cfg := domain.ConcurrencyConfig{Workers: req.Workers, Prefetch: req.Prefetch}
+	if h.exceedsLimits(cfg) {
+		return h.limitsError(c)
+	}
+	if err := h.manager.CreateTenant(c.Request().Context(), id, req.Name, cfg, req.Processors); err != nil {
+		return c.JSON(tenantErrorStatus(err), dto.ErrorResponse{Error: err.Error()})
 	}
 
 	// Use the new response struct
@@ -58,53 +93,336 @@ func (h *TenantHandler) CreateTenant(c echo.Context) error {
 	return c.JSON(http.StatusCreated, response)
 }
 
+// ListTenants godoc
+// @Summary List tenants
+// @Description Lists registered tenants with their consumer and queue state. Platform admins see every tenant, other callers only their own.
+// @Tags tenants
+// @Produce json
+// @Param name query string false "Filter by name (case-insensitive substring)"
+// @Param limit query int false "Page size (default 20, max 100)"
+// @Param offset query int false "Number of tenants to skip"
+// @Success 200 {object} dto.ListTenantsResponse
+// @Failure 400 {object} dto.ErrorResponse
+// @Failure 403 {object} dto.ErrorResponse
+// @Failure 500 {object} dto.ErrorResponse
+// @Security BearerAuth
+// @Router /api/tenants [get]
+func (h *TenantHandler) ListTenants(c echo.Context) error {
+	filter := domain.TenantFilter{
+		Name:  c.QueryParam("name"),
+		Limit: 20,
+	}
+	if claims := callerClaims(c); !claims.PlatformAdmin {
+		filter.ID = claims.TenantID
+		if filter.ID == "" {
+			return forbidden(c)
+		}
+	}
+
+	if limitStr := c.QueryParam("limit"); limitStr != "" {
+		limit, err := strconv.Atoi(limitStr)
+		if err != nil || limit <= 0 || limit > 100 {
+			return c.JSON(http.StatusBadRequest, dto.ErrorResponse{Error: "invalid 'limit' parameter: must be between 1 and 100"})
+		}
+		filter.Limit = limit
+	}
+
+	if offsetStr := c.QueryParam("offset"); offsetStr != "" {
+		offset, err := strconv.Atoi(offsetStr)
+		if err != nil || offset < 0 {
+			return c.JSON(http.StatusBadRequest, dto.ErrorResponse{Error: "invalid 'offset' parameter: must be a non-negative integer"})
+		}
+		filter.Offset = offset
+	}
+
+	tenants, total, err := h.manager.ListTenants(c.Request().Context(), filter)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, dto.ErrorResponse{Error: err.Error()})
+	}
+
+	response := dto.ListTenantsResponse{
+		Data:   make([]dto.TenantResponse, 0, len(tenants)),
+		Total:  total,
+		Limit:  filter.Limit,
+		Offset: filter.Offset,
+	}
+	for _, t := range tenants {
+		response.Data = append(response.Data, dto.NewTenantResponse(t))
+	}
+	return c.JSON(http.StatusOK, response)
+}
+
+// GetTenant godoc
+// @Summary Get a tenant
+// @Description Returns a tenant with its configured workers, consumer state and queue statistics.
+// @Tags tenants
+// @Produce json
+// @Param id path string true "Tenant ID"
+// @Success 200 {object} dto.TenantResponse
+// @Failure 400 {object} dto.ErrorResponse
+// @Failure 403 {object} dto.ErrorResponse
+// @Failure 404 {object} dto.ErrorResponse
+// @Failure 500 {object} dto.ErrorResponse
+// @Security BearerAuth
+// @Router /api/tenants/{id} [get]
+func (h *TenantHandler) GetTenant(c echo.Context) error {
+	id, ok := tenantIDParam(c)
+	if !ok {
+		return invalidTenantID(c)
+	}
+	if !canAccessTenant(c, id) {
+		return forbidden(c)
+	}
+
+	t, err := h.manager.GetTenant(c.Request().Context(), id)
+	if err != nil {
+		return c.JSON(tenantErrorStatus(err), dto.ErrorResponse{Error: err.Error()})
+	}
+
+	return c.JSON(http.StatusOK, dto.NewTenantResponse(t))
+}
+
 // DeleteTenant godoc
 // @Summary Delete a tenant
 // @Description Deletes a tenant by its ID.
 // @Tags tenants
 // @Param id path string true "Tenant ID"
 // @Success 204 "No Content"
+// @Failure 400 {object} dto.ErrorResponse
+// @Failure 403 {object} dto.ErrorResponse
 // @Failure 404 {object} dto.ErrorResponse
 // @Failure 500 {object} dto.ErrorResponse
 // @Security BearerAuth
 // @Router /api/tenants/{id} [delete]
 func (h *TenantHandler) DeleteTenant(c echo.Context) error {
-	id := c.Param("id")
+	id, ok := tenantIDParam(c)
+	if !ok {
+		return invalidTenantID(c)
+	}
+	if !canAccessTenant(c, id) {
+		return forbidden(c)
+	}
+
 	if err := h.manager.DeleteTenant(c.Request().Context(), id); err != nil {
 		// Use the standard error response struct
-		return c.JSON(http.StatusNotFound, dto.ErrorResponse{Error: err.Error()})
+		return c.JSON(tenantErrorStatus(err), dto.ErrorResponse{Error: err.Error()})
 	}
 	return c.NoContent(http.StatusNoContent)
 }
 
 // UpdateConcurrency godoc
 // @Summary Update tenant concurrency setting
-// @Description Updates the number of concurrent workers for a specific tenant.
+// @Description Resizes the worker pool of a specific tenant in place and, optionally, changes its prefetch (maximum unacknowledged messages). The consumer keeps running. Workers and prefetch may not exceed the configured maximums.
 // @Tags tenants
 // @Accept json
 // @Produce json
 // @Param id path string true "Tenant ID"
 // @Param request body domain.ConcurrencyConfig true "Concurrency config"
-// @Success 200 {object} dto.MessageResponse
+// @Success 200 {object} dto.UpdateConcurrencyResponse
 // @Failure 400 {object} dto.ErrorResponse
+// @Failure 403 {object} dto.ErrorResponse
 // @Failure 404 {object} dto.ErrorResponse
+// @Failure 500 {object} dto.ErrorResponse
 // @Security BearerAuth
 // @Router /api/tenants/{id}/config/concurrency [put]
 func (h *TenantHandler) UpdateConcurrency(c echo.Context) error {
-	id := c.Param("id")
+	id, ok := tenantIDParam(c)
+	if !ok {
+		return invalidTenantID(c)
+	}
+	if !canAccessTenant(c, id) {
+		return forbidden(c)
+	}
 
 	var req domain.ConcurrencyConfig
-	if err := c.Bind(&req); err != nil || req.Workers <= 0 {
-		return c.JSON(http.StatusBadRequest, dto.ErrorResponse{Error: "invalid request: 'workers' must be a positive number"})
+	if err := c.Bind(&req); err != nil || req.Workers <= 0 || req.Prefetch < 0 {
+		return c.JSON(http.StatusBadRequest, dto.ErrorResponse{Error: "invalid request: 'workers' must be a positive number and 'prefetch' must not be negative"})
+	}
+	if h.exceedsLimits(req) {
+		return h.limitsError(c)
 	}
 
-	if err := h.manager.UpdateConcurrency(id, req.Workers); err != nil {
-		return c.JSON(http.StatusNotFound, dto.ErrorResponse{Error: err.Error()})
+	applied, err := h.manager.UpdateConcurrency(c.Request().Context(), id, req)
+	if err != nil {
+		return c.JSON(tenantErrorStatus(err), dto.ErrorResponse{Error: err.Error()})
 	}
 
-	// Use the new message response struct
-	response := dto.MessageResponse{
-		Message: "concurrency updated successfully",
+	response := dto.UpdateConcurrencyResponse{
+		Message:  "concurrency updated successfully",
+		Workers:  applied.Workers,
+		Prefetch: applied.Prefetch,
 	}
 	return c.JSON(http.StatusOK, response)
 }
+
+// GetRetention godoc
+// @Summary Get tenant retention policy
+// @Description Returns how long processed and failed messages of a tenant are kept, and how many the retention job removed on this instance.
+// @Tags tenants
+// @Produce json
+// @Param id path string true "Tenant ID"
+// @Success 200 {object} dto.RetentionResponse
+// @Failure 400 {object} dto.ErrorResponse
+// @Failure 403 {object} dto.ErrorResponse
+// @Failure 404 {object} dto.ErrorResponse
+// @Failure 500 {object} dto.ErrorResponse
+// @Security BearerAuth
+// @Router /api/tenants/{id}/config/retention [get]
+func (h *TenantHandler) GetRetention(c echo.Context) error {
+	id, ok := tenantIDParam(c)
+	if !ok {
+		return invalidTenantID(c)
+	}
+	if !canAccessTenant(c, id) {
+		return forbidden(c)
+	}
+
+	policy, stats, err := h.manager.GetRetention(c.Request().Context(), id)
+	if err != nil {
+		return c.JSON(tenantErrorStatus(err), dto.ErrorResponse{Error: err.Error()})
+	}
+
+	return c.JSON(http.StatusOK, dto.RetentionResponse{
+		RetentionPolicy: dto.NewRetentionPolicy(policy),
+		LastRunAt:       stats.LastRunAt,
+		LastRemoved:     stats.LastRemoved,
+		TotalRemoved:    stats.TotalRemoved,
+		LastError:       stats.LastError,
+	})
+}
+
+// UpdateRetention godoc
+// @Summary Update tenant retention policy
+// @Description Replaces the retention policy of a tenant. Processed and failed messages older than max_age (a duration such as 720h) or beyond the newest max_rows messages are removed by the retention job; omitted limits keep messages forever.
+// @Tags tenants
+// @Accept json
+// @Produce json
+// @Param id path string true "Tenant ID"
+// @Param request body dto.RetentionPolicy true "Retention policy"
+// @Success 200 {object} dto.RetentionPolicy
+// @Failure 400 {object} dto.ErrorResponse
+// @Failure 403 {object} dto.ErrorResponse
+// @Failure 404 {object} dto.ErrorResponse
+// @Failure 500 {object} dto.ErrorResponse
+// @Security BearerAuth
+// @Router /api/tenants/{id}/config/retention [put]
+func (h *TenantHandler) UpdateRetention(c echo.Context) error {
+	id, ok := tenantIDParam(c)
+	if !ok {
+		return invalidTenantID(c)
+	}
+	if !canAccessTenant(c, id) {
+		return forbidden(c)
+	}
+
+	var req dto.RetentionPolicy
+	if err := c.Bind(&req); err != nil || req.MaxRows < 0 {
+		return c.JSON(http.StatusBadRequest, dto.ErrorResponse{Error: "invalid request: 'max_rows' must not be negative"})
+	}
+
+	policy := domain.RetentionPolicy{MaxRows: req.MaxRows}
+	if req.MaxAge != "" {
+		maxAge, err := time.ParseDuration(req.MaxAge)
+		if err != nil || maxAge < time.Second {
+			return c.JSON(http.StatusBadRequest, dto.ErrorResponse{Error: "invalid request: 'max_age' must be a duration of at least 1s, e.g. 720h"})
+		}
+		policy.MaxAge = maxAge.Truncate(time.Second)
+	}
+
+	if err := h.manager.UpdateRetention(c.Request().Context(), id, policy); err != nil {
+		return c.JSON(tenantErrorStatus(err), dto.ErrorResponse{Error: err.Error()})
+	}
+	return c.JSON(http.StatusOK, dto.NewRetentionPolicy(policy))
+}
+
+// GetProcessors godoc
+// @Summary Get tenant processor chain
+// @Description Returns the processors a tenant's messages pass through, in order. Webhook secrets are not returned, only "secret_set": true.
+// @Tags tenants
+// @Produce json
+// @Param id path string true "Tenant ID"
+// @Success 200 {object} dto.ProcessorChain
+// @Failure 400 {object} dto.ErrorResponse
+// @Failure 403 {object} dto.ErrorResponse
+// @Failure 404 {object} dto.ErrorResponse
+// @Failure 500 {object} dto.ErrorResponse
+// @Security BearerAuth
+// @Router /api/tenants/{id}/config/processors [get]
+func (h *TenantHandler) GetProcessors(c echo.Context) error {
+	id, ok := tenantIDParam(c)
+	if !ok {
+		return invalidTenantID(c)
+	}
+	if !canAccessTenant(c, id) {
+		return forbidden(c)
+	}
+
+	processors, err := h.manager.GetProcessors(c.Request().Context(), id)
+	if err != nil {
+		return c.JSON(tenantErrorStatus(err), dto.ErrorResponse{Error: err.Error()})
+	}
+	return c.JSON(http.StatusOK, dto.ProcessorChain{Processors: processor.RedactSecrets(processors)})
+}
+
+// UpdateProcessors godoc
+// @Summary Update tenant processor chain
+// @Description Replaces the processors a tenant's messages pass through; workers switch to the new chain with their next message. Types are store (save the payload), transform (options rename, set and remove dot-separated paths), forward (options exchange and routing_key), drop (option match, dropping payloads containing it, or every message without) and webhook (options url, secret, timeout and max_attempts). The response leaves webhook secrets out like the GET does, so a chain read back needs its secrets set again before it is sent. Requires a platform admin, as forwarding can reach any queue.
+// @Tags tenants
+// @Accept json
+// @Produce json
+// @Param id path string true "Tenant ID"
+// @Param request body dto.ProcessorChain true "Processor chain"
+// @Success 200 {object} dto.ProcessorChain
+// @Failure 400 {object} dto.ErrorResponse
+// @Failure 403 {object} dto.ErrorResponse
+// @Failure 404 {object} dto.ErrorResponse
+// @Failure 500 {object} dto.ErrorResponse
+// @Security BearerAuth
+// @Router /api/tenants/{id}/config/processors [put]
+func (h *TenantHandler) UpdateProcessors(c echo.Context) error {
+	if !callerClaims(c).PlatformAdmin {
+		return adminOnly(c)
+	}
+	id, ok := tenantIDParam(c)
+	if !ok {
+		return invalidTenantID(c)
+	}
+
+	var req dto.ProcessorChain
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, dto.ErrorResponse{Error: "invalid request body"})
+	}
+
+	processors, err := h.manager.UpdateProcessors(c.Request().Context(), id, req.Processors)
+	if err != nil {
+		return c.JSON(tenantErrorStatus(err), dto.ErrorResponse{Error: err.Error()})
+	}
+	return c.JSON(http.StatusOK, dto.ProcessorChain{Processors: processor.RedactSecrets(processors)})
+}
+
+// tenantIDParam returns the tenant ID in the path in its canonical form, and false if
+// it is not a UUID.
+func tenantIDParam(c echo.Context) (string, bool) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		return "", false
+	}
+	return id.String(), true
+}
+
+func invalidTenantID(c echo.Context) error {
+	return c.JSON(http.StatusBadRequest, dto.ErrorResponse{Error: "invalid tenant id"})
+}
+
+// tenantErrorStatus maps manager errors to HTTP status codes
+func tenantErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, domain.ErrTenantNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, processor.ErrInvalidConfig):
+		return http.StatusBadRequest
+	case errors.Is(err, broker.ErrUnavailable):
+		return http.StatusServiceUnavailable
+	}
+	return http.StatusInternalServerError
+}
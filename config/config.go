@@ -10,10 +10,24 @@ import (
 type Config struct {
 	Server    ServerConfig
 	Database  DatabaseConfig
+	Storage   StorageConfig
+	Broker    BrokerConfig
 	RabbitMQ  RabbitMQConfig
-	JWTConfig JWTConfig
+	PgQueue   PgQueueConfig
+	JWTConfig JWTConfig `mapstructure:"jwt"`
+	Cursor    CursorConfig
+	Retry     RetryConfig
+	Scheduler SchedulerConfig
+	Outbox    OutboxConfig
+	Retention RetentionConfig
+	Webhook   WebhookConfig
 
-	Workers int
+	Workers  int
+	Prefetch int
+	// MaxWorkers and MaxPrefetch cap the concurrency a tenant may be given, zero
+	// meaning no limit
+	MaxWorkers  int
+	MaxPrefetch int
 }
 
 type ServerConfig struct {
@@ -24,13 +38,100 @@ type DatabaseConfig struct {
 	URL string
 }
 
+// StorageConfig selects where tenants and messages are stored: "postgres", at the
+// Database URL, or "memory", which keeps them in process and suits local development
+// and tests.
+type StorageConfig struct {
+	Type string
+}
+
+// BrokerConfig selects the message broker: "rabbitmq", configured under RabbitMQ,
+// "postgres", which keeps the queues in the database and is configured under PgQueue,
+// or "memory", which keeps the queues in process and suits local development and tests.
+type BrokerConfig struct {
+	Type string
+}
+
+// RabbitMQConfig holds the broker URL and how outages are handled. A lost
+// connection is re-dialed after ReconnectDelay, doubling up to MaxReconnectDelay;
+// meanwhile publishers wait up to PublishWait before failing. Publishes use up to
+// PublisherChannels pooled channels and wait up to ConfirmTimeout for the broker ack.
 type RabbitMQConfig struct {
-	URL string
+	URL               string
+	ReconnectDelay    time.Duration
+	MaxReconnectDelay time.Duration
+	PublishWait       time.Duration
+	PublisherChannels int
+	ConfirmTimeout    time.Duration
+}
+
+// PgQueueConfig tunes the postgres broker. A claimed message is delivered again when
+// its consumer did not settle it or extend its claim within VisibilityTimeout, e.g.
+// because the instance died. Consumers are woken through LISTEN/NOTIFY and look for
+// due messages every PollInterval besides.
+type PgQueueConfig struct {
+	VisibilityTimeout time.Duration
+	PollInterval      time.Duration
+}
+
+// RetryConfig is the policy applied to messages whose processing failed.
+// Retries wait InitialBackoff, doubling up to MaxBackoff; after MaxAttempts
+// failed attempts the message is dead-lettered.
+type RetryConfig struct {
+	MaxAttempts    int
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+}
+
+// SchedulerConfig controls the release of scheduled messages. Due messages are looked
+// for every Interval; one that could not be released is retried after RetryDelay.
+type SchedulerConfig struct {
+	Interval   time.Duration
+	RetryDelay time.Duration
+}
+
+// OutboxConfig controls the relay publishing stored messages. Pending entries are
+// looked for every Interval, BatchSize at a time, and leased for Lease while they are
+// published; one whose publish failed is retried after RetryDelay, and sent entries
+// are kept for Retention.
+type OutboxConfig struct {
+	Interval   time.Duration
+	BatchSize  int
+	Lease      time.Duration
+	RetryDelay time.Duration
+	Retention  time.Duration
+}
+
+// RetentionConfig controls the job applying the tenants' retention policies. Every
+// Interval it removes expired messages in batches of at most BatchSize rows.
+type RetentionConfig struct {
+	Interval  time.Duration
+	BatchSize int
 }
 
+// WebhookConfig holds the defaults of tenant webhooks. Each request times out after
+// Timeout; a delivery makes up to MaxAttempts requests, waiting InitialBackoff
+// between them, doubling up to MaxBackoff.
+type WebhookConfig struct {
+	Timeout        time.Duration
+	MaxAttempts    int
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+}
+
+// CursorConfig holds the key message pagination cursors are signed with. The JWT
+// secret is used when it is empty.
+type CursorConfig struct {
+	Secret string
+}
+
+// JWTConfig holds the key tokens are signed with and how long they are valid. Mock
+// logins asking for a platform admin token must present AdminSecret; admin logins are
+// refused when it is empty.
 type JWTConfig struct {
 	Secret         string
 	ExpirationTime time.Duration
+	AdminSecret    string
 }
 
 func LoadConfig() *Config {
@@ -38,6 +139,35 @@ func LoadConfig() *Config {
 	viper.SetConfigType("yaml")
 	viper.AddConfigPath("./config")
 
+	viper.SetDefault("storage.type", "postgres")
+	viper.SetDefault("broker.type", "rabbitmq")
+	viper.SetDefault("rabbitmq.reconnectDelay", time.Second)
+	viper.SetDefault("rabbitmq.maxReconnectDelay", 30*time.Second)
+	viper.SetDefault("rabbitmq.publishWait", 5*time.Second)
+	viper.SetDefault("rabbitmq.publisherChannels", 8)
+	viper.SetDefault("rabbitmq.confirmTimeout", 5*time.Second)
+	viper.SetDefault("pgQueue.visibilityTimeout", 30*time.Second)
+	viper.SetDefault("pgQueue.pollInterval", time.Second)
+	viper.SetDefault("prefetch", 10)
+	viper.SetDefault("maxWorkers", 64)
+	viper.SetDefault("maxPrefetch", 1000)
+	viper.SetDefault("retry.maxAttempts", 5)
+	viper.SetDefault("retry.initialBackoff", time.Second)
+	viper.SetDefault("retry.maxBackoff", time.Minute)
+	viper.SetDefault("scheduler.interval", time.Second)
+	viper.SetDefault("scheduler.retryDelay", 30*time.Second)
+	viper.SetDefault("outbox.interval", time.Second)
+	viper.SetDefault("outbox.batchSize", 100)
+	viper.SetDefault("outbox.lease", time.Minute)
+	viper.SetDefault("outbox.retryDelay", 5*time.Second)
+	viper.SetDefault("outbox.retention", time.Hour)
+	viper.SetDefault("retention.interval", time.Minute)
+	viper.SetDefault("retention.batchSize", 1000)
+	viper.SetDefault("webhook.timeout", 10*time.Second)
+	viper.SetDefault("webhook.maxAttempts", 3)
+	viper.SetDefault("webhook.initialBackoff", 500*time.Millisecond)
+	viper.SetDefault("webhook.maxBackoff", 5*time.Second)
+
 	if err := viper.ReadInConfig(); err != nil {
 		log.Fatalf("Error loading config: %v", err)
 	}
@@ -16,6 +16,171 @@ CREATE TABLE IF NOT EXISTS messages (
 	created_at TIMESTAMPTZ DEFAULT NOW(),
 	PRIMARY KEY (tenant_id, id)
 ) PARTITION BY LIST (tenant_id);
+
+CREATE TABLE IF NOT EXISTS tenants (
+	id UUID PRIMARY KEY,
+	name TEXT NOT NULL,
+	workers INT NOT NULL,
+	status TEXT NOT NULL DEFAULT 'active',
+	created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+	updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+ALTER TABLE tenants ADD COLUMN IF NOT EXISTS prefetch INT NOT NULL DEFAULT 0;
+
+-- Rows stored before statuses existed were written by the consumer, i.e. processed
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS status TEXT NOT NULL DEFAULT 'processed';
+ALTER TABLE messages ALTER COLUMN status SET DEFAULT 'queued';
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS attempts INT NOT NULL DEFAULT 0;
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS last_error TEXT;
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS updated_at TIMESTAMPTZ DEFAULT NOW();
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS processed_at TIMESTAMPTZ;
+
+-- Indexes on the partitioned table are created on every tenant partition, present and future
+CREATE INDEX IF NOT EXISTS messages_created_at_idx ON messages (tenant_id, created_at, id);
+CREATE INDEX IF NOT EXISTS messages_status_idx ON messages (tenant_id, status, created_at, id);
+CREATE INDEX IF NOT EXISTS messages_payload_idx ON messages USING GIN (payload jsonb_path_ops);
+
+-- One-off data migrations, recorded so they run once rather than on every startup
+CREATE TABLE IF NOT EXISTS schema_migrations (
+	version TEXT PRIMARY KEY,
+	applied_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+-- Payloads used to be stored as base64 encoded JSON strings; decode the ones that still are
+DO $$
+DECLARE
+	r RECORD;
+BEGIN
+	IF EXISTS (SELECT 1 FROM schema_migrations WHERE version = 'decode_base64_payloads') THEN
+		RETURN;
+	END IF;
+	FOR r IN SELECT tenant_id, id, payload #>> '{}' AS encoded FROM messages WHERE jsonb_typeof(payload) = 'string' LOOP
+		BEGIN
+			UPDATE messages
+			SET payload = convert_from(decode(r.encoded, 'base64'), 'UTF8')::jsonb
+			WHERE tenant_id = r.tenant_id AND id = r.id;
+		EXCEPTION WHEN others THEN
+			-- A genuine string payload, keep it
+		END;
+	END LOOP;
+	INSERT INTO schema_migrations (version) VALUES ('decode_base64_payloads') ON CONFLICT DO NOTHING;
+END $$;
+
+-- Partitions created before the tenant registry existed belong to tenants without a row,
+-- whose consumers would never be restored. Register them as active; zero workers and
+-- prefetch stand for the configured defaults
+DO $$
+BEGIN
+	IF EXISTS (SELECT 1 FROM schema_migrations WHERE version = 'register_partition_tenants') THEN
+		RETURN;
+	END IF;
+	INSERT INTO tenants (id, name, workers, prefetch, status)
+	SELECT id, id::text, 0, 0, 'active'
+	FROM (
+		SELECT replace(substring(c.relname FROM '^messages_tenant_(.+)$'), '_', '-')::uuid AS id
+		FROM pg_inherits i
+		JOIN pg_class c ON c.oid = i.inhrelid
+		WHERE i.inhparent = 'messages'::regclass AND c.relname LIKE 'messages\_tenant\_%'
+	) partitions
+	ON CONFLICT (id) DO NOTHING;
+	INSERT INTO schema_migrations (version) VALUES ('register_partition_tenants') ON CONFLICT DO NOTHING;
+END $$;
+
+CREATE TABLE IF NOT EXISTS scheduled_messages (
+	tenant_id UUID NOT NULL REFERENCES tenants (id) ON DELETE CASCADE,
+	id UUID NOT NULL,
+	payload JSONB,
+	deliver_at TIMESTAMPTZ NOT NULL,
+	attempts INT NOT NULL DEFAULT 0,
+	last_error TEXT,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+	PRIMARY KEY (tenant_id, id)
+);
+
+CREATE INDEX IF NOT EXISTS scheduled_messages_due_idx ON scheduled_messages (deliver_at);
+
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS priority SMALLINT NOT NULL DEFAULT 0;
+ALTER TABLE scheduled_messages ADD COLUMN IF NOT EXISTS priority SMALLINT NOT NULL DEFAULT 0;
+CREATE INDEX IF NOT EXISTS messages_priority_idx ON messages (tenant_id, priority, created_at, id);
+
+-- Zero means no limit
+ALTER TABLE tenants ADD COLUMN IF NOT EXISTS retention_max_age_seconds BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE tenants ADD COLUMN IF NOT EXISTS retention_max_rows BIGINT NOT NULL DEFAULT 0;
+
+CREATE TABLE IF NOT EXISTS message_schemas (
+	tenant_id UUID NOT NULL REFERENCES tenants (id) ON DELETE CASCADE,
+	version INT NOT NULL,
+	schema JSONB NOT NULL,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+	PRIMARY KEY (tenant_id, version)
+);
+
+-- The schema version a message was validated against, NULL when none applied
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS schema_version INT;
+ALTER TABLE scheduled_messages ADD COLUMN IF NOT EXISTS schema_version INT;
+
+ALTER TABLE tenants ADD COLUMN IF NOT EXISTS processors JSONB NOT NULL DEFAULT '[{"type": "store"}]';
+
+CREATE TABLE IF NOT EXISTS webhook_deliveries (
+	id BIGSERIAL PRIMARY KEY,
+	tenant_id UUID NOT NULL REFERENCES tenants (id) ON DELETE CASCADE,
+	message_id UUID NOT NULL,
+	attempt INT NOT NULL,
+	url TEXT NOT NULL,
+	status_code INT,
+	error TEXT,
+	duration_ms BIGINT NOT NULL,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS webhook_deliveries_message_idx ON webhook_deliveries (tenant_id, message_id, id);
+
+-- Messages waiting to be published, written in the same transaction as the message
+CREATE TABLE IF NOT EXISTS outbox (
+	id BIGSERIAL PRIMARY KEY,
+	tenant_id UUID NOT NULL REFERENCES tenants (id) ON DELETE CASCADE,
+	message_id UUID NOT NULL,
+	payload JSONB,
+	priority SMALLINT NOT NULL DEFAULT 0,
+	attempts INT NOT NULL DEFAULT 0,
+	last_error TEXT,
+	available_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+	created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+	sent_at TIMESTAMPTZ
+);
+
+CREATE INDEX IF NOT EXISTS outbox_pending_idx ON outbox (available_at, id) WHERE sent_at IS NULL;
+CREATE INDEX IF NOT EXISTS outbox_sent_idx ON outbox (sent_at) WHERE sent_at IS NOT NULL;
+
+-- Tenant queues of the postgres broker, one partition per tenant. Claimed jobs are
+-- locked_by their consumer until locked_until; dead-lettered jobs stay, marked dead_at
+CREATE TABLE IF NOT EXISTS queue_jobs (
+	tenant_id UUID NOT NULL,
+	id BIGSERIAL,
+	message_id TEXT NOT NULL,
+	body BYTEA NOT NULL,
+	priority SMALLINT NOT NULL DEFAULT 0,
+	published_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+	attempts INT NOT NULL DEFAULT 0,
+	available_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+	locked_by UUID,
+	locked_until TIMESTAMPTZ,
+	dead_at TIMESTAMPTZ,
+	last_error TEXT,
+	PRIMARY KEY (tenant_id, id)
+) PARTITION BY LIST (tenant_id);
+
+CREATE INDEX IF NOT EXISTS queue_jobs_ready_idx ON queue_jobs (tenant_id, priority DESC, id) WHERE dead_at IS NULL;
+CREATE INDEX IF NOT EXISTS queue_jobs_dead_idx ON queue_jobs (tenant_id, message_id, dead_at) WHERE dead_at IS NOT NULL;
+
+-- Processed messages are numbered per tenant from a counter on the tenant row. Its row lock
+-- serializes the transactions drawing numbers, so a number is only visible once every lower
+-- one is, which processed_at does not guarantee. The live stream resumes by this number.
+ALTER TABLE tenants ADD COLUMN IF NOT EXISTS processed_seq BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS processed_seq BIGINT;
+CREATE INDEX IF NOT EXISTS messages_processed_seq_idx ON messages (tenant_id, processed_seq) WHERE processed_seq IS NOT NULL;
+DROP INDEX IF EXISTS messages_processed_at_idx;
 `
 	_, err := pool.Exec(context.Background(), schema)
 	if err != nil {
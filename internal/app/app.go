@@ -3,8 +3,13 @@ package app
 import (
 	"context"
 	"errors"
+	"fmt"
 	"github.com/fekalegi/multi-tenant-system/internal/auth"
+	"github.com/fekalegi/multi-tenant-system/internal/broker"
 	"github.com/fekalegi/multi-tenant-system/internal/message"
+	"github.com/fekalegi/multi-tenant-system/internal/pgqueue"
+	"github.com/fekalegi/multi-tenant-system/internal/processor"
+	"github.com/fekalegi/multi-tenant-system/internal/repository/memory"
 	message2 "github.com/fekalegi/multi-tenant-system/internal/repository/postgresql"
 	"net/http"
 	"os"
@@ -18,33 +23,77 @@ import (
 	"github.com/fekalegi/multi-tenant-system/internal/server"
 	"github.com/fekalegi/multi-tenant-system/internal/tenant"
 	"github.com/fekalegi/multi-tenant-system/pkg/logger"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/rs/zerolog"
 )
 
 func Start(cfg *config.Config) {
 	// Logger
 	log := logger.New()
 
-	// DB
-	dbPool := db.NewPostgres(cfg.Database.URL, log)
+	// Storage
+	dbPool, tenantRepo, messageRepo, err := newStorage(cfg, log)
+	if err != nil {
+		log.Fatal().Err(err).Msg("failed to set up the storage")
+	}
 
-	// Migrate
-	err := db.RunMigrations(dbPool)
+	// Broker
+	retryPolicy := broker.RetryPolicy{
+		MaxAttempts:    cfg.Retry.MaxAttempts,
+		InitialBackoff: cfg.Retry.InitialBackoff,
+		MaxBackoff:     cfg.Retry.MaxBackoff,
+	}
+	b, err := newBroker(cfg, dbPool, retryPolicy, log)
 	if err != nil {
-		log.Fatal().Err(err).Msg("failed to run migrations")
+		log.Fatal().Err(err).Msg("failed to set up the broker")
 	}
 
-	// RabbitMQ
-	rmq := rabbitmq.NewConnection(cfg.RabbitMQ.URL, log)
+	// Processors tenant chains are built from
+	processors := processor.NewRegistry(processor.Dependencies{
+		Messages:   messageRepo,
+		Forwarder:  b,
+		Deliveries: messageRepo,
+		Webhook: processor.WebhookDefaults{
+			Timeout: cfg.Webhook.Timeout,
+			Retry: broker.RetryPolicy{
+				MaxAttempts:    cfg.Webhook.MaxAttempts,
+				InitialBackoff: cfg.Webhook.InitialBackoff,
+				MaxBackoff:     cfg.Webhook.MaxBackoff,
+			},
+		},
+		Log: log,
+	})
 
 	// TenantManager
-	manager := tenant.NewTenantService(rmq, dbPool, log, cfg.Workers)
-
-	// Publisher
-	publisher := rabbitmq.NewPublisher(rmq, log)
+	manager := tenant.NewTenantService(b, tenantRepo, messageRepo, log, cfg.Workers, cfg.Prefetch, retryPolicy, processors)
+	if err := manager.RestoreTenants(context.Background()); err != nil {
+		log.Fatal().Err(err).Msg("failed to restore tenants")
+	}
 
 	// Message Service
-	messageRepo := message2.NewMessageRepository(dbPool)
-	messageService := message.NewService(publisher, messageRepo)
+	cursorSecret := cfg.Cursor.Secret
+	if cursorSecret == "" {
+		cursorSecret = cfg.JWTConfig.Secret
+	}
+	messageService := message.NewService(b, messageRepo, cursorSecret, log)
+	backgroundCtx, stopBackground := context.WithCancel(context.Background())
+	defer stopBackground()
+	go messageService.RunStream(backgroundCtx)
+	go messageService.RunScheduler(backgroundCtx, message.SchedulerOptions{
+		Interval:   cfg.Scheduler.Interval,
+		RetryDelay: cfg.Scheduler.RetryDelay,
+	})
+	go messageService.RunOutboxRelay(backgroundCtx, message.OutboxOptions{
+		Interval:   cfg.Outbox.Interval,
+		BatchSize:  cfg.Outbox.BatchSize,
+		Lease:      cfg.Outbox.Lease,
+		RetryDelay: cfg.Outbox.RetryDelay,
+		Retention:  cfg.Outbox.Retention,
+	})
+	go manager.RunRetention(backgroundCtx, tenant.RetentionOptions{
+		Interval:  cfg.Retention.Interval,
+		BatchSize: cfg.Retention.BatchSize,
+	})
 
 	// JWT Manager
 	jwtManager := auth.NewJWTManager(cfg.JWTConfig.Secret, cfg.JWTConfig.ExpirationTime)
@@ -73,18 +122,78 @@ func Start(cfg *config.Config) {
 	ctxTimeout, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
-	// 1. Stop the HTTP server
+	// 1. Stop the HTTP server, ending the live streams first so their connections close,
+	// and the scheduler, outbox relay and retention job
+	stopBackground()
 	if err := srv.Stop(ctxTimeout); err != nil {
 		log.Warn().Err(err).Msg("HTTP server shutdown error")
 	}
 	log.Info().Msg("HTTP server stopped")
 
-	// 2. Stop the tenant consumers using the new function
-	manager.ShutdownConsumers(ctxTimeout) // Pass the timeout context
-	log.Info().Msg("Tenant consumers stopped")
+	// 2. Drain the tenant consumers before their connections go away
+	if err := manager.ShutdownConsumers(ctxTimeout); err != nil {
+		log.Warn().Err(err).Msg("Tenant consumers did not drain cleanly, unfinished messages were requeued")
+	} else {
+		log.Info().Msg("Tenant consumers stopped")
+	}
 
 	// 3. Close connections
-	rmq.Close()
-	dbPool.Close()
+	b.Close()
+	if dbPool != nil {
+		dbPool.Close()
+	}
 	log.Info().Msg("Connections closed. Shutdown complete.")
 }
+
+// newStorage opens the tenant and message repositories selected in the configuration.
+// The pool is nil when nothing is stored in PostgreSQL.
+func newStorage(cfg *config.Config, log zerolog.Logger) (*pgxpool.Pool, message2.TenantRepository, message2.MessageRepository, error) {
+	switch cfg.Storage.Type {
+	case "postgres":
+		dbPool := db.NewPostgres(cfg.Database.URL, log)
+		if err := db.RunMigrations(dbPool); err != nil {
+			return nil, nil, nil, fmt.Errorf("failed to run migrations: %w", err)
+		}
+		return dbPool, message2.NewTenantRepository(dbPool), message2.NewMessageRepository(dbPool), nil
+
+	case "memory":
+		log.Warn().Msg("Using the in-memory storage, tenants and messages are lost on restart and not shared between instances")
+		store := memory.NewStore()
+		return nil, memory.NewTenantRepository(store), memory.NewMessageRepository(store), nil
+	}
+	return nil, nil, nil, fmt.Errorf("unknown storage type %q, must be postgres or memory", cfg.Storage.Type)
+}
+
+// newBroker connects to the broker selected in the configuration.
+func newBroker(cfg *config.Config, dbPool *pgxpool.Pool, retry broker.RetryPolicy, log zerolog.Logger) (broker.Broker, error) {
+	switch cfg.Broker.Type {
+	case "rabbitmq":
+		rmq, err := rabbitmq.NewConnection(cfg.RabbitMQ.URL, rabbitmq.ReconnectPolicy{
+			InitialDelay: cfg.RabbitMQ.ReconnectDelay,
+			MaxDelay:     cfg.RabbitMQ.MaxReconnectDelay,
+		}, log)
+		if err != nil {
+			return nil, err
+		}
+		publisher := rabbitmq.NewPublisher(rmq, rabbitmq.PublisherOptions{
+			PoolSize:       cfg.RabbitMQ.PublisherChannels,
+			Wait:           cfg.RabbitMQ.PublishWait,
+			ConfirmTimeout: cfg.RabbitMQ.ConfirmTimeout,
+		}, log)
+		return rabbitmq.NewBroker(rmq, publisher, retry, log), nil
+
+	case "postgres":
+		if dbPool == nil {
+			return nil, errors.New("the postgres broker needs storage type postgres")
+		}
+		return pgqueue.NewBroker(dbPool, pgqueue.Options{
+			VisibilityTimeout: cfg.PgQueue.VisibilityTimeout,
+			PollInterval:      cfg.PgQueue.PollInterval,
+		}, log), nil
+
+	case "memory":
+		log.Warn().Msg("Using the in-memory broker, queued messages are lost on restart and not shared between instances")
+		return broker.NewMemory(), nil
+	}
+	return nil, fmt.Errorf("unknown broker type %q, must be rabbitmq, postgres or memory", cfg.Broker.Type)
+}
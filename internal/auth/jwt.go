@@ -6,12 +6,27 @@ import (
 	"github.com/golang-jwt/jwt/v5"
 )
 
+// Keys under which the authenticated caller is stored in the echo context.
+const (
+	ContextUserIDKey   = "user_id"
+	ContextTenantIDKey = "tenant_id"
+	ContextClaimsKey   = "claims"
+)
+
+// Claims identifies the caller. Every caller is scoped to the tenant in TenantID;
+// only platform admins may act across tenants.
 type Claims struct {
-	UserID   string `json:"user_id"`
-	TenantID string `json:"tenant_id"`
+	UserID        string `json:"user_id"`
+	TenantID      string `json:"tenant_id"`
+	PlatformAdmin bool   `json:"platform_admin,omitempty"`
 	jwt.RegisteredClaims
 }
 
+// CanAccessTenant reports whether the caller may act on the given tenant.
+func (c *Claims) CanAccessTenant(tenantID string) bool {
+	return c.PlatformAdmin || (c.TenantID != "" && c.TenantID == tenantID)
+}
+
 type JWTManager struct {
 	secretKey     string
 	tokenDuration time.Duration
@@ -24,10 +39,11 @@ func NewJWTManager(secret string, duration time.Duration) *JWTManager {
 	}
 }
 
-func (j *JWTManager) Generate(userID, tenantID string) (string, error) {
+func (j *JWTManager) Generate(userID, tenantID string, platformAdmin bool) (string, error) {
 	claims := Claims{
-		UserID:   userID,
-		TenantID: tenantID,
+		UserID:        userID,
+		TenantID:      tenantID,
+		PlatformAdmin: platformAdmin,
 		RegisteredClaims: jwt.RegisteredClaims{
 			ExpiresAt: jwt.NewNumericDate(time.Now().Add(j.tokenDuration)),
 		},
@@ -0,0 +1,147 @@
+// Package broker defines the message broker tenant queues live on. The AMQP
+// implementation is in the rabbitmq package and the PostgreSQL one in pgqueue; Memory
+// keeps everything in process.
+package broker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/fekalegi/multi-tenant-system/internal/domain"
+)
+
+var (
+	// ErrUnroutable is returned when no queue exists for a message, e.g. an unknown tenant.
+	ErrUnroutable = errors.New("broker: message unroutable")
+	// ErrUnavailable is returned while the broker cannot be reached.
+	ErrUnavailable = errors.New("broker: unavailable")
+)
+
+// Broker moves messages from publishers to the consumers of tenant queues. Every tenant
+// has one queue, delivering messages of a higher priority first, together with a place
+// for failed messages to wait for their retry and a dead-letter queue.
+type Broker interface {
+	// DeclareTenantQueue makes sure the queues of a tenant exist. It is idempotent.
+	DeclareTenantQueue(ctx context.Context, tenantID string) error
+	// DeleteTenantQueue removes the queues of a tenant together with their messages.
+	DeleteTenantQueue(ctx context.Context, tenantID string) error
+
+	// Publish publishes a message to a tenant queue and returns once the broker took it.
+	Publish(ctx context.Context, tenantID string, msg Message) error
+	// PublishBatch publishes messages to a tenant queue and returns one error per
+	// message, nil for those the broker took.
+	PublishBatch(ctx context.Context, tenantID string, msgs []Message) []error
+	// Forward publishes a message to an exchange with a routing key; with the default
+	// exchange "" the routing key names the queue. It fails with ErrUnroutable when no
+	// queue takes the message.
+	Forward(ctx context.Context, exchange, routingKey, messageID string, body []byte, priority uint8) error
+
+	// Subscribe opens a subscription to a tenant queue.
+	Subscribe(tenantID string) (Subscription, error)
+	// Inspect returns the state of a tenant queue.
+	Inspect(ctx context.Context, tenantID string) (QueueStats, error)
+	// DeadLetters manages the messages parked in the tenants' dead-letter queues.
+	DeadLetters() DeadLetters
+
+	// WaitReady blocks until the broker can be reached or ctx expires.
+	WaitReady(ctx context.Context) error
+	// OnReconnect registers a hook run after the connection to the broker was
+	// re-established, which may have lost the queues declared before.
+	OnReconnect(fn func())
+	// Close releases the connection to the broker.
+	Close()
+}
+
+// Message is a message published to a tenant queue.
+type Message struct {
+	ID       string
+	Body     []byte
+	Priority uint8
+	// Timestamp is when the message was first published
+	Timestamp time.Time
+}
+
+// Subscription receives the deliveries of a tenant queue. It is used by one goroutine.
+type Subscription interface {
+	// Consume starts receiving deliveries, holding at most prefetch of them unsettled.
+	// The channel is closed once Cancel handed over what was already sent, or when
+	// the subscription is lost.
+	Consume(prefetch int) (<-chan *Delivery, error)
+	// Cancel stops the deliveries of the current Consume. Deliveries already received
+	// can still be settled, and Consume may be called again.
+	Cancel() error
+	// Close ends the subscription; deliveries not settled yet are requeued.
+	Close() error
+}
+
+// Delivery is a message received from a tenant queue. It must be settled exactly once
+// with Ack, Nack, Retry or DeadLetter.
+type Delivery struct {
+	Message
+	// Attempts is how many times processing of the message failed before
+	Attempts int
+
+	Acknowledger Acknowledger
+}
+
+// Acknowledger settles deliveries on the broker they came from.
+type Acknowledger interface {
+	Ack(d *Delivery) error
+	Nack(d *Delivery) error
+	Retry(d *Delivery, delay time.Duration) error
+	DeadLetter(d *Delivery, cause error) error
+}
+
+// Ack removes the message from the queue.
+func (d *Delivery) Ack() error {
+	return d.Acknowledger.Ack(d)
+}
+
+// Nack returns the message to the queue to be delivered again right away, without
+// counting an attempt.
+func (d *Delivery) Nack() error {
+	return d.Acknowledger.Nack(d)
+}
+
+// Retry counts a failed attempt and delivers the message again after delay. The AMQP
+// broker only supports the delays of its retry policy.
+func (d *Delivery) Retry(delay time.Duration) error {
+	return d.Acknowledger.Retry(d, delay)
+}
+
+// DeadLetter counts a failed attempt and parks the message in the dead-letter queue
+// with the cause of the failure.
+func (d *Delivery) DeadLetter(cause error) error {
+	return d.Acknowledger.DeadLetter(d, cause)
+}
+
+// QueueStats is the state of a tenant queue.
+type QueueStats struct {
+	// Messages is the number of messages ready to be delivered
+	Messages int
+	// Consumers is the number of subscriptions consuming the queue
+	Consumers int
+}
+
+// DeadLetters browses and manages dead-lettered messages.
+type DeadLetters interface {
+	// List returns up to limit dead-lettered messages of a tenant, oldest first.
+	List(tenantID string, limit int) ([]*domain.DeadLetter, error)
+	// Get returns a single dead-lettered message by its message ID.
+	Get(tenantID, messageID string) (*domain.DeadLetter, error)
+	// Delete permanently removes a dead-lettered message.
+	Delete(tenantID, messageID string) error
+	// Replay moves a dead-lettered message back onto the tenant queue with a fresh
+	// attempt count.
+	Replay(tenantID, messageID string) error
+	// ReplayAll moves every dead-lettered message of a tenant back onto the tenant
+	// queue and returns how many were replayed.
+	ReplayAll(tenantID string) (int, error)
+}
+
+// TenantQueue names the queue of a tenant.
+func TenantQueue(tenantID string) string {
+	return fmt.Sprintf("tenant_%s_queue", tenantID)
+}
@@ -0,0 +1,518 @@
+package broker
+
+import (
+	"container/heap"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/fekalegi/multi-tenant-system/internal/domain"
+)
+
+var (
+	errMemoryClosed = errors.New("memory broker: closed")
+	errNotHeld      = errors.New("memory broker: delivery already settled")
+)
+
+// Memory is a Broker keeping its queues in process memory. Messages are lost on restart
+// and not shared between instances, so it suits local development and tests. There are
+// no exchanges besides the default one, through which Forward reaches a tenant queue
+// by its name.
+type Memory struct {
+	mu     sync.Mutex
+	queues map[string]*memoryQueue
+	seq    uint64
+	closed bool
+}
+
+func NewMemory() *Memory {
+	return &Memory{queues: make(map[string]*memoryQueue)}
+}
+
+// memoryMessage is a message waiting in a queue. seq keeps messages of the same
+// priority in publish order.
+type memoryMessage struct {
+	msg      Message
+	attempts int
+	seq      uint64
+}
+
+// messageHeap orders messages by priority, highest first, then by seq.
+type messageHeap []*memoryMessage
+
+func (h messageHeap) Len() int { return len(h) }
+func (h messageHeap) Less(i, j int) bool {
+	if h[i].msg.Priority != h[j].msg.Priority {
+		return h[i].msg.Priority > h[j].msg.Priority
+	}
+	return h[i].seq < h[j].seq
+}
+func (h messageHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+func (h *messageHeap) Push(x any)   { *h = append(*h, x.(*memoryMessage)) }
+func (h *messageHeap) Pop() any {
+	old := *h
+	last := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return last
+}
+
+// deadMessage is a message parked in a dead-letter queue.
+type deadMessage struct {
+	msg      Message
+	attempts int
+	cause    string
+	failedAt time.Time
+}
+
+type memoryQueue struct {
+	tenantID  string
+	ready     messageHeap
+	dead      []*deadMessage
+	consumers int
+	// changed is closed and replaced whenever consumers may be able to take a message
+	changed chan struct{}
+	deleted bool
+}
+
+// notify wakes the consumers of the queue. Callers must hold Memory.mu.
+func (q *memoryQueue) notify() {
+	close(q.changed)
+	q.changed = make(chan struct{})
+}
+
+// enqueue makes a message ready for delivery. Callers must hold m.mu.
+func (m *Memory) enqueue(q *memoryQueue, msg Message, attempts int) {
+	m.seq++
+	heap.Push(&q.ready, &memoryMessage{msg: msg, attempts: attempts, seq: m.seq})
+	q.notify()
+}
+
+func (m *Memory) DeclareTenantQueue(ctx context.Context, tenantID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.queues[tenantID]; !ok {
+		m.queues[tenantID] = &memoryQueue{tenantID: tenantID, changed: make(chan struct{})}
+	}
+	return nil
+}
+
+// DeleteTenantQueue removes the queue of a tenant; its consumers lose their subscription.
+func (m *Memory) DeleteTenantQueue(ctx context.Context, tenantID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if q, ok := m.queues[tenantID]; ok {
+		q.deleted = true
+		q.notify()
+		delete(m.queues, tenantID)
+	}
+	return nil
+}
+
+func (m *Memory) Publish(ctx context.Context, tenantID string, msg Message) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.closed {
+		return errMemoryClosed
+	}
+	q, ok := m.queues[tenantID]
+	if !ok {
+		return ErrUnroutable
+	}
+	if msg.Timestamp.IsZero() {
+		msg.Timestamp = time.Now()
+	}
+	m.enqueue(q, msg, 0)
+	return nil
+}
+
+func (m *Memory) PublishBatch(ctx context.Context, tenantID string, msgs []Message) []error {
+	errs := make([]error, len(msgs))
+	for i, msg := range msgs {
+		errs[i] = m.Publish(ctx, tenantID, msg)
+	}
+	return errs
+}
+
+// Forward publishes a message to the tenant queue named by routingKey. Exchanges other
+// than the default one do not exist.
+func (m *Memory) Forward(ctx context.Context, exchange, routingKey, messageID string, body []byte, priority uint8) error {
+	if exchange != "" {
+		return fmt.Errorf("%w: the memory broker has no exchange %q", ErrUnroutable, exchange)
+	}
+
+	m.mu.Lock()
+	var tenantID string
+	for id := range m.queues {
+		if TenantQueue(id) == routingKey {
+			tenantID = id
+			break
+		}
+	}
+	m.mu.Unlock()
+
+	if tenantID == "" {
+		return ErrUnroutable
+	}
+	return m.Publish(ctx, tenantID, Message{ID: messageID, Body: body, Priority: priority})
+}
+
+func (m *Memory) Subscribe(tenantID string) (Subscription, error) {
+	return &memorySubscription{
+		m:         m,
+		tenantID:  tenantID,
+		unsettled: make(map[*Delivery]*memoryMessage),
+	}, nil
+}
+
+func (m *Memory) Inspect(ctx context.Context, tenantID string) (QueueStats, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	q, ok := m.queues[tenantID]
+	if !ok {
+		return QueueStats{}, fmt.Errorf("memory broker: no queue for tenant %s", tenantID)
+	}
+	return QueueStats{Messages: q.ready.Len(), Consumers: q.consumers}, nil
+}
+
+func (m *Memory) DeadLetters() DeadLetters {
+	return memoryDeadLetters{m: m}
+}
+
+// WaitReady returns at once, the memory broker is reachable until it is closed.
+func (m *Memory) WaitReady(ctx context.Context) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.closed {
+		return errMemoryClosed
+	}
+	return nil
+}
+
+// OnReconnect does nothing, the memory broker never reconnects.
+func (m *Memory) OnReconnect(fn func()) {}
+
+// Close ends every subscription and rejects further publishes.
+func (m *Memory) Close() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.closed = true
+	for _, q := range m.queues {
+		q.notify()
+	}
+}
+
+// memorySubscription consumes a tenant queue of a Memory broker and settles its deliveries.
+type memorySubscription struct {
+	m        *Memory
+	tenantID string
+
+	// Guarded by m.mu
+	unsettled map[*Delivery]*memoryMessage
+	prefetch  int
+	stop      chan struct{}
+	done      chan struct{}
+	closed    bool
+}
+
+func (s *memorySubscription) Consume(prefetch int) (<-chan *Delivery, error) {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+
+	switch {
+	case s.m.closed:
+		return nil, errMemoryClosed
+	case s.closed:
+		return nil, errors.New("memory broker: subscription closed")
+	case s.stop != nil:
+		return nil, errors.New("memory broker: subscription already consuming")
+	}
+	q, ok := s.m.queues[s.tenantID]
+	if !ok {
+		return nil, fmt.Errorf("memory broker: no queue for tenant %s", s.tenantID)
+	}
+
+	s.prefetch = prefetch
+	s.stop = make(chan struct{})
+	s.done = make(chan struct{})
+	q.consumers++
+
+	deliveries := make(chan *Delivery)
+	go s.deliver(q, s.stop, s.done, deliveries)
+	return deliveries, nil
+}
+
+// deliver hands ready messages to the consumer while it holds fewer than prefetch
+// unsettled deliveries, until the consume is cancelled or the queue goes away.
+func (s *memorySubscription) deliver(q *memoryQueue, stop, done chan struct{}, deliveries chan<- *Delivery) {
+	defer close(done)
+	defer close(deliveries)
+	defer func() {
+		s.m.mu.Lock()
+		q.consumers--
+		s.m.mu.Unlock()
+	}()
+
+	for {
+		s.m.mu.Lock()
+		if q.deleted || s.m.closed {
+			s.m.mu.Unlock()
+			return
+		}
+		var d *Delivery
+		if (s.prefetch <= 0 || len(s.unsettled) < s.prefetch) && q.ready.Len() > 0 {
+			next := heap.Pop(&q.ready).(*memoryMessage)
+			d = &Delivery{Message: next.msg, Attempts: next.attempts, Acknowledger: s}
+			s.unsettled[d] = next
+		}
+		changed := q.changed
+		s.m.mu.Unlock()
+
+		if d == nil {
+			select {
+			case <-changed:
+				continue
+			case <-stop:
+				return
+			}
+		}
+
+		select {
+		case deliveries <- d:
+		case <-stop:
+			// Never handed over, put it back where it was
+			s.m.mu.Lock()
+			if next, ok := s.unsettled[d]; ok {
+				delete(s.unsettled, d)
+				heap.Push(&q.ready, next)
+				q.notify()
+			}
+			s.m.mu.Unlock()
+			return
+		}
+	}
+}
+
+func (s *memorySubscription) Cancel() error {
+	s.m.mu.Lock()
+	stop, done := s.stop, s.done
+	s.stop, s.done = nil, nil
+	s.m.mu.Unlock()
+
+	if stop != nil {
+		close(stop)
+		<-done
+	}
+	return nil
+}
+
+// Close cancels the consume and requeues the deliveries not settled yet.
+func (s *memorySubscription) Close() error {
+	_ = s.Cancel()
+
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+
+	s.closed = true
+	q, ok := s.m.queues[s.tenantID]
+	for d, next := range s.unsettled {
+		delete(s.unsettled, d)
+		if ok {
+			heap.Push(&q.ready, next)
+		}
+	}
+	if ok {
+		q.notify()
+	}
+	return nil
+}
+
+// settle releases a delivery and returns its message with the queue it came from, nil
+// when the queue was deleted in the meantime. Callers must hold m.mu.
+func (s *memorySubscription) settle(d *Delivery) (*memoryMessage, *memoryQueue, error) {
+	next, ok := s.unsettled[d]
+	if !ok {
+		return nil, nil, errNotHeld
+	}
+	delete(s.unsettled, d)
+
+	q := s.m.queues[s.tenantID]
+	if q != nil {
+		// Room for another delivery within the prefetch
+		q.notify()
+	}
+	return next, q, nil
+}
+
+func (s *memorySubscription) Ack(d *Delivery) error {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+
+	_, _, err := s.settle(d)
+	return err
+}
+
+// Nack puts the message back at its place in the queue.
+func (s *memorySubscription) Nack(d *Delivery) error {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+
+	next, q, err := s.settle(d)
+	if err != nil || q == nil {
+		return err
+	}
+	heap.Push(&q.ready, next)
+	q.notify()
+	return nil
+}
+
+// Retry queues the message again once delay passed, behind the messages of the same
+// priority published in the meantime.
+func (s *memorySubscription) Retry(d *Delivery, delay time.Duration) error {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+
+	next, q, err := s.settle(d)
+	if err != nil || q == nil {
+		return err
+	}
+
+	time.AfterFunc(delay, func() {
+		s.m.mu.Lock()
+		defer s.m.mu.Unlock()
+
+		// The tenant may have been deleted, or recreated with a new queue
+		if s.m.queues[s.tenantID] == q && !s.m.closed {
+			s.m.enqueue(q, next.msg, next.attempts+1)
+		}
+	})
+	return nil
+}
+
+func (s *memorySubscription) DeadLetter(d *Delivery, cause error) error {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+
+	next, q, err := s.settle(d)
+	if err != nil || q == nil {
+		return err
+	}
+	q.dead = append(q.dead, &deadMessage{
+		msg:      next.msg,
+		attempts: next.attempts + 1,
+		cause:    cause.Error(),
+		failedAt: time.Now(),
+	})
+	return nil
+}
+
+// memoryDeadLetters manages the dead-letter queues of a Memory broker.
+type memoryDeadLetters struct {
+	m *Memory
+}
+
+func (l memoryDeadLetters) List(tenantID string, limit int) ([]*domain.DeadLetter, error) {
+	l.m.mu.Lock()
+	defer l.m.mu.Unlock()
+
+	letters := []*domain.DeadLetter{}
+	if q, ok := l.m.queues[tenantID]; ok {
+		for _, dead := range q.dead {
+			if len(letters) >= limit {
+				break
+			}
+			letters = append(letters, toDeadLetter(tenantID, dead))
+		}
+	}
+	return letters, nil
+}
+
+func (l memoryDeadLetters) Get(tenantID, messageID string) (*domain.DeadLetter, error) {
+	l.m.mu.Lock()
+	defer l.m.mu.Unlock()
+
+	q, i := l.find(tenantID, messageID)
+	if q == nil {
+		return nil, domain.ErrDeadLetterNotFound
+	}
+	return toDeadLetter(tenantID, q.dead[i]), nil
+}
+
+func (l memoryDeadLetters) Delete(tenantID, messageID string) error {
+	l.m.mu.Lock()
+	defer l.m.mu.Unlock()
+
+	q, i := l.find(tenantID, messageID)
+	if q == nil {
+		return domain.ErrDeadLetterNotFound
+	}
+	q.dead = slices.Delete(q.dead, i, i+1)
+	return nil
+}
+
+func (l memoryDeadLetters) Replay(tenantID, messageID string) error {
+	l.m.mu.Lock()
+	defer l.m.mu.Unlock()
+
+	q, i := l.find(tenantID, messageID)
+	if q == nil {
+		return domain.ErrDeadLetterNotFound
+	}
+	l.m.enqueue(q, q.dead[i].msg, 0)
+	q.dead = slices.Delete(q.dead, i, i+1)
+	return nil
+}
+
+func (l memoryDeadLetters) ReplayAll(tenantID string) (int, error) {
+	l.m.mu.Lock()
+	defer l.m.mu.Unlock()
+
+	q, ok := l.m.queues[tenantID]
+	if !ok {
+		return 0, nil
+	}
+	replayed := len(q.dead)
+	for _, dead := range q.dead {
+		l.m.enqueue(q, dead.msg, 0)
+	}
+	q.dead = nil
+	return replayed, nil
+}
+
+// find returns the queue holding a dead-lettered message and its index, or a nil
+// queue. Callers must hold m.mu.
+func (l memoryDeadLetters) find(tenantID, messageID string) (*memoryQueue, int) {
+	q, ok := l.m.queues[tenantID]
+	if !ok {
+		return nil, 0
+	}
+	i := slices.IndexFunc(q.dead, func(dead *deadMessage) bool { return dead.msg.ID == messageID })
+	if i < 0 {
+		return nil, 0
+	}
+	return q, i
+}
+
+func toDeadLetter(tenantID string, dead *deadMessage) *domain.DeadLetter {
+	letter := &domain.DeadLetter{
+		ID:       dead.msg.ID,
+		TenantID: tenantID,
+		Attempts: dead.attempts,
+		Payload:  dead.msg.Body,
+		Error:    dead.cause,
+		FailedAt: dead.failedAt,
+	}
+	if !json.Valid(dead.msg.Body) {
+		letter.Payload, _ = json.Marshal(string(dead.msg.Body))
+	}
+	return letter
+}
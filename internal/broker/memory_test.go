@@ -0,0 +1,185 @@
+package broker_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/fekalegi/multi-tenant-system/internal/broker"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+// newQueue declares a tenant queue on a new memory broker.
+func newQueue(t *testing.T) (*broker.Memory, string) {
+	b := broker.NewMemory()
+	t.Cleanup(b.Close)
+
+	tenantID := uuid.NewString()
+	require.NoError(t, b.DeclareTenantQueue(context.Background(), tenantID))
+	return b, tenantID
+}
+
+// consume subscribes to a tenant queue, closing the subscription when the test ends.
+func consume(t *testing.T, b *broker.Memory, tenantID string, prefetch int) (broker.Subscription, <-chan *broker.Delivery) {
+	sub, err := b.Subscribe(tenantID)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = sub.Close() })
+
+	deliveries, err := sub.Consume(prefetch)
+	require.NoError(t, err)
+	return sub, deliveries
+}
+
+func publish(t *testing.T, b *broker.Memory, tenantID, id string, priority uint8) {
+	require.NoError(t, b.Publish(context.Background(), tenantID, broker.Message{ID: id, Body: []byte(`{}`), Priority: priority}))
+}
+
+func next(t *testing.T, deliveries <-chan *broker.Delivery) *broker.Delivery {
+	select {
+	case d, ok := <-deliveries:
+		require.True(t, ok, "deliveries should stay open")
+		return d
+	case <-time.After(5 * time.Second):
+		t.Fatal("No message delivered")
+	}
+	return nil
+}
+
+// none asserts nothing is delivered for a while.
+func none(t *testing.T, deliveries <-chan *broker.Delivery) {
+	select {
+	case d := <-deliveries:
+		t.Fatalf("Unexpected delivery of %s", d.ID)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func ready(t *testing.T, b *broker.Memory, tenantID string) int {
+	stats, err := b.Inspect(context.Background(), tenantID)
+	require.NoError(t, err)
+	return stats.Messages
+}
+
+func TestMemory_When_Consumed_Then_HigherPriorityComesFirstThenPublishOrder(t *testing.T) {
+	b, tenantID := newQueue(t)
+	publish(t, b, tenantID, "low-1", 1)
+	publish(t, b, tenantID, "high", 9)
+	publish(t, b, tenantID, "low-2", 1)
+	publish(t, b, tenantID, "medium", 5)
+
+	_, deliveries := consume(t, b, tenantID, 0)
+	for _, id := range []string{"high", "medium", "low-1", "low-2"} {
+		d := next(t, deliveries)
+		require.Equal(t, id, d.ID)
+		require.NoError(t, d.Ack())
+	}
+}
+
+func TestMemory_When_Acked_Then_MessageIsGone(t *testing.T) {
+	b, tenantID := newQueue(t)
+	publish(t, b, tenantID, "a", 0)
+
+	_, deliveries := consume(t, b, tenantID, 1)
+	d := next(t, deliveries)
+	require.Zero(t, d.Attempts)
+	require.NoError(t, d.Ack())
+
+	require.Error(t, d.Ack(), "A delivery is settled once")
+	none(t, deliveries)
+	require.Zero(t, ready(t, b, tenantID))
+}
+
+func TestMemory_When_Nacked_Then_ItIsRedeliveredWithoutCountingAnAttempt(t *testing.T) {
+	b, tenantID := newQueue(t)
+	publish(t, b, tenantID, "a", 0)
+	publish(t, b, tenantID, "b", 0)
+
+	_, deliveries := consume(t, b, tenantID, 1)
+	d := next(t, deliveries)
+	require.Equal(t, "a", d.ID)
+	require.NoError(t, d.Nack())
+
+	// Back at its place, ahead of b
+	d = next(t, deliveries)
+	require.Equal(t, "a", d.ID)
+	require.Zero(t, d.Attempts)
+	require.NoError(t, d.Ack())
+}
+
+func TestMemory_When_Retried_Then_ItIsRedeliveredAfterTheDelayWithAnAttempt(t *testing.T) {
+	b, tenantID := newQueue(t)
+	publish(t, b, tenantID, "a", 0)
+
+	_, deliveries := consume(t, b, tenantID, 1)
+	require.NoError(t, next(t, deliveries).Retry(200*time.Millisecond))
+
+	started := time.Now()
+	none(t, deliveries)
+	d := next(t, deliveries)
+	require.GreaterOrEqual(t, time.Since(started), 150*time.Millisecond)
+	require.Equal(t, "a", d.ID)
+	require.Equal(t, 1, d.Attempts)
+
+	require.NoError(t, d.Retry(time.Millisecond))
+	d = next(t, deliveries)
+	require.Equal(t, 2, d.Attempts)
+	require.NoError(t, d.Ack())
+}
+
+func TestMemory_When_DeadLettered_Then_ItIsParkedUntilReplayed(t *testing.T) {
+	b, tenantID := newQueue(t)
+	publish(t, b, tenantID, "a", 0)
+
+	_, deliveries := consume(t, b, tenantID, 1)
+	require.NoError(t, next(t, deliveries).DeadLetter(errors.New("boom")))
+	none(t, deliveries)
+
+	letters, err := b.DeadLetters().List(tenantID, 10)
+	require.NoError(t, err)
+	require.Len(t, letters, 1)
+	require.Equal(t, "a", letters[0].ID)
+	require.Equal(t, 1, letters[0].Attempts)
+	require.Equal(t, "boom", letters[0].Error)
+
+	require.NoError(t, b.DeadLetters().Replay(tenantID, "a"))
+	d := next(t, deliveries)
+	require.Equal(t, "a", d.ID)
+	require.Zero(t, d.Attempts, "A replay starts over")
+	require.NoError(t, d.Ack())
+
+	letters, err = b.DeadLetters().List(tenantID, 10)
+	require.NoError(t, err)
+	require.Empty(t, letters)
+}
+
+func TestMemory_When_PrefetchIsReached_Then_DeliveriesWaitForASettle(t *testing.T) {
+	b, tenantID := newQueue(t)
+	for _, id := range []string{"a", "b", "c"} {
+		publish(t, b, tenantID, id, 0)
+	}
+
+	_, deliveries := consume(t, b, tenantID, 2)
+	first, second := next(t, deliveries), next(t, deliveries)
+	none(t, deliveries)
+	require.Equal(t, 1, ready(t, b, tenantID))
+
+	require.NoError(t, first.Ack())
+	require.Equal(t, "c", next(t, deliveries).ID)
+	require.NoError(t, second.Ack())
+}
+
+func TestMemory_When_SubscriptionCloses_Then_UnsettledDeliveriesAreRequeued(t *testing.T) {
+	b, tenantID := newQueue(t)
+	publish(t, b, tenantID, "a", 0)
+
+	sub, deliveries := consume(t, b, tenantID, 1)
+	d := next(t, deliveries)
+	require.NoError(t, sub.Close())
+	require.Error(t, d.Ack(), "The delivery went back to the queue")
+	require.Equal(t, 1, ready(t, b, tenantID))
+
+	_, deliveries = consume(t, b, tenantID, 1)
+	require.Equal(t, "a", next(t, deliveries).ID)
+}
@@ -0,0 +1,38 @@
+package broker
+
+import "time"
+
+// RetryPolicy controls how failed deliveries are retried before they are dead-lettered.
+type RetryPolicy struct {
+	MaxAttempts    int
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+}
+
+// Backoff returns the delay before the given retry, starting at 1, doubling
+// from InitialBackoff up to MaxBackoff.
+func (p RetryPolicy) Backoff(retry int) time.Duration {
+	delay := p.InitialBackoff
+	for i := 1; i < retry; i++ {
+		delay *= 2
+		if p.MaxBackoff > 0 && delay >= p.MaxBackoff {
+			break
+		}
+	}
+	if p.MaxBackoff > 0 && delay > p.MaxBackoff {
+		delay = p.MaxBackoff
+	}
+	return delay
+}
+
+// Delays returns the distinct backoff delays used by the policy.
+func (p RetryPolicy) Delays() []time.Duration {
+	var delays []time.Duration
+	for retry := 1; retry < p.MaxAttempts; retry++ {
+		d := p.Backoff(retry)
+		if len(delays) == 0 || delays[len(delays)-1] != d {
+			delays = append(delays, d)
+		}
+	}
+	return delays
+}
@@ -0,0 +1,17 @@
+package domain
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// DeadLetter is a message that exhausted its retries and was parked in its tenant's
+// dead-letter queue.
+type DeadLetter struct {
+	ID       string          `json:"id"`
+	TenantID string          `json:"tenant_id"`
+	Payload  json.RawMessage `json:"payload" swaggertype:"object"`
+	Attempts int             `json:"attempts"`
+	Error    string          `json:"error"`
+	FailedAt time.Time       `json:"failed_at"`
+}
@@ -0,0 +1,13 @@
+package domain
+
+import "errors"
+
+var (
+	ErrTenantNotFound     = errors.New("tenant not found")
+	ErrDeadLetterNotFound = errors.New("dead-lettered message not found")
+	ErrMessageNotFound    = errors.New("message not found")
+	ErrMessageInFlight    = errors.New("message is still queued or processing")
+
+	ErrScheduledMessageNotFound = errors.New("scheduled message not found")
+	ErrSchemaNotFound           = errors.New("schema not found")
+)
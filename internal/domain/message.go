@@ -1,13 +1,87 @@
 package domain
 
 import (
+	"encoding/json"
 	"github.com/google/uuid"
 	"time"
 )
 
+const (
+	MessageStatusQueued     = "queued"
+	MessageStatusProcessing = "processing"
+	MessageStatusProcessed  = "processed"
+	MessageStatusFailed     = "failed"
+)
+
+// MaxMessagePriority is the highest message priority. Tenant queues deliver messages
+// of a higher priority first; 0, the default, is the lowest.
+const MaxMessagePriority uint8 = 9
+
 type Message struct {
-	ID        uuid.UUID `json:"id"`
-	TenantID  uuid.UUID `json:"tenant_id"`
-	Payload   []byte    `json:"payload"`
-	CreatedAt time.Time `json:"created_at"`
+	ID       uuid.UUID       `json:"id"`
+	TenantID uuid.UUID       `json:"tenant_id"`
+	Payload  json.RawMessage `json:"payload" swaggertype:"object"`
+	Priority uint8           `json:"priority"`
+	// SchemaVersion is the version of the tenant's schema the payload was validated against
+	SchemaVersion *int       `json:"schema_version,omitempty"`
+	Status        string     `json:"status"`
+	Attempts      int        `json:"attempts"`
+	LastError     string     `json:"last_error,omitempty"`
+	CreatedAt     time.Time  `json:"created_at"`
+	UpdatedAt     time.Time  `json:"updated_at"`
+	ProcessedAt   *time.Time `json:"processed_at,omitempty"`
+	// ProcessedSeq numbers the processed messages of a tenant in the order their
+	// processing committed, zero until processed
+	ProcessedSeq int64 `json:"-"`
+}
+
+// ValidMessageStatus reports whether status is one of the message statuses.
+func ValidMessageStatus(status string) bool {
+	switch status {
+	case MessageStatusQueued, MessageStatusProcessing, MessageStatusProcessed, MessageStatusFailed:
+		return true
+	}
+	return false
+}
+
+// MessageFilter narrows down message listings. TenantID is required; every other
+// field is optional and all of them combine.
+type MessageFilter struct {
+	TenantID uuid.UUID
+	Status   string
+	// Priority only lists messages of this priority when set
+	Priority *uint8
+	// CreatedFrom and CreatedTo bound created_at, the upper bound is exclusive
+	CreatedFrom time.Time
+	CreatedTo   time.Time
+	// PayloadContains is a JSON document the payload must contain
+	PayloadContains json.RawMessage
+	// PayloadMatches are values expected at paths of the payload
+	PayloadMatches []PayloadMatch
+}
+
+// PayloadMatch expects Value at Path in the payload, e.g. Path ["order","id"] for
+// order.id. Value matches both the JSON value it parses as and the plain string,
+// so "123" matches 123 as well as "123".
+type PayloadMatch struct {
+	Path  []string
+	Value string
+}
+
+// MessagePosition is a message's place in a listing ordered by a timestamp and the ID,
+// created_at for pages and processed_at for the live stream.
+type MessagePosition struct {
+	At time.Time
+	ID uuid.UUID
+}
+
+// MessagePage selects one page of a keyset-paginated message listing.
+type MessagePage struct {
+	// Descending lists the newest messages first
+	Descending bool
+	// From is the position the page starts after, exclusive; nil starts at the beginning
+	From *MessagePosition
+	// Backward reads the page that ends before From instead of the one after it
+	Backward bool
+	Limit    int
 }
@@ -0,0 +1,20 @@
+package domain
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// OutboxEntry is a stored message waiting to be published to its tenant's queue. It is
+// written in the same transaction as the message, so a message is never stored without
+// eventually reaching the broker.
+type OutboxEntry struct {
+	ID        int64
+	TenantID  uuid.UUID
+	MessageID uuid.UUID
+	Payload   []byte
+	Priority  uint8
+	Attempts  int
+	CreatedAt time.Time
+}
@@ -0,0 +1,22 @@
+package domain
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ScheduledMessage is a message waiting to be published at DeliverAt.
+type ScheduledMessage struct {
+	ID       uuid.UUID       `json:"id"`
+	TenantID uuid.UUID       `json:"tenant_id"`
+	Payload  json.RawMessage `json:"payload" swaggertype:"object"`
+	Priority uint8           `json:"priority"`
+	// SchemaVersion is the version of the tenant's schema the payload was validated against
+	SchemaVersion *int      `json:"schema_version,omitempty"`
+	DeliverAt     time.Time `json:"deliver_at"`
+	Attempts      int       `json:"attempts"`
+	LastError     string    `json:"last_error,omitempty"`
+	CreatedAt     time.Time `json:"created_at"`
+}
@@ -0,0 +1,35 @@
+package domain
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// MessageSchema is one version of the JSON Schema a tenant's payloads must match.
+// The latest version applies to new messages.
+type MessageSchema struct {
+	TenantID  uuid.UUID       `json:"tenant_id"`
+	Version   int             `json:"version"`
+	Schema    json.RawMessage `json:"schema" swaggertype:"object"`
+	CreatedAt time.Time       `json:"created_at"`
+}
+
+// SchemaViolation is one way a payload fails its schema. Field is the dot-separated
+// path of the offending value, "(root)" for the payload itself.
+type SchemaViolation struct {
+	Field   string `json:"field" example:"order.id"`
+	Message string `json:"message" example:"order.id is required"`
+}
+
+// SchemaValidationError is returned for payloads that do not match the schema.
+type SchemaValidationError struct {
+	Version    int
+	Violations []SchemaViolation
+}
+
+func (e *SchemaValidationError) Error() string {
+	return fmt.Sprintf("payload does not match schema version %d", e.Version)
+}
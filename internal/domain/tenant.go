@@ -1,12 +1,88 @@
 package domain
 
-import "github.com/google/uuid"
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	TenantStatusActive   = "active"
+	TenantStatusDeleting = "deleting"
+)
 
 type Tenant struct {
-	ID   uuid.UUID `json:"id"`
-	Name string    `json:"name"`
+	ID        uuid.UUID `json:"id"`
+	Name      string    `json:"name"`
+	Workers   int       `json:"workers"`
+	Prefetch  int       `json:"prefetch"`
+	Status    string    `json:"status"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+
+	Retention  RetentionPolicy   `json:"-"`
+	Processors []ProcessorConfig `json:"-"`
+}
+
+// ProcessorConfig is one step of the chain a tenant's messages are processed by:
+// a registered processor type and the options it is built with.
+type ProcessorConfig struct {
+	Type    string          `json:"type" example:"store"`
+	Options json.RawMessage `json:"options,omitempty" swaggertype:"object"`
+}
+
+// DefaultProcessors is the chain of tenants that did not configure one, storing
+// every message as it was published.
+func DefaultProcessors() []ProcessorConfig {
+	return []ProcessorConfig{{Type: "store"}}
+}
+
+// RetentionPolicy bounds how many processed and failed messages of a tenant are kept.
+// MaxAge removes messages created longer ago, MaxRows removes the oldest messages
+// beyond the newest MaxRows. Zero values mean no limit.
+type RetentionPolicy struct {
+	MaxAge  time.Duration
+	MaxRows int
+}
+
+// Enabled reports whether the policy removes anything.
+func (p RetentionPolicy) Enabled() bool {
+	return p.MaxAge > 0 || p.MaxRows > 0
+}
+
+// RetentionStats describes the retention runs of a tenant on this instance.
+type RetentionStats struct {
+	LastRunAt    *time.Time
+	LastRemoved  int64
+	TotalRemoved int64
+	LastError    string
+}
+
+// TenantFilter narrows down tenant listings.
+type TenantFilter struct {
+	// ID restricts the listing to a single tenant when set
+	ID     string
+	Name   string
+	Limit  int
+	Offset int
+}
+
+// TenantRuntime describes the live state of a tenant's consumer and queue.
+type TenantRuntime struct {
+	ConsumerRunning bool `json:"consumer_running"`
+	ActiveWorkers   int  `json:"active_workers"`
+	QueueDepth      int  `json:"queue_depth"`
+	QueueConsumers  int  `json:"queue_consumers"`
+}
+
+// TenantInfo is a registered tenant together with its runtime state.
+type TenantInfo struct {
+	Tenant
+	Runtime TenantRuntime `json:"runtime"`
 }
 
 type ConcurrencyConfig struct {
-	Workers int `json:"workers"`
+	Workers  int `json:"workers"`
+	Prefetch int `json:"prefetch,omitempty"`
 }
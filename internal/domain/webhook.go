@@ -0,0 +1,20 @@
+package domain
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// WebhookDelivery is one attempt to push a message to a tenant's webhook. StatusCode
+// is zero when no response was received, e.g. on a timeout.
+type WebhookDelivery struct {
+	TenantID   uuid.UUID `json:"tenant_id"`
+	MessageID  uuid.UUID `json:"message_id"`
+	Attempt    int       `json:"attempt" example:"1"`
+	URL        string    `json:"url" example:"https://example.com/hooks/messages"`
+	StatusCode int       `json:"status_code,omitempty" example:"200"`
+	Error      string    `json:"error,omitempty"`
+	DurationMs int64     `json:"duration_ms" example:"84"`
+	CreatedAt  time.Time `json:"created_at"`
+}
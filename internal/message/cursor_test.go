@@ -0,0 +1,115 @@
+package message_test
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fekalegi/multi-tenant-system/internal/broker"
+	"github.com/fekalegi/multi-tenant-system/internal/domain"
+	"github.com/fekalegi/multi-tenant-system/internal/message"
+	"github.com/fekalegi/multi-tenant-system/internal/repository/memory"
+	message2 "github.com/fekalegi/multi-tenant-system/internal/repository/postgresql"
+	"github.com/google/uuid"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/require"
+)
+
+// harness is a message service on the memory repositories with one tenant.
+type harness struct {
+	t        *testing.T
+	store    *memory.Store
+	svc      *message.Service
+	messages message2.MessageRepository
+	tenantID uuid.UUID
+}
+
+// newHarness returns a harness publishing to b whose tenant has count messages.
+func newHarness(t *testing.T, b broker.Broker, count int) *harness {
+	store := memory.NewStore()
+	h := &harness{t: t, store: store, messages: memory.NewMessageRepository(store)}
+	h.svc = message.NewService(b, h.messages, "cursor-secret", zerolog.Nop())
+	h.tenantID = h.addTenant(count)
+	return h
+}
+
+// addTenant stores another tenant with count messages a second apart.
+func (h *harness) addTenant(count int) uuid.UUID {
+	ctx := context.Background()
+	tenantID := uuid.New()
+	tenants := memory.NewTenantRepository(h.store)
+	require.NoError(h.t, tenants.CreatePartitionForTenant(ctx, tenantID.String()))
+	require.NoError(h.t, tenants.SaveTenant(ctx, &domain.Tenant{ID: tenantID, Name: "message-test", CreatedAt: time.Now()}))
+
+	base := time.Now().Truncate(time.Second)
+	var msgs []*domain.Message
+	for i := range count {
+		msgs = append(msgs, &domain.Message{
+			ID:        uuid.New(),
+			TenantID:  tenantID,
+			Payload:   json.RawMessage(`{}`),
+			Status:    domain.MessageStatusQueued,
+			CreatedAt: base.Add(time.Duration(i) * time.Second),
+		})
+	}
+	require.NoError(h.t, h.messages.InsertMessages(ctx, msgs))
+	return tenantID
+}
+
+func TestFetchMessagesWithCursor_When_CursorIsInvalid_Then_ItIsRejected(t *testing.T) {
+	ctx := context.Background()
+	h := newHarness(t, broker.NewMemory(), 3)
+	svc, tenantID := h.svc, h.tenantID
+	otherTenantID := h.addTenant(3)
+
+	filter := domain.MessageFilter{TenantID: tenantID}
+	page, err := svc.FetchMessagesWithCursor(ctx, filter, false, "", 1)
+	require.NoError(t, err)
+	require.NotEmpty(t, page.NextCursor)
+	valid := page.NextCursor
+	payload, sig, _ := strings.Cut(valid, ".")
+
+	foreign, err := message.NewService(broker.NewMemory(), h.messages, "another-secret", zerolog.Nop()).FetchMessagesWithCursor(ctx, filter, false, "", 1)
+	require.NoError(t, err)
+
+	// A signature of the same length whose first character differs
+	flipped := "A"
+	if sig[0] == 'A' {
+		flipped = "B"
+	}
+
+	tests := []struct {
+		name       string
+		filter     domain.MessageFilter
+		descending bool
+		token      string
+	}{
+		{name: "tampered signature", filter: filter, token: payload + "." + flipped + sig[1:]},
+		{name: "tampered payload", filter: filter, token: "x" + payload[1:] + "." + sig},
+		{name: "signed with another secret", filter: filter, token: foreign.NextCursor},
+		{name: "wrong tenant", filter: domain.MessageFilter{TenantID: otherTenantID}, token: valid},
+		{name: "other filters", filter: domain.MessageFilter{TenantID: tenantID, Status: domain.MessageStatusQueued}, token: valid},
+		{name: "other order", filter: filter, descending: true, token: valid},
+		{name: "no separator", filter: filter, token: payload + sig},
+		{name: "malformed base64 signature", filter: filter, token: payload + ".!!!"},
+		{name: "malformed base64 payload", filter: filter, token: "!!!." + sig},
+		{name: "empty parts", filter: filter, token: "."},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := svc.FetchMessagesWithCursor(ctx, tt.filter, tt.descending, tt.token, 1)
+			require.ErrorIs(t, err, message.ErrInvalidCursor)
+		})
+	}
+
+	// The untouched cursor still reads the next page
+	page, err = svc.FetchMessagesWithCursor(ctx, filter, false, valid, 1)
+	require.NoError(t, err)
+	require.Len(t, page.Messages, 1)
+
+	// Listing cursors do not resume streams
+	_, err = svc.ParseStreamCursor(tenantID, valid)
+	require.ErrorIs(t, err, message.ErrInvalidCursor)
+}
@@ -0,0 +1,136 @@
+package message
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/fekalegi/multi-tenant-system/internal/broker"
+	"github.com/fekalegi/multi-tenant-system/internal/domain"
+	"github.com/google/uuid"
+)
+
+// OutboxOptions controls how stored messages are relayed to the tenant queues.
+type OutboxOptions struct {
+	// Interval is how often pending entries are looked for, besides right after a
+	// publish on this instance
+	Interval time.Duration
+	// BatchSize is the maximum number of entries claimed at once
+	BatchSize int
+	// Lease is how long claimed entries are hidden from other relays while they are
+	// published; entries left unsettled are claimed again after it
+	Lease time.Duration
+	// RetryDelay postpones an entry whose publish failed
+	RetryDelay time.Duration
+	// Retention is how long sent entries are kept before they are removed
+	Retention time.Duration
+}
+
+// RunOutboxRelay publishes the messages waiting in the outbox until ctx is done. An entry
+// is only marked sent once the broker confirmed it, and entries are leased when they are
+// claimed, so several instances may run the relay side by side.
+func (s *Service) RunOutboxRelay(ctx context.Context, opts OutboxOptions) {
+	ticker := time.NewTicker(opts.Interval)
+	defer ticker.Stop()
+	purge := time.NewTicker(opts.Retention)
+	defer purge.Stop()
+
+	for {
+		s.relayPending(ctx, opts)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-s.outbox:
+		case <-purge.C:
+			if n, err := s.repository.PurgeOutbox(ctx, time.Now().Add(-opts.Retention)); err != nil {
+				s.log.Warn().Err(err).Msg("Failed to purge outbox")
+			} else if n > 0 {
+				s.log.Debug().Int64("entries", n).Msg("Purged sent outbox entries")
+			}
+		}
+	}
+}
+
+// wakeRelay lets the relay publish newly stored messages without waiting for its next tick.
+func (s *Service) wakeRelay() {
+	select {
+	case s.outbox <- struct{}{}:
+	default:
+	}
+}
+
+// relayPending publishes pending outbox entries until none is left.
+func (s *Service) relayPending(ctx context.Context, opts OutboxOptions) {
+	for ctx.Err() == nil {
+		n, err := s.repository.RelayOutbox(ctx, opts.BatchSize, opts.Lease, opts.RetryDelay, func(entries []*domain.OutboxEntry) []error {
+			return s.publishEntries(ctx, entries, opts.RetryDelay)
+		})
+		if err != nil {
+			if ctx.Err() == nil {
+				s.log.Warn().Err(err).Msg("Failed to relay outbox")
+			}
+			return
+		}
+		if n < opts.BatchSize {
+			return
+		}
+	}
+}
+
+// publishEntries publishes outbox entries in one batch per tenant, keeping their order,
+// and returns one error per entry.
+func (s *Service) publishEntries(ctx context.Context, entries []*domain.OutboxEntry, retryDelay time.Duration) []error {
+	var tenants []uuid.UUID
+	byTenant := map[uuid.UUID][]int{}
+	for i, e := range entries {
+		if _, ok := byTenant[e.TenantID]; !ok {
+			tenants = append(tenants, e.TenantID)
+		}
+		byTenant[e.TenantID] = append(byTenant[e.TenantID], i)
+	}
+
+	errs := make([]error, len(entries))
+	for n, tenantID := range tenants {
+		indexes := byTenant[tenantID]
+		batch := make([]broker.Message, len(indexes))
+		for j, i := range indexes {
+			batch[j] = broker.Message{ID: entries[i].MessageID.String(), Body: entries[i].Payload, Priority: entries[i].Priority}
+		}
+
+		var (
+			failed   int
+			firstErr error
+		)
+		for j, err := range s.broker.PublishBatch(ctx, tenantID.String(), batch) {
+			errs[indexes[j]] = err
+			if err != nil {
+				if failed == 0 {
+					firstErr = err
+				}
+				failed++
+			}
+		}
+		if failed == 0 {
+			continue
+		}
+
+		// The other tenants would only wait for the same outage
+		if errors.Is(firstErr, broker.ErrUnavailable) {
+			postponed := 0
+			for _, later := range tenants[n+1:] {
+				for _, i := range byTenant[later] {
+					errs[i] = firstErr
+					postponed++
+				}
+			}
+			s.log.Warn().Err(firstErr).Int("failed", failed+postponed).Dur("retry_in", retryDelay).
+				Msg("Broker unavailable, postponing outbox entries")
+			break
+		}
+		s.log.Warn().Err(firstErr).Str("tenant_id", tenantID.String()).Int("failed", failed).
+			Dur("retry_in", retryDelay).Msg("Failed to publish outbox entries")
+	}
+	return errs
+}
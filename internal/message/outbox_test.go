@@ -0,0 +1,58 @@
+package message_test
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/fekalegi/multi-tenant-system/internal/broker"
+	"github.com/fekalegi/multi-tenant-system/internal/domain"
+	"github.com/fekalegi/multi-tenant-system/internal/message"
+	"github.com/stretchr/testify/require"
+)
+
+// unreachableBroker fails every publish as if the broker were down.
+type unreachableBroker struct {
+	broker.Broker
+	publishes atomic.Int32
+}
+
+func (b *unreachableBroker) PublishBatch(_ context.Context, _ string, msgs []broker.Message) []error {
+	b.publishes.Add(1)
+	errs := make([]error, len(msgs))
+	for i := range errs {
+		errs[i] = fmt.Errorf("%w: connection refused", broker.ErrUnavailable)
+	}
+	return errs
+}
+
+func TestOutboxRelay_When_BrokerIsUnavailable_Then_EntriesArePostponedAfterOneAttempt(t *testing.T) {
+	ctx := context.Background()
+	b := &unreachableBroker{Broker: broker.NewMemory()}
+	h := newHarness(t, b, 1)
+	h.addTenant(1)
+	svc, messages := h.svc, h.messages
+
+	runCtx, cancel := context.WithCancel(ctx)
+	t.Cleanup(cancel)
+	go svc.RunOutboxRelay(runCtx, message.OutboxOptions{
+		Interval:   10 * time.Millisecond,
+		BatchSize:  10,
+		Lease:      time.Minute,
+		RetryDelay: time.Minute,
+		Retention:  time.Hour,
+	})
+
+	require.Eventually(t, func() bool { return b.publishes.Load() > 0 }, 5*time.Second, 10*time.Millisecond, "the relay should publish")
+
+	// The second tenant was not tried against the same outage, and both entries wait
+	time.Sleep(50 * time.Millisecond)
+	require.EqualValues(t, 1, b.publishes.Load())
+	n, err := messages.RelayOutbox(ctx, 10, time.Minute, time.Minute, func(entries []*domain.OutboxEntry) []error {
+		return make([]error, len(entries))
+	})
+	require.NoError(t, err)
+	require.Zero(t, n, "both entries should be postponed")
+}
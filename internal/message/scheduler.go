@@ -0,0 +1,113 @@
+package message
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/fekalegi/multi-tenant-system/internal/domain"
+	"github.com/google/uuid"
+)
+
+// SchedulerOptions controls how scheduled messages are released.
+type SchedulerOptions struct {
+	// Interval is how often due messages are looked for
+	Interval time.Duration
+	// RetryDelay postpones a due message that could not be released
+	RetryDelay time.Duration
+}
+
+// ScheduleMessage validates a payload against the tenant's schema and stores it to be
+// published to the tenant's queue at deliverAt with the given priority.
+func (s *Service) ScheduleMessage(ctx context.Context, tenantID uuid.UUID, payload any, priority uint8, deliverAt time.Time) (*domain.ScheduledMessage, error) {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	version, err := s.validatePayload(ctx, tenantID, body)
+	if err != nil {
+		return nil, err
+	}
+
+	msg := &domain.ScheduledMessage{
+		ID:            uuid.New(),
+		TenantID:      tenantID,
+		Payload:       body,
+		Priority:      priority,
+		SchemaVersion: version,
+		DeliverAt:     deliverAt,
+		CreatedAt:     time.Now(),
+	}
+	if err := s.repository.ScheduleMessage(ctx, msg); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+// ListScheduledMessages returns the pending scheduled messages of a tenant, next due first.
+func (s *Service) ListScheduledMessages(ctx context.Context, tenantID uuid.UUID, limit, offset int) ([]*domain.ScheduledMessage, int, error) {
+	return s.repository.ListScheduledMessages(ctx, tenantID, limit, offset)
+}
+
+// CancelScheduledMessage removes a scheduled message that has not been published yet.
+func (s *Service) CancelScheduledMessage(ctx context.Context, tenantID, id uuid.UUID) error {
+	return s.repository.CancelScheduledMessage(ctx, tenantID, id)
+}
+
+// RunScheduler releases scheduled messages once they are due until ctx is done. A due
+// message moves to the outbox under its ID in the transaction that unschedules it, and
+// the relay publishes it from there. Due messages are claimed with FOR UPDATE SKIP
+// LOCKED, so several instances may run the scheduler side by side.
+func (s *Service) RunScheduler(ctx context.Context, opts SchedulerOptions) {
+	ticker := time.NewTicker(opts.Interval)
+	defer ticker.Stop()
+
+	for {
+		s.releaseDue(ctx, opts.RetryDelay)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// releaseDue releases due messages until none is left.
+func (s *Service) releaseDue(ctx context.Context, retryDelay time.Duration) {
+	released := 0
+	defer func() {
+		if released > 0 {
+			s.wakeRelay()
+		}
+	}()
+
+	for ctx.Err() == nil {
+		msg, err := s.repository.ReleaseDueMessage(ctx)
+		if msg == nil && err == nil {
+			return
+		}
+		if err == nil {
+			released++
+			continue
+		}
+
+		if ctx.Err() != nil {
+			return
+		}
+		if msg == nil {
+			s.log.Warn().Err(err).Msg("Failed to release scheduled messages")
+			return
+		}
+
+		// Move it out of the way so the messages due after it are not held up
+		s.log.Warn().Err(err).Str("tenant_id", msg.TenantID.String()).Str("msg_id", msg.ID.String()).
+			Dur("retry_in", retryDelay).Msg("Failed to release scheduled message")
+		reason := err.Error()
+		if err := s.repository.PostponeScheduledMessage(ctx, msg.TenantID, msg.ID, time.Now().Add(retryDelay), reason); err != nil {
+			s.log.Warn().Err(err).Str("msg_id", msg.ID.String()).Msg("Failed to postpone scheduled message")
+			return
+		}
+	}
+}
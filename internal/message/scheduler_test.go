@@ -0,0 +1,48 @@
+package message_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/fekalegi/multi-tenant-system/internal/broker"
+	"github.com/fekalegi/multi-tenant-system/internal/domain"
+	"github.com/fekalegi/multi-tenant-system/internal/message"
+	"github.com/stretchr/testify/require"
+)
+
+func TestScheduler_When_MessageIsDue_Then_ItMovesToTheOutbox(t *testing.T) {
+	ctx := context.Background()
+	h := newHarness(t, broker.NewMemory(), 0)
+	svc, messages, tenantID := h.svc, h.messages, h.tenantID
+
+	due, err := svc.ScheduleMessage(ctx, tenantID, map[string]string{"data": "due"}, 3, time.Now().Add(-time.Second))
+	require.NoError(t, err)
+	later, err := svc.ScheduleMessage(ctx, tenantID, map[string]string{"data": "later"}, 0, time.Now().Add(time.Hour))
+	require.NoError(t, err)
+
+	runCtx, cancel := context.WithCancel(ctx)
+	t.Cleanup(cancel)
+	go svc.RunScheduler(runCtx, message.SchedulerOptions{Interval: 10 * time.Millisecond, RetryDelay: time.Minute})
+
+	require.Eventually(t, func() bool {
+		pending, _, err := svc.ListScheduledMessages(ctx, tenantID, 0, 0)
+		return err == nil && len(pending) == 1 && pending[0].ID == later.ID
+	}, 5*time.Second, 10*time.Millisecond, "the due message should leave the schedule")
+
+	stored, err := messages.GetMessage(ctx, tenantID, due.ID)
+	require.NoError(t, err)
+	require.Equal(t, domain.MessageStatusQueued, stored.Status)
+
+	// Published by the relay under the same ID, not by the scheduler
+	var relayed []*domain.OutboxEntry
+	_, err = messages.RelayOutbox(ctx, 10, time.Minute, time.Minute, func(entries []*domain.OutboxEntry) []error {
+		relayed = entries
+		return make([]error, len(entries))
+	})
+	require.NoError(t, err)
+	require.Len(t, relayed, 1)
+	require.Equal(t, due.ID, relayed[0].MessageID)
+	require.EqualValues(t, 3, relayed[0].Priority)
+	require.JSONEq(t, `{"data": "due"}`, string(relayed[0].Payload))
+}
@@ -0,0 +1,186 @@
+package message
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/fekalegi/multi-tenant-system/internal/domain"
+	"github.com/google/uuid"
+	"github.com/xeipuuv/gojsonschema"
+)
+
+// ErrInvalidSchema is returned when a schema to register is not a valid JSON Schema.
+var ErrInvalidSchema = errors.New("invalid json schema")
+
+// schemaCacheTTL is how long a tenant's latest schema is reused before it is looked up
+// again. It bounds how long other instances keep validating against a replaced version.
+const schemaCacheTTL = 5 * time.Second
+
+// compiledSchema is the schema version that applies to a tenant's new messages. A nil
+// schema means the tenant has none and every payload is accepted.
+type compiledSchema struct {
+	version  int
+	schema   *gojsonschema.Schema
+	loadedAt time.Time
+}
+
+// validate checks a payload and returns the schema version it matched, nil when the
+// tenant has no schema, or a *domain.SchemaValidationError.
+func (c *compiledSchema) validate(payload []byte) (*int, error) {
+	if c.schema == nil {
+		return nil, nil
+	}
+
+	result, err := c.schema.Validate(gojsonschema.NewBytesLoader(payload))
+	if err != nil {
+		return nil, &domain.SchemaValidationError{
+			Version:    c.version,
+			Violations: []domain.SchemaViolation{{Field: "(root)", Message: err.Error()}},
+		}
+	}
+	if !result.Valid() {
+		invalid := &domain.SchemaValidationError{Version: c.version}
+		for _, e := range result.Errors() {
+			invalid.Violations = append(invalid.Violations, domain.SchemaViolation{Field: e.Field(), Message: e.Description()})
+		}
+		return nil, invalid
+	}
+
+	version := c.version
+	return &version, nil
+}
+
+// schemaCache holds the compiled latest schema of each tenant.
+type schemaCache struct {
+	mu      sync.Mutex
+	tenants map[uuid.UUID]*compiledSchema
+}
+
+func (c *schemaCache) get(tenantID uuid.UUID) *compiledSchema {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	s, ok := c.tenants[tenantID]
+	if !ok || time.Since(s.loadedAt) > schemaCacheTTL {
+		return nil
+	}
+	return s
+}
+
+func (c *schemaCache) put(tenantID uuid.UUID, s *compiledSchema) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.tenants[tenantID] = s
+}
+
+func (c *schemaCache) invalidate(tenantID uuid.UUID) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.tenants, tenantID)
+}
+
+// errExternalRef is returned when a schema refers to a document outside itself.
+var errExternalRef = errors.New("only local $ref fragments are allowed")
+
+// localSchemaLoader loads a schema whose $refs may only point into the schema itself.
+// The default loader follows file and http references, which would let a tenant read
+// local files or make the service send requests.
+type localSchemaLoader struct {
+	gojsonschema.JSONLoader
+}
+
+func (localSchemaLoader) LoaderFactory() gojsonschema.JSONLoaderFactory {
+	return refusingLoaderFactory{}
+}
+
+// refusingLoaderFactory creates the loaders of external references, which never load.
+type refusingLoaderFactory struct{}
+
+func (refusingLoaderFactory) New(source string) gojsonschema.JSONLoader {
+	return refusingLoader{gojsonschema.NewReferenceLoader(source)}
+}
+
+type refusingLoader struct {
+	gojsonschema.JSONLoader
+}
+
+func (l refusingLoader) LoadJSON() (interface{}, error) {
+	return nil, fmt.Errorf("%w: %v", errExternalRef, l.JsonSource())
+}
+
+func compileSchema(raw []byte) (*gojsonschema.Schema, error) {
+	schema, err := gojsonschema.NewSchema(localSchemaLoader{gojsonschema.NewBytesLoader(raw)})
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidSchema, err)
+	}
+	return schema, nil
+}
+
+// currentSchema returns the schema that applies to a tenant's new messages.
+func (s *Service) currentSchema(ctx context.Context, tenantID uuid.UUID) (*compiledSchema, error) {
+	if cached := s.schemas.get(tenantID); cached != nil {
+		return cached, nil
+	}
+
+	latest, err := s.repository.LatestSchema(ctx, tenantID)
+	if err != nil {
+		return nil, err
+	}
+
+	compiled := &compiledSchema{loadedAt: time.Now()}
+	if latest != nil {
+		if compiled.schema, err = compileSchema(latest.Schema); err != nil {
+			return nil, err
+		}
+		compiled.version = latest.Version
+	}
+	s.schemas.put(tenantID, compiled)
+	return compiled, nil
+}
+
+// validatePayload checks a payload against the tenant's latest schema and returns the
+// version it matched, nil when the tenant has none.
+func (s *Service) validatePayload(ctx context.Context, tenantID uuid.UUID, payload []byte) (*int, error) {
+	schema, err := s.currentSchema(ctx, tenantID)
+	if err != nil {
+		return nil, err
+	}
+	return schema.validate(payload)
+}
+
+// RegisterSchema adds a new version of a tenant's schema. It applies to every message
+// published from then on.
+func (s *Service) RegisterSchema(ctx context.Context, tenantID uuid.UUID, schema []byte) (*domain.MessageSchema, error) {
+	if _, err := compileSchema(schema); err != nil {
+		return nil, err
+	}
+
+	registered, err := s.repository.CreateSchema(ctx, tenantID, schema)
+	if err != nil {
+		return nil, err
+	}
+	s.schemas.invalidate(tenantID)
+	return registered, nil
+}
+
+// ListSchemas returns every schema version of a tenant, latest first.
+func (s *Service) ListSchemas(ctx context.Context, tenantID uuid.UUID) ([]*domain.MessageSchema, error) {
+	return s.repository.ListSchemas(ctx, tenantID)
+}
+
+func (s *Service) GetSchema(ctx context.Context, tenantID uuid.UUID, version int) (*domain.MessageSchema, error) {
+	return s.repository.GetSchema(ctx, tenantID, version)
+}
+
+// DeleteSchema removes a schema version. Without versions left payloads are no
+// longer validated.
+func (s *Service) DeleteSchema(ctx context.Context, tenantID uuid.UUID, version int) error {
+	if err := s.repository.DeleteSchema(ctx, tenantID, version); err != nil {
+		return err
+	}
+	s.schemas.invalidate(tenantID)
+	return nil
+}
@@ -0,0 +1,54 @@
+package message_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+
+	"github.com/fekalegi/multi-tenant-system/internal/broker"
+	"github.com/fekalegi/multi-tenant-system/internal/message"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRegisterSchema_When_RefIsExternal_Then_ItIsRejectedWithoutBeingLoaded(t *testing.T) {
+	ctx := context.Background()
+	h := newHarness(t, broker.NewMemory(), 0)
+	svc, tenantID := h.svc, h.tenantID
+
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		_, _ = w.Write([]byte(`{"type": "object"}`))
+	}))
+	t.Cleanup(server.Close)
+
+	file := filepath.Join(t.TempDir(), "schema.json")
+	require.NoError(t, os.WriteFile(file, []byte(`{"type": "object"}`), 0o600))
+
+	tests := []struct {
+		name   string
+		schema string
+	}{
+		{name: "http", schema: `{"$ref": "` + server.URL + `/schema.json"}`},
+		{name: "file", schema: `{"$ref": "file://` + file + `"}`},
+		{name: "nested", schema: `{"type": "object", "properties": {"a": {"$ref": "` + server.URL + `/schema.json#/a"}}}`},
+		{name: "relative to an external id", schema: `{"$id": "` + server.URL + `/root.json", "properties": {"a": {"$ref": "other.json"}}}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := svc.RegisterSchema(ctx, tenantID, []byte(tt.schema))
+			require.ErrorIs(t, err, message.ErrInvalidSchema)
+		})
+	}
+	require.Zero(t, requests.Load(), "external references should not be fetched")
+
+	// References into the schema itself still resolve
+	local := `{"definitions": {"name": {"type": "string"}}, "properties": {"name": {"$ref": "#/definitions/name"}}}`
+	registered, err := svc.RegisterSchema(ctx, tenantID, []byte(local))
+	require.NoError(t, err)
+	require.Equal(t, 1, registered.Version)
+}
@@ -4,56 +4,196 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
-	"fmt"
+	"github.com/fekalegi/multi-tenant-system/internal/broker"
 	"github.com/fekalegi/multi-tenant-system/internal/domain"
 	message2 "github.com/fekalegi/multi-tenant-system/internal/repository/postgresql"
 	"time"
 
-	"github.com/fekalegi/multi-tenant-system/internal/rabbitmq"
 	"github.com/google/uuid"
+	"github.com/rs/zerolog"
 )
 
 type Service struct {
-	publisher  *rabbitmq.Publisher
+	broker     broker.Broker
 	repository message2.MessageRepository
+	cursors    *cursorSigner
+	hub        *broadcaster
+	schemas    *schemaCache
+	// outbox wakes the relay once messages were stored
+	outbox chan struct{}
+	log    zerolog.Logger
 }
 
-func NewService(publisher *rabbitmq.Publisher, repo message2.MessageRepository) *Service {
+// Page is one page of messages with the cursors of its neighbouring pages.
+type Page struct {
+	Messages   []*domain.Message
+	NextCursor string
+	PrevCursor string
+}
+
+// NewService creates a message service. cursorSecret signs the pagination and stream
+// cursors. Live streams only receive messages while RunStream is running, and scheduled
+// messages are only published while RunScheduler is running. Published messages only
+// reach the broker while RunOutboxRelay is running on some instance.
+func NewService(b broker.Broker, repo message2.MessageRepository, cursorSecret string, log zerolog.Logger) *Service {
 	return &Service{
-		publisher:  publisher,
+		broker:     b,
 		repository: repo,
+		cursors:    &cursorSigner{key: []byte(cursorSecret)},
+		hub:        newBroadcaster(repo, log),
+		schemas:    &schemaCache{tenants: make(map[uuid.UUID]*compiledSchema)},
+		outbox:     make(chan struct{}, 1),
+		log:        log,
 	}
 }
 
-func (s *Service) PublishMessage(ctx context.Context, tenantID uuid.UUID, payload any) error {
+// PublishMessage validates a payload against the tenant's schema and stores it as a
+// queued message together with its outbox entry, from which the relay publishes it
+// under the same ID with the given priority. It returns the ID of the message, or a
+// *domain.SchemaValidationError for a payload that does not match the schema.
+func (s *Service) PublishMessage(ctx context.Context, tenantID uuid.UUID, payload any, priority uint8) (uuid.UUID, error) {
 	body, err := json.Marshal(payload)
 	if err != nil {
-		return err
+		return uuid.Nil, err
+	}
+
+	version, err := s.validatePayload(ctx, tenantID, body)
+	if err != nil {
+		return uuid.Nil, err
 	}
 
 	msg := &domain.Message{
-		ID:        uuid.New(),
-		TenantID:  tenantID,
-		Payload:   body,
-		CreatedAt: time.Now(),
+		ID:            uuid.New(),
+		TenantID:      tenantID,
+		Payload:       body,
+		Priority:      priority,
+		SchemaVersion: version,
+		CreatedAt:     time.Now(),
 	}
 
-	// Store in database first (can be swapped order if needed)
 	if err := s.repository.InsertMessage(ctx, msg); err != nil {
-		return err
+		return uuid.Nil, err
 	}
+	s.wakeRelay()
 
-	// Publish to RabbitMQ
-	if err := s.publisher.PublishToTenantQueue(tenantID.String(), body); err != nil {
-		return fmt.Errorf("rabbitmq publish error: %w", err)
+	return msg.ID, nil
+}
+
+// BatchResult is the outcome of one message of a batch publish. Err is a
+// *domain.SchemaValidationError for payloads that were rejected and never stored.
+type BatchResult struct {
+	ID  uuid.UUID
+	Err error
+}
+
+// PublishBatch validates the payloads against the tenant's schema and stores the valid
+// ones as queued messages with the given priority, together with their outbox entries,
+// in one transaction. It fails as a whole only when the messages cannot be stored;
+// invalid payloads are reported per message.
+func (s *Service) PublishBatch(ctx context.Context, tenantID uuid.UUID, payloads []json.RawMessage, priority uint8) ([]BatchResult, error) {
+	schema, err := s.currentSchema(ctx, tenantID)
+	if err != nil {
+		return nil, err
 	}
 
-	return nil
+	now := time.Now()
+	results := make([]BatchResult, len(payloads))
+	var msgs []*domain.Message
+	for i, payload := range payloads {
+		version, err := schema.validate(payload)
+		if err != nil {
+			results[i].Err = err
+			continue
+		}
+
+		msg := &domain.Message{
+			ID:            uuid.New(),
+			TenantID:      tenantID,
+			Payload:       payload,
+			Priority:      priority,
+			SchemaVersion: version,
+			CreatedAt:     now,
+		}
+		msgs = append(msgs, msg)
+		results[i].ID = msg.ID
+	}
+	if len(msgs) == 0 {
+		return results, nil
+	}
+
+	if err := s.repository.InsertMessages(ctx, msgs); err != nil {
+		return nil, err
+	}
+	s.wakeRelay()
+
+	return results, nil
 }
 
-func (s *Service) FetchMessagesWithCursor(ctx context.Context, cursor string, limit int) ([]*domain.Message, string, error) {
+// FetchMessagesWithCursor returns the page of messages matching the filter that the
+// cursor points at, or the first page without one. Cursors are only valid for the
+// filter and order they were issued with.
+func (s *Service) FetchMessagesWithCursor(ctx context.Context, filter domain.MessageFilter, descending bool, token string, limit int) (*Page, error) {
 	if limit <= 0 {
-		return nil, "", errors.New("limit must be > 0")
+		return nil, errors.New("limit must be > 0")
+	}
+
+	page := domain.MessagePage{Descending: descending, Limit: limit}
+	if token != "" {
+		c, err := s.cursors.decode(token, listingScope(filter, descending))
+		if err != nil {
+			return nil, err
+		}
+		page.From = c.position()
+		page.Backward = c.Backward
 	}
-	return s.repository.GetMessagesWithCursor(ctx, cursor, limit)
+
+	messages, more, err := s.repository.GetMessages(ctx, filter, page)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &Page{Messages: messages}
+	if len(messages) == 0 {
+		return result, nil
+	}
+
+	// Moving in one direction proves there is a page in the other
+	hasNext, hasPrev := more, page.From != nil
+	if page.Backward {
+		hasNext, hasPrev = page.From != nil, more
+	}
+
+	if hasNext {
+		last := messages[len(messages)-1]
+		result.NextCursor = s.cursors.encode(cursor{At: last.CreatedAt, ID: last.ID}, listingScope(filter, descending))
+	}
+	if hasPrev {
+		first := messages[0]
+		result.PrevCursor = s.cursors.encode(cursor{At: first.CreatedAt, ID: first.ID, Backward: true}, listingScope(filter, descending))
+	}
+	return result, nil
+}
+
+func (s *Service) GetMessage(ctx context.Context, tenantID, id uuid.UUID) (*domain.Message, error) {
+	return s.repository.GetMessage(ctx, tenantID, id)
+}
+
+// ListWebhookDeliveries returns the attempts to deliver a message to the tenant's
+// webhook, oldest first.
+func (s *Service) ListWebhookDeliveries(ctx context.Context, tenantID, id uuid.UUID) ([]*domain.WebhookDelivery, error) {
+	deliveries, err := s.repository.ListWebhookDeliveries(ctx, tenantID, id)
+	if err != nil || len(deliveries) > 0 {
+		return deliveries, err
+	}
+
+	// Tell a message without deliveries from one that does not exist
+	if _, err := s.repository.GetMessage(ctx, tenantID, id); err != nil {
+		return nil, err
+	}
+	return deliveries, nil
+}
+
+// DeleteMessage deletes a message once it is processed or failed.
+func (s *Service) DeleteMessage(ctx context.Context, tenantID, id uuid.UUID) error {
+	return s.repository.DeleteMessage(ctx, tenantID, id)
 }
@@ -0,0 +1,287 @@
+package message
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/fekalegi/multi-tenant-system/internal/domain"
+	message2 "github.com/fekalegi/multi-tenant-system/internal/repository/postgresql"
+	"github.com/google/uuid"
+	"github.com/rs/zerolog"
+)
+
+// ErrStreamLagged ends a stream whose subscriber fell behind or missed notifications.
+// The client should reconnect with its last cursor to catch up from the database.
+var ErrStreamLagged = errors.New("stream fell behind, reconnect with the last cursor")
+
+const (
+	// streamBuffer is how many messages a subscriber may have pending before it lags
+	streamBuffer = 256
+	// streamCatchUpBatch is the page size used to replay messages on resume
+	streamCatchUpBatch = 500
+	// listenRetryDelay is the pause before listening again after the connection failed
+	listenRetryDelay = time.Second
+)
+
+// StreamEvent is a processed message with the cursor to resume the stream after it.
+type StreamEvent struct {
+	Message *domain.Message
+	Cursor  string
+}
+
+type subscription struct {
+	tenantID uuid.UUID
+	messages chan *domain.Message
+	lagged   bool // guarded by broadcaster.mu
+}
+
+// broadcaster fans processed messages out to the stream subscribers of this instance.
+// Messages are announced through Postgres notifications, so messages processed by
+// any instance reach every subscriber.
+type broadcaster struct {
+	repo message2.MessageRepository
+	log  zerolog.Logger
+
+	mu     sync.Mutex
+	subs   map[uuid.UUID]map[*subscription]struct{}
+	closed bool
+}
+
+func newBroadcaster(repo message2.MessageRepository, log zerolog.Logger) *broadcaster {
+	return &broadcaster{
+		repo: repo,
+		log:  log,
+		subs: make(map[uuid.UUID]map[*subscription]struct{}),
+	}
+}
+
+func (b *broadcaster) subscribe(tenantID uuid.UUID) *subscription {
+	sub := &subscription{tenantID: tenantID, messages: make(chan *domain.Message, streamBuffer)}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.closed {
+		close(sub.messages)
+		return sub
+	}
+	if b.subs[tenantID] == nil {
+		b.subs[tenantID] = make(map[*subscription]struct{})
+	}
+	b.subs[tenantID][sub] = struct{}{}
+	return sub
+}
+
+func (b *broadcaster) unsubscribe(sub *subscription) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if _, ok := b.subs[sub.tenantID][sub]; !ok {
+		return
+	}
+	b.drop(sub)
+}
+
+// isLagged reports whether the subscription was ended for falling behind.
+func (b *broadcaster) isLagged(sub *subscription) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return sub.lagged
+}
+
+// drop removes a subscription and closes its channel. Callers hold b.mu.
+func (b *broadcaster) drop(sub *subscription) {
+	delete(b.subs[sub.tenantID], sub)
+	if len(b.subs[sub.tenantID]) == 0 {
+		delete(b.subs, sub.tenantID)
+	}
+	close(sub.messages)
+}
+
+// run listens for processed messages until ctx is done, then ends every subscription.
+func (b *broadcaster) run(ctx context.Context) {
+	for {
+		err := b.repo.ListenProcessed(ctx, func(tenantID, id uuid.UUID) {
+			b.dispatch(ctx, tenantID, id)
+		})
+		if ctx.Err() != nil {
+			b.close()
+			return
+		}
+
+		// Notifications sent meanwhile are lost, subscribers have to catch up
+		b.log.Warn().Err(err).Dur("retry_in", listenRetryDelay).Msg("Listening for processed messages failed")
+		b.lagAll()
+
+		select {
+		case <-ctx.Done():
+			b.close()
+			return
+		case <-time.After(listenRetryDelay):
+		}
+	}
+}
+
+func (b *broadcaster) dispatch(ctx context.Context, tenantID, id uuid.UUID) {
+	b.mu.Lock()
+	interested := len(b.subs[tenantID]) > 0
+	b.mu.Unlock()
+	if !interested {
+		return
+	}
+
+	msg, err := b.repo.GetMessage(ctx, tenantID, id)
+	if err != nil {
+		b.log.Warn().Err(err).Str("tenant_id", tenantID.String()).Str("msg_id", id.String()).Msg("Failed to load processed message for streaming")
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for sub := range b.subs[tenantID] {
+		select {
+		case sub.messages <- msg:
+		default:
+			sub.lagged = true
+			b.drop(sub)
+		}
+	}
+}
+
+func (b *broadcaster) lagAll() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for _, subs := range b.subs {
+		for sub := range subs {
+			sub.lagged = true
+			b.drop(sub)
+		}
+	}
+}
+
+func (b *broadcaster) close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.closed = true
+	for _, subs := range b.subs {
+		for sub := range subs {
+			b.drop(sub)
+		}
+	}
+}
+
+// RunStream delivers processed messages to the live streams until ctx is done, which
+// also ends every open stream.
+func (s *Service) RunStream(ctx context.Context) {
+	s.hub.run(ctx)
+}
+
+// ParseStreamCursor validates the resume cursor of a tenant's stream and returns the
+// processed sequence number to resume after.
+func (s *Service) ParseStreamCursor(tenantID uuid.UUID, token string) (int64, error) {
+	c, err := s.cursors.decode(token, streamScope(tenantID))
+	if err != nil {
+		return 0, err
+	}
+	// Cursors of earlier versions held a processing time, which does not follow commit order
+	if c.Seq <= 0 {
+		return 0, ErrInvalidCursor
+	}
+	return c.Seq, nil
+}
+
+// Stream sends the messages of a tenant to events as they are processed, in the order
+// their processing committed. With a positive afterSeq, as returned by
+// ParseStreamCursor, it first replays the messages processed since. Stream returns nil
+// when ctx is done or the service shuts down, and ErrStreamLagged when the subscriber
+// could not keep up.
+func (s *Service) Stream(ctx context.Context, tenantID uuid.UUID, afterSeq int64, events chan<- StreamEvent) error {
+	// Subscribe before reading the position so nothing processed in between is missed
+	sub := s.hub.subscribe(tenantID)
+	defer s.hub.unsubscribe(sub)
+
+	last := afterSeq
+	if last <= 0 {
+		var err error
+		if last, err = s.repository.LastProcessedSeq(ctx, tenantID); err != nil {
+			return streamError(ctx, err)
+		}
+	}
+
+	send := func(msg *domain.Message) bool {
+		event := StreamEvent{Message: msg, Cursor: s.cursors.encode(cursor{Seq: msg.ProcessedSeq}, streamScope(tenantID))}
+		select {
+		case events <- event:
+			last = msg.ProcessedSeq
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	}
+
+	// catchUp sends the messages processed after the last one sent from the database
+	catchUp := func() (bool, error) {
+		for {
+			msgs, err := s.repository.GetProcessedAfter(ctx, tenantID, last, streamCatchUpBatch)
+			if err != nil {
+				return false, err
+			}
+			for _, msg := range msgs {
+				if !send(msg) {
+					return false, nil
+				}
+			}
+			if len(msgs) < streamCatchUpBatch {
+				return true, nil
+			}
+		}
+	}
+
+	if afterSeq > 0 {
+		if ok, err := catchUp(); !ok {
+			return streamError(ctx, err)
+		}
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case msg, ok := <-sub.messages:
+			if !ok {
+				if s.hub.isLagged(sub) {
+					return ErrStreamLagged
+				}
+				return nil
+			}
+
+			switch {
+			case msg.ProcessedSeq <= last:
+				// Sent already, or no longer processed
+			case msg.ProcessedSeq == last+1:
+				if !send(msg) {
+					return nil
+				}
+			default:
+				// Notifications do not arrive in commit order. Every lower number is
+				// committed by now, so the database fills the gap, this message included.
+				if ok, err := catchUp(); !ok {
+					return streamError(ctx, err)
+				}
+			}
+		}
+	}
+}
+
+// streamError is the error a stream ends with after err, none once ctx is done.
+func streamError(ctx context.Context, err error) error {
+	if ctx.Err() != nil {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,180 @@
+package message_test
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/fekalegi/multi-tenant-system/internal/broker"
+	"github.com/fekalegi/multi-tenant-system/internal/domain"
+	"github.com/fekalegi/multi-tenant-system/internal/message"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+// newStreamHarness returns a harness running the live stream.
+func newStreamHarness(t *testing.T) *harness {
+	h := newHarness(t, broker.NewMemory(), 0)
+	ctx, cancel := context.WithCancel(context.Background())
+	go h.svc.RunStream(ctx)
+	t.Cleanup(cancel)
+	return h
+}
+
+// process stores messages and marks them processed, in order, without announcing them.
+func (h *harness) process(count int) []uuid.UUID {
+	ctx := context.Background()
+	ids := make([]uuid.UUID, count)
+	for i := range ids {
+		ids[i] = uuid.New()
+		require.NoError(h.t, h.messages.InsertMessage(ctx, &domain.Message{ID: ids[i], TenantID: h.tenantID, Payload: json.RawMessage(`{}`), CreatedAt: time.Now()}))
+		require.NoError(h.t, h.messages.UpdateMessageStatus(ctx, h.tenantID, ids[i], domain.MessageStatusProcessed, ""))
+	}
+	return ids
+}
+
+func (h *harness) notify(ids ...uuid.UUID) {
+	for _, id := range ids {
+		require.NoError(h.t, h.messages.NotifyProcessed(context.Background(), h.tenantID, id))
+	}
+}
+
+type testStream struct {
+	t      *testing.T
+	events chan message.StreamEvent
+	done   chan error
+	cancel context.CancelFunc
+}
+
+// open starts a stream, resuming after afterSeq when it is positive.
+func (h *harness) open(afterSeq int64) *testStream {
+	ctx, cancel := context.WithCancel(context.Background())
+	h.t.Cleanup(cancel)
+
+	s := &testStream{t: h.t, events: make(chan message.StreamEvent), done: make(chan error, 1), cancel: cancel}
+	go func() { s.done <- h.svc.Stream(ctx, h.tenantID, afterSeq, s.events) }()
+	return s
+}
+
+func (s *testStream) next() message.StreamEvent {
+	select {
+	case event := <-s.events:
+		return event
+	case err := <-s.done:
+		s.t.Fatalf("Stream ended early: %v", err)
+	case <-time.After(5 * time.Second):
+		s.t.Fatal("No message streamed")
+	}
+	return message.StreamEvent{}
+}
+
+// goLive waits until the streams are subscribed. A stream only sends what is processed
+// after it subscribed, so markers are processed until every stream sent the latest one,
+// which leaves none of them with an event pending.
+func (h *harness) goLive(streams ...*testStream) {
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		marker := h.process(1)[0]
+		h.notify(marker)
+
+		live := true
+		for _, s := range streams {
+			live = s.receives(marker) && live
+		}
+		if live {
+			return
+		}
+	}
+	h.t.Fatal("Streams did not go live")
+}
+
+// receives reads events until the one of id, briefly.
+func (s *testStream) receives(id uuid.UUID) bool {
+	for {
+		select {
+		case event := <-s.events:
+			if event.Message.ID == id {
+				return true
+			}
+		case <-time.After(50 * time.Millisecond):
+			return false
+		}
+	}
+}
+
+func TestStream_When_NotificationsArriveOutOfOrder_Then_MessagesAreSentInCommitOrder(t *testing.T) {
+	h := newStreamHarness(t)
+	s := h.open(0)
+	h.goLive(s)
+
+	ids := h.process(3)
+	h.notify(ids[2], ids[0], ids[1])
+	for _, id := range ids {
+		require.Equal(t, id, s.next().Message.ID)
+	}
+
+	// The late notifications of messages sent already are skipped
+	last := h.process(1)
+	h.notify(last...)
+	require.Equal(t, last[0], s.next().Message.ID)
+}
+
+func TestStream_When_Resumed_Then_MessagesProcessedMeanwhileAreReplayed(t *testing.T) {
+	h := newStreamHarness(t)
+	s := h.open(0)
+	h.goLive(s)
+
+	seen := h.process(1)
+	h.notify(seen...)
+	event := s.next()
+	require.Equal(t, seen[0], event.Message.ID)
+	s.cancel()
+	require.NoError(t, <-s.done)
+
+	// Processed while disconnected, their notifications reach nobody
+	missed := h.process(3)
+	h.notify(missed...)
+
+	afterSeq, err := h.svc.ParseStreamCursor(h.tenantID, event.Cursor)
+	require.NoError(t, err)
+	_, err = h.svc.ParseStreamCursor(uuid.New(), event.Cursor)
+	require.ErrorIs(t, err, message.ErrInvalidCursor, "A cursor only resumes the stream of its tenant")
+
+	resumed := h.open(afterSeq)
+	for _, id := range missed {
+		require.Equal(t, id, resumed.next().Message.ID)
+	}
+
+	// Then it goes live
+	live := h.process(1)
+	h.notify(live...)
+	require.Equal(t, live[0], resumed.next().Message.ID)
+}
+
+func TestStream_When_SubscriberFallsBehind_Then_StreamLags(t *testing.T) {
+	h := newStreamHarness(t)
+	lagging, witness := h.open(0), h.open(0)
+	h.goLive(lagging, witness)
+
+	// The lagging stream stops reading. Once the witness received a message, its
+	// notification reached every subscriber.
+	for range 3 {
+		ids := h.process(100)
+		h.notify(ids...)
+		for _, id := range ids {
+			require.Equal(t, id, witness.next().Message.ID)
+		}
+	}
+
+	for {
+		select {
+		case <-lagging.events:
+		case err := <-lagging.done:
+			require.ErrorIs(t, err, message.ErrStreamLagged)
+			return
+		case <-time.After(5 * time.Second):
+			t.Fatal("The lagging stream should end")
+		}
+	}
+}
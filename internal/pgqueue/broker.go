@@ -0,0 +1,399 @@
+// Package pgqueue implements broker.Broker on PostgreSQL, for deployments without
+// RabbitMQ. Every tenant queue is a partition of the queue_jobs table.
+package pgqueue
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fekalegi/multi-tenant-system/internal/broker"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/rs/zerolog"
+)
+
+// notifyChannel is the notification channel announcing published jobs, with the
+// tenant ID as payload.
+const notifyChannel = "queue_jobs"
+
+var errClosed = errors.New("postgres broker: closed")
+
+// Options tune the Postgres broker. A claimed job is hidden from other consumers for
+// VisibilityTimeout, which its consumer keeps extending while it holds the job, so only
+// the jobs of a consumer that died are delivered again. Consumers are woken through
+// LISTEN/NOTIFY and also look for jobs every PollInterval, which picks up retries once
+// they are due and notifications missed while the listener reconnected.
+type Options struct {
+	VisibilityTimeout time.Duration
+	PollInterval      time.Duration
+}
+
+// Broker keeps tenant queues in Postgres. Consumers claim jobs with SELECT ... FOR
+// UPDATE SKIP LOCKED, so any number of instances may consume a queue side by side.
+// Retries wait in the queue until they are due and dead-lettered jobs stay in it,
+// marked dead.
+type Broker struct {
+	db   *pgxpool.Pool
+	opts Options
+	log  zerolog.Logger
+
+	// ctx ends with Close, stopping the listener and every subscription
+	ctx    context.Context
+	cancel context.CancelFunc
+	done   chan struct{}
+
+	mu sync.Mutex
+	// subscriptions are woken when a job is published to their tenant queue
+	subscriptions map[string]map[*subscription]struct{}
+	consumers     map[string]int
+}
+
+// NewBroker returns a broker on db and starts listening for published jobs. Zero
+// options default to a 30s visibility timeout and a 1s poll interval.
+func NewBroker(db *pgxpool.Pool, opts Options, log zerolog.Logger) *Broker {
+	if opts.VisibilityTimeout <= 0 {
+		opts.VisibilityTimeout = 30 * time.Second
+	}
+	if opts.PollInterval <= 0 {
+		opts.PollInterval = time.Second
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	b := &Broker{
+		db:            db,
+		opts:          opts,
+		log:           log,
+		ctx:           ctx,
+		cancel:        cancel,
+		done:          make(chan struct{}),
+		subscriptions: make(map[string]map[*subscription]struct{}),
+		consumers:     make(map[string]int),
+	}
+	go b.listen()
+	return b
+}
+
+// unavailable wraps err in broker.ErrUnavailable when it means the database cannot be
+// reached, so callers can tell an outage from a failing statement.
+func unavailable(err error) error {
+	if err == nil || errors.Is(err, broker.ErrUnavailable) {
+		return err
+	}
+
+	var (
+		connectErr *pgconn.ConnectError
+		netErr     net.Error
+		pgErr      *pgconn.PgError
+	)
+	switch {
+	case errors.As(err, &connectErr), errors.As(err, &netErr):
+	// Connection exceptions, shutdowns and too many connections
+	case errors.As(err, &pgErr) && (strings.HasPrefix(pgErr.Code, "08") || strings.HasPrefix(pgErr.Code, "57P") || pgErr.Code == "53300"):
+	default:
+		return err
+	}
+	return fmt.Errorf("%w: %w", broker.ErrUnavailable, err)
+}
+
+// partition names the queue_jobs partition of a tenant. The tenant ID is parsed so
+// nothing but a UUID ends up in the DDL.
+func partition(tenantID string) (string, uuid.UUID, error) {
+	id, err := uuid.Parse(tenantID)
+	if err != nil {
+		return "", uuid.Nil, fmt.Errorf("%w: invalid tenant id %q", broker.ErrUnroutable, tenantID)
+	}
+	return "queue_jobs_tenant_" + strings.ReplaceAll(id.String(), "-", "_"), id, nil
+}
+
+// DeclareTenantQueue creates the queue_jobs partition of the tenant.
+func (b *Broker) DeclareTenantQueue(ctx context.Context, tenantID string) error {
+	name, id, err := partition(tenantID)
+	if err != nil {
+		return err
+	}
+
+	_, err = b.db.Exec(ctx, fmt.Sprintf(
+		"CREATE TABLE IF NOT EXISTS %s PARTITION OF queue_jobs FOR VALUES IN ('%s')",
+		pgx.Identifier{name}.Sanitize(),
+		id,
+	))
+	if err != nil {
+		return unavailable(fmt.Errorf("could not create queue for tenant %s: %w", tenantID, err))
+	}
+	return nil
+}
+
+// DeleteTenantQueue drops the queue_jobs partition of the tenant with its jobs.
+func (b *Broker) DeleteTenantQueue(ctx context.Context, tenantID string) error {
+	name, _, err := partition(tenantID)
+	if err != nil {
+		return err
+	}
+
+	_, err = b.db.Exec(ctx, "DROP TABLE IF EXISTS "+pgx.Identifier{name}.Sanitize())
+	if err != nil {
+		return unavailable(fmt.Errorf("could not drop queue for tenant %s: %w", tenantID, err))
+	}
+	return nil
+}
+
+// Publish stores a job in the tenant queue and notifies its consumers.
+func (b *Broker) Publish(ctx context.Context, tenantID string, msg broker.Message) error {
+	return b.PublishBatch(ctx, tenantID, []broker.Message{msg})[0]
+}
+
+// PublishBatch stores jobs in the tenant queue through COPY in one transaction, so
+// either every message is taken or none is.
+func (b *Broker) PublishBatch(ctx context.Context, tenantID string, msgs []broker.Message) []error {
+	err := unavailable(b.publish(ctx, tenantID, msgs))
+
+	errs := make([]error, len(msgs))
+	for i := range errs {
+		errs[i] = err
+	}
+	return errs
+}
+
+func (b *Broker) publish(ctx context.Context, tenantID string, msgs []broker.Message) error {
+	_, id, err := partition(tenantID)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	rows := make([][]any, len(msgs))
+	for i, m := range msgs {
+		publishedAt := m.Timestamp
+		if publishedAt.IsZero() {
+			publishedAt = now
+		}
+		rows[i] = []any{id, m.ID, m.Body, int16(m.Priority), publishedAt}
+	}
+
+	tx, err := b.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	_, err = tx.CopyFrom(ctx,
+		pgx.Identifier{"queue_jobs"},
+		[]string{"tenant_id", "message_id", "body", "priority", "published_at"},
+		pgx.CopyFromRows(rows),
+	)
+
+	// A tenant without a partition has no queue
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == "23514" {
+		return broker.ErrUnroutable
+	}
+	if err != nil {
+		return fmt.Errorf("could not queue messages: %w", err)
+	}
+
+	// Delivered to the listeners once the transaction commits
+	if _, err := tx.Exec(ctx, "SELECT pg_notify($1, $2)", notifyChannel, id.String()); err != nil {
+		return fmt.Errorf("could not notify consumers: %w", err)
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return err
+	}
+
+	b.wake(id.String())
+	return nil
+}
+
+// Forward publishes a message to the tenant queue named by routingKey. Exchanges other
+// than the default one do not exist.
+func (b *Broker) Forward(ctx context.Context, exchange, routingKey, messageID string, body []byte, priority uint8) error {
+	if exchange != "" {
+		return fmt.Errorf("%w: the postgres broker has no exchange %q", broker.ErrUnroutable, exchange)
+	}
+
+	tenantID, ok := strings.CutPrefix(routingKey, "tenant_")
+	if ok {
+		tenantID, ok = strings.CutSuffix(tenantID, "_queue")
+	}
+	if !ok {
+		return broker.ErrUnroutable
+	}
+	return b.Publish(ctx, tenantID, broker.Message{ID: messageID, Body: body, Priority: priority})
+}
+
+// Subscribe opens a subscription claiming the jobs of the tenant queue.
+func (b *Broker) Subscribe(tenantID string) (broker.Subscription, error) {
+	_, id, err := partition(tenantID)
+	if err != nil {
+		return nil, err
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.ctx.Err() != nil {
+		return nil, errClosed
+	}
+
+	s := &subscription{
+		b:        b,
+		tenantID: id.String(),
+		tenant:   id,
+		token:    uuid.New(),
+		wakeup:   make(chan struct{}, 1),
+		held:     make(map[*broker.Delivery]int64),
+		closing:  make(chan struct{}),
+	}
+	if b.subscriptions[s.tenantID] == nil {
+		b.subscriptions[s.tenantID] = make(map[*subscription]struct{})
+	}
+	b.subscriptions[s.tenantID][s] = struct{}{}
+
+	go s.heartbeat()
+	return s, nil
+}
+
+// Inspect returns the number of jobs ready to be claimed from the tenant queue and the
+// subscriptions of this instance consuming it.
+func (b *Broker) Inspect(ctx context.Context, tenantID string) (broker.QueueStats, error) {
+	_, id, err := partition(tenantID)
+	if err != nil {
+		return broker.QueueStats{}, err
+	}
+
+	var ready int
+	err = b.db.QueryRow(ctx, `
+		SELECT COUNT(*)
+		FROM queue_jobs
+		WHERE tenant_id = $1 AND dead_at IS NULL AND available_at <= NOW()
+		AND (locked_until IS NULL OR locked_until <= NOW())
+	`, id).Scan(&ready)
+	if err != nil {
+		return broker.QueueStats{}, unavailable(fmt.Errorf("could not inspect queue: %w", err))
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return broker.QueueStats{Messages: ready, Consumers: b.consumers[id.String()]}, nil
+}
+
+func (b *Broker) DeadLetters() broker.DeadLetters {
+	return deadLetters{b: b}
+}
+
+// WaitReady blocks until Postgres answers a ping.
+func (b *Broker) WaitReady(ctx context.Context) error {
+	for {
+		if b.ctx.Err() != nil {
+			return errClosed
+		}
+		if err := b.db.Ping(ctx); err == nil {
+			return nil
+		}
+
+		select {
+		case <-time.After(b.opts.PollInterval):
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-b.ctx.Done():
+			return errClosed
+		}
+	}
+}
+
+// OnReconnect does nothing, tenant queues are tables and outlive lost connections.
+func (b *Broker) OnReconnect(fn func()) {}
+
+// Close stops the listener and ends every subscription. The pool stays open, it is
+// shared with the repositories; deliveries received before can still be settled until
+// it is closed, so close it after the consumers drained.
+func (b *Broker) Close() {
+	b.cancel()
+	<-b.done
+}
+
+// listen wakes the subscriptions of the tenants jobs are published for, reconnecting
+// until the broker is closed.
+func (b *Broker) listen() {
+	defer close(b.done)
+
+	for {
+		err := b.listenOnce()
+		if b.ctx.Err() != nil {
+			return
+		}
+		b.log.Warn().Err(err).Msg("Queue listener lost its connection, reconnecting")
+
+		select {
+		case <-time.After(b.opts.PollInterval):
+		case <-b.ctx.Done():
+			return
+		}
+	}
+}
+
+func (b *Broker) listenOnce() error {
+	pooled, err := b.db.Acquire(b.ctx)
+	if err != nil {
+		return fmt.Errorf("could not acquire listen connection: %w", err)
+	}
+	// A connection that listened must not go back to the pool
+	conn := pooled.Hijack()
+	defer conn.Close(context.Background())
+
+	if _, err := conn.Exec(b.ctx, "LISTEN "+notifyChannel); err != nil {
+		return fmt.Errorf("could not listen for queued messages: %w", err)
+	}
+	// Jobs may have been published while nobody listened
+	b.wakeAll()
+
+	for {
+		n, err := conn.WaitForNotification(b.ctx)
+		if err != nil {
+			return err
+		}
+		b.wake(n.Payload)
+	}
+}
+
+// wake tells the subscriptions of a tenant to look for jobs.
+func (b *Broker) wake(tenantID string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for s := range b.subscriptions[tenantID] {
+		s.poke()
+	}
+}
+
+func (b *Broker) wakeAll() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for _, subs := range b.subscriptions {
+		for s := range subs {
+			s.poke()
+		}
+	}
+}
+
+func (b *Broker) addConsumer(tenantID string, delta int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.consumers[tenantID] += delta
+}
+
+func (b *Broker) unsubscribe(s *subscription) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.subscriptions[s.tenantID], s)
+	if len(b.subscriptions[s.tenantID]) == 0 {
+		delete(b.subscriptions, s.tenantID)
+	}
+}
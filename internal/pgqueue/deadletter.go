@@ -0,0 +1,139 @@
+package pgqueue
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/fekalegi/multi-tenant-system/internal/domain"
+	"github.com/jackc/pgx/v5"
+)
+
+// deadLetterTimeout bounds the dead-letter queries, whose interface takes no context.
+const deadLetterTimeout = 10 * time.Second
+
+// deadLetters manages the jobs marked dead in the tenant queues. A message ID that
+// was dead-lettered more than once refers to the oldest of its jobs.
+type deadLetters struct {
+	b *Broker
+}
+
+func (l deadLetters) List(tenantID string, limit int) ([]*domain.DeadLetter, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), deadLetterTimeout)
+	defer cancel()
+
+	rows, err := l.b.db.Query(ctx, `
+		SELECT tenant_id, message_id, body, attempts, COALESCE(last_error, ''), dead_at
+		FROM queue_jobs
+		WHERE tenant_id = $1 AND dead_at IS NOT NULL
+		ORDER BY dead_at, id
+		LIMIT $2
+	`, tenantID, limit)
+	if err != nil {
+		return nil, unavailable(fmt.Errorf("could not list dead letters: %w", err))
+	}
+	defer rows.Close()
+
+	letters := []*domain.DeadLetter{}
+	for rows.Next() {
+		letter, err := scanDeadLetter(rows)
+		if err != nil {
+			return nil, err
+		}
+		letters = append(letters, letter)
+	}
+	return letters, rows.Err()
+}
+
+func (l deadLetters) Get(tenantID, messageID string) (*domain.DeadLetter, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), deadLetterTimeout)
+	defer cancel()
+
+	letter, err := scanDeadLetter(l.b.db.QueryRow(ctx, `
+		SELECT tenant_id, message_id, body, attempts, COALESCE(last_error, ''), dead_at
+		FROM queue_jobs
+		WHERE tenant_id = $1 AND message_id = $2 AND dead_at IS NOT NULL
+		ORDER BY dead_at, id
+		LIMIT 1
+	`, tenantID, messageID))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, domain.ErrDeadLetterNotFound
+	}
+	if err != nil {
+		return nil, unavailable(fmt.Errorf("could not get dead letter: %w", err))
+	}
+	return letter, nil
+}
+
+func (l deadLetters) Delete(tenantID, messageID string) error {
+	return l.update(tenantID, messageID, `DELETE FROM queue_jobs`)
+}
+
+// Replay makes the job claimable again with a fresh attempt count.
+func (l deadLetters) Replay(tenantID, messageID string) error {
+	if err := l.update(tenantID, messageID, replayJobs); err != nil {
+		return err
+	}
+	l.b.wake(tenantID)
+	return nil
+}
+
+func (l deadLetters) ReplayAll(tenantID string) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), deadLetterTimeout)
+	defer cancel()
+
+	tag, err := l.b.db.Exec(ctx, replayJobs+` WHERE tenant_id = $1 AND dead_at IS NOT NULL`, tenantID)
+	if err != nil {
+		return 0, unavailable(fmt.Errorf("could not replay dead letters: %w", err))
+	}
+	l.b.wake(tenantID)
+	return int(tag.RowsAffected()), nil
+}
+
+// replayJobs is the statement moving dead jobs back into their queue, without its
+// WHERE clause.
+const replayJobs = `
+	UPDATE queue_jobs
+	SET dead_at = NULL, last_error = NULL, attempts = 0, available_at = NOW(), locked_by = NULL, locked_until = NULL`
+
+// update runs statement, an UPDATE or DELETE on queue_jobs without its WHERE clause,
+// on the oldest dead job of a message.
+func (l deadLetters) update(tenantID, messageID, statement string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), deadLetterTimeout)
+	defer cancel()
+
+	tag, err := l.b.db.Exec(ctx, statement+`
+		WHERE tenant_id = $1 AND id = (
+			SELECT id
+			FROM queue_jobs
+			WHERE tenant_id = $1 AND message_id = $2 AND dead_at IS NOT NULL
+			ORDER BY dead_at, id
+			LIMIT 1
+		)
+	`, tenantID, messageID)
+	if err != nil {
+		return unavailable(fmt.Errorf("could not update dead letter: %w", err))
+	}
+	if tag.RowsAffected() == 0 {
+		return domain.ErrDeadLetterNotFound
+	}
+	return nil
+}
+
+func scanDeadLetter(row pgx.Row) (*domain.DeadLetter, error) {
+	var (
+		letter domain.DeadLetter
+		body   []byte
+	)
+	if err := row.Scan(&letter.TenantID, &letter.ID, &body, &letter.Attempts, &letter.Error, &letter.FailedAt); err != nil {
+		return nil, err
+	}
+
+	letter.Payload = body
+	if !json.Valid(body) {
+		letter.Payload, _ = json.Marshal(string(body))
+	}
+	return &letter, nil
+}
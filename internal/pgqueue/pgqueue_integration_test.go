@@ -0,0 +1,217 @@
+//go:build integration
+
+package pgqueue_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/fekalegi/multi-tenant-system/db"
+	"github.com/fekalegi/multi-tenant-system/internal/broker"
+	"github.com/fekalegi/multi-tenant-system/internal/pgqueue"
+	"github.com/fekalegi/multi-tenant-system/pkg/logger"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/ory/dockertest/v3"
+	"github.com/ory/dockertest/v3/docker"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+)
+
+type PgQueueTestSuite struct {
+	suite.Suite
+	dbPool *pgxpool.Pool
+	opts   pgqueue.Options
+	broker *pgqueue.Broker
+}
+
+func (s *PgQueueTestSuite) SetupSuite() {
+	pool, err := dockertest.NewPool("")
+	require.NoError(s.T(), err, "Could not construct docker pool")
+
+	pgResource, err := pool.RunWithOptions(&dockertest.RunOptions{
+		Repository: "postgres", Tag: "13",
+		Env: []string{"POSTGRES_USER=testuser", "POSTGRES_PASSWORD=testpassword", "POSTGRES_DB=testdb"},
+	}, func(config *docker.HostConfig) {
+		config.AutoRemove = true
+		config.RestartPolicy = docker.RestartPolicy{Name: "no"}
+	})
+	require.NoError(s.T(), err, "Could not start PostgreSQL resource")
+	s.T().Cleanup(func() {
+		require.NoError(s.T(), pool.Purge(pgResource))
+	})
+
+	databaseURL := fmt.Sprintf("postgres://testuser:testpassword@%s/testdb?sslmode=disable", pgResource.GetHostPort("5432/tcp"))
+	require.NoError(s.T(), pool.Retry(func() error {
+		var err error
+		s.dbPool, err = pgxpool.New(context.Background(), databaseURL)
+		if err != nil {
+			return err
+		}
+		return s.dbPool.Ping(context.Background())
+	}), "Could not connect to PostgreSQL")
+	require.NoError(s.T(), db.RunMigrations(s.dbPool), "Could not run migrations")
+
+	s.opts = pgqueue.Options{
+		VisibilityTimeout: 2 * time.Second,
+		PollInterval:      100 * time.Millisecond,
+	}
+	s.broker = pgqueue.NewBroker(s.dbPool, s.opts, logger.New())
+}
+
+func (s *PgQueueTestSuite) TearDownSuite() {
+	s.broker.Close()
+	s.dbPool.Close()
+}
+
+// declareTenant creates a tenant queue for one test.
+func (s *PgQueueTestSuite) declareTenant() string {
+	tenantID := uuid.NewString()
+	require.NoError(s.T(), s.broker.DeclareTenantQueue(context.Background(), tenantID))
+	s.T().Cleanup(func() {
+		require.NoError(s.T(), s.broker.DeleteTenantQueue(context.Background(), tenantID))
+	})
+	return tenantID
+}
+
+// consume subscribes to a tenant queue, closing the subscription when the test ends.
+func (s *PgQueueTestSuite) consume(b *pgqueue.Broker, tenantID string, prefetch int) <-chan *broker.Delivery {
+	sub, err := b.Subscribe(tenantID)
+	require.NoError(s.T(), err)
+	deliveries, err := sub.Consume(prefetch)
+	require.NoError(s.T(), err)
+	s.T().Cleanup(func() { _ = sub.Close() })
+	return deliveries
+}
+
+func (s *PgQueueTestSuite) receive(deliveries <-chan *broker.Delivery, within time.Duration) *broker.Delivery {
+	select {
+	case d, ok := <-deliveries:
+		require.True(s.T(), ok, "Delivery channel should stay open")
+		return d
+	case <-time.After(within):
+		s.T().Fatal("No message delivered")
+		return nil
+	}
+}
+
+func (s *PgQueueTestSuite) TestPublishToUndeclaredQueueIsUnroutable() {
+	err := s.broker.Publish(context.Background(), uuid.NewString(), broker.Message{ID: "m", Body: []byte(`{}`)})
+	require.ErrorIs(s.T(), err, broker.ErrUnroutable)
+}
+
+func (s *PgQueueTestSuite) TestHigherPriorityIsDeliveredFirst() {
+	ctx := context.Background()
+	tenantID := s.declareTenant()
+
+	errs := s.broker.PublishBatch(ctx, tenantID, []broker.Message{
+		{ID: "low", Body: []byte(`{"n": 1}`), Priority: 1},
+		{ID: "high", Body: []byte(`{"n": 2}`), Priority: 9},
+	})
+	require.NoError(s.T(), errors.Join(errs...))
+
+	deliveries := s.consume(s.broker, tenantID, 10)
+	first := s.receive(deliveries, 5*time.Second)
+	second := s.receive(deliveries, 5*time.Second)
+	require.Equal(s.T(), "high", first.ID)
+	require.Equal(s.T(), "low", second.ID)
+
+	require.NoError(s.T(), first.Ack())
+	require.NoError(s.T(), second.Ack())
+
+	stats, err := s.broker.Inspect(ctx, tenantID)
+	require.NoError(s.T(), err)
+	require.Equal(s.T(), 0, stats.Messages)
+	require.Equal(s.T(), 1, stats.Consumers)
+}
+
+func (s *PgQueueTestSuite) TestRetryThenDeadLetterThenReplay() {
+	ctx := context.Background()
+	tenantID := s.declareTenant()
+	deliveries := s.consume(s.broker, tenantID, 1)
+
+	require.NoError(s.T(), s.broker.Publish(ctx, tenantID, broker.Message{ID: "job", Body: []byte(`{"n": 1}`)}))
+
+	d := s.receive(deliveries, 5*time.Second)
+	require.Equal(s.T(), 0, d.Attempts)
+	require.NoError(s.T(), d.Retry(200*time.Millisecond))
+
+	d = s.receive(deliveries, 5*time.Second)
+	require.Equal(s.T(), 1, d.Attempts)
+	require.NoError(s.T(), d.DeadLetter(errors.New("boom")))
+
+	dead, err := s.broker.DeadLetters().Get(tenantID, "job")
+	require.NoError(s.T(), err)
+	require.Equal(s.T(), 2, dead.Attempts)
+	require.Equal(s.T(), "boom", dead.Error)
+	require.JSONEq(s.T(), `{"n": 1}`, string(dead.Payload))
+
+	require.NoError(s.T(), s.broker.DeadLetters().Replay(tenantID, "job"))
+	d = s.receive(deliveries, 5*time.Second)
+	require.Equal(s.T(), 0, d.Attempts)
+	require.NoError(s.T(), d.Ack())
+
+	letters, err := s.broker.DeadLetters().List(tenantID, 10)
+	require.NoError(s.T(), err)
+	require.Empty(s.T(), letters)
+}
+
+func (s *PgQueueTestSuite) TestJobOfDeadConsumerIsDeliveredAfterVisibilityTimeout() {
+	ctx := context.Background()
+	tenantID := s.declareTenant()
+
+	// A second instance claims the job and dies without settling it
+	other := pgqueue.NewBroker(s.dbPool, s.opts, logger.New())
+	require.NoError(s.T(), s.broker.Publish(ctx, tenantID, broker.Message{ID: "orphan", Body: []byte(`{}`)}))
+	claimed := s.receive(s.consume(other, tenantID, 1), 5*time.Second)
+	other.Close()
+
+	d := s.receive(s.consume(s.broker, tenantID, 1), 2*s.opts.VisibilityTimeout+time.Second)
+	require.Equal(s.T(), "orphan", d.ID)
+	require.Equal(s.T(), 1, d.Attempts, "The claim of the dead consumer counts an attempt")
+	require.NoError(s.T(), d.Ack())
+
+	require.Error(s.T(), claimed.Ack(), "The dead consumer no longer holds the job")
+}
+
+func (s *PgQueueTestSuite) TestNackDoesNotCountAnAttempt() {
+	ctx := context.Background()
+	tenantID := s.declareTenant()
+	deliveries := s.consume(s.broker, tenantID, 1)
+
+	require.NoError(s.T(), s.broker.Publish(ctx, tenantID, broker.Message{ID: "job", Body: []byte(`{}`)}))
+	for range 3 {
+		d := s.receive(deliveries, 5*time.Second)
+		require.Equal(s.T(), 0, d.Attempts)
+		require.NoError(s.T(), d.Nack())
+	}
+
+	d := s.receive(deliveries, 5*time.Second)
+	require.NoError(s.T(), d.Retry(time.Millisecond))
+	d = s.receive(deliveries, 5*time.Second)
+	require.Equal(s.T(), 1, d.Attempts)
+	require.NoError(s.T(), d.Ack())
+}
+
+func (s *PgQueueTestSuite) TestDeliveryIsSettledAfterBrokerClosed() {
+	ctx := context.Background()
+	tenantID := s.declareTenant()
+
+	// Consumers drain after the broker stopped handing out jobs
+	other := pgqueue.NewBroker(s.dbPool, s.opts, logger.New())
+	require.NoError(s.T(), s.broker.Publish(ctx, tenantID, broker.Message{ID: "job", Body: []byte(`{}`)}))
+	d := s.receive(s.consume(other, tenantID, 1), 5*time.Second)
+	other.Close()
+
+	require.NoError(s.T(), d.Ack())
+	stats, err := s.broker.Inspect(ctx, tenantID)
+	require.NoError(s.T(), err)
+	require.Equal(s.T(), 0, stats.Messages)
+}
+
+func TestPgQueueTestSuite(t *testing.T) {
+	suite.Run(t, new(PgQueueTestSuite))
+}
@@ -0,0 +1,347 @@
+package pgqueue
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/fekalegi/multi-tenant-system/internal/broker"
+	"github.com/google/uuid"
+)
+
+// claimBatch is how many jobs a consume without a prefetch claims at once.
+const claimBatch = 10
+
+// settleTimeout bounds the queries settling and releasing jobs. They do not depend on
+// the broker's context, so deliveries received before Close can still be settled.
+const settleTimeout = 5 * time.Second
+
+var errNotHeld = errors.New("postgres broker: delivery no longer held")
+
+// subscription claims jobs of a tenant queue under its own token. Jobs stay locked by
+// the token until they are settled or the subscription is closed; the heartbeat keeps
+// their visibility timeout from passing meanwhile. Every claim counts an attempt, so a
+// job whose consumer died counts one too; Nack and releasing a job take it back.
+type subscription struct {
+	b        *Broker
+	tenantID string
+	tenant   uuid.UUID
+	token    uuid.UUID
+	// wakeup is signalled when jobs were published or a delivery was settled
+	wakeup chan struct{}
+
+	mu        sync.Mutex
+	held      map[*broker.Delivery]int64
+	stop      chan struct{}
+	done      chan struct{}
+	closed    bool
+	closing   chan struct{}
+	closeOnce sync.Once
+}
+
+func (s *subscription) poke() {
+	select {
+	case s.wakeup <- struct{}{}:
+	default:
+	}
+}
+
+func (s *subscription) Consume(prefetch int) (<-chan *broker.Delivery, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch {
+	case s.b.ctx.Err() != nil:
+		return nil, errClosed
+	case s.closed:
+		return nil, errors.New("postgres broker: subscription closed")
+	case s.stop != nil:
+		return nil, errors.New("postgres broker: subscription already consuming")
+	}
+
+	s.stop = make(chan struct{})
+	s.done = make(chan struct{})
+
+	deliveries := make(chan *broker.Delivery)
+	go s.deliver(prefetch, s.stop, s.done, deliveries)
+	return deliveries, nil
+}
+
+// deliver claims jobs while the consumer holds fewer than prefetch of them and hands
+// them over, until the consume is cancelled, the broker closed or claiming fails.
+func (s *subscription) deliver(prefetch int, stop, done chan struct{}, deliveries chan<- *broker.Delivery) {
+	defer close(done)
+	defer close(deliveries)
+
+	s.b.addConsumer(s.tenantID, 1)
+	defer s.b.addConsumer(s.tenantID, -1)
+
+	poll := time.NewTicker(s.b.opts.PollInterval)
+	defer poll.Stop()
+
+	for {
+		room := claimBatch
+		if prefetch > 0 {
+			s.mu.Lock()
+			room = prefetch - len(s.held)
+			s.mu.Unlock()
+		}
+
+		if room > 0 {
+			claimed, err := s.claim(room)
+			if err != nil {
+				if s.b.ctx.Err() == nil {
+					s.b.log.Error().Err(err).Str("tenant_id", s.tenantID).Msg("Failed to claim queued messages")
+				}
+				return
+			}
+
+			for i, d := range claimed {
+				select {
+				case deliveries <- d:
+				case <-stop:
+					// Never handed over, give them back right away
+					s.release(claimed[i:])
+					return
+				}
+			}
+			if len(claimed) == room {
+				// More may be ready
+				continue
+			}
+		}
+
+		select {
+		case <-s.wakeup:
+		case <-poll.C:
+		case <-stop:
+			return
+		case <-s.b.ctx.Done():
+			return
+		}
+	}
+}
+
+// claim locks up to limit ready jobs for this subscription, highest priority first,
+// skipping the jobs other consumers are claiming, and counts an attempt on each.
+func (s *subscription) claim(limit int) ([]*broker.Delivery, error) {
+	rows, err := s.b.db.Query(s.b.ctx, `
+		UPDATE queue_jobs j
+		SET attempts = j.attempts + 1, locked_by = $2, locked_until = NOW() + make_interval(secs => $3)
+		FROM (
+			SELECT id
+			FROM queue_jobs
+			WHERE tenant_id = $1 AND dead_at IS NULL AND available_at <= NOW()
+			AND (locked_until IS NULL OR locked_until <= NOW())
+			ORDER BY priority DESC, id
+			LIMIT $4
+			FOR UPDATE SKIP LOCKED
+		) claimed
+		WHERE j.tenant_id = $1 AND j.id = claimed.id
+		RETURNING j.id, j.message_id, j.body, j.priority, j.published_at, j.attempts - 1
+	`, s.tenant, s.token, s.b.opts.VisibilityTimeout.Seconds(), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	type job struct {
+		id int64
+		d  *broker.Delivery
+	}
+	var jobs []job
+	for rows.Next() {
+		var (
+			j        job
+			priority int16
+		)
+		j.d = &broker.Delivery{Acknowledger: s}
+		if err := rows.Scan(&j.id, &j.d.ID, &j.d.Body, &priority, &j.d.Timestamp, &j.d.Attempts); err != nil {
+			return nil, err
+		}
+		j.d.Priority = uint8(priority)
+		jobs = append(jobs, j)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// UPDATE ... RETURNING has no order
+	slices.SortFunc(jobs, func(a, b job) int {
+		if a.d.Priority != b.d.Priority {
+			return int(b.d.Priority) - int(a.d.Priority)
+		}
+		return cmp.Compare(a.id, b.id)
+	})
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	deliveries := make([]*broker.Delivery, len(jobs))
+	for i, j := range jobs {
+		s.held[j.d] = j.id
+		deliveries[i] = j.d
+	}
+	return deliveries, nil
+}
+
+// release unlocks deliveries so they can be claimed again at once, without counting
+// their attempt.
+func (s *subscription) release(deliveries []*broker.Delivery) {
+	s.mu.Lock()
+	ids := make([]int64, 0, len(deliveries))
+	for _, d := range deliveries {
+		if id, ok := s.held[d]; ok {
+			ids = append(ids, id)
+			delete(s.held, d)
+		}
+	}
+	s.mu.Unlock()
+
+	if len(ids) == 0 {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), settleTimeout)
+	defer cancel()
+
+	_, err := s.b.db.Exec(ctx, `
+		UPDATE queue_jobs
+		SET attempts = attempts - 1, locked_by = NULL, locked_until = NULL
+		WHERE tenant_id = $1 AND id = ANY($2) AND locked_by = $3
+	`, s.tenant, ids, s.token)
+	if err != nil {
+		// Claimable again once their visibility timeout passed
+		s.b.log.Warn().Err(err).Str("tenant_id", s.tenantID).Msg("Failed to release claimed messages")
+	}
+}
+
+// heartbeat extends the visibility timeout of the held jobs until the subscription is
+// closed. A job whose timeout passed anyway, e.g. during a database outage, may be
+// claimed by another consumer; settling it here then fails with errNotHeld.
+func (s *subscription) heartbeat() {
+	ticker := time.NewTicker(s.b.opts.VisibilityTimeout / 3)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-s.closing:
+			return
+		case <-s.b.ctx.Done():
+			return
+		}
+
+		s.mu.Lock()
+		holding := len(s.held) > 0
+		s.mu.Unlock()
+		if !holding {
+			continue
+		}
+
+		_, err := s.b.db.Exec(s.b.ctx, `
+			UPDATE queue_jobs
+			SET locked_until = NOW() + make_interval(secs => $3)
+			WHERE tenant_id = $1 AND locked_by = $2
+		`, s.tenant, s.token, s.b.opts.VisibilityTimeout.Seconds())
+		if err != nil && s.b.ctx.Err() == nil {
+			s.b.log.Warn().Err(err).Str("tenant_id", s.tenantID).Msg("Failed to extend the visibility timeout of claimed messages")
+		}
+	}
+}
+
+func (s *subscription) Cancel() error {
+	s.mu.Lock()
+	stop, done := s.stop, s.done
+	s.stop, s.done = nil, nil
+	s.mu.Unlock()
+
+	if stop != nil {
+		close(stop)
+		<-done
+	}
+	return nil
+}
+
+// Close cancels the consume and releases the deliveries not settled yet.
+func (s *subscription) Close() error {
+	_ = s.Cancel()
+
+	s.mu.Lock()
+	s.closed = true
+	held := make([]*broker.Delivery, 0, len(s.held))
+	for d := range s.held {
+		held = append(held, d)
+	}
+	s.mu.Unlock()
+
+	s.closeOnce.Do(func() { close(s.closing) })
+	s.b.unsubscribe(s)
+	s.release(held)
+	return nil
+}
+
+// settle runs query on a held delivery's job, with the tenant, the job ID and the
+// token as its first arguments, and makes room for another delivery. It works after
+// the broker was closed for as long as the pool is open.
+func (s *subscription) settle(d *broker.Delivery, query string, args ...any) error {
+	s.mu.Lock()
+	id, ok := s.held[d]
+	delete(s.held, d)
+	s.mu.Unlock()
+
+	if !ok {
+		return errNotHeld
+	}
+	defer s.poke()
+
+	ctx, cancel := context.WithTimeout(context.Background(), settleTimeout)
+	defer cancel()
+
+	tag, err := s.b.db.Exec(ctx, query, append([]any{s.tenant, id, s.token}, args...)...)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		// The visibility timeout passed and another consumer claimed the job
+		return errNotHeld
+	}
+	return nil
+}
+
+// Ack removes the job from the queue.
+func (s *subscription) Ack(d *broker.Delivery) error {
+	return s.settle(d, `DELETE FROM queue_jobs WHERE tenant_id = $1 AND id = $2 AND locked_by = $3`)
+}
+
+// Nack unlocks the job so it is claimed again right away, taking back the attempt its
+// claim counted.
+func (s *subscription) Nack(d *broker.Delivery) error {
+	return s.settle(d, `
+		UPDATE queue_jobs
+		SET attempts = attempts - 1, locked_by = NULL, locked_until = NULL
+		WHERE tenant_id = $1 AND id = $2 AND locked_by = $3
+	`)
+}
+
+// Retry keeps the job, whose attempt its claim counted, from being claimed until delay
+// passed. Any delay is supported.
+func (s *subscription) Retry(d *broker.Delivery, delay time.Duration) error {
+	return s.settle(d, `
+		UPDATE queue_jobs
+		SET available_at = NOW() + make_interval(secs => $4), locked_by = NULL, locked_until = NULL
+		WHERE tenant_id = $1 AND id = $2 AND locked_by = $3
+	`, delay.Seconds())
+}
+
+// DeadLetter marks the job, whose attempt its claim counted, dead with the cause.
+func (s *subscription) DeadLetter(d *broker.Delivery, cause error) error {
+	return s.settle(d, `
+		UPDATE queue_jobs
+		SET dead_at = NOW(), last_error = $4, locked_by = NULL, locked_until = NULL
+		WHERE tenant_id = $1 AND id = $2 AND locked_by = $3
+	`, cause.Error())
+}
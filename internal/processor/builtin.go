@@ -0,0 +1,275 @@
+package processor
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/fekalegi/multi-tenant-system/internal/broker"
+)
+
+// Built-in processor types.
+const (
+	TypeStore     = "store"
+	TypeTransform = "transform"
+	TypeForward   = "forward"
+	TypeDrop      = "drop"
+)
+
+// errNotObject is returned when a processor needs a JSON object payload.
+var errNotObject = errors.New("payload is not a JSON object")
+
+// store saves the payload as it is at its point of the chain onto the stored message.
+type store struct {
+	messages MessageStore
+}
+
+func newStore(options json.RawMessage, deps Dependencies) (Processor, error) {
+	if err := DecodeOptions(options, &struct{}{}); err != nil {
+		return nil, err
+	}
+	if deps.Messages == nil {
+		return nil, errors.New("no message store available")
+	}
+	return &store{messages: deps.Messages}, nil
+}
+
+func (s *store) Process(ctx context.Context, d *Delivery) (Action, error) {
+	msg := d.Message
+	if err := s.messages.UpdateMessagePayload(ctx, msg.TenantID, msg.ID, msg.Payload); err != nil {
+		return StorageAction(err), err
+	}
+	return Continue, nil
+}
+
+// TransformOptions rewrite a JSON object payload. Keys are dot-separated paths;
+// renames are applied first, then sets, then removals.
+type TransformOptions struct {
+	Rename map[string]string          `json:"rename,omitempty"`
+	Set    map[string]json.RawMessage `json:"set,omitempty"`
+	Remove []string                   `json:"remove,omitempty"`
+}
+
+type transform struct {
+	opts TransformOptions
+}
+
+func newTransform(options json.RawMessage, _ Dependencies) (Processor, error) {
+	var opts TransformOptions
+	if err := DecodeOptions(options, &opts); err != nil {
+		return nil, err
+	}
+	if len(opts.Rename)+len(opts.Set)+len(opts.Remove) == 0 {
+		return nil, errors.New("nothing to transform: set rename, set or remove")
+	}
+	for from, to := range opts.Rename {
+		if from == "" || to == "" {
+			return nil, errors.New("rename paths must not be empty")
+		}
+	}
+	for path, value := range opts.Set {
+		if path == "" || !json.Valid(value) {
+			return nil, fmt.Errorf("invalid value to set at %q", path)
+		}
+	}
+	return &transform{opts: opts}, nil
+}
+
+func (t *transform) Process(_ context.Context, d *Delivery) (Action, error) {
+	payload, err := decodeObject(d.Message.Payload)
+	if err != nil {
+		return DeadLetter, err
+	}
+
+	for from, to := range t.opts.Rename {
+		if value, ok := removePath(payload, splitPath(from)); ok {
+			setPath(payload, splitPath(to), value)
+		}
+	}
+	for path, value := range t.opts.Set {
+		setPath(payload, splitPath(path), value)
+	}
+	for _, path := range t.opts.Remove {
+		removePath(payload, splitPath(path))
+	}
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return DeadLetter, err
+	}
+	d.Message.Payload = body
+	return Continue, nil
+}
+
+// ForwardOptions name where a forwarded copy is published. With the default exchange
+// the routing key is the name of the queue.
+type ForwardOptions struct {
+	Exchange   string `json:"exchange,omitempty"`
+	RoutingKey string `json:"routing_key"`
+}
+
+// forward publishes the message, under its own ID, to another exchange or queue.
+type forward struct {
+	opts      ForwardOptions
+	forwarder Forwarder
+}
+
+func newForward(options json.RawMessage, deps Dependencies) (Processor, error) {
+	var opts ForwardOptions
+	if err := DecodeOptions(options, &opts); err != nil {
+		return nil, err
+	}
+	if opts.RoutingKey == "" {
+		return nil, errors.New("routing_key is required")
+	}
+	if deps.Forwarder == nil {
+		return nil, errors.New("no forwarder available")
+	}
+	return &forward{opts: opts, forwarder: deps.Forwarder}, nil
+}
+
+func (f *forward) Process(ctx context.Context, d *Delivery) (Action, error) {
+	msg := d.Message
+	err := f.forwarder.Forward(ctx, f.opts.Exchange, f.opts.RoutingKey, msg.ID.String(), msg.Payload, msg.Priority)
+	if errors.Is(err, broker.ErrUnroutable) {
+		// No queue will appear by retrying
+		return DeadLetter, err
+	}
+	if err != nil {
+		return Retry, err
+	}
+	return Continue, nil
+}
+
+// DropOptions select the messages to drop. Without a match every message is dropped.
+type DropOptions struct {
+	// Match drops payloads containing this JSON value, with the containment
+	// semantics of the payload filter
+	Match json.RawMessage `json:"match,omitempty" swaggertype:"object"`
+}
+
+// drop ends the chain for matching messages, which count as processed.
+type drop struct {
+	match any
+}
+
+func newDrop(options json.RawMessage, _ Dependencies) (Processor, error) {
+	var opts DropOptions
+	if err := DecodeOptions(options, &opts); err != nil {
+		return nil, err
+	}
+
+	p := &drop{}
+	if len(opts.Match) > 0 {
+		if err := unmarshalNumbers(opts.Match, &p.match); err != nil {
+			return nil, fmt.Errorf("invalid match: %v", err)
+		}
+	}
+	return p, nil
+}
+
+func (p *drop) Process(_ context.Context, d *Delivery) (Action, error) {
+	if p.match == nil {
+		return Ack, nil
+	}
+
+	var payload any
+	if err := unmarshalNumbers(d.Message.Payload, &payload); err != nil {
+		return DeadLetter, err
+	}
+	if contains(payload, p.match) {
+		return Ack, nil
+	}
+	return Continue, nil
+}
+
+// contains reports whether doc contains want the way jsonb @> does: objects match
+// on a subset of their keys, arrays when every wanted element is in them.
+func contains(doc, want any) bool {
+	switch w := want.(type) {
+	case map[string]any:
+		obj, ok := doc.(map[string]any)
+		if !ok {
+			return false
+		}
+		for k, v := range w {
+			if got, ok := obj[k]; !ok || !contains(got, v) {
+				return false
+			}
+		}
+		return true
+	case []any:
+		arr, ok := doc.([]any)
+		if !ok {
+			return false
+		}
+		for _, v := range w {
+			found := false
+			for _, got := range arr {
+				if contains(got, v) {
+					found = true
+					break
+				}
+			}
+			if !found {
+				return false
+			}
+		}
+		return true
+	}
+	return reflect.DeepEqual(doc, want)
+}
+
+// unmarshalNumbers decodes JSON keeping numbers exact.
+func unmarshalNumbers(data []byte, v any) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	return dec.Decode(v)
+}
+
+func decodeObject(data []byte) (map[string]any, error) {
+	var obj map[string]any
+	if err := unmarshalNumbers(data, &obj); err != nil || obj == nil {
+		return nil, errNotObject
+	}
+	return obj, nil
+}
+
+func splitPath(path string) []string {
+	return strings.Split(path, ".")
+}
+
+// setPath sets a value, creating the objects on the way. Values that are not objects
+// are replaced when the path leads through them.
+func setPath(obj map[string]any, path []string, value any) {
+	for _, key := range path[:len(path)-1] {
+		next, ok := obj[key].(map[string]any)
+		if !ok {
+			next = map[string]any{}
+			obj[key] = next
+		}
+		obj = next
+	}
+	obj[path[len(path)-1]] = value
+}
+
+// removePath deletes a value and returns it, if it exists.
+func removePath(obj map[string]any, path []string) (any, bool) {
+	for _, key := range path[:len(path)-1] {
+		next, ok := obj[key].(map[string]any)
+		if !ok {
+			return nil, false
+		}
+		obj = next
+	}
+	last := path[len(path)-1]
+	value, ok := obj[last]
+	if ok {
+		delete(obj, last)
+	}
+	return value, ok
+}
@@ -0,0 +1,155 @@
+package processor_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/fekalegi/multi-tenant-system/internal/broker"
+	"github.com/fekalegi/multi-tenant-system/internal/processor"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/stretchr/testify/require"
+)
+
+type failingStore struct{ err error }
+
+func (s failingStore) UpdateMessagePayload(context.Context, uuid.UUID, uuid.UUID, []byte) error {
+	return s.err
+}
+
+type failingForwarder struct{ err error }
+
+func (f failingForwarder) Forward(context.Context, string, string, string, []byte, uint8) error {
+	return f.err
+}
+
+func TestStore_When_StoringFails_Then_TheErrorDecidesTheAction(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want processor.Action
+	}{
+		{name: "stored", err: nil, want: processor.Continue},
+		{name: "rejected value", err: &pgconn.PgError{Code: "22P05"}, want: processor.DeadLetter},
+		{name: "database down", err: errors.New("connection refused"), want: processor.Retry},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := build(t, processor.Dependencies{Messages: failingStore{err: tt.err}}, processor.TypeStore, ``)
+
+			action, err := p.Process(context.Background(), newDelivery(`{"a": 1}`))
+			require.ErrorIs(t, err, tt.err)
+			require.Equal(t, tt.want, action)
+		})
+	}
+}
+
+func TestForward_When_ForwardingFails_Then_OnlyUnroutableMessagesAreDeadLettered(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want processor.Action
+	}{
+		{name: "forwarded", err: nil, want: processor.Continue},
+		{name: "unroutable", err: broker.ErrUnroutable, want: processor.DeadLetter},
+		{name: "broker down", err: broker.ErrUnavailable, want: processor.Retry},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := build(t, processor.Dependencies{Forwarder: failingForwarder{err: tt.err}}, processor.TypeForward, `{"routing_key": "audit"}`)
+
+			action, err := p.Process(context.Background(), newDelivery(`{"a": 1}`))
+			require.ErrorIs(t, err, tt.err)
+			require.Equal(t, tt.want, action)
+		})
+	}
+}
+
+func TestTransform_When_PayloadIsRewritten_Then_PathsAreRenamedSetAndRemoved(t *testing.T) {
+	tests := []struct {
+		name    string
+		options string
+		payload string
+		want    string
+	}{
+		{name: "rename", options: `{"rename": {"a": "b"}}`, payload: `{"a": 1, "c": 2}`, want: `{"b": 1, "c": 2}`},
+		{name: "rename a missing key", options: `{"rename": {"x": "y"}}`, payload: `{"a": 1}`, want: `{"a": 1}`},
+		{name: "rename nested keys", options: `{"rename": {"a.b": "c.d"}}`, payload: `{"a": {"b": 1}}`, want: `{"a": {}, "c": {"d": 1}}`},
+		{name: "rename keeps large numbers", options: `{"rename": {"n": "m"}}`, payload: `{"n": 12345678901234567890}`, want: `{"m": 12345678901234567890}`},
+		{name: "set", options: `{"set": {"a": "x"}}`, payload: `{"a": 1}`, want: `{"a": "x"}`},
+		{name: "set creates nested objects", options: `{"set": {"x.y.z": [1, 2]}}`, payload: `{}`, want: `{"x": {"y": {"z": [1, 2]}}}`},
+		{name: "set replaces a non-object", options: `{"set": {"x.y": true}}`, payload: `{"x": 1}`, want: `{"x": {"y": true}}`},
+		{name: "remove", options: `{"remove": ["a"]}`, payload: `{"a": 1, "b": 2}`, want: `{"b": 2}`},
+		{name: "remove a missing key", options: `{"remove": ["x", "a.x"]}`, payload: `{"a": 1}`, want: `{"a": 1}`},
+		{name: "remove nested keys", options: `{"remove": ["a.b"]}`, payload: `{"a": {"b": 1, "c": 2}}`, want: `{"a": {"c": 2}}`},
+		{
+			name:    "rename then set then remove",
+			options: `{"rename": {"a": "b"}, "set": {"a": 2}, "remove": ["b"]}`,
+			payload: `{"a": 1}`,
+			want:    `{"a": 2}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := build(t, processor.Dependencies{}, processor.TypeTransform, tt.options)
+			d := newDelivery(tt.payload)
+
+			action, err := p.Process(context.Background(), d)
+			require.NoError(t, err)
+			require.Equal(t, processor.Continue, action)
+			require.JSONEq(t, tt.want, string(d.Message.Payload))
+		})
+	}
+}
+
+func TestTransform_When_PayloadIsNotAnObject_Then_ItIsDeadLettered(t *testing.T) {
+	for _, payload := range []string{`[1, 2]`, `"text"`, `42`, `{"a":`} {
+		t.Run(payload, func(t *testing.T) {
+			p := build(t, processor.Dependencies{}, processor.TypeTransform, `{"remove": ["a"]}`)
+			d := newDelivery(payload)
+
+			action, err := p.Process(context.Background(), d)
+			require.Error(t, err)
+			require.Equal(t, processor.DeadLetter, action)
+			require.Equal(t, payload, string(d.Message.Payload), "the payload should be left as it was")
+		})
+	}
+}
+
+func TestDrop_When_MessagesAreMatched_Then_OnlyMatchingOnesAreDropped(t *testing.T) {
+	tests := []struct {
+		name    string
+		options string
+		payload string
+		want    processor.Action
+	}{
+		{name: "no match drops objects", options: ``, payload: `{"a": 1}`, want: processor.Ack},
+		{name: "no match drops anything", options: `{}`, payload: `not json`, want: processor.Ack},
+		{name: "matching key", options: `{"match": {"type": "test"}}`, payload: `{"type": "test", "a": 1}`, want: processor.Ack},
+		{name: "other value", options: `{"match": {"type": "test"}}`, payload: `{"type": "order"}`, want: processor.Continue},
+		{name: "missing key", options: `{"match": {"type": "test"}}`, payload: `{"a": 1}`, want: processor.Continue},
+		{name: "nested match", options: `{"match": {"meta": {"env": "dev"}}}`, payload: `{"meta": {"env": "dev", "region": "eu"}}`, want: processor.Ack},
+		{name: "array elements", options: `{"match": {"tags": ["b"]}}`, payload: `{"tags": ["a", "b"]}`, want: processor.Ack},
+		{name: "missing array element", options: `{"match": {"tags": ["c"]}}`, payload: `{"tags": ["a", "b"]}`, want: processor.Continue},
+		{name: "large numbers stay exact", options: `{"match": {"n": 12345678901234567890}}`, payload: `{"n": 12345678901234567891}`, want: processor.Continue},
+		{name: "non-object payload", options: `{"match": {"a": 1}}`, payload: `[1]`, want: processor.Continue},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := build(t, processor.Dependencies{}, processor.TypeDrop, tt.options)
+
+			action, err := p.Process(context.Background(), newDelivery(tt.payload))
+			require.NoError(t, err)
+			require.Equal(t, tt.want, action)
+		})
+	}
+}
+
+func TestDrop_When_PayloadIsInvalid_Then_ItIsDeadLettered(t *testing.T) {
+	p := build(t, processor.Dependencies{}, processor.TypeDrop, `{"match": {"a": 1}}`)
+
+	action, err := p.Process(context.Background(), newDelivery(`{"a":`))
+	require.Error(t, err)
+	require.Equal(t, processor.DeadLetter, action)
+}
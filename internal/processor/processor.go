@@ -0,0 +1,105 @@
+package processor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/fekalegi/multi-tenant-system/internal/domain"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// Action tells the consumer what to do with a delivery once a processor has run.
+type Action int
+
+const (
+	// Continue hands the message to the next processor of the chain
+	Continue Action = iota
+	// Ack ends the chain, the message is processed
+	Ack
+	// Retry ends the chain, the message is retried with backoff until the retry policy is exhausted
+	Retry
+	// DeadLetter ends the chain, the message is dead-lettered at once
+	DeadLetter
+)
+
+func (a Action) String() string {
+	switch a {
+	case Continue:
+		return "continue"
+	case Ack:
+		return "ack"
+	case Retry:
+		return "retry"
+	case DeadLetter:
+		return "dead-letter"
+	}
+	return fmt.Sprintf("action(%d)", int(a))
+}
+
+// Delivery is a message passing through a tenant's processor chain. Processors may
+// change its payload for the processors after them.
+type Delivery struct {
+	Message *domain.Message
+	// Attempt counts the processing attempts including this one, starting at 1
+	Attempt int
+}
+
+// Processor is one step of a tenant's processor chain.
+type Processor interface {
+	// Process handles a delivery and tells the chain how to go on. Retry and
+	// DeadLetter come with the error that caused them.
+	Process(ctx context.Context, d *Delivery) (Action, error)
+}
+
+// Func adapts a function to the Processor interface.
+type Func func(ctx context.Context, d *Delivery) (Action, error)
+
+func (f Func) Process(ctx context.Context, d *Delivery) (Action, error) {
+	return f(ctx, d)
+}
+
+// Chain runs the processors configured for a tenant in order.
+type Chain struct {
+	configs    []domain.ProcessorConfig
+	processors []Processor
+}
+
+// Configs returns the configuration the chain was built from.
+func (c *Chain) Configs() []domain.ProcessorConfig {
+	return c.configs
+}
+
+// Run passes the delivery through the processors until one of them ends the chain.
+// A chain whose processors all continue acks the delivery.
+func (c *Chain) Run(ctx context.Context, d *Delivery) (Action, error) {
+	for i, p := range c.processors {
+		action, err := p.Process(ctx, d)
+		switch {
+		case err == nil && action == Continue:
+			continue
+		case err == nil && action == Ack:
+			return Ack, nil
+		case err == nil:
+			err = errors.New("rejected")
+		case action == Continue || action == Ack:
+			// A processor that failed without saying how is retried
+			action = Retry
+		}
+		return action, fmt.Errorf("%s processor: %w", c.configs[i].Type, err)
+	}
+	return Ack, nil
+}
+
+// StorageAction decides how to go on after a storage error. Data and integrity
+// violations will fail again no matter how often they are retried.
+func StorageAction(err error) Action {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		class := pgErr.Code[:2]
+		if class == "22" || class == "23" {
+			return DeadLetter
+		}
+	}
+	return Retry
+}
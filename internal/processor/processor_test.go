@@ -0,0 +1,125 @@
+package processor_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/fekalegi/multi-tenant-system/internal/domain"
+	"github.com/fekalegi/multi-tenant-system/internal/processor"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/stretchr/testify/require"
+)
+
+// newDelivery returns a first delivery of a message with the given payload.
+func newDelivery(payload string) *processor.Delivery {
+	return &processor.Delivery{
+		Message: &domain.Message{ID: uuid.New(), TenantID: uuid.New(), Payload: json.RawMessage(payload)},
+		Attempt: 1,
+	}
+}
+
+// build returns the processor of typ built from options by a registry, as a chain runs
+// it: handing the delivery on to the next processor shows as Continue.
+func build(t *testing.T, deps processor.Dependencies, typ, options string) processor.Processor {
+	registry := processor.NewRegistry(deps)
+	var reached bool
+	registry.Register("next", func(json.RawMessage, processor.Dependencies) (processor.Processor, error) {
+		return processor.Func(func(context.Context, *processor.Delivery) (processor.Action, error) {
+			reached = true
+			return processor.Continue, nil
+		}), nil
+	})
+	chain, err := registry.Build([]domain.ProcessorConfig{{Type: typ, Options: json.RawMessage(options)}, {Type: "next"}})
+	require.NoError(t, err)
+
+	return processor.Func(func(ctx context.Context, d *processor.Delivery) (processor.Action, error) {
+		reached = false
+		action, err := chain.Run(ctx, d)
+		if reached {
+			return processor.Continue, err
+		}
+		return action, err
+	})
+}
+
+func TestChainRun_When_ProcessorsDecide_Then_TheChainEndsAccordingly(t *testing.T) {
+	type step struct {
+		action processor.Action
+		err    error
+	}
+	boom := errors.New("boom")
+
+	tests := []struct {
+		name    string
+		steps   []step
+		want    processor.Action
+		wantErr string
+		ran     int
+	}{
+		{name: "all continue", steps: []step{{processor.Continue, nil}, {processor.Continue, nil}}, want: processor.Ack, ran: 2},
+		{name: "ack ends the chain", steps: []step{{processor.Ack, nil}, {processor.Continue, nil}}, want: processor.Ack, ran: 1},
+		{name: "retry", steps: []step{{processor.Continue, nil}, {processor.Retry, boom}}, want: processor.Retry, wantErr: "step1 processor: boom", ran: 2},
+		{name: "dead letter", steps: []step{{processor.DeadLetter, boom}, {processor.Continue, nil}}, want: processor.DeadLetter, wantErr: "step0 processor: boom", ran: 1},
+		{name: "retry without an error", steps: []step{{processor.Retry, nil}}, want: processor.Retry, wantErr: "step0 processor: rejected", ran: 1},
+		{name: "dead letter without an error", steps: []step{{processor.DeadLetter, nil}}, want: processor.DeadLetter, wantErr: "step0 processor: rejected", ran: 1},
+		{name: "continue with an error", steps: []step{{processor.Continue, boom}, {processor.Continue, nil}}, want: processor.Retry, wantErr: "step0 processor: boom", ran: 1},
+		{name: "ack with an error", steps: []step{{processor.Ack, boom}}, want: processor.Retry, wantErr: "step0 processor: boom", ran: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			registry := processor.NewRegistry(processor.Dependencies{})
+			var (
+				configs []domain.ProcessorConfig
+				ran     int
+			)
+			for i, s := range tt.steps {
+				typ := fmt.Sprintf("step%d", i)
+				registry.Register(typ, func(json.RawMessage, processor.Dependencies) (processor.Processor, error) {
+					return processor.Func(func(context.Context, *processor.Delivery) (processor.Action, error) {
+						ran++
+						return s.action, s.err
+					}), nil
+				})
+				configs = append(configs, domain.ProcessorConfig{Type: typ})
+			}
+			chain, err := registry.Build(configs)
+			require.NoError(t, err)
+
+			action, err := chain.Run(context.Background(), newDelivery(`{}`))
+			require.Equal(t, tt.want, action)
+			if tt.wantErr == "" {
+				require.NoError(t, err)
+			} else {
+				require.EqualError(t, err, tt.wantErr)
+			}
+			require.Equal(t, tt.ran, ran)
+		})
+	}
+}
+
+func TestStorageAction_When_StorageFails_Then_OnlyDataErrorsAreDeadLettered(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want processor.Action
+	}{
+		{name: "invalid text representation", err: &pgconn.PgError{Code: "22P02"}, want: processor.DeadLetter},
+		{name: "value too long", err: &pgconn.PgError{Code: "22001"}, want: processor.DeadLetter},
+		{name: "unique violation", err: &pgconn.PgError{Code: "23505"}, want: processor.DeadLetter},
+		{name: "wrapped check violation", err: fmt.Errorf("could not store: %w", &pgconn.PgError{Code: "23514"}), want: processor.DeadLetter},
+		{name: "serialization failure", err: &pgconn.PgError{Code: "40001"}, want: processor.Retry},
+		{name: "connection failure", err: &pgconn.PgError{Code: "08006"}, want: processor.Retry},
+		{name: "admin shutdown", err: &pgconn.PgError{Code: "57P01"}, want: processor.Retry},
+		{name: "not a database error", err: errors.New("connection reset"), want: processor.Retry},
+		{name: "deadline", err: context.DeadlineExceeded, want: processor.Retry},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.Equal(t, tt.want, processor.StorageAction(tt.err))
+		})
+	}
+}
@@ -0,0 +1,126 @@
+package processor
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/fekalegi/multi-tenant-system/internal/domain"
+	"github.com/google/uuid"
+	"github.com/rs/zerolog"
+)
+
+// ErrInvalidConfig is returned when a processor chain cannot be built from its configuration.
+var ErrInvalidConfig = errors.New("invalid processor configuration")
+
+// MessageStore persists what processors make of a message.
+type MessageStore interface {
+	UpdateMessagePayload(ctx context.Context, tenantID, id uuid.UUID, payload []byte) error
+}
+
+// Forwarder publishes a message to an exchange, waiting for the broker's confirm.
+type Forwarder interface {
+	Forward(ctx context.Context, exchange, routingKey, messageID string, body []byte, priority uint8) error
+}
+
+// DeliveryLog records the attempts to deliver messages to webhooks.
+type DeliveryLog interface {
+	RecordWebhookDelivery(ctx context.Context, delivery *domain.WebhookDelivery) error
+}
+
+// Dependencies are the services processors are built with.
+type Dependencies struct {
+	Messages   MessageStore
+	Forwarder  Forwarder
+	Deliveries DeliveryLog
+	// Webhook holds the settings of webhooks that do not override them
+	Webhook WebhookDefaults
+	Log     zerolog.Logger
+}
+
+// Factory builds a processor of one type from its options, which may be empty.
+type Factory func(options json.RawMessage, deps Dependencies) (Processor, error)
+
+// Registry knows the processor types tenants can configure and builds their chains.
+type Registry struct {
+	deps Dependencies
+
+	mu        sync.RWMutex
+	factories map[string]Factory
+}
+
+// NewRegistry returns a registry with the built-in processors: store, transform,
+// forward, drop and webhook.
+func NewRegistry(deps Dependencies) *Registry {
+	r := &Registry{
+		deps:      deps,
+		factories: make(map[string]Factory),
+	}
+	r.Register(TypeStore, newStore)
+	r.Register(TypeTransform, newTransform)
+	r.Register(TypeForward, newForward)
+	r.Register(TypeDrop, newDrop)
+	r.Register(TypeWebhook, newWebhook)
+	return r
+}
+
+// Register makes a processor type available, replacing a type of the same name.
+func (r *Registry) Register(typ string, factory Factory) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.factories[typ] = factory
+}
+
+// types returns the registered processor types in alphabetical order. Callers must
+// hold r.mu.
+func (r *Registry) types() []string {
+	types := make([]string, 0, len(r.factories))
+	for typ := range r.factories {
+		types = append(types, typ)
+	}
+	sort.Strings(types)
+	return types
+}
+
+// Build creates the chain described by configs. Errors wrap ErrInvalidConfig.
+func (r *Registry) Build(configs []domain.ProcessorConfig) (*Chain, error) {
+	if len(configs) == 0 {
+		return nil, fmt.Errorf("%w: a chain needs at least one processor", ErrInvalidConfig)
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	chain := &Chain{
+		configs:    configs,
+		processors: make([]Processor, len(configs)),
+	}
+	for i, cfg := range configs {
+		factory, ok := r.factories[cfg.Type]
+		if !ok {
+			return nil, fmt.Errorf("%w: processor %d: unknown type %q, must be one of %s", ErrInvalidConfig, i, cfg.Type, strings.Join(r.types(), ", "))
+		}
+		p, err := factory(cfg.Options, r.deps)
+		if err != nil {
+			return nil, fmt.Errorf("%w: processor %d (%s): %v", ErrInvalidConfig, i, cfg.Type, err)
+		}
+		chain.processors[i] = p
+	}
+	return chain, nil
+}
+
+// DecodeOptions reads the options of a processor into v, rejecting unknown fields.
+// Empty options leave v untouched.
+func DecodeOptions(options json.RawMessage, v any) error {
+	if len(bytes.TrimSpace(options)) == 0 || string(bytes.TrimSpace(options)) == "null" {
+		return nil
+	}
+	dec := json.NewDecoder(bytes.NewReader(options))
+	dec.DisallowUnknownFields()
+	return dec.Decode(v)
+}
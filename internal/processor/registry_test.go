@@ -0,0 +1,73 @@
+package processor_test
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/fekalegi/multi-tenant-system/internal/domain"
+	"github.com/fekalegi/multi-tenant-system/internal/processor"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+type nopStore struct{}
+
+func (nopStore) UpdateMessagePayload(context.Context, uuid.UUID, uuid.UUID, []byte) error {
+	return nil
+}
+
+type nopForwarder struct{}
+
+func (nopForwarder) Forward(context.Context, string, string, string, []byte, uint8) error {
+	return nil
+}
+
+func TestRegistryBuild_When_ConfigIsInvalid_Then_ErrInvalidConfigIsReturned(t *testing.T) {
+	tests := []struct {
+		name    string
+		configs []domain.ProcessorConfig
+		wantErr string
+	}{
+		{name: "empty chain", configs: nil, wantErr: "at least one processor"},
+		{name: "unknown type", configs: []domain.ProcessorConfig{{Type: "store"}, {Type: "enrich"}}, wantErr: `processor 1: unknown type "enrich"`},
+		{name: "unknown option", configs: []domain.ProcessorConfig{{Type: "store", Options: json.RawMessage(`{"table": "x"}`)}}, wantErr: "unknown field"},
+		{name: "malformed options", configs: []domain.ProcessorConfig{{Type: "drop", Options: json.RawMessage(`{"match":`)}}, wantErr: "processor 0 (drop)"},
+		{name: "options of the wrong type", configs: []domain.ProcessorConfig{{Type: "transform", Options: json.RawMessage(`{"remove": "a"}`)}}, wantErr: "processor 0 (transform)"},
+		{name: "empty transform", configs: []domain.ProcessorConfig{{Type: "transform", Options: json.RawMessage(`{}`)}}, wantErr: "nothing to transform"},
+		{name: "empty rename path", configs: []domain.ProcessorConfig{{Type: "transform", Options: json.RawMessage(`{"rename": {"a": ""}}`)}}, wantErr: "rename paths must not be empty"},
+		{name: "forward without routing key", configs: []domain.ProcessorConfig{{Type: "forward", Options: json.RawMessage(`{"exchange": "x"}`)}}, wantErr: "routing_key is required"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			registry := processor.NewRegistry(processor.Dependencies{Messages: nopStore{}, Forwarder: nopForwarder{}})
+
+			chain, err := registry.Build(tt.configs)
+			require.ErrorIs(t, err, processor.ErrInvalidConfig)
+			require.ErrorContains(t, err, tt.wantErr)
+			require.Nil(t, chain)
+		})
+	}
+}
+
+func TestRegistryBuild_When_DependencyIsMissing_Then_ErrInvalidConfigIsReturned(t *testing.T) {
+	registry := processor.NewRegistry(processor.Dependencies{Messages: nopStore{}})
+
+	_, err := registry.Build([]domain.ProcessorConfig{{Type: "forward", Options: json.RawMessage(`{"routing_key": "audit"}`)}})
+	require.ErrorIs(t, err, processor.ErrInvalidConfig)
+	require.ErrorContains(t, err, "no forwarder available")
+}
+
+func TestRegistryBuild_When_ConfigIsValid_Then_TheChainKeepsIt(t *testing.T) {
+	registry := processor.NewRegistry(processor.Dependencies{Messages: nopStore{}, Forwarder: nopForwarder{}})
+	configs := []domain.ProcessorConfig{
+		{Type: "drop", Options: json.RawMessage(`null`)},
+		{Type: "transform", Options: json.RawMessage(`{"set": {"seen": true}}`)},
+		{Type: "forward", Options: json.RawMessage(`{"routing_key": "audit"}`)},
+		{Type: "store"},
+	}
+
+	chain, err := registry.Build(configs)
+	require.NoError(t, err)
+	require.Equal(t, configs, chain.Configs())
+}
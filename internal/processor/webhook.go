@@ -0,0 +1,217 @@
+package processor
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/fekalegi/multi-tenant-system/internal/broker"
+	"github.com/fekalegi/multi-tenant-system/internal/domain"
+	"github.com/rs/zerolog"
+)
+
+// TypeWebhook is the processor type pushing messages to a tenant's HTTP endpoint.
+const TypeWebhook = "webhook"
+
+// Headers sent with every webhook request. The signature is the hex encoded
+// HMAC-SHA256 of the timestamp, a dot and the body, keyed with the webhook secret.
+const (
+	HeaderWebhookID        = "X-Webhook-Id"
+	HeaderWebhookTimestamp = "X-Webhook-Timestamp"
+	HeaderWebhookSignature = "X-Webhook-Signature"
+)
+
+// maxWebhookAttempts bounds the attempts a webhook may make per delivery.
+const maxWebhookAttempts = 10
+
+// WebhookDefaults apply to webhooks that do not set their own timeout or attempts.
+// Retry spaces the attempts made for one delivery.
+type WebhookDefaults struct {
+	Timeout time.Duration
+	Retry   broker.RetryPolicy
+}
+
+// WebhookOptions configure a tenant's webhook.
+type WebhookOptions struct {
+	URL    string `json:"url"`
+	Secret string `json:"secret"`
+	// Timeout bounds each request, e.g. 5s
+	Timeout string `json:"timeout,omitempty"`
+	// MaxAttempts bounds the requests made per delivery before the message is retried later
+	MaxAttempts int `json:"max_attempts,omitempty"`
+}
+
+// webhook posts the payload to a URL. Failed requests are retried with backoff a few
+// times in place; when they keep failing the message goes back to the retry queues.
+// Every request is recorded in the delivery log.
+type webhook struct {
+	url        string
+	secret     []byte
+	client     *http.Client
+	retry      broker.RetryPolicy
+	deliveries DeliveryLog
+	log        zerolog.Logger
+}
+
+func newWebhook(options json.RawMessage, deps Dependencies) (Processor, error) {
+	var opts WebhookOptions
+	if err := DecodeOptions(options, &opts); err != nil {
+		return nil, err
+	}
+
+	target, err := url.Parse(opts.URL)
+	if err != nil || (target.Scheme != "https" && target.Scheme != "http") || target.Host == "" {
+		return nil, errors.New("url must be an absolute http or https URL")
+	}
+	if opts.Secret == "" {
+		return nil, errors.New("secret is required")
+	}
+	if deps.Deliveries == nil {
+		return nil, errors.New("no delivery log available")
+	}
+
+	timeout := deps.Webhook.Timeout
+	if opts.Timeout != "" {
+		if timeout, err = time.ParseDuration(opts.Timeout); err != nil || timeout <= 0 {
+			return nil, errors.New("timeout must be a positive duration, e.g. 5s")
+		}
+	}
+
+	retry := deps.Webhook.Retry
+	if opts.MaxAttempts != 0 {
+		if opts.MaxAttempts < 0 || opts.MaxAttempts > maxWebhookAttempts {
+			return nil, fmt.Errorf("max_attempts must be between 1 and %d", maxWebhookAttempts)
+		}
+		retry.MaxAttempts = opts.MaxAttempts
+	}
+	if retry.MaxAttempts <= 0 {
+		retry.MaxAttempts = 1
+	}
+
+	return &webhook{
+		url:        target.String(),
+		secret:     []byte(opts.Secret),
+		client:     &http.Client{Timeout: timeout},
+		retry:      retry,
+		deliveries: deps.Deliveries,
+		log:        deps.Log,
+	}, nil
+}
+
+// RedactSecrets returns a copy of a processor chain fit to show to clients: the secret
+// of every webhook is replaced by "secret_set": true.
+func RedactSecrets(configs []domain.ProcessorConfig) []domain.ProcessorConfig {
+	redacted := make([]domain.ProcessorConfig, len(configs))
+	for i, cfg := range configs {
+		redacted[i] = cfg
+		if cfg.Type != TypeWebhook {
+			continue
+		}
+
+		var options map[string]json.RawMessage
+		if err := json.Unmarshal(cfg.Options, &options); err != nil {
+			// Not valid options of any webhook, nothing worth showing
+			redacted[i].Options = nil
+			continue
+		}
+		if _, ok := options["secret"]; !ok {
+			continue
+		}
+		delete(options, "secret")
+		options["secret_set"] = json.RawMessage("true")
+		redacted[i].Options, _ = json.Marshal(options)
+	}
+	return redacted
+}
+
+func (w *webhook) Process(ctx context.Context, d *Delivery) (Action, error) {
+	var err error
+	for attempt := 1; attempt <= w.retry.MaxAttempts; attempt++ {
+		if attempt > 1 {
+			select {
+			case <-time.After(w.retry.Backoff(attempt - 1)):
+			case <-ctx.Done():
+				return Retry, ctx.Err()
+			}
+		}
+
+		var retryable bool
+		if retryable, err = w.send(ctx, d.Message); err == nil {
+			return Continue, nil
+		}
+		if !retryable {
+			return DeadLetter, err
+		}
+	}
+	return Retry, err
+}
+
+// send makes one request and records it. It reports whether a failure may succeed
+// when retried.
+func (w *webhook) send(ctx context.Context, msg *domain.Message) (bool, error) {
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.url, bytes.NewReader(msg.Payload))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(HeaderWebhookID, msg.ID.String())
+	req.Header.Set(HeaderWebhookTimestamp, timestamp)
+	req.Header.Set(HeaderWebhookSignature, "sha256="+w.sign(timestamp, msg.Payload))
+
+	delivery := &domain.WebhookDelivery{
+		TenantID:  msg.TenantID,
+		MessageID: msg.ID,
+		URL:       w.url,
+	}
+
+	start := time.Now()
+	resp, err := w.client.Do(req)
+	retryable := true
+	if err == nil {
+		// Drain a little of the body so the connection can be reused
+		_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+		_ = resp.Body.Close()
+
+		delivery.StatusCode = resp.StatusCode
+		if resp.StatusCode < 200 || resp.StatusCode > 299 {
+			err = fmt.Errorf("webhook responded %s", resp.Status)
+			retryable = retryableStatus(resp.StatusCode)
+		}
+	}
+	delivery.DurationMs = time.Since(start).Milliseconds()
+	if err != nil {
+		delivery.Error = err.Error()
+	}
+
+	// The delivery itself is done either way, only the log misses it
+	if logErr := w.deliveries.RecordWebhookDelivery(context.WithoutCancel(ctx), delivery); logErr != nil {
+		w.log.Warn().Err(logErr).Str("msg_id", msg.ID.String()).Msg("Failed to record webhook delivery")
+	}
+	return retryable, err
+}
+
+func (w *webhook) sign(timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, w.secret)
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// retryableStatus reports whether a response status may change on retry. Other client
+// errors mean the endpoint refuses the message.
+func retryableStatus(code int) bool {
+	return code == http.StatusRequestTimeout || code == http.StatusTooManyRequests || code >= 500
+}
@@ -0,0 +1,200 @@
+package processor_test
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/fekalegi/multi-tenant-system/internal/broker"
+	"github.com/fekalegi/multi-tenant-system/internal/domain"
+	"github.com/fekalegi/multi-tenant-system/internal/processor"
+	"github.com/fekalegi/multi-tenant-system/internal/repository/memory"
+	message2 "github.com/fekalegi/multi-tenant-system/internal/repository/postgresql"
+	"github.com/stretchr/testify/require"
+)
+
+// webhookDeps returns the dependencies of webhooks logging to messages, retrying
+// in place without noticeable backoff.
+func webhookDeps(messages message2.MessageRepository) processor.Dependencies {
+	return processor.Dependencies{
+		Deliveries: messages,
+		Webhook: processor.WebhookDefaults{
+			Timeout: 5 * time.Second,
+			Retry:   broker.RetryPolicy{MaxAttempts: 1, InitialBackoff: time.Millisecond},
+		},
+	}
+}
+
+func webhookOptions(t *testing.T, opts processor.WebhookOptions) string {
+	options, err := json.Marshal(opts)
+	require.NoError(t, err)
+	return string(options)
+}
+
+func TestWebhook_When_MessageIsDelivered_Then_TheRequestIsSigned(t *testing.T) {
+	type request struct {
+		header http.Header
+		body   []byte
+	}
+	requests := make(chan request, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		requests <- request{header: r.Header.Clone(), body: body}
+	}))
+	t.Cleanup(srv.Close)
+
+	messages := memory.NewMessageRepository(memory.NewStore())
+	p := build(t, webhookDeps(messages), processor.TypeWebhook, webhookOptions(t, processor.WebhookOptions{URL: srv.URL, Secret: "s3cret"}))
+	d := newDelivery(`{"order": 42}`)
+
+	action, err := p.Process(context.Background(), d)
+	require.NoError(t, err)
+	require.Equal(t, processor.Continue, action)
+
+	req := <-requests
+	require.JSONEq(t, `{"order": 42}`, string(req.body))
+	require.Equal(t, "application/json", req.header.Get("Content-Type"))
+	require.Equal(t, d.Message.ID.String(), req.header.Get(processor.HeaderWebhookID))
+
+	timestamp := req.header.Get(processor.HeaderWebhookTimestamp)
+	require.NotEmpty(t, timestamp)
+	mac := hmac.New(sha256.New, []byte("s3cret"))
+	mac.Write([]byte(timestamp + "." + string(req.body)))
+	require.Equal(t, "sha256="+hex.EncodeToString(mac.Sum(nil)), req.header.Get(processor.HeaderWebhookSignature))
+
+	deliveries, err := messages.ListWebhookDeliveries(context.Background(), d.Message.TenantID, d.Message.ID)
+	require.NoError(t, err)
+	require.Len(t, deliveries, 1)
+	require.Equal(t, srv.URL, deliveries[0].URL)
+	require.Equal(t, http.StatusOK, deliveries[0].StatusCode)
+	require.Empty(t, deliveries[0].Error)
+}
+
+func TestWebhook_When_EndpointFails_Then_OnlyRetryableResponsesAreRetried(t *testing.T) {
+	tests := []struct {
+		name        string
+		maxAttempts int
+		responses   []int
+		want        processor.Action
+		wantSent    int
+	}{
+		{name: "server error then success", maxAttempts: 3, responses: []int{500, 200}, want: processor.Continue, wantSent: 2},
+		{name: "timeout and throttling then success", maxAttempts: 3, responses: []int{408, 429, 204}, want: processor.Continue, wantSent: 3},
+		{name: "server errors up to max attempts", maxAttempts: 3, responses: []int{502, 503, 500, 200}, want: processor.Retry, wantSent: 3},
+		{name: "throttled with the default attempts", maxAttempts: 0, responses: []int{429, 200}, want: processor.Retry, wantSent: 1},
+		{name: "bad request", maxAttempts: 3, responses: []int{400, 200}, want: processor.DeadLetter, wantSent: 1},
+		{name: "not found after a server error", maxAttempts: 3, responses: []int{500, 404, 200}, want: processor.DeadLetter, wantSent: 2},
+		{name: "redirect", maxAttempts: 3, responses: []int{304, 200}, want: processor.DeadLetter, wantSent: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var (
+				mu   sync.Mutex
+				sent int
+			)
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				mu.Lock()
+				defer mu.Unlock()
+				w.WriteHeader(tt.responses[sent])
+				sent++
+			}))
+			t.Cleanup(srv.Close)
+
+			messages := memory.NewMessageRepository(memory.NewStore())
+			options := webhookOptions(t, processor.WebhookOptions{URL: srv.URL, Secret: "s3cret", MaxAttempts: tt.maxAttempts})
+			p := build(t, webhookDeps(messages), processor.TypeWebhook, options)
+			d := newDelivery(`{"a": 1}`)
+
+			action, err := p.Process(context.Background(), d)
+			require.Equal(t, tt.want, action)
+			if tt.want == processor.Continue {
+				require.NoError(t, err)
+			} else {
+				require.Error(t, err)
+			}
+
+			// Every request is logged, numbered in order
+			require.Equal(t, tt.wantSent, sent)
+			deliveries, err := messages.ListWebhookDeliveries(context.Background(), d.Message.TenantID, d.Message.ID)
+			require.NoError(t, err)
+			require.Len(t, deliveries, tt.wantSent)
+			for i, delivery := range deliveries {
+				require.Equal(t, i+1, delivery.Attempt)
+				require.Equal(t, tt.responses[i], delivery.StatusCode)
+				require.Equal(t, delivery.StatusCode >= 300, delivery.Error != "")
+			}
+		})
+	}
+}
+
+func TestWebhook_When_EndpointIsTooSlow_Then_TheRequestTimesOut(t *testing.T) {
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	t.Cleanup(srv.Close)
+	t.Cleanup(func() { close(release) })
+
+	messages := memory.NewMessageRepository(memory.NewStore())
+	options := webhookOptions(t, processor.WebhookOptions{URL: srv.URL, Secret: "s3cret", Timeout: "50ms", MaxAttempts: 2})
+	p := build(t, webhookDeps(messages), processor.TypeWebhook, options)
+	d := newDelivery(`{"a": 1}`)
+
+	action, err := p.Process(context.Background(), d)
+	require.Error(t, err)
+	require.Equal(t, processor.Retry, action)
+
+	deliveries, err := messages.ListWebhookDeliveries(context.Background(), d.Message.TenantID, d.Message.ID)
+	require.NoError(t, err)
+	require.Len(t, deliveries, 2)
+	for _, delivery := range deliveries {
+		require.Zero(t, delivery.StatusCode)
+		require.NotEmpty(t, delivery.Error)
+	}
+}
+
+func TestWebhook_When_OptionsAreInvalid_Then_TheChainIsRejected(t *testing.T) {
+	tests := []struct {
+		name    string
+		options string
+		wantErr string
+	}{
+		{name: "no options", options: ``, wantErr: "url must be an absolute http or https URL"},
+		{name: "relative url", options: `{"url": "/hooks", "secret": "s"}`, wantErr: "url must be an absolute http or https URL"},
+		{name: "other scheme", options: `{"url": "ftp://example.com/hooks", "secret": "s"}`, wantErr: "url must be an absolute http or https URL"},
+		{name: "no host", options: `{"url": "https://", "secret": "s"}`, wantErr: "url must be an absolute http or https URL"},
+		{name: "malformed url", options: `{"url": "https://exa mple.com", "secret": "s"}`, wantErr: "url must be an absolute http or https URL"},
+		{name: "missing secret", options: `{"url": "https://example.com/hooks"}`, wantErr: "secret is required"},
+		{name: "zero timeout", options: `{"url": "https://example.com/hooks", "secret": "s", "timeout": "0s"}`, wantErr: "timeout must be a positive duration"},
+		{name: "negative timeout", options: `{"url": "https://example.com/hooks", "secret": "s", "timeout": "-1s"}`, wantErr: "timeout must be a positive duration"},
+		{name: "timeout without unit", options: `{"url": "https://example.com/hooks", "secret": "s", "timeout": "5"}`, wantErr: "timeout must be a positive duration"},
+		{name: "negative max attempts", options: `{"url": "https://example.com/hooks", "secret": "s", "max_attempts": -1}`, wantErr: "max_attempts must be between 1 and 10"},
+		{name: "too many attempts", options: `{"url": "https://example.com/hooks", "secret": "s", "max_attempts": 11}`, wantErr: "max_attempts must be between 1 and 10"},
+		{name: "unknown option", options: `{"url": "https://example.com/hooks", "secret": "s", "headers": {}}`, wantErr: "unknown field"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			registry := processor.NewRegistry(webhookDeps(memory.NewMessageRepository(memory.NewStore())))
+
+			_, err := registry.Build([]domain.ProcessorConfig{{Type: processor.TypeWebhook, Options: json.RawMessage(tt.options)}})
+			require.ErrorIs(t, err, processor.ErrInvalidConfig)
+			require.ErrorContains(t, err, tt.wantErr)
+		})
+	}
+
+	t.Run("no delivery log", func(t *testing.T) {
+		registry := processor.NewRegistry(processor.Dependencies{})
+
+		_, err := registry.Build([]domain.ProcessorConfig{{Type: processor.TypeWebhook, Options: json.RawMessage(`{"url": "https://example.com/hooks", "secret": "s"}`)}})
+		require.ErrorIs(t, err, processor.ErrInvalidConfig)
+		require.ErrorContains(t, err, "no delivery log available")
+	})
+}
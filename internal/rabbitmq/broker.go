@@ -0,0 +1,246 @@
+package rabbitmq
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/fekalegi/multi-tenant-system/internal/broker"
+	"github.com/fekalegi/multi-tenant-system/internal/domain"
+	"github.com/rs/zerolog"
+	"github.com/streadway/amqp"
+)
+
+// Broker is the RabbitMQ implementation of broker.Broker. Every tenant has a durable
+// priority queue with a dead-letter exchange and queue, and one delayed retry queue per
+// backoff step of the retry policy, declared on its first retry.
+type Broker struct {
+	conn        *Connection
+	publisher   *Publisher
+	deadLetters *DeadLetterQueues
+	retry       broker.RetryPolicy
+	log         zerolog.Logger
+
+	// subscriptions numbers the consumer tags
+	subscriptions atomic.Int64
+}
+
+// NewBroker returns a broker publishing through publisher on conn. The retry policy
+// decides which retry queues may be declared, so deliveries can only be retried with
+// its delays.
+func NewBroker(conn *Connection, publisher *Publisher, retry broker.RetryPolicy, log zerolog.Logger) *Broker {
+	return &Broker{
+		conn:        conn,
+		publisher:   publisher,
+		deadLetters: NewDeadLetterQueues(conn, publisher),
+		retry:       retry,
+		log:         log,
+	}
+}
+
+// DeclareTenantQueue declares the tenant topology. A queue declared before priorities
+// existed cannot be converted in place; it is kept as it is and delivers in publish order.
+func (b *Broker) DeclareTenantQueue(ctx context.Context, tenantID string) error {
+	err := b.declare(tenantID, domain.MaxMessagePriority)
+
+	var amqpErr *amqp.Error
+	if errors.As(err, &amqpErr) && amqpErr.Code == amqp.PreconditionFailed {
+		b.log.Warn().Str("tenant_id", tenantID).Msg("Tenant queue was declared without priorities, recreate the tenant to enable them")
+		return b.declare(tenantID, 0)
+	}
+	return err
+}
+
+func (b *Broker) declare(tenantID string, maxPriority uint8) error {
+	// A failed declare closes the channel, so use a dedicated one
+	ch, err := b.conn.Channel()
+	if err != nil {
+		return fmt.Errorf("failed to open channel: %w", err)
+	}
+	defer ch.Close()
+
+	return DeclareTenantTopology(ch, tenantID, maxPriority)
+}
+
+// DeleteTenantQueue deletes the tenant topology.
+func (b *Broker) DeleteTenantQueue(ctx context.Context, tenantID string) error {
+	ch, err := b.conn.Channel()
+	if err != nil {
+		return fmt.Errorf("failed to open channel: %w", err)
+	}
+	defer ch.Close()
+
+	return DeleteTenantTopology(ch, tenantID, b.retry)
+}
+
+// Publish publishes a message to the tenant queue and waits for the broker's confirm.
+func (b *Broker) Publish(ctx context.Context, tenantID string, msg broker.Message) error {
+	return b.publisher.PublishToTenantQueue(ctx, tenantID, msg.ID, msg.Body, msg.Priority)
+}
+
+// PublishBatch publishes messages to the tenant queue on one confirmed channel.
+func (b *Broker) PublishBatch(ctx context.Context, tenantID string, msgs []broker.Message) []error {
+	return b.publisher.PublishBatch(ctx, tenantID, msgs)
+}
+
+// Forward publishes a message to an exchange and waits for the broker's confirm.
+func (b *Broker) Forward(ctx context.Context, exchange, routingKey, messageID string, body []byte, priority uint8) error {
+	return b.publisher.Forward(ctx, exchange, routingKey, messageID, body, priority)
+}
+
+// Subscribe opens a channel to consume the tenant queue on.
+func (b *Broker) Subscribe(tenantID string) (broker.Subscription, error) {
+	ch, err := b.conn.Channel()
+	if err != nil {
+		return nil, fmt.Errorf("failed to open channel: %w", err)
+	}
+	return &subscription{
+		broker:   b,
+		tenantID: tenantID,
+		ch:       ch,
+		closed:   make(chan struct{}),
+	}, nil
+}
+
+// Inspect returns the depth and consumers of the tenant queue.
+func (b *Broker) Inspect(ctx context.Context, tenantID string) (broker.QueueStats, error) {
+	// A failed inspect closes the channel, so use a dedicated one
+	ch, err := b.conn.Channel()
+	if err != nil {
+		return broker.QueueStats{}, fmt.Errorf("failed to open channel: %w", err)
+	}
+	defer ch.Close()
+
+	q, err := ch.QueueInspect(TenantQueue(tenantID))
+	if err != nil {
+		return broker.QueueStats{}, err
+	}
+	return broker.QueueStats{Messages: q.Messages, Consumers: q.Consumers}, nil
+}
+
+func (b *Broker) DeadLetters() broker.DeadLetters {
+	return b.deadLetters
+}
+
+func (b *Broker) WaitReady(ctx context.Context) error {
+	return b.conn.WaitReady(ctx)
+}
+
+func (b *Broker) OnReconnect(fn func()) {
+	b.conn.OnReconnect(fn)
+}
+
+// Close closes the publisher channels and the connection.
+func (b *Broker) Close() {
+	b.publisher.Close()
+	b.conn.Close()
+}
+
+// subscription consumes a tenant queue on its own channel. Deliveries are settled on
+// that channel, so it stays open until Close even when a consume is cancelled.
+type subscription struct {
+	broker   *Broker
+	tenantID string
+	ch       *amqp.Channel
+	tag      string
+
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+func (s *subscription) Consume(prefetch int) (<-chan *broker.Delivery, error) {
+	// Bound the number of unacknowledged deliveries held by this consumer
+	if err := s.ch.Qos(prefetch, 0, false); err != nil {
+		return nil, fmt.Errorf("failed to set prefetch: %w", err)
+	}
+
+	s.tag = fmt.Sprintf("consumer-%s-%d", s.tenantID, s.broker.subscriptions.Add(1))
+	msgs, err := s.ch.Consume(
+		TenantQueue(s.tenantID),
+		s.tag,
+		false, // auto-ack
+		false, // exclusive
+		false,
+		false,
+		nil,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	deliveries := make(chan *broker.Delivery)
+	go func() {
+		defer close(deliveries)
+		for msg := range msgs {
+			select {
+			case deliveries <- s.delivery(msg):
+			case <-s.closed:
+				// Unacked, the broker redelivers it once the channel closes
+				return
+			}
+		}
+	}()
+	return deliveries, nil
+}
+
+func (s *subscription) Cancel() error {
+	return s.ch.Cancel(s.tag, false)
+}
+
+func (s *subscription) Close() error {
+	s.closeOnce.Do(func() { close(s.closed) })
+	return s.ch.Close()
+}
+
+func (s *subscription) delivery(msg amqp.Delivery) *broker.Delivery {
+	return &broker.Delivery{
+		Message: broker.Message{
+			ID:        msg.MessageId,
+			Body:      msg.Body,
+			Priority:  msg.Priority,
+			Timestamp: msg.Timestamp,
+		},
+		Attempts:     Attempts(msg),
+		Acknowledger: &acknowledger{sub: s, msg: msg},
+	}
+}
+
+// acknowledger settles one delivery on the channel it arrived on.
+type acknowledger struct {
+	sub *subscription
+	msg amqp.Delivery
+}
+
+func (a *acknowledger) Ack(d *broker.Delivery) error {
+	return a.msg.Ack(false)
+}
+
+func (a *acknowledger) Nack(d *broker.Delivery) error {
+	return a.msg.Nack(false, true)
+}
+
+// Retry parks the delivery in the retry queue of the delay and acknowledges it once
+// the broker confirmed the retry.
+func (a *acknowledger) Retry(d *broker.Delivery, delay time.Duration) error {
+	// Bound the retry queues to the delays of the policy
+	if !slices.Contains(a.sub.broker.retry.Delays(), delay) {
+		return fmt.Errorf("no retry queue for a delay of %s", delay)
+	}
+	if err := a.sub.broker.publisher.PublishRetry(context.Background(), a.sub.tenantID, a.msg, d.Attempts+1, delay); err != nil {
+		return err
+	}
+	return a.msg.Ack(false)
+}
+
+// DeadLetter publishes the delivery to the dead-letter exchange and acknowledges it
+// once the broker confirmed the dead letter.
+func (a *acknowledger) DeadLetter(d *broker.Delivery, cause error) error {
+	if err := a.sub.broker.publisher.PublishDeadLetter(context.Background(), a.sub.tenantID, a.msg, d.Attempts+1, cause); err != nil {
+		return err
+	}
+	return a.msg.Ack(false)
+}
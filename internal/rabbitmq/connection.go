@@ -1,34 +1,219 @@
 package rabbitmq
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/fekalegi/multi-tenant-system/internal/broker"
 	"github.com/rs/zerolog"
 	"github.com/streadway/amqp"
 )
 
+// ErrNotConnected is returned while the connection to the broker is down. It wraps
+// broker.ErrUnavailable.
+var ErrNotConnected = fmt.Errorf("rabbitmq: not connected: %w", broker.ErrUnavailable)
+
+// initialDialAttempts bounds how long NewConnection waits for the broker on startup.
+const initialDialAttempts = 5
+
+// ReconnectPolicy controls how a lost connection is re-established. The delay
+// between attempts starts at InitialDelay and doubles up to MaxDelay.
+type ReconnectPolicy struct {
+	InitialDelay time.Duration
+	MaxDelay     time.Duration
+}
+
+func (p ReconnectPolicy) next(delay time.Duration) time.Duration {
+	if delay <= 0 {
+		return p.InitialDelay
+	}
+	delay *= 2
+	if delay > p.MaxDelay {
+		delay = p.MaxDelay
+	}
+	return delay
+}
+
+// Connection is a supervised AMQP connection. When the broker closes it, it is
+// re-dialed with backoff and the registered reconnect hooks are run.
 type Connection struct {
-	conn *amqp.Connection
-	log  zerolog.Logger
+	url    string
+	policy ReconnectPolicy
+	log    zerolog.Logger
+
+	mu    sync.RWMutex
+	conn  *amqp.Connection
+	ready chan struct{} // closed while connected
+	hooks []func()
+
+	closed    chan struct{}
+	closeOnce sync.Once
 }
 
-func NewConnection(url string, log zerolog.Logger) *Connection {
-	conn, err := amqp.Dial(url)
+// NewConnection dials the broker, retrying a few times with backoff, and supervises
+// the connection from then on. It fails if the broker cannot be reached on startup.
+func NewConnection(url string, policy ReconnectPolicy, log zerolog.Logger) (*Connection, error) {
+	c := &Connection{
+		url:    url,
+		policy: policy,
+		log:    log,
+		ready:  make(chan struct{}),
+		closed: make(chan struct{}),
+	}
+
+	var (
+		conn  *amqp.Connection
+		err   error
+		delay time.Duration
+	)
+	for attempt := 1; attempt <= initialDialAttempts; attempt++ {
+		if conn, err = amqp.Dial(url); err == nil || attempt == initialDialAttempts {
+			break
+		}
+		delay = policy.next(delay)
+		log.Warn().Err(err).Int("attempt", attempt).Dur("retry_in", delay).Msg("Failed to connect to RabbitMQ")
+		time.Sleep(delay)
+	}
 	if err != nil {
-		log.Fatal().Err(err).Msg("Failed to connect to RabbitMQ")
+		return nil, fmt.Errorf("could not connect to RabbitMQ after %d attempts: %w", initialDialAttempts, err)
 	}
 
 	log.Info().Msg("Connected to RabbitMQ")
-	return &Connection{
-		conn: conn,
-		log:  log,
-	}
+	c.setConn(conn)
+	go c.supervise(conn)
+
+	return c, nil
+}
+
+// OnReconnect registers a hook run after every successful reconnection.
+func (c *Connection) OnReconnect(fn func()) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.hooks = append(c.hooks, fn)
 }
 
+// Channel opens a channel on the current connection, or fails with ErrNotConnected
+// during an outage.
 func (c *Connection) Channel() (*amqp.Channel, error) {
-	return c.conn.Channel()
+	c.mu.RLock()
+	conn := c.conn
+	c.mu.RUnlock()
+
+	if conn == nil {
+		return nil, ErrNotConnected
+	}
+
+	ch, err := conn.Channel()
+	if errors.Is(err, amqp.ErrClosed) {
+		return nil, fmt.Errorf("%w: %v", ErrNotConnected, err)
+	}
+	return ch, err
+}
+
+// WaitReady blocks until the connection is up, ctx expires or the connection is closed.
+func (c *Connection) WaitReady(ctx context.Context) error {
+	c.mu.RLock()
+	ready := c.ready
+	c.mu.RUnlock()
+
+	select {
+	case <-ready:
+		return nil
+	case <-c.closed:
+		return ErrNotConnected
+	case <-ctx.Done():
+		return fmt.Errorf("%w: %v", ErrNotConnected, ctx.Err())
+	}
 }
 
 func (c *Connection) Close() {
-	if err := c.conn.Close(); err != nil {
+	c.closeOnce.Do(func() { close(c.closed) })
+
+	c.mu.Lock()
+	conn := c.conn
+	c.conn = nil
+	c.mu.Unlock()
+
+	if conn == nil {
+		return
+	}
+	if err := conn.Close(); err != nil {
 		c.log.Error().Err(err).Msg("Failed to close RabbitMQ connection")
 	}
 }
+
+func (c *Connection) setConn(conn *amqp.Connection) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.conn = conn
+	close(c.ready)
+}
+
+// supervise watches the connection and re-dials it whenever the broker closes it.
+func (c *Connection) supervise(conn *amqp.Connection) {
+	for {
+		notify := conn.NotifyClose(make(chan *amqp.Error, 1))
+
+		select {
+		case <-c.closed:
+			return
+		case amqpErr := <-notify:
+			select {
+			case <-c.closed:
+				return
+			default:
+			}
+			c.log.Warn().Interface("reason", amqpErr).Msg("RabbitMQ connection lost, reconnecting")
+		}
+
+		c.mu.Lock()
+		c.conn = nil
+		c.ready = make(chan struct{})
+		c.mu.Unlock()
+
+		conn = c.redial()
+		if conn == nil {
+			return
+		}
+
+		c.setConn(conn)
+		c.log.Info().Msg("Reconnected to RabbitMQ")
+
+		c.mu.RLock()
+		hooks := append([]func(){}, c.hooks...)
+		c.mu.RUnlock()
+		for _, hook := range hooks {
+			hook()
+		}
+	}
+}
+
+// redial dials until it succeeds or the connection is closed, in which case it returns nil.
+func (c *Connection) redial() *amqp.Connection {
+	var delay time.Duration
+	for {
+		delay = c.policy.next(delay)
+
+		select {
+		case <-c.closed:
+			return nil
+		case <-time.After(delay):
+		}
+
+		conn, err := amqp.Dial(c.url)
+		if err == nil {
+			select {
+			case <-c.closed:
+				_ = conn.Close()
+				return nil
+			default:
+				return conn
+			}
+		}
+		c.log.Warn().Err(err).Dur("retry_in", c.policy.next(delay)).Msg("RabbitMQ reconnect failed")
+	}
+}
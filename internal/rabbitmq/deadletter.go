@@ -0,0 +1,172 @@
+package rabbitmq
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/fekalegi/multi-tenant-system/internal/domain"
+	"github.com/streadway/amqp"
+)
+
+// DeadLetterQueues browses and manages the messages parked in tenant dead-letter queues.
+//
+// RabbitMQ has no way to read a queue without consuming it, so every operation fetches
+// messages unacknowledged on a dedicated channel and requeues whatever it did not
+// remove once it is done.
+type DeadLetterQueues struct {
+	rmq       *Connection
+	publisher *Publisher
+}
+
+// NewDeadLetterQueues returns the dead-letter queues on rmq. Replayed messages are
+// published through publisher and only removed once the broker confirmed them.
+func NewDeadLetterQueues(rmq *Connection, publisher *Publisher) *DeadLetterQueues {
+	return &DeadLetterQueues{rmq: rmq, publisher: publisher}
+}
+
+// List returns up to limit dead-lettered messages of a tenant, oldest first.
+func (q *DeadLetterQueues) List(tenantID string, limit int) ([]*domain.DeadLetter, error) {
+	letters := []*domain.DeadLetter{}
+	err := q.browse(tenantID, func(ch *amqp.Channel, d amqp.Delivery) (bool, error) {
+		letters = append(letters, toDeadLetter(tenantID, d))
+		return len(letters) >= limit, nil
+	})
+	return letters, err
+}
+
+// Get returns a single dead-lettered message by its message ID.
+func (q *DeadLetterQueues) Get(tenantID, messageID string) (*domain.DeadLetter, error) {
+	var letter *domain.DeadLetter
+	err := q.find(tenantID, messageID, func(ch *amqp.Channel, d amqp.Delivery) error {
+		letter = toDeadLetter(tenantID, d)
+		return nil
+	})
+	return letter, err
+}
+
+// Delete permanently removes a dead-lettered message.
+func (q *DeadLetterQueues) Delete(tenantID, messageID string) error {
+	return q.find(tenantID, messageID, func(ch *amqp.Channel, d amqp.Delivery) error {
+		return d.Ack(false)
+	})
+}
+
+// Replay publishes a dead-lettered message back onto the tenant queue with a fresh
+// attempt count and removes it from the dead-letter queue.
+func (q *DeadLetterQueues) Replay(tenantID, messageID string) error {
+	return q.find(tenantID, messageID, q.replay(tenantID))
+}
+
+// ReplayAll moves every dead-lettered message of a tenant back onto the tenant queue
+// and returns how many were replayed.
+func (q *DeadLetterQueues) ReplayAll(tenantID string) (int, error) {
+	replayed := 0
+	err := q.browse(tenantID, func(ch *amqp.Channel, d amqp.Delivery) (bool, error) {
+		if err := q.replay(tenantID)(ch, d); err != nil {
+			return true, err
+		}
+		replayed++
+		return false, nil
+	})
+	return replayed, err
+}
+
+// replay returns a visitor publishing a dead-lettered message to the tenant queue
+// and removing it from the dead-letter queue once the broker confirmed the publish.
+func (q *DeadLetterQueues) replay(tenantID string) func(ch *amqp.Channel, d amqp.Delivery) error {
+	return func(ch *amqp.Channel, d amqp.Delivery) error {
+		pub := republish(d)
+		delete(pub.Headers, HeaderAttempt)
+		delete(pub.Headers, HeaderError)
+		delete(pub.Headers, HeaderFailedAt)
+		delete(pub.Headers, "x-death")
+
+		if err := q.publisher.publish(context.Background(), "", TenantQueue(tenantID), pub); err != nil {
+			return fmt.Errorf("failed to replay message: %w", err)
+		}
+		return d.Ack(false)
+	}
+}
+
+// find browses the dead-letter queue until it reaches the message with the given ID
+// and applies fn to it.
+func (q *DeadLetterQueues) find(tenantID, messageID string, fn func(ch *amqp.Channel, d amqp.Delivery) error) error {
+	found := false
+	err := q.browse(tenantID, func(ch *amqp.Channel, d amqp.Delivery) (bool, error) {
+		if d.MessageId != messageID {
+			return false, nil
+		}
+		found = true
+		return true, fn(ch, d)
+	})
+	if err != nil {
+		return err
+	}
+	if !found {
+		return domain.ErrDeadLetterNotFound
+	}
+	return nil
+}
+
+// browse fetches dead-lettered messages one by one until visit asks to stop or every
+// message present when browsing started was seen. Messages visit did not ack are
+// requeued when the channel closes.
+func (q *DeadLetterQueues) browse(tenantID string, visit func(ch *amqp.Channel, d amqp.Delivery) (bool, error)) error {
+	ch, err := q.rmq.Channel()
+	if err != nil {
+		return fmt.Errorf("failed to open channel: %w", err)
+	}
+	defer ch.Close()
+
+	// Snapshot the depth so replayed messages that fail again are not revisited
+	remaining := -1
+	for remaining != 0 {
+		d, ok, err := ch.Get(DeadLetterQueue(tenantID), false)
+		if err != nil {
+			return fmt.Errorf("failed to read dead-letter queue: %w", err)
+		}
+		if !ok {
+			return nil
+		}
+		if remaining < 0 {
+			remaining = int(d.MessageCount) + 1
+		}
+		remaining--
+
+		stop, err := visit(ch, d)
+		if err != nil || stop {
+			return err
+		}
+	}
+	return nil
+}
+
+func toDeadLetter(tenantID string, d amqp.Delivery) *domain.DeadLetter {
+	letter := &domain.DeadLetter{
+		ID:       d.MessageId,
+		TenantID: tenantID,
+		Attempts: Attempts(d),
+		Payload:  d.Body,
+	}
+
+	if !json.Valid(d.Body) {
+		letter.Payload, _ = json.Marshal(string(d.Body))
+	}
+
+	if reason, ok := d.Headers[HeaderError].(string); ok {
+		letter.Error = reason
+	} else if reason, ok := d.Headers["x-first-death-reason"].(string); ok {
+		// Dead-lettered by the broker itself, e.g. a rejected delivery
+		letter.Error = reason
+	}
+
+	if failedAt, ok := d.Headers[HeaderFailedAt].(string); ok {
+		letter.FailedAt, _ = time.Parse(time.RFC3339Nano, failedAt)
+	} else if !d.Timestamp.IsZero() {
+		letter.FailedAt = d.Timestamp
+	}
+
+	return letter
+}
@@ -1,71 +1,454 @@
 package rabbitmq
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"time"
 
+	"github.com/fekalegi/multi-tenant-system/internal/broker"
 	"github.com/google/uuid"
 	"github.com/rs/zerolog"
 	"github.com/streadway/amqp"
 )
 
+var (
+	// ErrPublishNacked is returned when the broker refused to take a message.
+	ErrPublishNacked = errors.New("rabbitmq: publish nacked by broker")
+	// ErrConfirmTimeout is returned when the broker did not confirm a message in time.
+	ErrConfirmTimeout = errors.New("rabbitmq: publish confirm timed out")
+)
+
+// PublisherOptions configures a Publisher.
+type PublisherOptions struct {
+	// PoolSize bounds the number of channels publishing concurrently.
+	PoolSize int
+	// Wait is how long a publish waits for the connection during an outage.
+	Wait time.Duration
+	// ConfirmTimeout is how long a publish waits for the broker to confirm it.
+	ConfirmTimeout time.Duration
+}
+
+// confirmChannel is a channel in confirm mode with its notification channels.
+type confirmChannel struct {
+	ch       *amqp.Channel
+	confirms chan amqp.Confirmation
+	returns  chan amqp.Return
+	closed   chan *amqp.Error
+}
+
+func (cc *confirmChannel) isClosed() bool {
+	select {
+	case <-cc.closed:
+		return true
+	default:
+		return false
+	}
+}
+
+// Publisher publishes persistent messages on a pool of channels in confirm mode.
+// A publish only succeeds once the broker has acknowledged the message.
 type Publisher struct {
-	rmq *Connection
-	log zerolog.Logger
+	rmq  *Connection
+	opts PublisherOptions
+	log  zerolog.Logger
+
+	slots chan struct{}
+	idle  chan *confirmChannel
 }
 
-func NewPublisher(r *Connection, log zerolog.Logger) *Publisher {
-	return &Publisher{rmq: r, log: log}
+func NewPublisher(r *Connection, opts PublisherOptions, log zerolog.Logger) *Publisher {
+	if opts.PoolSize <= 0 {
+		opts.PoolSize = 1
+	}
+
+	return &Publisher{
+		rmq:   r,
+		opts:  opts,
+		log:   log,
+		slots: make(chan struct{}, opts.PoolSize),
+		idle:  make(chan *confirmChannel, opts.PoolSize),
+	}
 }
 
-func (p *Publisher) Publish(tenantID string, payload map[string]interface{}) error {
-	queue := fmt.Sprintf("tenant_%s_queue", tenantID)
+func (p *Publisher) Publish(ctx context.Context, tenantID string, payload map[string]interface{}) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
 
-	ch, err := p.rmq.Channel()
+	return p.PublishToTenantQueue(ctx, tenantID, uuid.New().String(), data, 0)
+}
+
+// PublishToTenantQueue publishes a message to the tenant queue. The message ID travels
+// as the AMQP message ID so the consumer updates the stored message instead of adding one.
+// Messages of a higher priority are delivered first.
+func (p *Publisher) PublishToTenantQueue(ctx context.Context, tenantID, messageID string, body []byte, priority uint8) error {
+	err := p.publish(ctx, "", TenantQueue(tenantID), amqp.Publishing{
+		ContentType:  "application/json",
+		DeliveryMode: amqp.Persistent,
+		Priority:     priority,
+		MessageId:    messageID,
+		Timestamp:    time.Now(),
+		Body:         body,
+	})
 	if err != nil {
+		return fmt.Errorf("failed to publish message: %w", err)
+	}
+
+	return nil
+}
+
+// Forward publishes a message to an exchange with a routing key; with the default
+// exchange the routing key names the queue. It fails with broker.ErrUnroutable when no queue
+// is bound to take the message.
+func (p *Publisher) Forward(ctx context.Context, exchange, routingKey, messageID string, body []byte, priority uint8) error {
+	err := p.publish(ctx, exchange, routingKey, amqp.Publishing{
+		ContentType:  "application/json",
+		DeliveryMode: amqp.Persistent,
+		Priority:     priority,
+		MessageId:    messageID,
+		Timestamp:    time.Now(),
+		Body:         body,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to forward message: %w", err)
+	}
+
+	return nil
+}
+
+// PublishRetry parks a failed delivery in the retry queue of delay, declaring the
+// queue first, and waits for the broker's confirm. attempt is the number of failed
+// attempts so far.
+func (p *Publisher) PublishRetry(ctx context.Context, tenantID string, d amqp.Delivery, attempt int, delay time.Duration) error {
+	if err := p.declareRetryQueue(ctx, tenantID, delay); err != nil {
 		return err
 	}
-	defer ch.Close()
 
-	data, _ := json.Marshal(payload)
+	if err := p.publish(ctx, "", RetryQueue(tenantID, delay), retryPublishing(d, attempt)); err != nil {
+		return fmt.Errorf("failed to publish retry: %w", err)
+	}
+	return nil
+}
 
-	err = ch.Publish(
-		"",    // exchange
-		queue, // routing key
-		false, // mandatory
-		false, // immediate
-		amqp.Publishing{
-			ContentType: "application/json",
-			MessageId:   uuid.New().String(),
-			Body:        data,
-		},
-	)
+// PublishDeadLetter moves a failed delivery to the tenant's dead-letter queue and waits
+// for the broker's confirm.
+func (p *Publisher) PublishDeadLetter(ctx context.Context, tenantID string, d amqp.Delivery, attempt int, cause error) error {
+	if err := p.publish(ctx, DeadLetterExchange(tenantID), TenantQueue(tenantID), deadLetterPublishing(d, attempt, cause)); err != nil {
+		return fmt.Errorf("failed to publish dead letter: %w", err)
+	}
+	return nil
+}
+
+// declareRetryQueue declares a retry queue on a pooled channel, which a failed declare
+// closes.
+func (p *Publisher) declareRetryQueue(ctx context.Context, tenantID string, delay time.Duration) error {
+	cc, err := p.acquire(ctx)
+	if err != nil {
+		return err
+	}
+
+	err = DeclareRetryQueue(cc.ch, tenantID, delay)
+	p.release(cc, err == nil)
+
+	var amqpErr *amqp.Error
+	if errors.As(err, &amqpErr) && amqpErr.Code == amqp.PreconditionFailed {
+		// Declared without an expiry by an earlier version, it still takes retries
+		return nil
+	}
 	return err
 }
 
-func (p *Publisher) PublishToTenantQueue(tenantID string, body []byte) error {
-	channel, err := p.rmq.Channel()
+// PublishBatch publishes messages to the tenant queue on a single channel and waits for
+// all their confirms at once rather than one round trip per message. It returns one
+// error per message, nil for those the broker acknowledged.
+func (p *Publisher) PublishBatch(ctx context.Context, tenantID string, msgs []broker.Message) []error {
+	now := time.Now()
+	pubs := make([]amqp.Publishing, len(msgs))
+	for i, m := range msgs {
+		pubs[i] = amqp.Publishing{
+			ContentType:  "application/json",
+			DeliveryMode: amqp.Persistent,
+			Priority:     m.Priority,
+			MessageId:    m.ID,
+			Timestamp:    now,
+			Body:         m.Body,
+		}
+	}
+	return p.publishBatch(ctx, TenantQueue(tenantID), pubs)
+}
+
+// Close closes the idle channels of the pool.
+func (p *Publisher) Close() {
+	for {
+		select {
+		case cc := <-p.idle:
+			_ = cc.ch.Close()
+		default:
+			return
+		}
+	}
+}
+
+// publish sends a message to an exchange and waits for the broker's confirm.
+func (p *Publisher) publish(ctx context.Context, exchange, routingKey string, msg amqp.Publishing) error {
+	cc, err := p.acquire(ctx)
 	if err != nil {
-		return fmt.Errorf("failed to open channel: %w", err)
+		return err
 	}
-	defer channel.Close()
 
-	queueName := fmt.Sprintf("tenant_%s_queue", tenantID)
+	err = cc.ch.Publish(
+		exchange,
+		routingKey,
+		true,  // mandatory
+		false, // immediate
+		msg,
+	)
+	if err != nil {
+		p.release(cc, false)
+		return err
+	}
+
+	timer := time.NewTimer(p.opts.ConfirmTimeout)
+	defer timer.Stop()
+
+	select {
+	case confirm, ok := <-cc.confirms:
+		if !ok {
+			p.release(cc, false)
+			return ErrNotConnected
+		}
+		// The broker sends a return before the ack of an unroutable message
+		select {
+		case <-cc.returns:
+			p.release(cc, true)
+			return broker.ErrUnroutable
+		default:
+		}
+		p.release(cc, true)
+		if !confirm.Ack {
+			return ErrPublishNacked
+		}
+		return nil
+
+	case <-timer.C:
+		// A late confirm would be mistaken for the next publish's, drop the channel
+		p.release(cc, false)
+		return ErrConfirmTimeout
+
+	case <-ctx.Done():
+		p.release(cc, false)
+		return ctx.Err()
+	}
+}
+
+// publishBatch sends messages to a queue on one channel, then waits for their confirms.
+func (p *Publisher) publishBatch(ctx context.Context, queue string, msgs []amqp.Publishing) []error {
+	errs := make([]error, len(msgs))
+	fail := func(from int, err error) {
+		for i := from; i < len(msgs); i++ {
+			errs[i] = err
+		}
+	}
 
-	err = channel.Publish(
-		"",        // exchange
-		queueName, // routing key
-		false,     // mandatory
-		false,     // immediate
-		amqp.Publishing{
-			ContentType: "application/json",
-			Body:        body,
-		},
+	cc, err := p.acquireBuffered(ctx, len(msgs))
+	if err != nil {
+		fail(0, err)
+		return errs
+	}
+
+	var (
+		acks      = make([]bool, len(msgs))
+		returned  = map[string]bool{}
+		confirmed int
+		published = make(chan int, 1)
+		done      = make(chan error, 1)
 	)
 
+	// Confirms and returns are drained while publishing so the library never blocks on them
+	go func() {
+		total := -1
+		var timeout <-chan time.Time
+		for total < 0 || confirmed < total {
+			select {
+			case n := <-published:
+				total = n
+				timer := time.NewTimer(p.opts.ConfirmTimeout)
+				defer timer.Stop()
+				timeout = timer.C
+			case r := <-cc.returns:
+				returned[r.MessageId] = true
+			case confirm, ok := <-cc.confirms:
+				if !ok {
+					done <- ErrNotConnected
+					return
+				}
+				acks[confirmed] = confirm.Ack
+				confirmed++
+			case <-timeout:
+				done <- ErrConfirmTimeout
+				discard(cc)
+				return
+			case <-ctx.Done():
+				done <- ctx.Err()
+				discard(cc)
+				return
+			}
+		}
+
+		// A return comes before the ack of its message but may still sit in the buffer
+		for {
+			select {
+			case r := <-cc.returns:
+				returned[r.MessageId] = true
+			default:
+				done <- nil
+				return
+			}
+		}
+	}()
+
+	n := 0
+	for ; n < len(msgs); n++ {
+		if err := ctx.Err(); err != nil {
+			fail(n, err)
+			break
+		}
+		err := cc.ch.Publish(
+			"",    // exchange
+			queue, // routing key
+			true,  // mandatory
+			false, // immediate
+			msgs[n],
+		)
+		if err != nil {
+			fail(n, err)
+			break
+		}
+	}
+	published <- n
+	waitErr := <-done
+
+	for i := 0; i < n; i++ {
+		switch {
+		case i >= confirmed:
+			errs[i] = waitErr
+		case !acks[i]:
+			errs[i] = ErrPublishNacked
+		case returned[msgs[i].MessageId]:
+			errs[i] = broker.ErrUnroutable
+		}
+	}
+
+	// Unconfirmed messages would be mistaken for the next publish's, drop the channel then
+	p.release(cc, waitErr == nil && n == len(msgs))
+	return errs
+}
+
+// discard reads the confirms and returns of an abandoned batch until the channel is
+// closed. The connection's reader delivers them and would otherwise block on a full
+// buffer, taking every channel of the connection, including the close, down with it.
+func discard(cc *confirmChannel) {
+	confirms, returns := cc.confirms, cc.returns
+	for confirms != nil || returns != nil {
+		select {
+		case _, ok := <-confirms:
+			if !ok {
+				confirms = nil
+			}
+		case _, ok := <-returns:
+			if !ok {
+				returns = nil
+			}
+		}
+	}
+}
+
+// acquire takes a pool slot and returns an idle channel or opens a new one. During an
+// outage it waits up to the configured wait for the connection to come back.
+func (p *Publisher) acquire(ctx context.Context) (*confirmChannel, error) {
+	return p.acquireBuffered(ctx, 1)
+}
+
+// acquireBuffered is acquire for a channel that buffers the confirms and returns of
+// size publishes. Idle channels may buffer fewer, so larger ones are always opened anew.
+func (p *Publisher) acquireBuffered(ctx context.Context, size int) (*confirmChannel, error) {
+	waitCtx, cancel := context.WithTimeout(ctx, p.opts.Wait)
+	defer cancel()
+
+	select {
+	case p.slots <- struct{}{}:
+	case <-waitCtx.Done():
+		return nil, fmt.Errorf("no publisher channel available: %w", waitCtx.Err())
+	}
+
+	if size <= 1 {
+		if cc := p.takeIdle(); cc != nil {
+			return cc, nil
+		}
+	}
+
+	if err := p.rmq.WaitReady(waitCtx); err != nil {
+		<-p.slots
+		return nil, err
+	}
+
+	cc, err := p.open(size)
 	if err != nil {
-		return fmt.Errorf("failed to publish message: %w", err)
+		<-p.slots
+		return nil, err
 	}
+	return cc, nil
+}
 
-	return nil
+// takeIdle returns an idle channel that is still open, or nil.
+func (p *Publisher) takeIdle() *confirmChannel {
+	for {
+		select {
+		case cc := <-p.idle:
+			if !cc.isClosed() {
+				return cc
+			}
+		default:
+			return nil
+		}
+	}
+}
+
+// release returns a healthy channel to the pool, closes a broken one, and frees the slot.
+func (p *Publisher) release(cc *confirmChannel, healthy bool) {
+	defer func() { <-p.slots }()
+
+	if healthy && !cc.isClosed() {
+		select {
+		case p.idle <- cc:
+			return
+		default:
+		}
+	}
+	_ = cc.ch.Close()
+}
+
+// open opens a channel in confirm mode buffering the confirms and returns of size
+// publishes.
+func (p *Publisher) open(size int) (*confirmChannel, error) {
+	ch, err := p.rmq.Channel()
+	if err != nil {
+		return nil, fmt.Errorf("failed to open channel: %w", err)
+	}
+
+	if err := ch.Confirm(false); err != nil {
+		_ = ch.Close()
+		return nil, fmt.Errorf("failed to enable publisher confirms: %w", err)
+	}
+
+	// Buffered so the library never blocks on a publish we are not waiting for
+	return &confirmChannel{
+		ch:       ch,
+		confirms: ch.NotifyPublish(make(chan amqp.Confirmation, size)),
+		returns:  ch.NotifyReturn(make(chan amqp.Return, size)),
+		closed:   ch.NotifyClose(make(chan *amqp.Error, 1)),
+	}, nil
 }
@@ -0,0 +1,270 @@
+//go:build integration
+
+package rabbitmq_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fekalegi/multi-tenant-system/internal/broker"
+	"github.com/fekalegi/multi-tenant-system/internal/domain"
+	"github.com/fekalegi/multi-tenant-system/internal/rabbitmq"
+	"github.com/fekalegi/multi-tenant-system/pkg/logger"
+	"github.com/google/uuid"
+	"github.com/ory/dockertest/v3"
+	"github.com/ory/dockertest/v3/docker"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+)
+
+type RabbitMQTestSuite struct {
+	suite.Suite
+	conn   *rabbitmq.Connection
+	retry  broker.RetryPolicy
+	broker *rabbitmq.Broker
+}
+
+func (s *RabbitMQTestSuite) SetupSuite() {
+	pool, err := dockertest.NewPool("")
+	require.NoError(s.T(), err, "Could not construct docker pool")
+
+	rmqResource, err := pool.RunWithOptions(&dockertest.RunOptions{
+		Repository: "rabbitmq", Tag: "3-management",
+	}, func(config *docker.HostConfig) {
+		config.AutoRemove = true
+		config.RestartPolicy = docker.RestartPolicy{Name: "no"}
+	})
+	require.NoError(s.T(), err, "Could not start RabbitMQ resource")
+	s.T().Cleanup(func() {
+		require.NoError(s.T(), pool.Purge(rmqResource))
+	})
+
+	rabbitmqURL := fmt.Sprintf("amqp://guest:guest@%s/", rmqResource.GetHostPort("5672/tcp"))
+	s.conn, err = rabbitmq.NewConnection(rabbitmqURL, rabbitmq.ReconnectPolicy{
+		InitialDelay: time.Second,
+		MaxDelay:     5 * time.Second,
+	}, logger.New())
+	require.NoError(s.T(), err, "Could not connect to RabbitMQ")
+
+	publisher := rabbitmq.NewPublisher(s.conn, rabbitmq.PublisherOptions{
+		PoolSize:       2,
+		Wait:           time.Second,
+		ConfirmTimeout: 5 * time.Second,
+	}, logger.New())
+
+	s.retry = broker.RetryPolicy{
+		MaxAttempts:    3,
+		InitialBackoff: 100 * time.Millisecond,
+		MaxBackoff:     time.Second,
+	}
+	s.broker = rabbitmq.NewBroker(s.conn, publisher, s.retry, logger.New())
+}
+
+func (s *RabbitMQTestSuite) TearDownSuite() {
+	s.broker.Close()
+}
+
+// declareTenant declares a tenant topology for one test.
+func (s *RabbitMQTestSuite) declareTenant() string {
+	tenantID := uuid.NewString()
+	require.NoError(s.T(), s.broker.DeclareTenantQueue(context.Background(), tenantID))
+	s.T().Cleanup(func() {
+		require.NoError(s.T(), s.broker.DeleteTenantQueue(context.Background(), tenantID))
+	})
+	return tenantID
+}
+
+// consume subscribes to a tenant queue, closing the subscription when the test ends.
+func (s *RabbitMQTestSuite) consume(tenantID string, prefetch int) <-chan *broker.Delivery {
+	sub, err := s.broker.Subscribe(tenantID)
+	require.NoError(s.T(), err)
+	deliveries, err := sub.Consume(prefetch)
+	require.NoError(s.T(), err)
+	s.T().Cleanup(func() { _ = sub.Close() })
+	return deliveries
+}
+
+func (s *RabbitMQTestSuite) receive(deliveries <-chan *broker.Delivery, within time.Duration) *broker.Delivery {
+	select {
+	case d, ok := <-deliveries:
+		require.True(s.T(), ok, "Delivery channel should stay open")
+		return d
+	case <-time.After(within):
+		s.T().Fatal("No message delivered")
+		return nil
+	}
+}
+
+// queueExists declares a queue passively on a channel of its own, which a missing
+// queue closes.
+func (s *RabbitMQTestSuite) queueExists(name string) bool {
+	ch, err := s.conn.Channel()
+	require.NoError(s.T(), err)
+	defer ch.Close()
+
+	_, err = ch.QueueDeclarePassive(name, true, false, false, false, nil)
+	return err == nil
+}
+
+func (s *RabbitMQTestSuite) TestPublishIsConfirmedOrReturned() {
+	ctx := context.Background()
+	tenantID := s.declareTenant()
+
+	require.NoError(s.T(), s.broker.Publish(ctx, tenantID, broker.Message{ID: "confirmed", Body: []byte(`{}`)}))
+	stats, err := s.broker.Inspect(ctx, tenantID)
+	require.NoError(s.T(), err)
+	require.Equal(s.T(), 1, stats.Messages, "A confirmed message is in the queue")
+
+	// Mandatory publishes no queue takes are returned before they are confirmed
+	err = s.broker.Publish(ctx, uuid.NewString(), broker.Message{ID: "returned", Body: []byte(`{}`)})
+	require.ErrorIs(s.T(), err, broker.ErrUnroutable)
+	err = s.broker.Forward(ctx, "", "no_such_queue", "forwarded", []byte(`{}`), 0)
+	require.ErrorIs(s.T(), err, broker.ErrUnroutable)
+
+	// The channel of a return stays usable
+	require.NoError(s.T(), s.broker.Publish(ctx, tenantID, broker.Message{ID: "after", Body: []byte(`{}`)}))
+	stats, err = s.broker.Inspect(ctx, tenantID)
+	require.NoError(s.T(), err)
+	require.Equal(s.T(), 2, stats.Messages)
+}
+
+func (s *RabbitMQTestSuite) TestPublishBatchReportsEveryMessage() {
+	ctx := context.Background()
+	tenantID := s.declareTenant()
+
+	msgs := make([]broker.Message, 20)
+	for i := range msgs {
+		msgs[i] = broker.Message{ID: fmt.Sprintf("m%d", i), Body: []byte(`{}`)}
+	}
+	require.NoError(s.T(), errors.Join(s.broker.PublishBatch(ctx, tenantID, msgs)...))
+	stats, err := s.broker.Inspect(ctx, tenantID)
+	require.NoError(s.T(), err)
+	require.Equal(s.T(), len(msgs), stats.Messages)
+
+	for _, err := range s.broker.PublishBatch(ctx, uuid.NewString(), msgs[:3]) {
+		require.ErrorIs(s.T(), err, broker.ErrUnroutable)
+	}
+}
+
+func (s *RabbitMQTestSuite) TestPublishBatchCanceledMidwayLeavesTheConnectionUsable() {
+	tenantID := s.declareTenant()
+
+	msgs := make([]broker.Message, 2000)
+	body := []byte(`{"padding": "` + strings.Repeat("x", 16<<10) + `"}`)
+	for i := range msgs {
+		msgs[i] = broker.Message{ID: fmt.Sprintf("m%d", i), Body: body}
+	}
+
+	// More batches than pooled channels, so a channel or slot left behind would block
+	for range 3 {
+		ctx, cancel := context.WithCancel(context.Background())
+		time.AfterFunc(5*time.Millisecond, cancel)
+
+		result := make(chan []error, 1)
+		go func() { result <- s.broker.PublishBatch(ctx, tenantID, msgs) }()
+		select {
+		case errs := <-result:
+			require.ErrorIs(s.T(), errs[len(errs)-1], context.Canceled, "The batch should be canceled before its end")
+		case <-time.After(30 * time.Second):
+			s.T().Fatal("A canceled batch should return")
+		}
+		cancel()
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- s.broker.Publish(context.Background(), tenantID, broker.Message{ID: "after", Body: []byte(`{}`)})
+	}()
+	select {
+	case err := <-done:
+		require.NoError(s.T(), err, "The connection should still publish")
+	case <-time.After(10 * time.Second):
+		s.T().Fatal("Publishing after a canceled batch should not hang")
+	}
+}
+
+func (s *RabbitMQTestSuite) TestRetryIsRedeliveredAfterItsDelay() {
+	ctx := context.Background()
+	tenantID := s.declareTenant()
+	deliveries := s.consume(tenantID, 1)
+
+	require.NoError(s.T(), s.broker.Publish(ctx, tenantID, broker.Message{ID: "job", Body: []byte(`{"n": 1}`), Priority: 3}))
+	d := s.receive(deliveries, 5*time.Second)
+	require.Equal(s.T(), 0, d.Attempts)
+
+	require.Error(s.T(), d.Retry(42*time.Millisecond), "Only the delays of the policy have retry queues")
+	delay := s.retry.Backoff(1)
+	require.NoError(s.T(), d.Retry(delay))
+	require.True(s.T(), s.queueExists(rabbitmq.RetryQueue(tenantID, delay)), "The retry queue is declared on the first retry")
+
+	started := time.Now()
+	d = s.receive(deliveries, 5*time.Second)
+	require.GreaterOrEqual(s.T(), time.Since(started), delay/2, "The retry should wait for its delay")
+	require.Equal(s.T(), "job", d.ID)
+	require.Equal(s.T(), 1, d.Attempts)
+	require.EqualValues(s.T(), 3, d.Priority, "A retry keeps its priority")
+	require.NoError(s.T(), d.Ack())
+}
+
+func (s *RabbitMQTestSuite) TestDeadLettersAreListedReplayedAndDeleted() {
+	ctx := context.Background()
+	tenantID := s.declareTenant()
+	deliveries := s.consume(tenantID, 1)
+
+	for _, id := range []string{"first", "second"} {
+		require.NoError(s.T(), s.broker.Publish(ctx, tenantID, broker.Message{ID: id, Body: []byte(`{"id": "` + id + `"}`)}))
+		d := s.receive(deliveries, 5*time.Second)
+		require.NoError(s.T(), d.DeadLetter(errors.New("boom "+id)))
+	}
+
+	dead := s.broker.DeadLetters()
+	letters, err := dead.List(tenantID, 10)
+	require.NoError(s.T(), err)
+	require.Len(s.T(), letters, 2)
+	require.Equal(s.T(), "first", letters[0].ID)
+	require.Equal(s.T(), 1, letters[0].Attempts)
+	require.Equal(s.T(), "boom first", letters[0].Error)
+
+	letter, err := dead.Get(tenantID, "second")
+	require.NoError(s.T(), err)
+	require.JSONEq(s.T(), `{"id": "second"}`, string(letter.Payload))
+
+	_, err = dead.Get(tenantID, "missing")
+	require.ErrorIs(s.T(), err, domain.ErrDeadLetterNotFound)
+
+	// A replay is delivered again with a fresh attempt count
+	require.NoError(s.T(), dead.Replay(tenantID, "first"))
+	d := s.receive(deliveries, 5*time.Second)
+	require.Equal(s.T(), "first", d.ID)
+	require.Equal(s.T(), 0, d.Attempts)
+	require.NoError(s.T(), d.Ack())
+
+	require.NoError(s.T(), dead.Delete(tenantID, "second"))
+	letters, err = dead.List(tenantID, 10)
+	require.NoError(s.T(), err)
+	require.Empty(s.T(), letters)
+}
+
+func (s *RabbitMQTestSuite) TestDeleteTenantQueueRemovesItsRetryQueues() {
+	ctx := context.Background()
+	tenantID := uuid.NewString()
+	require.NoError(s.T(), s.broker.DeclareTenantQueue(ctx, tenantID))
+	deliveries := s.consume(tenantID, 1)
+
+	require.NoError(s.T(), s.broker.Publish(ctx, tenantID, broker.Message{ID: "job", Body: []byte(`{}`)}))
+	delay := s.retry.Backoff(2)
+	require.NoError(s.T(), s.receive(deliveries, 5*time.Second).Retry(delay))
+
+	require.NoError(s.T(), s.broker.DeleteTenantQueue(ctx, tenantID))
+	for _, queue := range []string{rabbitmq.TenantQueue(tenantID), rabbitmq.DeadLetterQueue(tenantID), rabbitmq.RetryQueue(tenantID, delay)} {
+		require.False(s.T(), s.queueExists(queue), "%s should be deleted", queue)
+	}
+}
+
+func TestRabbitMQTestSuite(t *testing.T) {
+	suite.Run(t, new(RabbitMQTestSuite))
+}
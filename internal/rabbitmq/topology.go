@@ -0,0 +1,175 @@
+package rabbitmq
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/fekalegi/multi-tenant-system/internal/broker"
+	"github.com/google/uuid"
+	"github.com/streadway/amqp"
+)
+
+const (
+	// HeaderAttempt counts the failed processing attempts of a message.
+	HeaderAttempt = "x-attempt"
+	// HeaderError holds the last processing error of a dead-lettered message.
+	HeaderError = "x-error"
+	// HeaderFailedAt holds the time a message was dead-lettered.
+	HeaderFailedAt = "x-failed-at"
+)
+
+func TenantQueue(tenantID string) string {
+	return broker.TenantQueue(tenantID)
+}
+
+func DeadLetterExchange(tenantID string) string {
+	return fmt.Sprintf("tenant_%s_dlx", tenantID)
+}
+
+func DeadLetterQueue(tenantID string) string {
+	return fmt.Sprintf("tenant_%s_dlq", tenantID)
+}
+
+// RetryQueue names the delay queue holding messages waiting for their next attempt.
+// The delay is part of the name so a changed policy never conflicts with existing queues.
+func RetryQueue(tenantID string, delay time.Duration) string {
+	return fmt.Sprintf("tenant_%s_retry_%dms", tenantID, delay.Milliseconds())
+}
+
+// retryQueueLinger is how long a retry queue outlives the last message parked in it.
+const retryQueueLinger = time.Hour
+
+// DeclareTenantTopology declares the tenant queue and its dead-letter exchange and
+// queue. With a maxPriority the tenant queue is a priority queue; a queue that already
+// exists without one fails with a precondition error, RabbitMQ cannot change the
+// arguments of a queue. Retry queues are declared by DeclareRetryQueue when needed.
+func DeclareTenantTopology(ch *amqp.Channel, tenantID string, maxPriority uint8) error {
+	dlx := DeadLetterExchange(tenantID)
+	queue := TenantQueue(tenantID)
+
+	if err := ch.ExchangeDeclare(dlx, amqp.ExchangeDirect, true, false, false, false, nil); err != nil {
+		return fmt.Errorf("dead-letter exchange declare failed: %w", err)
+	}
+
+	if _, err := ch.QueueDeclare(DeadLetterQueue(tenantID), true, false, false, false, nil); err != nil {
+		return fmt.Errorf("dead-letter queue declare failed: %w", err)
+	}
+
+	if err := ch.QueueBind(DeadLetterQueue(tenantID), queue, dlx, false, nil); err != nil {
+		return fmt.Errorf("dead-letter queue bind failed: %w", err)
+	}
+
+	// Rejected messages are routed to the dead-letter exchange with the queue name as key
+	args := amqp.Table{"x-dead-letter-exchange": dlx}
+	if maxPriority > 0 {
+		args["x-max-priority"] = int32(maxPriority)
+	}
+	_, err := ch.QueueDeclare(
+		queue,
+		true,  // durable
+		false, // autoDelete
+		false, // exclusive
+		false,
+		args,
+	)
+	if err != nil {
+		return fmt.Errorf("queue declare failed: %w", err)
+	}
+
+	return nil
+}
+
+// DeclareRetryQueue declares the retry queue of a delay. Expired retries flow back into
+// the tenant queue through the default exchange, keeping their priority.
+//
+// The queue deletes itself once it was not declared for the delay plus retryQueueLinger,
+// so the queues of delays a changed retry policy no longer uses do not outlive their
+// last message. Declaring renews that lease, so the queue must be declared before
+// every publish to it. A retry queue declared by an earlier version without an expiry
+// fails with a precondition error; it is kept and used as it is.
+func DeclareRetryQueue(ch *amqp.Channel, tenantID string, delay time.Duration) error {
+	_, err := ch.QueueDeclare(RetryQueue(tenantID, delay), true, false, false, false, amqp.Table{
+		"x-message-ttl":             delay.Milliseconds(),
+		"x-expires":                 (delay + retryQueueLinger).Milliseconds(),
+		"x-dead-letter-exchange":    "",
+		"x-dead-letter-routing-key": TenantQueue(tenantID),
+	})
+	if err != nil {
+		return fmt.Errorf("retry queue declare failed: %w", err)
+	}
+	return nil
+}
+
+// DeleteTenantTopology removes the queues and exchange of a tenant together with the
+// retry queues of the policy's delays. Retry queues of delays the policy no longer
+// uses expire on their own.
+func DeleteTenantTopology(ch *amqp.Channel, tenantID string, policy broker.RetryPolicy) error {
+	queues := []string{TenantQueue(tenantID), DeadLetterQueue(tenantID)}
+	for _, delay := range policy.Delays() {
+		queues = append(queues, RetryQueue(tenantID, delay))
+	}
+
+	for _, q := range queues {
+		if _, err := ch.QueueDelete(q, false, false, false); err != nil {
+			return fmt.Errorf("queue delete failed: %w", err)
+		}
+	}
+
+	if err := ch.ExchangeDelete(DeadLetterExchange(tenantID), false, false); err != nil {
+		return fmt.Errorf("dead-letter exchange delete failed: %w", err)
+	}
+	return nil
+}
+
+// Attempts returns how many times processing of the delivery already failed.
+func Attempts(d amqp.Delivery) int {
+	switch v := d.Headers[HeaderAttempt].(type) {
+	case int32:
+		return int(v)
+	case int64:
+		return int(v)
+	case int:
+		return v
+	}
+	return 0
+}
+
+// retryPublishing copies a failed delivery for its retry queue. attempt is the number
+// of failed attempts so far.
+func retryPublishing(d amqp.Delivery, attempt int) amqp.Publishing {
+	pub := republish(d)
+	pub.Headers[HeaderAttempt] = int32(attempt)
+	return pub
+}
+
+// deadLetterPublishing copies a failed delivery for the dead-letter exchange.
+func deadLetterPublishing(d amqp.Delivery, attempt int, cause error) amqp.Publishing {
+	pub := republish(d)
+	pub.Headers[HeaderAttempt] = int32(attempt)
+	pub.Headers[HeaderError] = cause.Error()
+	pub.Headers[HeaderFailedAt] = time.Now().UTC().Format(time.RFC3339Nano)
+	return pub
+}
+
+// republish copies a delivery into a persistent publishing, keeping its headers, ID and priority.
+func republish(d amqp.Delivery) amqp.Publishing {
+	headers := amqp.Table{}
+	for k, v := range d.Headers {
+		headers[k] = v
+	}
+
+	messageID := d.MessageId
+	if messageID == "" {
+		messageID = uuid.New().String()
+	}
+
+	return amqp.Publishing{
+		Headers:      headers,
+		ContentType:  d.ContentType,
+		DeliveryMode: amqp.Persistent,
+		Priority:     d.Priority,
+		MessageId:    messageID,
+		Timestamp:    d.Timestamp,
+		Body:         d.Body,
+	}
+}
@@ -0,0 +1,151 @@
+package memory
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math/big"
+
+	"github.com/fekalegi/multi-tenant-system/internal/domain"
+)
+
+// messageMatcher turns a filter, apart from the tenant, into a predicate over stored
+// messages. Payload filters follow JSONB containment, as the Postgres repository uses.
+func messageMatcher(filter domain.MessageFilter) (func(*domain.Message) bool, error) {
+	var docs [][]any
+	if len(filter.PayloadContains) > 0 {
+		doc, err := decodeJSON(filter.PayloadContains)
+		if err != nil {
+			return nil, fmt.Errorf("invalid payload filter: %w", err)
+		}
+		docs = append(docs, []any{doc})
+	}
+	for _, match := range filter.PayloadMatches {
+		docs = append(docs, payloadMatchDocuments(match))
+	}
+
+	return func(m *domain.Message) bool {
+		if filter.Status != "" && m.Status != filter.Status {
+			return false
+		}
+		if filter.Priority != nil && m.Priority != *filter.Priority {
+			return false
+		}
+		if !filter.CreatedFrom.IsZero() && m.CreatedAt.Before(filter.CreatedFrom) {
+			return false
+		}
+		if !filter.CreatedTo.IsZero() && !m.CreatedAt.Before(filter.CreatedTo) {
+			return false
+		}
+		if len(docs) == 0 {
+			return true
+		}
+
+		payload, err := decodeJSON(m.Payload)
+		if err != nil {
+			return false
+		}
+		// Every filter has to match, through any of its documents
+		for _, alternatives := range docs {
+			matched := false
+			for _, doc := range alternatives {
+				if contains(payload, doc) {
+					matched = true
+					break
+				}
+			}
+			if !matched {
+				return false
+			}
+		}
+		return true
+	}, nil
+}
+
+// payloadMatchDocuments builds the documents a payload has to contain for the match:
+// one with the value as a string and, when it is valid JSON, one with the value as
+// parsed.
+func payloadMatchDocuments(match domain.PayloadMatch) []any {
+	values := []any{match.Value}
+	if parsed, err := decodeJSON([]byte(match.Value)); err == nil {
+		if _, isString := parsed.(string); !isString {
+			values = append(values, parsed)
+		}
+	}
+
+	docs := make([]any, 0, len(values))
+	for _, v := range values {
+		for i := len(match.Path) - 1; i >= 0; i-- {
+			v = map[string]any{match.Path[i]: v}
+		}
+		docs = append(docs, v)
+	}
+	return docs
+}
+
+// decodeJSON decodes a document keeping numbers as written, float64 would round large IDs.
+func decodeJSON(data []byte) (any, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+
+	var v any
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// contains reports whether doc contains sub the way the JSONB @> operator does:
+// objects contain the keys of sub with contained values, arrays contain every
+// element of sub somewhere, and scalars are equal.
+func contains(doc, sub any) bool {
+	switch sub := sub.(type) {
+	case map[string]any:
+		obj, ok := doc.(map[string]any)
+		if !ok {
+			return false
+		}
+		for k, v := range sub {
+			if dv, ok := obj[k]; !ok || !contains(dv, v) {
+				return false
+			}
+		}
+		return true
+
+	case []any:
+		arr, ok := doc.([]any)
+		if !ok {
+			return false
+		}
+		for _, v := range sub {
+			found := false
+			for _, dv := range arr {
+				if contains(dv, v) {
+					found = true
+					break
+				}
+			}
+			if !found {
+				return false
+			}
+		}
+		return true
+
+	case json.Number:
+		n, ok := doc.(json.Number)
+		return ok && numbersEqual(n, sub)
+
+	default:
+		return doc == sub
+	}
+}
+
+// numbersEqual compares JSON numbers by value, so 1.0 equals 1 as in JSONB.
+func numbersEqual(a, b json.Number) bool {
+	x, okX := new(big.Rat).SetString(a.String())
+	y, okY := new(big.Rat).SetString(b.String())
+	if !okX || !okY {
+		return a == b
+	}
+	return x.Cmp(y) == 0
+}
@@ -0,0 +1,362 @@
+package memory
+
+import (
+	"cmp"
+	"context"
+	"slices"
+	"time"
+
+	"github.com/fekalegi/multi-tenant-system/internal/domain"
+	message2 "github.com/fekalegi/multi-tenant-system/internal/repository/postgresql"
+	"github.com/google/uuid"
+)
+
+type messageRepository struct {
+	s *Store
+}
+
+func NewMessageRepository(s *Store) message2.MessageRepository {
+	return &messageRepository{s: s}
+}
+
+// InsertMessage stores a queued message together with the outbox entry that gets it
+// published. It returns domain.ErrTenantNotFound for a tenant without a partition.
+func (r *messageRepository) InsertMessage(ctx context.Context, msg *domain.Message) error {
+	return r.InsertMessages(ctx, []*domain.Message{msg})
+}
+
+// InsertMessages stores queued messages and their outbox entries, all of them or none.
+// It returns domain.ErrTenantNotFound for a tenant without a partition.
+func (r *messageRepository) InsertMessages(ctx context.Context, msgs []*domain.Message) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	for _, m := range msgs {
+		if err := r.s.checkStorable(m.TenantID, m.ID); err != nil {
+			return err
+		}
+	}
+
+	for _, m := range msgs {
+		stored := copyMessage(m)
+		stored.Status = domain.MessageStatusQueued
+		stored.Attempts = 0
+		stored.LastError = ""
+		stored.UpdatedAt = m.CreatedAt
+		stored.ProcessedAt = nil
+		stored.ProcessedSeq = 0
+		r.s.partitions[m.TenantID.String()][m.ID] = stored
+		r.s.addOutboxEntry(stored)
+	}
+	return nil
+}
+
+// addOutboxEntry adds the outbox entry that gets a stored message published. Callers
+// must hold s.mu.
+func (s *Store) addOutboxEntry(m *domain.Message) {
+	s.outboxSeq++
+	s.outbox = append(s.outbox, &outboxEntry{
+		OutboxEntry: domain.OutboxEntry{
+			ID:        s.outboxSeq,
+			TenantID:  m.TenantID,
+			MessageID: m.ID,
+			Payload:   slices.Clone(m.Payload),
+			Priority:  m.Priority,
+			CreatedAt: m.CreatedAt,
+		},
+		availableAt: m.CreatedAt,
+	})
+}
+
+// checkStorable tells whether a new message can be stored for a tenant, which takes
+// a partition and a registry entry. Callers must hold s.mu.
+func (s *Store) checkStorable(tenantID, id uuid.UUID) error {
+	partition, ok := s.partitions[tenantID.String()]
+	if !ok {
+		return domain.ErrTenantNotFound
+	}
+	if _, ok := s.tenants[tenantID.String()]; !ok {
+		return domain.ErrTenantNotFound
+	}
+	if _, ok := partition[id]; ok {
+		return errDuplicateMessage
+	}
+	return nil
+}
+
+// BeginProcessing marks a message as processing and counts the attempt. A message that
+// was never stored is inserted. It returns false without touching the message when it
+// was already processed, so a redelivered message is not processed twice.
+func (r *messageRepository) BeginProcessing(ctx context.Context, msg *domain.Message) (bool, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	partition, ok := r.s.partitions[msg.TenantID.String()]
+	if !ok {
+		return false, domain.ErrTenantNotFound
+	}
+
+	now := time.Now()
+	stored, ok := partition[msg.ID]
+	if !ok {
+		stored = copyMessage(msg)
+		stored.Attempts = 0
+		stored.LastError = ""
+		stored.ProcessedAt = nil
+		stored.ProcessedSeq = 0
+		partition[msg.ID] = stored
+	} else if stored.Status == domain.MessageStatusProcessed {
+		return false, nil
+	}
+
+	stored.Status = domain.MessageStatusProcessing
+	stored.Attempts++
+	stored.UpdatedAt = now
+	return true, nil
+}
+
+// UpdateMessageStatus moves a message to the given status and records the last
+// processing error, if any. Processed messages get their processing time stamped and
+// the tenant's next processed sequence number.
+func (r *messageRepository) UpdateMessageStatus(ctx context.Context, tenantID, id uuid.UUID, status, lastError string) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	m, err := r.s.message(tenantID, id)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	m.Status = status
+	m.LastError = lastError
+	m.UpdatedAt = now
+	if status == domain.MessageStatusProcessed {
+		m.ProcessedAt = &now
+		r.s.processedSeq[tenantID.String()]++
+		m.ProcessedSeq = r.s.processedSeq[tenantID.String()]
+	}
+	return nil
+}
+
+// UpdateMessagePayload replaces the payload of a message, e.g. after it was transformed.
+func (r *messageRepository) UpdateMessagePayload(ctx context.Context, tenantID, id uuid.UUID, payload []byte) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	m, err := r.s.message(tenantID, id)
+	if err != nil {
+		return err
+	}
+	m.Payload = slices.Clone(payload)
+	m.UpdatedAt = time.Now()
+	return nil
+}
+
+// message returns a stored message for changing it in place. Callers must hold s.mu.
+func (s *Store) message(tenantID, id uuid.UUID) (*domain.Message, error) {
+	m, ok := s.partitions[tenantID.String()][id]
+	if !ok {
+		return nil, domain.ErrMessageNotFound
+	}
+	return m, nil
+}
+
+// GetMessages returns a page of the messages matching the filter in the requested
+// order, and whether more messages follow in the direction the page was read.
+func (r *messageRepository) GetMessages(ctx context.Context, filter domain.MessageFilter, page domain.MessagePage) ([]*domain.Message, bool, error) {
+	match, err := messageMatcher(filter)
+	if err != nil {
+		return nil, false, err
+	}
+
+	// Reading backwards scans the opposite way and flips the messages afterwards
+	scanDesc := page.Descending != page.Backward
+
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	var matches []*domain.Message
+	for _, m := range r.s.partitions[filter.TenantID.String()] {
+		if !match(m) {
+			continue
+		}
+		if page.From != nil {
+			c := comparePosition(m.CreatedAt, m.ID, *page.From)
+			if (scanDesc && c >= 0) || (!scanDesc && c <= 0) {
+				continue
+			}
+		}
+		matches = append(matches, m)
+	}
+	sortByCreated(matches, scanDesc)
+
+	more := len(matches) > page.Limit
+	if more {
+		matches = matches[:page.Limit]
+	}
+	if page.Backward {
+		slices.Reverse(matches)
+	}
+
+	messages := make([]*domain.Message, len(matches))
+	for i, m := range matches {
+		messages[i] = copyMessage(m)
+	}
+	return messages, more, nil
+}
+
+// GetMessage looks a message up by its tenant and ID.
+func (r *messageRepository) GetMessage(ctx context.Context, tenantID, id uuid.UUID) (*domain.Message, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	m, err := r.s.message(tenantID, id)
+	if err != nil {
+		return nil, err
+	}
+	return copyMessage(m), nil
+}
+
+// DeleteMessage deletes a processed or failed message together with its webhook
+// deliveries. Messages still queued or processing are refused, the consumer would
+// store them again.
+func (r *messageRepository) DeleteMessage(ctx context.Context, tenantID, id uuid.UUID) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	m, err := r.s.message(tenantID, id)
+	if err != nil {
+		return err
+	}
+	if !settled(m) {
+		return domain.ErrMessageInFlight
+	}
+
+	delete(r.s.partitions[tenantID.String()], id)
+	r.s.deleteWebhookDeliveries(tenantID, map[uuid.UUID]bool{id: true})
+	return nil
+}
+
+// GetProcessedAfter returns the processed messages of a tenant numbered after afterSeq,
+// in processed sequence order.
+func (r *messageRepository) GetProcessedAfter(ctx context.Context, tenantID uuid.UUID, afterSeq int64, limit int) ([]*domain.Message, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	var matches []*domain.Message
+	for _, m := range r.s.partitions[tenantID.String()] {
+		if m.Status == domain.MessageStatusProcessed && m.ProcessedSeq > afterSeq {
+			matches = append(matches, m)
+		}
+	}
+	slices.SortFunc(matches, func(a, b *domain.Message) int {
+		return cmp.Compare(a.ProcessedSeq, b.ProcessedSeq)
+	})
+	if len(matches) > limit {
+		matches = matches[:limit]
+	}
+
+	messages := make([]*domain.Message, len(matches))
+	for i, m := range matches {
+		messages[i] = copyMessage(m)
+	}
+	return messages, nil
+}
+
+// LastProcessedSeq returns the sequence number of the tenant's latest processed message,
+// zero when none was processed.
+func (r *messageRepository) LastProcessedSeq(ctx context.Context, tenantID uuid.UUID) (int64, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	if _, ok := r.s.tenants[tenantID.String()]; !ok {
+		return 0, domain.ErrTenantNotFound
+	}
+	return r.s.processedSeq[tenantID.String()], nil
+}
+
+// RetentionCutoff returns the position of the newest message of a tenant beyond the
+// keep newest ones, or nil when the tenant has no more than keep messages.
+func (r *messageRepository) RetentionCutoff(ctx context.Context, tenantID uuid.UUID, keep int) (*domain.MessagePosition, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	messages := r.s.tenantMessages(tenantID)
+	if len(messages) <= keep {
+		return nil, nil
+	}
+	sortByCreated(messages, true)
+	return &domain.MessagePosition{At: messages[keep].CreatedAt, ID: messages[keep].ID}, nil
+}
+
+// PurgeMessages deletes up to limit of the oldest processed or failed messages of a
+// tenant at or before a position, with their webhook deliveries, and returns how many
+// messages it deleted. Messages still queued or processing are kept.
+func (r *messageRepository) PurgeMessages(ctx context.Context, tenantID uuid.UUID, through domain.MessagePosition, limit int) (int64, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	var purgeable []*domain.Message
+	for _, m := range r.s.partitions[tenantID.String()] {
+		if settled(m) && comparePosition(m.CreatedAt, m.ID, through) <= 0 {
+			purgeable = append(purgeable, m)
+		}
+	}
+	sortByCreated(purgeable, false)
+	if len(purgeable) > limit {
+		purgeable = purgeable[:limit]
+	}
+
+	deleted := make(map[uuid.UUID]bool, len(purgeable))
+	for _, m := range purgeable {
+		delete(r.s.partitions[tenantID.String()], m.ID)
+		deleted[m.ID] = true
+	}
+	r.s.deleteWebhookDeliveries(tenantID, deleted)
+	return int64(len(deleted)), nil
+}
+
+// NotifyProcessed announces a processed message to every ListenProcessed call on the
+// same store.
+func (r *messageRepository) NotifyProcessed(ctx context.Context, tenantID, id uuid.UUID) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	for l := range r.s.listeners {
+		l.push(notification{tenantID: tenantID, id: id})
+	}
+	return nil
+}
+
+// ListenProcessed calls fn for every message announced by NotifyProcessed until ctx is done.
+func (r *messageRepository) ListenProcessed(ctx context.Context, fn func(tenantID, id uuid.UUID)) error {
+	return r.s.listen(ctx, fn)
+}
+
+// tenantMessages returns the messages of a tenant in no particular order. Callers
+// must hold s.mu.
+func (s *Store) tenantMessages(tenantID uuid.UUID) []*domain.Message {
+	partition := s.partitions[tenantID.String()]
+	messages := make([]*domain.Message, 0, len(partition))
+	for _, m := range partition {
+		messages = append(messages, m)
+	}
+	return messages
+}
+
+// settled reports whether the consumer is done with a message.
+func settled(m *domain.Message) bool {
+	return m.Status == domain.MessageStatusProcessed || m.Status == domain.MessageStatusFailed
+}
+
+// sortByCreated sorts messages in created_at, id order, newest first when desc is set.
+func sortByCreated(messages []*domain.Message, desc bool) {
+	slices.SortFunc(messages, func(a, b *domain.Message) int {
+		c := comparePosition(a.CreatedAt, a.ID, domain.MessagePosition{At: b.CreatedAt, ID: b.ID})
+		if desc {
+			return -c
+		}
+		return c
+	})
+}
@@ -0,0 +1,117 @@
+package memory_test
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/fekalegi/multi-tenant-system/internal/domain"
+	"github.com/fekalegi/multi-tenant-system/internal/repository/memory"
+	message2 "github.com/fekalegi/multi-tenant-system/internal/repository/postgresql"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+func newTenant(t *testing.T, tenants message2.TenantRepository) uuid.UUID {
+	ctx := context.Background()
+	id := uuid.New()
+	require.NoError(t, tenants.CreatePartitionForTenant(ctx, id.String()))
+	require.NoError(t, tenants.SaveTenant(ctx, &domain.Tenant{ID: id, Name: "memory-test", CreatedAt: time.Now()}))
+	return id
+}
+
+func TestMessageRepository_When_TenantHasNoPartition_Then_InsertFails(t *testing.T) {
+	ctx := context.Background()
+	store := memory.NewStore()
+	tenants := memory.NewTenantRepository(store)
+	messages := memory.NewMessageRepository(store)
+
+	err := messages.InsertMessage(ctx, &domain.Message{ID: uuid.New(), TenantID: uuid.New(), Payload: json.RawMessage(`{}`)})
+	require.ErrorIs(t, err, domain.ErrTenantNotFound)
+
+	tenantID := newTenant(t, tenants)
+	require.Error(t, tenants.CreatePartitionForTenant(ctx, tenantID.String()), "a partition exists only once")
+	require.NoError(t, messages.InsertMessage(ctx, &domain.Message{ID: uuid.New(), TenantID: tenantID, Payload: json.RawMessage(`{}`)}))
+
+	require.NoError(t, tenants.DeletePartitionForTenant(ctx, tenantID.String()))
+	err = messages.InsertMessage(ctx, &domain.Message{ID: uuid.New(), TenantID: tenantID, Payload: json.RawMessage(`{}`)})
+	require.ErrorIs(t, err, domain.ErrTenantNotFound)
+}
+
+func TestMessageRepository_When_Paging_Then_CursorOrderIsKept(t *testing.T) {
+	ctx := context.Background()
+	store := memory.NewStore()
+	messages := memory.NewMessageRepository(store)
+	tenantID := newTenant(t, memory.NewTenantRepository(store))
+
+	// Two messages share each timestamp, so the ID breaks the tie
+	base := time.Now().Truncate(time.Second)
+	var msgs []*domain.Message
+	for i := range 6 {
+		msgs = append(msgs, &domain.Message{
+			ID:        uuid.New(),
+			TenantID:  tenantID,
+			Payload:   json.RawMessage(`{"n": 1}`),
+			CreatedAt: base.Add(time.Duration(i/2) * time.Second),
+		})
+	}
+	require.NoError(t, messages.InsertMessages(ctx, msgs))
+
+	filter := domain.MessageFilter{TenantID: tenantID}
+	var forward []*domain.Message
+	page := domain.MessagePage{Descending: true, Limit: 4}
+	for {
+		got, more, err := messages.GetMessages(ctx, filter, page)
+		require.NoError(t, err)
+		forward = append(forward, got...)
+		if !more {
+			break
+		}
+		last := got[len(got)-1]
+		page.From = &domain.MessagePosition{At: last.CreatedAt, ID: last.ID}
+	}
+	require.Len(t, forward, len(msgs))
+	for i := 1; i < len(forward); i++ {
+		prev, cur := forward[i-1], forward[i]
+		require.True(t, prev.CreatedAt.After(cur.CreatedAt) || (prev.CreatedAt.Equal(cur.CreatedAt) && prev.ID.String() > cur.ID.String()), "messages should be newest first")
+	}
+
+	// Reading backward from the last message returns the page before it in listing order
+	last := forward[len(forward)-1]
+	back, more, err := messages.GetMessages(ctx, filter, domain.MessagePage{
+		Descending: true,
+		From:       &domain.MessagePosition{At: last.CreatedAt, ID: last.ID},
+		Backward:   true,
+		Limit:      2,
+	})
+	require.NoError(t, err)
+	require.True(t, more)
+	require.Equal(t, []uuid.UUID{forward[3].ID, forward[4].ID}, []uuid.UUID{back[0].ID, back[1].ID})
+}
+
+func TestMessageRepository_When_RelayStalls_Then_EntriesAreClaimedAgainAfterTheLease(t *testing.T) {
+	ctx := context.Background()
+	store := memory.NewStore()
+	messages := memory.NewMessageRepository(store)
+	tenantID := newTenant(t, memory.NewTenantRepository(store))
+	require.NoError(t, messages.InsertMessage(ctx, &domain.Message{ID: uuid.New(), TenantID: tenantID, Payload: json.RawMessage(`{}`)}))
+
+	relay := func(lease time.Duration, publish func([]*domain.OutboxEntry) []error) int {
+		n, err := messages.RelayOutbox(ctx, 10, lease, time.Minute, publish)
+		require.NoError(t, err)
+		return n
+	}
+	sent := func(entries []*domain.OutboxEntry) []error { return make([]error, len(entries)) }
+
+	stalled := relay(20*time.Millisecond, func(entries []*domain.OutboxEntry) []error {
+		// Leased to this relay, so another one skips it
+		require.Zero(t, relay(time.Minute, sent))
+
+		time.Sleep(30 * time.Millisecond)
+		require.Equal(t, 1, relay(time.Minute, sent), "the entry should be claimed again once the lease ran out")
+		return sent(entries)
+	})
+	require.Equal(t, 1, stalled)
+	require.Zero(t, relay(time.Minute, sent), "a sent entry is not relayed again")
+}
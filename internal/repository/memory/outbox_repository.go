@@ -0,0 +1,74 @@
+package memory
+
+import (
+	"context"
+	"slices"
+	"time"
+
+	"github.com/fekalegi/multi-tenant-system/internal/domain"
+)
+
+// RelayOutbox claims up to limit pending outbox entries, oldest first, and hands them to
+// publish. Claiming leases the entries for lease: concurrent calls skip leased entries,
+// and entries left unsettled are claimed again once the lease ran out. publish returns
+// one error per entry: published entries are marked sent, the others are retried after
+// retryDelay. It returns the number of entries claimed.
+func (r *messageRepository) RelayOutbox(ctx context.Context, limit int, lease, retryDelay time.Duration, publish func([]*domain.OutboxEntry) []error) (int, error) {
+	r.s.mu.Lock()
+	now := time.Now()
+	var claimed []*outboxEntry
+	for _, e := range r.s.outbox {
+		if len(claimed) == limit {
+			break
+		}
+		if e.sentAt.IsZero() && !e.availableAt.After(now) {
+			e.availableAt = now.Add(lease)
+			claimed = append(claimed, e)
+		}
+	}
+	r.s.mu.Unlock()
+
+	if len(claimed) == 0 {
+		return 0, nil
+	}
+
+	entries := make([]*domain.OutboxEntry, len(claimed))
+	for i, e := range claimed {
+		c := e.OutboxEntry
+		c.Payload = slices.Clone(e.Payload)
+		entries[i] = &c
+	}
+	errs := publish(entries)
+
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	now = time.Now()
+	for i, e := range claimed {
+		e.Attempts++
+		if errs[i] != nil {
+			if !e.sentAt.IsZero() {
+				continue
+			}
+			e.lastError = errs[i].Error()
+			e.availableAt = now.Add(retryDelay)
+			continue
+		}
+		e.lastError = ""
+		e.sentAt = now
+	}
+	return len(claimed), nil
+}
+
+// PurgeOutbox removes the outbox entries sent before sentBefore and returns how many
+// were removed.
+func (r *messageRepository) PurgeOutbox(ctx context.Context, sentBefore time.Time) (int64, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	before := len(r.s.outbox)
+	r.s.outbox = slices.DeleteFunc(r.s.outbox, func(e *outboxEntry) bool {
+		return !e.sentAt.IsZero() && e.sentAt.Before(sentBefore)
+	})
+	return int64(before - len(r.s.outbox)), nil
+}
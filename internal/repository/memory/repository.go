@@ -0,0 +1,178 @@
+package memory
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/fekalegi/multi-tenant-system/internal/domain"
+	message2 "github.com/fekalegi/multi-tenant-system/internal/repository/postgresql"
+	"github.com/google/uuid"
+)
+
+type tenantRepository struct {
+	s *Store
+}
+
+func NewTenantRepository(s *Store) message2.TenantRepository {
+	return &tenantRepository{s: s}
+}
+
+func (r *tenantRepository) CreatePartitionForTenant(ctx context.Context, tenantID string) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	if _, ok := r.s.partitions[tenantID]; ok {
+		return fmt.Errorf("could not create message partition for tenant %s: partition already exists", tenantID)
+	}
+	r.s.partitions[tenantID] = make(map[uuid.UUID]*domain.Message)
+	return nil
+}
+
+func (r *tenantRepository) DeletePartitionForTenant(ctx context.Context, tenantID string) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	delete(r.s.partitions, tenantID)
+	return nil
+}
+
+func (r *tenantRepository) SaveTenant(ctx context.Context, tenant *domain.Tenant) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	id := tenant.ID.String()
+	if _, ok := r.s.tenants[id]; ok {
+		return fmt.Errorf("could not save tenant %s: tenant already exists", tenant.ID)
+	}
+
+	t := copyTenant(tenant)
+	t.Processors = processorsOrDefault(t.Processors)
+	t.UpdatedAt = t.CreatedAt
+	r.s.tenants[id] = t
+	return nil
+}
+
+func (r *tenantRepository) UpdateTenantConcurrency(ctx context.Context, tenantID string, workers, prefetch int) error {
+	return r.updateTenant(tenantID, func(t *domain.Tenant) {
+		t.Workers = workers
+		t.Prefetch = prefetch
+	})
+}
+
+func (r *tenantRepository) UpdateTenantStatus(ctx context.Context, tenantID string, status string) error {
+	return r.updateTenant(tenantID, func(t *domain.Tenant) {
+		t.Status = status
+	})
+}
+
+func (r *tenantRepository) UpdateTenantRetention(ctx context.Context, tenantID string, policy domain.RetentionPolicy) error {
+	// Stored in whole seconds, as in Postgres
+	policy.MaxAge = policy.MaxAge.Truncate(time.Second)
+	return r.updateTenant(tenantID, func(t *domain.Tenant) {
+		t.Retention = policy
+	})
+}
+
+func (r *tenantRepository) UpdateTenantProcessors(ctx context.Context, tenantID string, processors []domain.ProcessorConfig) error {
+	processors = processorsOrDefault(processors)
+	return r.updateTenant(tenantID, func(t *domain.Tenant) {
+		t.Processors = processors
+	})
+}
+
+func (r *tenantRepository) updateTenant(tenantID string, update func(t *domain.Tenant)) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	t, ok := r.s.tenants[tenantID]
+	if !ok {
+		return domain.ErrTenantNotFound
+	}
+	update(t)
+	t.UpdatedAt = time.Now()
+	return nil
+}
+
+// DeleteTenant removes a tenant together with what references it, as the foreign keys
+// of the Postgres schema cascade. Its messages stay until the partition is dropped.
+func (r *tenantRepository) DeleteTenant(ctx context.Context, tenantID string) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	t, ok := r.s.tenants[tenantID]
+	if !ok {
+		return nil
+	}
+	delete(r.s.tenants, tenantID)
+	delete(r.s.processedSeq, tenantID)
+	delete(r.s.scheduled, tenantID)
+	delete(r.s.schemas, tenantID)
+
+	r.s.webhooks = slices.DeleteFunc(r.s.webhooks, func(d *domain.WebhookDelivery) bool {
+		return d.TenantID == t.ID
+	})
+	r.s.outbox = slices.DeleteFunc(r.s.outbox, func(e *outboxEntry) bool {
+		return e.TenantID == t.ID
+	})
+	return nil
+}
+
+func (r *tenantRepository) GetTenant(ctx context.Context, tenantID string) (*domain.Tenant, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	t, ok := r.s.tenants[tenantID]
+	if !ok {
+		return nil, domain.ErrTenantNotFound
+	}
+	return copyTenant(t), nil
+}
+
+// ListTenants returns the tenants matching the filter together with the total number
+// of matches, oldest first. A zero Limit returns every match.
+func (r *tenantRepository) ListTenants(ctx context.Context, filter domain.TenantFilter) ([]*domain.Tenant, int, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	var matches []*domain.Tenant
+	for id, t := range r.s.tenants {
+		if filter.ID != "" && id != filter.ID {
+			continue
+		}
+		if filter.Name != "" && !strings.Contains(strings.ToLower(t.Name), strings.ToLower(filter.Name)) {
+			continue
+		}
+		matches = append(matches, t)
+	}
+	slices.SortFunc(matches, func(a, b *domain.Tenant) int {
+		if c := a.CreatedAt.Compare(b.CreatedAt); c != 0 {
+			return c
+		}
+		return bytes.Compare(a.ID[:], b.ID[:])
+	})
+
+	total := len(matches)
+	matches = matches[min(filter.Offset, total):]
+	if filter.Limit > 0 && filter.Limit < len(matches) {
+		matches = matches[:filter.Limit]
+	}
+
+	tenants := make([]*domain.Tenant, len(matches))
+	for i, t := range matches {
+		tenants[i] = copyTenant(t)
+	}
+	return tenants, total, nil
+}
+
+// processorsOrDefault returns the chain to store for a tenant, the default chain when
+// none is configured.
+func processorsOrDefault(processors []domain.ProcessorConfig) []domain.ProcessorConfig {
+	if len(processors) == 0 {
+		return domain.DefaultProcessors()
+	}
+	return processors
+}
@@ -0,0 +1,141 @@
+package memory
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/fekalegi/multi-tenant-system/internal/domain"
+	"github.com/google/uuid"
+)
+
+// ScheduleMessage stores a message to be published once it is due.
+func (r *messageRepository) ScheduleMessage(ctx context.Context, msg *domain.ScheduledMessage) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	tenantID := msg.TenantID.String()
+	if _, ok := r.s.tenants[tenantID]; !ok {
+		return domain.ErrTenantNotFound
+	}
+	if r.s.scheduled[tenantID] == nil {
+		r.s.scheduled[tenantID] = make(map[uuid.UUID]*domain.ScheduledMessage)
+	}
+	if _, ok := r.s.scheduled[tenantID][msg.ID]; ok {
+		return errors.New("could not schedule message: message already scheduled")
+	}
+
+	m := copyScheduled(msg)
+	m.Attempts = 0
+	m.LastError = ""
+	r.s.scheduled[tenantID][msg.ID] = m
+	return nil
+}
+
+// ListScheduledMessages returns the pending scheduled messages of a tenant, next due
+// first, together with their total number.
+func (r *messageRepository) ListScheduledMessages(ctx context.Context, tenantID uuid.UUID, limit, offset int) ([]*domain.ScheduledMessage, int, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	var pending []*domain.ScheduledMessage
+	for _, m := range r.s.scheduled[tenantID.String()] {
+		pending = append(pending, m)
+	}
+	sortByDue(pending)
+
+	total := len(pending)
+	pending = pending[min(offset, total):]
+	if limit > 0 && limit < len(pending) {
+		pending = pending[:limit]
+	}
+
+	msgs := make([]*domain.ScheduledMessage, len(pending))
+	for i, m := range pending {
+		msgs[i] = copyScheduled(m)
+	}
+	return msgs, total, nil
+}
+
+// CancelScheduledMessage removes a scheduled message before it is published.
+func (r *messageRepository) CancelScheduledMessage(ctx context.Context, tenantID, id uuid.UUID) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	if _, ok := r.s.scheduled[tenantID.String()][id]; !ok {
+		return domain.ErrScheduledMessageNotFound
+	}
+	delete(r.s.scheduled[tenantID.String()], id)
+	return nil
+}
+
+// ReleaseDueMessage moves the earliest due scheduled message to the messages and the
+// outbox at once, for the relay to publish it. It returns the released message, or nil
+// when none is due; on error the message stays scheduled.
+func (r *messageRepository) ReleaseDueMessage(ctx context.Context) (*domain.ScheduledMessage, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	now := time.Now()
+	var due []*domain.ScheduledMessage
+	for _, pending := range r.s.scheduled {
+		for _, m := range pending {
+			if !m.DeliverAt.After(now) {
+				due = append(due, m)
+			}
+		}
+	}
+	if len(due) == 0 {
+		return nil, nil
+	}
+	sortByDue(due)
+	m := copyScheduled(due[0])
+
+	partition, ok := r.s.partitions[m.TenantID.String()]
+	if !ok {
+		return m, fmt.Errorf("could not store scheduled message: %w", domain.ErrTenantNotFound)
+	}
+	// Released under its ID, so a message stored already is not published twice
+	if _, ok := partition[m.ID]; !ok {
+		stored := &domain.Message{
+			ID:            m.ID,
+			TenantID:      m.TenantID,
+			Payload:       slices.Clone(m.Payload),
+			Priority:      m.Priority,
+			SchemaVersion: m.SchemaVersion,
+			Status:        domain.MessageStatusQueued,
+			CreatedAt:     now,
+			UpdatedAt:     now,
+		}
+		partition[m.ID] = stored
+		r.s.addOutboxEntry(stored)
+	}
+	delete(r.s.scheduled[m.TenantID.String()], m.ID)
+	return m, nil
+}
+
+// PostponeScheduledMessage moves a scheduled message whose delivery failed to a later time.
+func (r *messageRepository) PostponeScheduledMessage(ctx context.Context, tenantID, id uuid.UUID, until time.Time, reason string) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	if m, ok := r.s.scheduled[tenantID.String()][id]; ok {
+		m.DeliverAt = until
+		m.Attempts++
+		m.LastError = reason
+	}
+	return nil
+}
+
+// sortByDue sorts scheduled messages in deliver_at, id order.
+func sortByDue(msgs []*domain.ScheduledMessage) {
+	slices.SortFunc(msgs, func(a, b *domain.ScheduledMessage) int {
+		if c := a.DeliverAt.Compare(b.DeliverAt); c != 0 {
+			return c
+		}
+		return bytes.Compare(a.ID[:], b.ID[:])
+	})
+}
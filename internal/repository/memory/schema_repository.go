@@ -0,0 +1,94 @@
+package memory
+
+import (
+	"context"
+	"slices"
+	"time"
+
+	"github.com/fekalegi/multi-tenant-system/internal/domain"
+	"github.com/google/uuid"
+)
+
+// CreateSchema stores a new schema version for a tenant, numbered after its latest one.
+func (r *messageRepository) CreateSchema(ctx context.Context, tenantID uuid.UUID, schema []byte) (*domain.MessageSchema, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	id := tenantID.String()
+	if _, ok := r.s.tenants[id]; !ok {
+		return nil, domain.ErrTenantNotFound
+	}
+
+	version := 1
+	if schemas := r.s.schemas[id]; len(schemas) > 0 {
+		version = schemas[len(schemas)-1].Version + 1
+	}
+	s := &domain.MessageSchema{
+		TenantID:  tenantID,
+		Version:   version,
+		Schema:    slices.Clone(schema),
+		CreatedAt: time.Now(),
+	}
+	r.s.schemas[id] = append(r.s.schemas[id], s)
+	return copySchema(s), nil
+}
+
+// ListSchemas returns every schema version of a tenant, latest first.
+func (r *messageRepository) ListSchemas(ctx context.Context, tenantID uuid.UUID) ([]*domain.MessageSchema, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	stored := r.s.schemas[tenantID.String()]
+	schemas := make([]*domain.MessageSchema, 0, len(stored))
+	for i := len(stored) - 1; i >= 0; i-- {
+		schemas = append(schemas, copySchema(stored[i]))
+	}
+	return schemas, nil
+}
+
+// GetSchema returns one schema version of a tenant.
+func (r *messageRepository) GetSchema(ctx context.Context, tenantID uuid.UUID, version int) (*domain.MessageSchema, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	for _, s := range r.s.schemas[tenantID.String()] {
+		if s.Version == version {
+			return copySchema(s), nil
+		}
+	}
+	return nil, domain.ErrSchemaNotFound
+}
+
+// LatestSchema returns the schema version that applies to new messages of a tenant,
+// or nil when the tenant has none.
+func (r *messageRepository) LatestSchema(ctx context.Context, tenantID uuid.UUID) (*domain.MessageSchema, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	schemas := r.s.schemas[tenantID.String()]
+	if len(schemas) == 0 {
+		return nil, nil
+	}
+	return copySchema(schemas[len(schemas)-1]), nil
+}
+
+// DeleteSchema removes a schema version. Deleting the latest one makes the previous
+// version apply again.
+func (r *messageRepository) DeleteSchema(ctx context.Context, tenantID uuid.UUID, version int) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	id := tenantID.String()
+	i := slices.IndexFunc(r.s.schemas[id], func(s *domain.MessageSchema) bool { return s.Version == version })
+	if i < 0 {
+		return domain.ErrSchemaNotFound
+	}
+	r.s.schemas[id] = slices.Delete(r.s.schemas[id], i, i+1)
+	return nil
+}
+
+func copySchema(s *domain.MessageSchema) *domain.MessageSchema {
+	c := *s
+	c.Schema = slices.Clone(s.Schema)
+	return &c
+}
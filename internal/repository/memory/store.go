@@ -0,0 +1,164 @@
+// Package memory implements the repositories of the postgresql package in process
+// memory. It follows the same rules as the Postgres schema, so messages need their
+// tenant's partition and listings page in the same order, but nothing survives a
+// restart. It suits tests and local development.
+package memory
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/fekalegi/multi-tenant-system/internal/domain"
+	"github.com/google/uuid"
+)
+
+// errDuplicateMessage stands in for the primary key violation of a message stored twice.
+var errDuplicateMessage = errors.New("could not store messages: message already exists")
+
+// Store holds the data of the repositories, the way a database backs the Postgres
+// ones. Repositories built on the same store see each other's writes.
+type Store struct {
+	mu sync.Mutex
+
+	tenants map[string]*domain.Tenant
+	// processedSeq is the processed sequence number last drawn by each tenant
+	processedSeq map[string]int64
+	// partitions holds the messages of each tenant that has a partition
+	partitions map[string]map[uuid.UUID]*domain.Message
+
+	scheduled map[string]map[uuid.UUID]*domain.ScheduledMessage
+	schemas   map[string][]*domain.MessageSchema
+	webhooks  []*domain.WebhookDelivery
+	outbox    []*outboxEntry
+	outboxSeq int64
+
+	listeners map[*listener]struct{}
+}
+
+func NewStore() *Store {
+	return &Store{
+		tenants:      make(map[string]*domain.Tenant),
+		processedSeq: make(map[string]int64),
+		partitions:   make(map[string]map[uuid.UUID]*domain.Message),
+		scheduled:    make(map[string]map[uuid.UUID]*domain.ScheduledMessage),
+		schemas:      make(map[string][]*domain.MessageSchema),
+		listeners:    make(map[*listener]struct{}),
+	}
+}
+
+// outboxEntry is an outbox entry with the state the relay keeps on it.
+type outboxEntry struct {
+	domain.OutboxEntry
+	lastError   string
+	availableAt time.Time
+	sentAt      time.Time
+}
+
+// listener queues the notifications of one ListenProcessed call, so NotifyProcessed
+// never waits for a slow listener.
+type listener struct {
+	mu      sync.Mutex
+	pending []notification
+	wake    chan struct{}
+}
+
+type notification struct {
+	tenantID uuid.UUID
+	id       uuid.UUID
+}
+
+func (l *listener) push(n notification) {
+	l.mu.Lock()
+	l.pending = append(l.pending, n)
+	l.mu.Unlock()
+
+	select {
+	case l.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (l *listener) take() []notification {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	pending := l.pending
+	l.pending = nil
+	return pending
+}
+
+// listen calls fn for every notification until ctx is done.
+func (s *Store) listen(ctx context.Context, fn func(tenantID, id uuid.UUID)) error {
+	l := &listener{wake: make(chan struct{}, 1)}
+
+	s.mu.Lock()
+	s.listeners[l] = struct{}{}
+	s.mu.Unlock()
+
+	defer func() {
+		s.mu.Lock()
+		delete(s.listeners, l)
+		s.mu.Unlock()
+	}()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-l.wake:
+			for _, n := range l.take() {
+				fn(n.tenantID, n.id)
+			}
+		}
+	}
+}
+
+// deleteWebhookDeliveries removes the webhook deliveries of the given messages of a
+// tenant. Callers must hold s.mu.
+func (s *Store) deleteWebhookDeliveries(tenantID uuid.UUID, ids map[uuid.UUID]bool) {
+	s.webhooks = slices.DeleteFunc(s.webhooks, func(d *domain.WebhookDelivery) bool {
+		return d.TenantID == tenantID && ids[d.MessageID]
+	})
+}
+
+// comparePosition orders by timestamp and then by ID, like the (at, id) row
+// comparisons of the Postgres queries.
+func comparePosition(at time.Time, id uuid.UUID, other domain.MessagePosition) int {
+	if c := at.Compare(other.At); c != 0 {
+		return c
+	}
+	return bytes.Compare(id[:], other.ID[:])
+}
+
+func copyMessage(m *domain.Message) *domain.Message {
+	c := *m
+	c.Payload = slices.Clone(m.Payload)
+	if m.SchemaVersion != nil {
+		v := *m.SchemaVersion
+		c.SchemaVersion = &v
+	}
+	if m.ProcessedAt != nil {
+		at := *m.ProcessedAt
+		c.ProcessedAt = &at
+	}
+	return &c
+}
+
+func copyTenant(t *domain.Tenant) *domain.Tenant {
+	c := *t
+	c.Processors = slices.Clone(t.Processors)
+	return &c
+}
+
+func copyScheduled(m *domain.ScheduledMessage) *domain.ScheduledMessage {
+	c := *m
+	c.Payload = slices.Clone(m.Payload)
+	if m.SchemaVersion != nil {
+		v := *m.SchemaVersion
+		c.SchemaVersion = &v
+	}
+	return &c
+}
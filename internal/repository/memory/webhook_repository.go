@@ -0,0 +1,44 @@
+package memory
+
+import (
+	"context"
+	"time"
+
+	"github.com/fekalegi/multi-tenant-system/internal/domain"
+	"github.com/google/uuid"
+)
+
+// RecordWebhookDelivery logs an attempt to deliver a message to a webhook, numbering
+// it after the earlier attempts for the message.
+func (r *messageRepository) RecordWebhookDelivery(ctx context.Context, d *domain.WebhookDelivery) error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	attempt := 1
+	for _, earlier := range r.s.webhooks {
+		if earlier.TenantID == d.TenantID && earlier.MessageID == d.MessageID {
+			attempt++
+		}
+	}
+	d.Attempt = attempt
+	d.CreatedAt = time.Now()
+
+	stored := *d
+	r.s.webhooks = append(r.s.webhooks, &stored)
+	return nil
+}
+
+// ListWebhookDeliveries returns the delivery attempts of a message, oldest first.
+func (r *messageRepository) ListWebhookDeliveries(ctx context.Context, tenantID, messageID uuid.UUID) ([]*domain.WebhookDelivery, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	deliveries := []*domain.WebhookDelivery{}
+	for _, d := range r.s.webhooks {
+		if d.TenantID == tenantID && d.MessageID == messageID {
+			c := *d
+			deliveries = append(deliveries, &c)
+		}
+	}
+	return deliveries, nil
+}
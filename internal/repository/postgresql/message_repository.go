@@ -2,22 +2,61 @@ package postgresql
 
 import (
 	"context"
-	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/fekalegi/multi-tenant-system/internal/domain"
+	"slices"
 	"strings"
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
 type MessageRepository interface {
 	InsertMessage(ctx context.Context, msg *domain.Message) error
-	GetMessagesWithCursor(ctx context.Context, cursor string, limit int) ([]*domain.Message, string, error)
+	InsertMessages(ctx context.Context, msgs []*domain.Message) error
+	BeginProcessing(ctx context.Context, msg *domain.Message) (bool, error)
+	UpdateMessageStatus(ctx context.Context, tenantID, id uuid.UUID, status, lastError string) error
+	UpdateMessagePayload(ctx context.Context, tenantID, id uuid.UUID, payload []byte) error
+	GetMessages(ctx context.Context, filter domain.MessageFilter, page domain.MessagePage) ([]*domain.Message, bool, error)
+	GetMessage(ctx context.Context, tenantID, id uuid.UUID) (*domain.Message, error)
+	DeleteMessage(ctx context.Context, tenantID, id uuid.UUID) error
+	GetProcessedAfter(ctx context.Context, tenantID uuid.UUID, afterSeq int64, limit int) ([]*domain.Message, error)
+	LastProcessedSeq(ctx context.Context, tenantID uuid.UUID) (int64, error)
+	RetentionCutoff(ctx context.Context, tenantID uuid.UUID, keep int) (*domain.MessagePosition, error)
+	PurgeMessages(ctx context.Context, tenantID uuid.UUID, through domain.MessagePosition, limit int) (int64, error)
+	NotifyProcessed(ctx context.Context, tenantID, id uuid.UUID) error
+	ListenProcessed(ctx context.Context, fn func(tenantID, id uuid.UUID)) error
+
+	ScheduleMessage(ctx context.Context, msg *domain.ScheduledMessage) error
+	ListScheduledMessages(ctx context.Context, tenantID uuid.UUID, limit, offset int) ([]*domain.ScheduledMessage, int, error)
+	CancelScheduledMessage(ctx context.Context, tenantID, id uuid.UUID) error
+	ReleaseDueMessage(ctx context.Context) (*domain.ScheduledMessage, error)
+	PostponeScheduledMessage(ctx context.Context, tenantID, id uuid.UUID, until time.Time, reason string) error
+
+	CreateSchema(ctx context.Context, tenantID uuid.UUID, schema []byte) (*domain.MessageSchema, error)
+	ListSchemas(ctx context.Context, tenantID uuid.UUID) ([]*domain.MessageSchema, error)
+	GetSchema(ctx context.Context, tenantID uuid.UUID, version int) (*domain.MessageSchema, error)
+	LatestSchema(ctx context.Context, tenantID uuid.UUID) (*domain.MessageSchema, error)
+	DeleteSchema(ctx context.Context, tenantID uuid.UUID, version int) error
+
+	RecordWebhookDelivery(ctx context.Context, delivery *domain.WebhookDelivery) error
+	ListWebhookDeliveries(ctx context.Context, tenantID, messageID uuid.UUID) ([]*domain.WebhookDelivery, error)
+
+	RelayOutbox(ctx context.Context, limit int, lease, retryDelay time.Duration, publish func([]*domain.OutboxEntry) []error) (int, error)
+	PurgeOutbox(ctx context.Context, sentBefore time.Time) (int64, error)
 }
 
+// processedChannel is the Postgres notification channel announcing processed messages.
+const processedChannel = "messages_processed"
+
+// messageColumns are the columns scanned by scanMessage.
+const messageColumns = `id, tenant_id, payload, priority, schema_version, status, attempts, COALESCE(last_error, ''), created_at, COALESCE(updated_at, created_at), processed_at, COALESCE(processed_seq, 0)`
+
 type messageRepository struct {
 	db *pgxpool.Pool
 }
@@ -26,82 +65,493 @@ func NewMessageRepository(db *pgxpool.Pool) MessageRepository {
 	return &messageRepository{db: db}
 }
 
+// InsertMessage stores a queued message together with the outbox entry that gets it
+// published, in one transaction. It returns domain.ErrTenantNotFound for a tenant
+// without a partition.
 func (r *messageRepository) InsertMessage(ctx context.Context, msg *domain.Message) error {
-	payloadJSON, err := json.Marshal(msg.Payload)
+	tx, err := r.db.Begin(ctx)
 	if err != nil {
 		return err
 	}
+	defer tx.Rollback(ctx)
 
-	_, err = r.db.Exec(ctx, `
-		INSERT INTO messages (id, tenant_id, payload, created_at)
-		VALUES ($1, $2, $3, $4)
-	`, msg.ID, msg.TenantID, payloadJSON, msg.CreatedAt)
+	_, err = tx.Exec(ctx, `
+		INSERT INTO messages (id, tenant_id, payload, priority, schema_version, created_at, status, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $6)
+	`, msg.ID, msg.TenantID, msg.Payload, int16(msg.Priority), msg.SchemaVersion, msg.CreatedAt, domain.MessageStatusQueued)
+	if err != nil {
+		return storeError(err)
+	}
 
-	return err
+	_, err = tx.Exec(ctx, `
+		INSERT INTO outbox (tenant_id, message_id, payload, priority, created_at)
+		VALUES ($1, $2, $3, $4, $5)
+	`, msg.TenantID, msg.ID, msg.Payload, int16(msg.Priority), msg.CreatedAt)
+	if err != nil {
+		return storeError(err)
+	}
+	return tx.Commit(ctx)
 }
 
-func (r *messageRepository) GetMessagesWithCursor(ctx context.Context, cursor string, limit int) ([]*domain.Message, string, error) {
-	var afterTime time.Time
-	var afterID uuid.UUID
+// InsertMessages stores queued messages and their outbox entries in bulk through COPY,
+// in one transaction. It returns domain.ErrTenantNotFound for a tenant without a partition.
+func (r *messageRepository) InsertMessages(ctx context.Context, msgs []*domain.Message) error {
+	rows := make([][]any, len(msgs))
+	entries := make([][]any, len(msgs))
+	for i, m := range msgs {
+		rows[i] = []any{m.ID, m.TenantID, m.Payload, int16(m.Priority), m.SchemaVersion, m.CreatedAt, domain.MessageStatusQueued, m.CreatedAt}
+		entries[i] = []any{m.TenantID, m.ID, m.Payload, int16(m.Priority), m.CreatedAt}
+	}
 
-	if cursor != "" {
-		decoded, err := base64.StdEncoding.DecodeString(cursor)
-		if err != nil {
-			return nil, "", fmt.Errorf("invalid cursor: not base64 encoded")
-		}
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
 
-		parts := strings.SplitN(string(decoded), "|", 2)
-		if len(parts) != 2 {
-			return nil, "", fmt.Errorf("invalid cursor: malformed structure")
-		}
+	_, err = tx.CopyFrom(ctx,
+		pgx.Identifier{"messages"},
+		[]string{"id", "tenant_id", "payload", "priority", "schema_version", "created_at", "status", "updated_at"},
+		pgx.CopyFromRows(rows),
+	)
+	if err != nil {
+		return storeError(err)
+	}
+
+	_, err = tx.CopyFrom(ctx,
+		pgx.Identifier{"outbox"},
+		[]string{"tenant_id", "message_id", "payload", "priority", "created_at"},
+		pgx.CopyFromRows(entries),
+	)
+	if err != nil {
+		return storeError(err)
+	}
+	return tx.Commit(ctx)
+}
 
-		afterTime, err = time.Parse(time.RFC3339Nano, parts[0])
+// storeError tells messages of an unknown tenant, which has neither a partition nor a
+// registry entry, from other failures to store them.
+func storeError(err error) error {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && (pgErr.Code == "23514" || pgErr.Code == "23503") {
+		return domain.ErrTenantNotFound
+	}
+	return fmt.Errorf("could not store messages: %w", err)
+}
+
+// BeginProcessing marks a message as processing and counts the attempt. A message that
+// was never stored, e.g. one published before it reached the database, is inserted.
+// It returns false without touching the row when the message was already processed,
+// so a redelivered message is not processed twice.
+func (r *messageRepository) BeginProcessing(ctx context.Context, msg *domain.Message) (bool, error) {
+	var status string
+	err := r.db.QueryRow(ctx, `
+		INSERT INTO messages (id, tenant_id, payload, priority, created_at, status, attempts, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, 1, NOW())
+		ON CONFLICT (tenant_id, id) DO UPDATE
+		SET status = EXCLUDED.status, attempts = messages.attempts + 1, updated_at = NOW()
+		WHERE messages.status <> $7
+		RETURNING status
+	`, msg.ID, msg.TenantID, msg.Payload, int16(msg.Priority), msg.CreatedAt, domain.MessageStatusProcessing, domain.MessageStatusProcessed).Scan(&status)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// UpdateMessageStatus moves a message to the given status and records the last
+// processing error, if any. Processed messages get their processing time stamped and
+// the tenant's next processed sequence number, drawn under the tenant row's lock in the
+// same transaction so the numbers follow commit order. A number is only drawn once the
+// message was found, so a missing message leaves no gap.
+func (r *messageRepository) UpdateMessageStatus(ctx context.Context, tenantID, id uuid.UUID, status, lastError string) error {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	tag, err := tx.Exec(ctx, `
+		UPDATE messages
+		SET status = $3,
+			last_error = NULLIF($4, ''),
+			updated_at = NOW(),
+			processed_at = CASE WHEN $3 = 'processed' THEN NOW() ELSE processed_at END
+		WHERE tenant_id = $1 AND id = $2
+	`, tenantID, id, status, lastError)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return domain.ErrMessageNotFound
+	}
+
+	if status == domain.MessageStatusProcessed {
+		_, err := tx.Exec(ctx, `
+			WITH seq AS (
+				UPDATE tenants
+				SET processed_seq = processed_seq + 1
+				WHERE id = $1
+				RETURNING processed_seq
+			)
+			UPDATE messages
+			SET processed_seq = (SELECT processed_seq FROM seq)
+			WHERE tenant_id = $1 AND id = $2
+		`, tenantID, id)
 		if err != nil {
-			return nil, "", fmt.Errorf("invalid cursor: could not parse time")
+			return err
 		}
+	}
+	return tx.Commit(ctx)
+}
 
-		afterID, err = uuid.Parse(parts[1])
+// UpdateMessagePayload replaces the payload of a message, e.g. after it was transformed.
+func (r *messageRepository) UpdateMessagePayload(ctx context.Context, tenantID, id uuid.UUID, payload []byte) error {
+	tag, err := r.db.Exec(ctx, `
+		UPDATE messages
+		SET payload = $3, updated_at = NOW()
+		WHERE tenant_id = $1 AND id = $2
+	`, tenantID, id, payload)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return domain.ErrMessageNotFound
+	}
+	return nil
+}
+
+// GetMessages returns a page of the messages matching the filter in the requested
+// order, and whether more messages follow in the direction the page was read. The
+// filter always includes the tenant, which lets Postgres prune every other partition.
+func (r *messageRepository) GetMessages(ctx context.Context, filter domain.MessageFilter, page domain.MessagePage) ([]*domain.Message, bool, error) {
+	where, args, err := messageConditions(filter)
+	if err != nil {
+		return nil, false, err
+	}
+
+	// Reading backwards scans the opposite way and flips the rows afterwards
+	scanDesc := page.Descending != page.Backward
+	comparison, direction := ">", "ASC"
+	if scanDesc {
+		comparison, direction = "<", "DESC"
+	}
+
+	if page.From != nil {
+		args = append(args, page.From.At, page.From.ID)
+		where = append(where, fmt.Sprintf("(created_at, id) %s ($%d, $%d)", comparison, len(args)-1, len(args)))
+	}
+	// One extra row tells whether another page follows
+	args = append(args, page.Limit+1)
+
+	query := fmt.Sprintf(`
+		SELECT %s
+		FROM messages
+		WHERE %s
+		ORDER BY created_at %s, id %s
+		LIMIT $%d
+	`, messageColumns, strings.Join(where, " AND "), direction, direction, len(args))
+
+	rows, err := r.db.Query(ctx, query, args...)
+	if err != nil {
+		return nil, false, err
+	}
+	defer rows.Close()
+
+	messages := []*domain.Message{}
+	for rows.Next() {
+		m, err := scanMessage(rows)
 		if err != nil {
-			return nil, "", fmt.Errorf("invalid cursor: could not parse id")
+			return nil, false, err
 		}
+		messages = append(messages, m)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, false, err
+	}
+
+	more := len(messages) > page.Limit
+	if more {
+		messages = messages[:page.Limit]
+	}
+	if page.Backward {
+		slices.Reverse(messages)
 	}
 
-	query := `
-		SELECT id, tenant_id, payload, created_at
+	return messages, more, nil
+}
+
+// GetMessage looks a message up by its primary key, which reads the tenant's partition only.
+func (r *messageRepository) GetMessage(ctx context.Context, tenantID, id uuid.UUID) (*domain.Message, error) {
+	row := r.db.QueryRow(ctx, `
+		SELECT `+messageColumns+`
 		FROM messages
-		WHERE ($1 = '' OR (created_at, id) > ($2, $3))
-		ORDER BY created_at, id
-		LIMIT $4
-	`
+		WHERE tenant_id = $1 AND id = $2
+	`, tenantID, id)
+
+	m, err := scanMessage(row)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, domain.ErrMessageNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not get message: %w", err)
+	}
+	return m, nil
+}
 
-	rows, err := r.db.Query(ctx, query, cursor, afterTime, afterID, limit)
+// DeleteMessage deletes a processed or failed message together with its webhook
+// deliveries. Messages still queued or processing are refused, the consumer would
+// store them again.
+func (r *messageRepository) DeleteMessage(ctx context.Context, tenantID, id uuid.UUID) error {
+	var deleted int64
+	err := r.db.QueryRow(ctx, `
+		WITH deleted AS (
+			DELETE FROM messages
+			WHERE tenant_id = $1 AND id = $2 AND status IN ($3, $4)
+			RETURNING id
+		), deliveries AS (
+			DELETE FROM webhook_deliveries
+			WHERE tenant_id = $1 AND message_id IN (SELECT id FROM deleted)
+		)
+		SELECT COUNT(*) FROM deleted
+	`, tenantID, id, domain.MessageStatusProcessed, domain.MessageStatusFailed).Scan(&deleted)
 	if err != nil {
-		return nil, "", err
+		return fmt.Errorf("could not delete message: %w", err)
+	}
+	if deleted > 0 {
+		return nil
+	}
+
+	if _, err := r.GetMessage(ctx, tenantID, id); err != nil {
+		return err
+	}
+	return domain.ErrMessageInFlight
+}
+
+// GetProcessedAfter returns the processed messages of a tenant numbered after afterSeq,
+// in processed sequence order.
+func (r *messageRepository) GetProcessedAfter(ctx context.Context, tenantID uuid.UUID, afterSeq int64, limit int) ([]*domain.Message, error) {
+	rows, err := r.db.Query(ctx, `
+		SELECT `+messageColumns+`
+		FROM messages
+		WHERE tenant_id = $1 AND status = $2 AND processed_seq > $3
+		ORDER BY processed_seq
+		LIMIT $4
+	`, tenantID, domain.MessageStatusProcessed, afterSeq, limit)
+	if err != nil {
+		return nil, fmt.Errorf("could not list processed messages: %w", err)
 	}
 	defer rows.Close()
 
 	messages := []*domain.Message{}
-	var nextCursor string
-
 	for rows.Next() {
-		var (
-			m       domain.Message
-			rawJSON []byte
+		m, err := scanMessage(rows)
+		if err != nil {
+			return nil, err
+		}
+		messages = append(messages, m)
+	}
+	return messages, rows.Err()
+}
+
+// LastProcessedSeq returns the sequence number of the tenant's latest processed message,
+// zero when none was processed.
+func (r *messageRepository) LastProcessedSeq(ctx context.Context, tenantID uuid.UUID) (int64, error) {
+	var seq int64
+	err := r.db.QueryRow(ctx, `SELECT processed_seq FROM tenants WHERE id = $1`, tenantID).Scan(&seq)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return 0, domain.ErrTenantNotFound
+	}
+	if err != nil {
+		return 0, fmt.Errorf("could not read processed sequence: %w", err)
+	}
+	return seq, nil
+}
+
+// RetentionCutoff returns the position of the newest message of a tenant beyond the
+// keep newest ones, or nil when the tenant has no more than keep messages.
+func (r *messageRepository) RetentionCutoff(ctx context.Context, tenantID uuid.UUID, keep int) (*domain.MessagePosition, error) {
+	var pos domain.MessagePosition
+	err := r.db.QueryRow(ctx, `
+		SELECT created_at, id
+		FROM messages
+		WHERE tenant_id = $1
+		ORDER BY created_at DESC, id DESC
+		OFFSET $2 LIMIT 1
+	`, tenantID, keep).Scan(&pos.At, &pos.ID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not find retention cutoff: %w", err)
+	}
+	return &pos, nil
+}
+
+// PurgeMessages deletes up to limit of the oldest processed or failed messages of a
+// tenant at or before a position, with their webhook deliveries, and returns how many
+// messages it deleted. Messages still queued or processing are kept, the consumer
+// would store them again.
+func (r *messageRepository) PurgeMessages(ctx context.Context, tenantID uuid.UUID, through domain.MessagePosition, limit int) (int64, error) {
+	var deleted int64
+	err := r.db.QueryRow(ctx, `
+		WITH deleted AS (
+			DELETE FROM messages
+			WHERE tenant_id = $1 AND id IN (
+				SELECT id
+				FROM messages
+				WHERE tenant_id = $1 AND status IN ($4, $5) AND (created_at, id) <= ($2, $3)
+				ORDER BY created_at, id
+				LIMIT $6
+			)
+			RETURNING id
+		), deliveries AS (
+			DELETE FROM webhook_deliveries
+			WHERE tenant_id = $1 AND message_id IN (SELECT id FROM deleted)
 		)
-		err := rows.Scan(&m.ID, &m.TenantID, &rawJSON, &m.CreatedAt)
+		SELECT COUNT(*) FROM deleted
+	`, tenantID, through.At, through.ID, domain.MessageStatusProcessed, domain.MessageStatusFailed, limit).Scan(&deleted)
+	if err != nil {
+		return 0, fmt.Errorf("could not purge messages: %w", err)
+	}
+	return deleted, nil
+}
+
+// NotifyProcessed announces a processed message to every instance listening.
+func (r *messageRepository) NotifyProcessed(ctx context.Context, tenantID, id uuid.UUID) error {
+	_, err := r.db.Exec(ctx, "SELECT pg_notify($1, $2)", processedChannel, tenantID.String()+":"+id.String())
+	return err
+}
+
+// ListenProcessed calls fn for every message announced by NotifyProcessed until ctx is
+// done or the listening connection fails. The connection is taken out of the pool for
+// good, a connection that listened must not be reused for queries.
+func (r *messageRepository) ListenProcessed(ctx context.Context, fn func(tenantID, id uuid.UUID)) error {
+	pooled, err := r.db.Acquire(ctx)
+	if err != nil {
+		return fmt.Errorf("could not acquire listen connection: %w", err)
+	}
+	conn := pooled.Hijack()
+	defer conn.Close(context.Background())
+
+	if _, err := conn.Exec(ctx, "LISTEN "+processedChannel); err != nil {
+		return fmt.Errorf("could not listen for processed messages: %w", err)
+	}
+
+	for {
+		n, err := conn.WaitForNotification(ctx)
 		if err != nil {
-			return nil, "", err
+			return err
 		}
 
-		_ = json.Unmarshal(rawJSON, &m.Payload)
-		messages = append(messages, &m)
+		tenantPart, idPart, _ := strings.Cut(n.Payload, ":")
+		tenantID, err := uuid.Parse(tenantPart)
+		if err != nil {
+			continue
+		}
+		id, err := uuid.Parse(idPart)
+		if err != nil {
+			continue
+		}
+		fn(tenantID, id)
 	}
+}
 
-	if len(messages) == limit {
-		last := messages[len(messages)-1]
-		rawCursor := fmt.Sprintf("%s|%s", last.CreatedAt.Format(time.RFC3339Nano), last.ID)
-		nextCursor = base64.StdEncoding.EncodeToString([]byte(rawCursor))
+func scanMessage(row pgx.Row) (*domain.Message, error) {
+	var (
+		m       domain.Message
+		rawJSON []byte
+	)
+	err := row.Scan(&m.ID, &m.TenantID, &rawJSON, &m.Priority, &m.SchemaVersion, &m.Status, &m.Attempts, &m.LastError, &m.CreatedAt, &m.UpdatedAt, &m.ProcessedAt, &m.ProcessedSeq)
+	if err != nil {
+		return nil, err
 	}
 
-	return messages, nextCursor, nil
+	m.Payload = rawJSON
+	return &m, nil
+}
+
+// messageConditions translates a filter into SQL conditions and their arguments.
+// Payload filters use JSONB containment so the GIN index on payload applies.
+func messageConditions(filter domain.MessageFilter) ([]string, []any, error) {
+	var (
+		where []string
+		args  []any
+	)
+	add := func(cond string, values ...any) {
+		placeholders := make([]any, len(values))
+		for i, v := range values {
+			args = append(args, v)
+			placeholders[i] = fmt.Sprintf("$%d", len(args))
+		}
+		where = append(where, fmt.Sprintf(cond, placeholders...))
+	}
+
+	add("tenant_id = %s", filter.TenantID)
+
+	if filter.Status != "" {
+		add("status = %s", filter.Status)
+	}
+	if filter.Priority != nil {
+		add("priority = %s", int16(*filter.Priority))
+	}
+	if !filter.CreatedFrom.IsZero() {
+		add("created_at >= %s", filter.CreatedFrom)
+	}
+	if !filter.CreatedTo.IsZero() {
+		add("created_at < %s", filter.CreatedTo)
+	}
+	if len(filter.PayloadContains) > 0 {
+		add("payload @> %s::jsonb", string(filter.PayloadContains))
+	}
+
+	for _, match := range filter.PayloadMatches {
+		docs, err := payloadMatchDocuments(match)
+		if err != nil {
+			return nil, nil, err
+		}
+		if len(docs) == 1 {
+			add("payload @> %s::jsonb", docs[0])
+		} else {
+			add("(payload @> %s::jsonb OR payload @> %s::jsonb)", docs[0], docs[1])
+		}
+	}
+
+	return where, args, nil
+}
+
+// payloadMatchDocuments builds the JSON documents a payload has to contain for the
+// match: one with the value as a string and, when it is valid JSON, one with the
+// value as parsed.
+func payloadMatchDocuments(match domain.PayloadMatch) ([]string, error) {
+	values := []any{match.Value}
+
+	if json.Valid([]byte(match.Value)) {
+		// Keep numbers as written, float64 would round large IDs
+		dec := json.NewDecoder(strings.NewReader(match.Value))
+		dec.UseNumber()
+
+		var parsed any
+		if err := dec.Decode(&parsed); err == nil {
+			if _, isString := parsed.(string); !isString {
+				values = append(values, parsed)
+			}
+		}
+	}
+
+	docs := make([]string, 0, len(values))
+	for _, v := range values {
+		for i := len(match.Path) - 1; i >= 0; i-- {
+			v = map[string]any{match.Path[i]: v}
+		}
+		doc, err := json.Marshal(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid payload filter: %w", err)
+		}
+		docs = append(docs, string(doc))
+	}
+	return docs, nil
 }
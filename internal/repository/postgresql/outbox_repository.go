@@ -0,0 +1,114 @@
+package postgresql
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/fekalegi/multi-tenant-system/internal/domain"
+)
+
+// RelayOutbox claims up to limit pending outbox entries, oldest first, and hands them to
+// publish. Claiming leases the entries for lease in a statement of its own, so no
+// transaction stays open while they are published: other relays skip leased entries,
+// and the entries of a relay that stopped before settling them are claimed again once
+// the lease ran out. publish returns one error per entry: published entries are marked
+// sent, the others are retried after retryDelay. It returns the number of entries claimed.
+func (r *messageRepository) RelayOutbox(ctx context.Context, limit int, lease, retryDelay time.Duration, publish func([]*domain.OutboxEntry) []error) (int, error) {
+	rows, err := r.db.Query(ctx, `
+		UPDATE outbox o
+		SET available_at = NOW() + make_interval(secs => $2)
+		FROM (
+			SELECT id
+			FROM outbox
+			WHERE sent_at IS NULL AND available_at <= NOW()
+			ORDER BY id
+			LIMIT $1
+			FOR UPDATE SKIP LOCKED
+		) claimed
+		WHERE o.id = claimed.id
+		RETURNING o.id, o.tenant_id, o.message_id, o.payload, o.priority, o.attempts, o.created_at
+	`, limit, lease.Seconds())
+	if err != nil {
+		return 0, fmt.Errorf("could not claim outbox entries: %w", err)
+	}
+
+	var entries []*domain.OutboxEntry
+	for rows.Next() {
+		var e domain.OutboxEntry
+		if err := rows.Scan(&e.ID, &e.TenantID, &e.MessageID, &e.Payload, &e.Priority, &e.Attempts, &e.CreatedAt); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		entries = append(entries, &e)
+	}
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("could not claim outbox entries: %w", err)
+	}
+	if len(entries) == 0 {
+		return 0, nil
+	}
+	// RETURNING does not keep the order of the claim
+	slices.SortFunc(entries, func(a, b *domain.OutboxEntry) int { return cmp.Compare(a.ID, b.ID) })
+
+	errs := publish(entries)
+	return len(entries), r.settleOutbox(ctx, entries, errs, retryDelay)
+}
+
+// settleOutbox marks the published entries sent and postpones the others by retryDelay.
+func (r *messageRepository) settleOutbox(ctx context.Context, entries []*domain.OutboxEntry, errs []error, retryDelay time.Duration) error {
+	var (
+		sent    []int64
+		failed  []int64
+		reasons []string
+	)
+	for i, e := range entries {
+		if errs[i] != nil {
+			failed = append(failed, e.ID)
+			reasons = append(reasons, errs[i].Error())
+			continue
+		}
+		sent = append(sent, e.ID)
+	}
+
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	if len(sent) > 0 {
+		_, err := tx.Exec(ctx, `
+			UPDATE outbox
+			SET sent_at = NOW(), attempts = attempts + 1, last_error = NULL
+			WHERE id = ANY($1)
+		`, sent)
+		if err != nil {
+			return fmt.Errorf("could not mark outbox entries sent: %w", err)
+		}
+	}
+	if len(failed) > 0 {
+		_, err := tx.Exec(ctx, `
+			UPDATE outbox o
+			SET attempts = o.attempts + 1, last_error = f.reason, available_at = NOW() + make_interval(secs => $3)
+			FROM unnest($1::BIGINT[], $2::TEXT[]) AS f (id, reason)
+			WHERE o.id = f.id AND o.sent_at IS NULL
+		`, failed, reasons, retryDelay.Seconds())
+		if err != nil {
+			return fmt.Errorf("could not postpone outbox entries: %w", err)
+		}
+	}
+	return tx.Commit(ctx)
+}
+
+// PurgeOutbox removes the outbox entries sent before sentBefore and returns how many
+// were removed.
+func (r *messageRepository) PurgeOutbox(ctx context.Context, sentBefore time.Time) (int64, error) {
+	tag, err := r.db.Exec(ctx, `DELETE FROM outbox WHERE sent_at < $1`, sentBefore)
+	if err != nil {
+		return 0, fmt.Errorf("could not purge outbox: %w", err)
+	}
+	return tag.RowsAffected(), nil
+}
@@ -2,17 +2,29 @@ package postgresql
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"strings"
+	"time"
 
+	"github.com/fekalegi/multi-tenant-system/internal/domain"
 	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
-// TenantRepository defines the interface for partition management.
+// TenantRepository defines the interface for partition management and the tenant registry.
 type TenantRepository interface {
 	CreatePartitionForTenant(ctx context.Context, tenantID string) error
 	DeletePartitionForTenant(ctx context.Context, tenantID string) error
+
+	SaveTenant(ctx context.Context, tenant *domain.Tenant) error
+	UpdateTenantConcurrency(ctx context.Context, tenantID string, workers, prefetch int) error
+	UpdateTenantStatus(ctx context.Context, tenantID string, status string) error
+	UpdateTenantRetention(ctx context.Context, tenantID string, policy domain.RetentionPolicy) error
+	UpdateTenantProcessors(ctx context.Context, tenantID string, processors []domain.ProcessorConfig) error
+	DeleteTenant(ctx context.Context, tenantID string) error
+	GetTenant(ctx context.Context, tenantID string) (*domain.Tenant, error)
+	ListTenants(ctx context.Context, filter domain.TenantFilter) ([]*domain.Tenant, int, error)
 }
 
 type tenantRepository struct {
@@ -43,7 +55,7 @@ func (r *tenantRepository) DeletePartitionForTenant(ctx context.Context, tenantI
 	partitionName := fmt.Sprintf("messages_tenant_%s", strings.ReplaceAll(tenantID, "-", "_"))
 
 	dropPartitionSQL := fmt.Sprintf(
-		"DROP TABLE %s",
+		"DROP TABLE IF EXISTS %s",
 		pgx.Identifier{partitionName}.Sanitize(),
 	)
 
@@ -53,3 +65,151 @@ func (r *tenantRepository) DeletePartitionForTenant(ctx context.Context, tenantI
 	}
 	return nil
 }
+
+func (r *tenantRepository) SaveTenant(ctx context.Context, tenant *domain.Tenant) error {
+	_, err := r.db.Exec(ctx, `
+		INSERT INTO tenants (id, name, workers, prefetch, status, processors, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $7)
+	`, tenant.ID, tenant.Name, tenant.Workers, tenant.Prefetch, tenant.Status, processorsOrDefault(tenant.Processors), tenant.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("could not save tenant %s: %w", tenant.ID, err)
+	}
+	return nil
+}
+
+func (r *tenantRepository) UpdateTenantConcurrency(ctx context.Context, tenantID string, workers, prefetch int) error {
+	tag, err := r.db.Exec(ctx, `
+		UPDATE tenants SET workers = $2, prefetch = $3, updated_at = NOW() WHERE id = $1
+	`, tenantID, workers, prefetch)
+	if err != nil {
+		return fmt.Errorf("could not update concurrency for tenant %s: %w", tenantID, err)
+	}
+	if tag.RowsAffected() == 0 {
+		return domain.ErrTenantNotFound
+	}
+	return nil
+}
+
+func (r *tenantRepository) UpdateTenantRetention(ctx context.Context, tenantID string, policy domain.RetentionPolicy) error {
+	tag, err := r.db.Exec(ctx, `
+		UPDATE tenants SET retention_max_age_seconds = $2, retention_max_rows = $3, updated_at = NOW() WHERE id = $1
+	`, tenantID, int64(policy.MaxAge/time.Second), policy.MaxRows)
+	if err != nil {
+		return fmt.Errorf("could not update retention for tenant %s: %w", tenantID, err)
+	}
+	if tag.RowsAffected() == 0 {
+		return domain.ErrTenantNotFound
+	}
+	return nil
+}
+
+func (r *tenantRepository) UpdateTenantProcessors(ctx context.Context, tenantID string, processors []domain.ProcessorConfig) error {
+	return r.updateTenant(ctx, tenantID, "processors", processorsOrDefault(processors))
+}
+
+func (r *tenantRepository) UpdateTenantStatus(ctx context.Context, tenantID string, status string) error {
+	return r.updateTenant(ctx, tenantID, "status", status)
+}
+
+func (r *tenantRepository) updateTenant(ctx context.Context, tenantID string, column string, value any) error {
+	query := fmt.Sprintf(
+		"UPDATE tenants SET %s = $2, updated_at = NOW() WHERE id = $1",
+		pgx.Identifier{column}.Sanitize(),
+	)
+
+	tag, err := r.db.Exec(ctx, query, tenantID, value)
+	if err != nil {
+		return fmt.Errorf("could not update %s for tenant %s: %w", column, tenantID, err)
+	}
+	if tag.RowsAffected() == 0 {
+		return domain.ErrTenantNotFound
+	}
+	return nil
+}
+
+func (r *tenantRepository) DeleteTenant(ctx context.Context, tenantID string) error {
+	_, err := r.db.Exec(ctx, "DELETE FROM tenants WHERE id = $1", tenantID)
+	if err != nil {
+		return fmt.Errorf("could not delete tenant %s: %w", tenantID, err)
+	}
+	return nil
+}
+
+func (r *tenantRepository) GetTenant(ctx context.Context, tenantID string) (*domain.Tenant, error) {
+	var (
+		t          domain.Tenant
+		maxAgeSecs int64
+	)
+	err := r.db.QueryRow(ctx, `
+		SELECT id, name, workers, prefetch, status, created_at, updated_at, retention_max_age_seconds, retention_max_rows, processors
+		FROM tenants
+		WHERE id = $1
+	`, tenantID).Scan(&t.ID, &t.Name, &t.Workers, &t.Prefetch, &t.Status, &t.CreatedAt, &t.UpdatedAt, &maxAgeSecs, &t.Retention.MaxRows, &t.Processors)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, domain.ErrTenantNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not get tenant %s: %w", tenantID, err)
+	}
+	t.Retention.MaxAge = time.Duration(maxAgeSecs) * time.Second
+	return &t, nil
+}
+
+// likeEscaper escapes the LIKE wildcards, so a name filter matches them literally.
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// ListTenants returns the tenants matching the filter together with the total number
+// of matches. A zero Limit returns every match.
+func (r *tenantRepository) ListTenants(ctx context.Context, filter domain.TenantFilter) ([]*domain.Tenant, int, error) {
+	const where = `
+		WHERE ($1 = '' OR name ILIKE '%' || $1 || '%' ESCAPE '\')
+		AND ($2 = '' OR id::text = $2)
+	`
+	name := likeEscaper.Replace(filter.Name)
+
+	rows, err := r.db.Query(ctx, `
+		SELECT id, name, workers, prefetch, status, created_at, updated_at, retention_max_age_seconds, retention_max_rows, processors, COUNT(*) OVER()
+		FROM tenants`+where+`
+		ORDER BY created_at, id
+		LIMIT NULLIF($3, 0) OFFSET $4
+	`, name, filter.ID, filter.Limit, filter.Offset)
+	if err != nil {
+		return nil, 0, fmt.Errorf("could not list tenants: %w", err)
+	}
+	defer rows.Close()
+
+	tenants := []*domain.Tenant{}
+	total := 0
+	for rows.Next() {
+		var (
+			t          domain.Tenant
+			maxAgeSecs int64
+		)
+		if err := rows.Scan(&t.ID, &t.Name, &t.Workers, &t.Prefetch, &t.Status, &t.CreatedAt, &t.UpdatedAt, &maxAgeSecs, &t.Retention.MaxRows, &t.Processors, &total); err != nil {
+			return nil, 0, err
+		}
+		t.Retention.MaxAge = time.Duration(maxAgeSecs) * time.Second
+		tenants = append(tenants, &t)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, err
+	}
+
+	// A page past the last match has no row to carry the count
+	if len(tenants) == 0 && filter.Offset > 0 {
+		err := r.db.QueryRow(ctx, "SELECT COUNT(*) FROM tenants"+where, name, filter.ID).Scan(&total)
+		if err != nil {
+			return nil, 0, fmt.Errorf("could not count tenants: %w", err)
+		}
+	}
+	return tenants, total, nil
+}
+
+// processorsOrDefault returns the chain to store for a tenant, the default chain when
+// none is configured.
+func processorsOrDefault(processors []domain.ProcessorConfig) []domain.ProcessorConfig {
+	if len(processors) == 0 {
+		return domain.DefaultProcessors()
+	}
+	return processors
+}
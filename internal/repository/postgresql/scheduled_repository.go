@@ -0,0 +1,142 @@
+package postgresql
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/fekalegi/multi-tenant-system/internal/domain"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+const scheduledColumns = `tenant_id, id, payload, priority, schema_version, deliver_at, attempts, COALESCE(last_error, ''), created_at`
+
+// ScheduleMessage stores a message to be published once it is due.
+func (r *messageRepository) ScheduleMessage(ctx context.Context, msg *domain.ScheduledMessage) error {
+	_, err := r.db.Exec(ctx, `
+		INSERT INTO scheduled_messages (tenant_id, id, payload, priority, schema_version, deliver_at, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+	`, msg.TenantID, msg.ID, msg.Payload, int16(msg.Priority), msg.SchemaVersion, msg.DeliverAt, msg.CreatedAt)
+
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == "23503" {
+		return domain.ErrTenantNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("could not schedule message: %w", err)
+	}
+	return nil
+}
+
+// ListScheduledMessages returns the pending scheduled messages of a tenant, next due
+// first, together with their total number.
+func (r *messageRepository) ListScheduledMessages(ctx context.Context, tenantID uuid.UUID, limit, offset int) ([]*domain.ScheduledMessage, int, error) {
+	rows, err := r.db.Query(ctx, `
+		SELECT `+scheduledColumns+`, COUNT(*) OVER()
+		FROM scheduled_messages
+		WHERE tenant_id = $1
+		ORDER BY deliver_at, id
+		LIMIT NULLIF($2, 0) OFFSET $3
+	`, tenantID, limit, offset)
+	if err != nil {
+		return nil, 0, fmt.Errorf("could not list scheduled messages: %w", err)
+	}
+	defer rows.Close()
+
+	msgs := []*domain.ScheduledMessage{}
+	total := 0
+	for rows.Next() {
+		var m domain.ScheduledMessage
+		var payload []byte
+		if err := rows.Scan(&m.TenantID, &m.ID, &payload, &m.Priority, &m.SchemaVersion, &m.DeliverAt, &m.Attempts, &m.LastError, &m.CreatedAt, &total); err != nil {
+			return nil, 0, err
+		}
+		m.Payload = payload
+		msgs = append(msgs, &m)
+	}
+	return msgs, total, rows.Err()
+}
+
+// CancelScheduledMessage removes a scheduled message before it is published.
+func (r *messageRepository) CancelScheduledMessage(ctx context.Context, tenantID, id uuid.UUID) error {
+	tag, err := r.db.Exec(ctx, `DELETE FROM scheduled_messages WHERE tenant_id = $1 AND id = $2`, tenantID, id)
+	if err != nil {
+		return fmt.Errorf("could not cancel scheduled message: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return domain.ErrScheduledMessageNotFound
+	}
+	return nil
+}
+
+// ReleaseDueMessage claims the earliest due scheduled message and moves it to the
+// messages and the outbox in one transaction, for the relay to publish it. Instances
+// running concurrently skip messages another one claimed. It returns the released
+// message, or nil when none is due; on error the message stays scheduled.
+func (r *messageRepository) ReleaseDueMessage(ctx context.Context) (*domain.ScheduledMessage, error) {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	var (
+		m       domain.ScheduledMessage
+		payload []byte
+	)
+	err = tx.QueryRow(ctx, `
+		SELECT `+scheduledColumns+`
+		FROM scheduled_messages
+		WHERE deliver_at <= NOW()
+		ORDER BY deliver_at
+		LIMIT 1
+		FOR UPDATE SKIP LOCKED
+	`).Scan(&m.TenantID, &m.ID, &payload, &m.Priority, &m.SchemaVersion, &m.DeliverAt, &m.Attempts, &m.LastError, &m.CreatedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not claim scheduled message: %w", err)
+	}
+	m.Payload = payload
+
+	// Released under its ID, so a message stored already is not published twice
+	tag, err := tx.Exec(ctx, `
+		INSERT INTO messages (id, tenant_id, payload, priority, schema_version, created_at, status, updated_at)
+		VALUES ($1, $2, $3, $4, $5, NOW(), $6, NOW())
+		ON CONFLICT (tenant_id, id) DO NOTHING
+	`, m.ID, m.TenantID, m.Payload, int16(m.Priority), m.SchemaVersion, domain.MessageStatusQueued)
+	if err != nil {
+		return &m, fmt.Errorf("could not store scheduled message: %w", err)
+	}
+	if tag.RowsAffected() == 1 {
+		_, err = tx.Exec(ctx, `
+			INSERT INTO outbox (tenant_id, message_id, payload, priority, created_at)
+			VALUES ($1, $2, $3, $4, NOW())
+		`, m.TenantID, m.ID, m.Payload, int16(m.Priority))
+		if err != nil {
+			return &m, fmt.Errorf("could not store scheduled message: %w", err)
+		}
+	}
+
+	if _, err := tx.Exec(ctx, `DELETE FROM scheduled_messages WHERE tenant_id = $1 AND id = $2`, m.TenantID, m.ID); err != nil {
+		return &m, fmt.Errorf("could not unschedule message: %w", err)
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return &m, err
+	}
+	return &m, nil
+}
+
+// PostponeScheduledMessage moves a scheduled message whose delivery failed to a later time.
+func (r *messageRepository) PostponeScheduledMessage(ctx context.Context, tenantID, id uuid.UUID, until time.Time, reason string) error {
+	_, err := r.db.Exec(ctx, `
+		UPDATE scheduled_messages
+		SET deliver_at = $3, attempts = attempts + 1, last_error = $4
+		WHERE tenant_id = $1 AND id = $2
+	`, tenantID, id, until, reason)
+	return err
+}
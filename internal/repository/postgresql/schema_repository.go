@@ -0,0 +1,130 @@
+package postgresql
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/fekalegi/multi-tenant-system/internal/domain"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+// CreateSchema stores a new schema version for a tenant, numbered after its latest one.
+func (r *messageRepository) CreateSchema(ctx context.Context, tenantID uuid.UUID, schema []byte) (*domain.MessageSchema, error) {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	// Locking the tenant serializes version numbers of concurrent registrations
+	var locked uuid.UUID
+	err = tx.QueryRow(ctx, `SELECT id FROM tenants WHERE id = $1 FOR UPDATE`, tenantID).Scan(&locked)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, domain.ErrTenantNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not lock tenant: %w", err)
+	}
+
+	s := domain.MessageSchema{TenantID: tenantID}
+	var stored []byte
+	err = tx.QueryRow(ctx, `
+		INSERT INTO message_schemas (tenant_id, version, schema, created_at)
+		SELECT $1, COALESCE(MAX(version), 0) + 1, $2, NOW()
+		FROM message_schemas
+		WHERE tenant_id = $1
+		RETURNING version, schema, created_at
+	`, tenantID, schema).Scan(&s.Version, &stored, &s.CreatedAt)
+	if err != nil {
+		return nil, fmt.Errorf("could not store schema: %w", err)
+	}
+	s.Schema = stored
+
+	return &s, tx.Commit(ctx)
+}
+
+// ListSchemas returns every schema version of a tenant, latest first.
+func (r *messageRepository) ListSchemas(ctx context.Context, tenantID uuid.UUID) ([]*domain.MessageSchema, error) {
+	rows, err := r.db.Query(ctx, `
+		SELECT tenant_id, version, schema, created_at
+		FROM message_schemas
+		WHERE tenant_id = $1
+		ORDER BY version DESC
+	`, tenantID)
+	if err != nil {
+		return nil, fmt.Errorf("could not list schemas: %w", err)
+	}
+	defer rows.Close()
+
+	schemas := []*domain.MessageSchema{}
+	for rows.Next() {
+		s, err := scanSchema(rows)
+		if err != nil {
+			return nil, err
+		}
+		schemas = append(schemas, s)
+	}
+	return schemas, rows.Err()
+}
+
+// GetSchema returns one schema version of a tenant.
+func (r *messageRepository) GetSchema(ctx context.Context, tenantID uuid.UUID, version int) (*domain.MessageSchema, error) {
+	s, err := scanSchema(r.db.QueryRow(ctx, `
+		SELECT tenant_id, version, schema, created_at
+		FROM message_schemas
+		WHERE tenant_id = $1 AND version = $2
+	`, tenantID, version))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, domain.ErrSchemaNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not get schema: %w", err)
+	}
+	return s, nil
+}
+
+// LatestSchema returns the schema version that applies to new messages of a tenant,
+// or nil when the tenant has none.
+func (r *messageRepository) LatestSchema(ctx context.Context, tenantID uuid.UUID) (*domain.MessageSchema, error) {
+	s, err := scanSchema(r.db.QueryRow(ctx, `
+		SELECT tenant_id, version, schema, created_at
+		FROM message_schemas
+		WHERE tenant_id = $1
+		ORDER BY version DESC
+		LIMIT 1
+	`, tenantID))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not get schema: %w", err)
+	}
+	return s, nil
+}
+
+// DeleteSchema removes a schema version. Deleting the latest one makes the previous
+// version apply again.
+func (r *messageRepository) DeleteSchema(ctx context.Context, tenantID uuid.UUID, version int) error {
+	tag, err := r.db.Exec(ctx, `DELETE FROM message_schemas WHERE tenant_id = $1 AND version = $2`, tenantID, version)
+	if err != nil {
+		return fmt.Errorf("could not delete schema: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return domain.ErrSchemaNotFound
+	}
+	return nil
+}
+
+func scanSchema(row pgx.Row) (*domain.MessageSchema, error) {
+	var (
+		s      domain.MessageSchema
+		schema []byte
+	)
+	if err := row.Scan(&s.TenantID, &s.Version, &schema, &s.CreatedAt); err != nil {
+		return nil, err
+	}
+	s.Schema = schema
+	return &s, nil
+}
@@ -0,0 +1,49 @@
+package postgresql
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/fekalegi/multi-tenant-system/internal/domain"
+	"github.com/google/uuid"
+)
+
+// RecordWebhookDelivery logs an attempt to deliver a message to a webhook, numbering
+// it after the earlier attempts for the message.
+func (r *messageRepository) RecordWebhookDelivery(ctx context.Context, d *domain.WebhookDelivery) error {
+	err := r.db.QueryRow(ctx, `
+		INSERT INTO webhook_deliveries (tenant_id, message_id, attempt, url, status_code, error, duration_ms)
+		SELECT $1, $2, COUNT(*) + 1, $3, NULLIF($4, 0), NULLIF($5, ''), $6
+		FROM webhook_deliveries
+		WHERE tenant_id = $1 AND message_id = $2
+		RETURNING attempt, created_at
+	`, d.TenantID, d.MessageID, d.URL, d.StatusCode, d.Error, d.DurationMs).Scan(&d.Attempt, &d.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("could not record webhook delivery: %w", err)
+	}
+	return nil
+}
+
+// ListWebhookDeliveries returns the delivery attempts of a message, oldest first.
+func (r *messageRepository) ListWebhookDeliveries(ctx context.Context, tenantID, messageID uuid.UUID) ([]*domain.WebhookDelivery, error) {
+	rows, err := r.db.Query(ctx, `
+		SELECT tenant_id, message_id, attempt, url, COALESCE(status_code, 0), COALESCE(error, ''), duration_ms, created_at
+		FROM webhook_deliveries
+		WHERE tenant_id = $1 AND message_id = $2
+		ORDER BY id
+	`, tenantID, messageID)
+	if err != nil {
+		return nil, fmt.Errorf("could not list webhook deliveries: %w", err)
+	}
+	defer rows.Close()
+
+	deliveries := []*domain.WebhookDelivery{}
+	for rows.Next() {
+		var d domain.WebhookDelivery
+		if err := rows.Scan(&d.TenantID, &d.MessageID, &d.Attempt, &d.URL, &d.StatusCode, &d.Error, &d.DurationMs, &d.CreatedAt); err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, &d)
+	}
+	return deliveries, rows.Err()
+}
@@ -8,11 +8,6 @@ import (
 	"github.com/labstack/echo/v4"
 )
 
-const (
-	ContextUserIDKey   = "user_id"
-	ContextTenantIDKey = "tenant_id"
-)
-
 func JWTAuthMiddleware(jwtManager *auth.JWTManager) echo.MiddlewareFunc {
 	return func(next echo.HandlerFunc) echo.HandlerFunc {
 		return func(c echo.Context) error {
@@ -29,9 +24,24 @@ func JWTAuthMiddleware(jwtManager *auth.JWTManager) echo.MiddlewareFunc {
 			}
 
 			// Set tenant and user in context
-			c.Set(ContextUserIDKey, claims.UserID)
-			c.Set(ContextTenantIDKey, claims.TenantID)
+			c.Set(auth.ContextUserIDKey, claims.UserID)
+			c.Set(auth.ContextTenantIDKey, claims.TenantID)
+			c.Set(auth.ContextClaimsKey, claims)
+
+			return next(c)
+		}
+	}
+}
 
+// PlatformAdminMiddleware lets only platform admins through. It runs after
+// JWTAuthMiddleware, which stores the caller's claims.
+func PlatformAdminMiddleware() echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			claims, ok := c.Get(auth.ContextClaimsKey).(*auth.Claims)
+			if !ok || !claims.PlatformAdmin {
+				return c.JSON(http.StatusForbidden, echo.Map{"error": "platform admin required"})
+			}
 			return next(c)
 		}
 	}
@@ -2,6 +2,7 @@ package server
 
 import (
 	"context"
+	"expvar"
 	"fmt"
 	"github.com/fekalegi/multi-tenant-system/api/handler"
 	"github.com/fekalegi/multi-tenant-system/config"
@@ -23,7 +24,7 @@ type Server struct {
 
 func NewServer(cfg *config.Config, manager *tenant.Manager, messageService *message.Service, jwtManager *auth.JWTManager, log zerolog.Logger) *Server {
 	e := echo.New()
-	registerRoutes(e, manager, messageService, jwtManager)
+	registerRoutes(e, cfg, manager, messageService, jwtManager)
 
 	return &Server{
 		e:    e,
@@ -43,20 +44,30 @@ func (s *Server) Stop(ctx context.Context) error {
 	return s.e.Shutdown(ctx)
 }
 
-func registerRoutes(e *echo.Echo, manager *tenant.Manager, messageService *message.Service, jwtManager *auth.JWTManager) {
+func registerRoutes(e *echo.Echo, cfg *config.Config, manager *tenant.Manager, messageService *message.Service, jwtManager *auth.JWTManager) {
 
 	e.GET("/swagger/*", echoSwagger.WrapHandler)
 
+	// Metrics name tenants and their volumes, so only platform admins read them
+	debug := e.Group("/debug", JWTAuthMiddleware(jwtManager), PlatformAdminMiddleware())
+	debug.GET("/vars", echo.WrapHandler(expvar.Handler()))
+
 	public := e.Group("/api")
-	loginHandler := handler.NewLoginHandler(jwtManager)
+	loginHandler := handler.NewLoginHandler(jwtManager, cfg.JWTConfig.AdminSecret)
 	loginHandler.RegisterRoutes(public)
 
 	protected := e.Group("/api", JWTAuthMiddleware(jwtManager))
-	tenantHandler := handler.NewTenantHandler(manager)
+	tenantHandler := handler.NewTenantHandler(manager, cfg.MaxWorkers, cfg.MaxPrefetch)
 	tenantHandler.RegisterTenantRoutes(protected)
 
+	deadLetterHandler := handler.NewDeadLetterHandler(manager)
+	deadLetterHandler.RegisterDeadLetterRoutes(protected)
+
 	messageHandler := handler.NewMessageHandler(messageService)
 	messageHandler.RegisterMessageRoute(protected)
+
+	schemaHandler := handler.NewSchemaHandler(messageService)
+	schemaHandler.RegisterSchemaRoutes(protected)
 }
 
 func (s *Server) GetEcho() *echo.Echo {
@@ -0,0 +1,433 @@
+package tenant
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/fekalegi/multi-tenant-system/internal/broker"
+	"github.com/fekalegi/multi-tenant-system/internal/domain"
+	"github.com/fekalegi/multi-tenant-system/internal/processor"
+	"github.com/google/uuid"
+)
+
+// tenantConsumer owns the broker subscription of a tenant and the worker pool the
+// deliveries are fanned out to. Workers are independent of the subscription, so the
+// pool can grow or shrink while the subscription stays open.
+//
+// A consumer is stopped in two steps: stop ends the subscription and lets the workers
+// finish what was already delivered, cancelFunc aborts in-flight work so the broker
+// requeues it. done is closed once the fan-in loop and every worker have exited.
+type tenantConsumer struct {
+	tenantID   string
+	ctx        context.Context
+	cancelFunc context.CancelFunc
+	running    atomic.Bool
+	jobs       chan *broker.Delivery
+	process    func(ctx context.Context, workerID int, d *broker.Delivery)
+
+	// chain is the tenant's processor chain, replaced in place when it is reconfigured
+	chain atomic.Pointer[processor.Chain]
+
+	// resubscribe is signalled when the prefetch changed
+	resubscribe chan struct{}
+
+	stopping chan struct{}
+	stopOnce sync.Once
+	done     chan struct{}
+	wg       sync.WaitGroup
+
+	mu         sync.Mutex
+	prefetch   int
+	workers    []chan struct{}
+	nextWorker int
+}
+
+func newTenantConsumer(tenantID string, prefetch int, process func(ctx context.Context, workerID int, d *broker.Delivery)) *tenantConsumer {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	return &tenantConsumer{
+		tenantID:   tenantID,
+		ctx:        ctx,
+		cancelFunc: cancel,
+		// Unbuffered so prefetch alone bounds in-flight work
+		jobs:        make(chan *broker.Delivery),
+		process:     process,
+		resubscribe: make(chan struct{}, 1),
+		stopping:    make(chan struct{}),
+		done:        make(chan struct{}),
+		prefetch:    prefetch,
+	}
+}
+
+// resize grows or shrinks the worker pool in place. Retired workers finish the
+// message they are processing before they exit.
+func (tc *tenantConsumer) resize(workers int) {
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+
+	for len(tc.workers) < workers {
+		quit := make(chan struct{})
+		tc.workers = append(tc.workers, quit)
+		tc.wg.Add(1)
+		go tc.work(tc.nextWorker, quit)
+		tc.nextWorker++
+	}
+
+	for len(tc.workers) > workers {
+		last := len(tc.workers) - 1
+		close(tc.workers[last])
+		tc.workers = tc.workers[:last]
+	}
+}
+
+func (tc *tenantConsumer) workerCount() int {
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+	return len(tc.workers)
+}
+
+func (tc *tenantConsumer) currentPrefetch() int {
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+	return tc.prefetch
+}
+
+// setPrefetch changes the prefetch and asks the subscription to renew itself.
+func (tc *tenantConsumer) setPrefetch(prefetch int) {
+	tc.mu.Lock()
+	changed := tc.prefetch != prefetch
+	tc.prefetch = prefetch
+	tc.mu.Unlock()
+
+	if changed {
+		select {
+		case tc.resubscribe <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// stop ends the subscription. Deliveries already received are still processed.
+func (tc *tenantConsumer) stop() {
+	tc.stopOnce.Do(func() { close(tc.stopping) })
+}
+
+// shutdown stops the consumer and waits for it to drain. When ctx expires first the
+// in-flight work is aborted and requeued, and ctx's error is returned.
+func (tc *tenantConsumer) shutdown(ctx context.Context) error {
+	tc.stop()
+
+	select {
+	case <-tc.done:
+		tc.cancelFunc()
+		return nil
+	case <-ctx.Done():
+		tc.cancelFunc()
+		<-tc.done
+		return ctx.Err()
+	}
+}
+
+// closeWorkers releases the workers once no more jobs will be sent and waits for
+// them to finish their current job.
+func (tc *tenantConsumer) closeWorkers() {
+	close(tc.jobs)
+	tc.wg.Wait()
+}
+
+func (tc *tenantConsumer) work(workerID int, quit <-chan struct{}) {
+	defer tc.wg.Done()
+
+	for {
+		select {
+		case d, ok := <-tc.jobs:
+			if !ok {
+				return
+			}
+			tc.process(tc.ctx, workerID, d)
+		case <-quit:
+			return
+		case <-tc.ctx.Done():
+			return
+		}
+	}
+}
+
+// runConsumer starts a consumer with its worker pool and processor chain and tracks
+// it. Callers must hold m.mu.
+func (m *Manager) runConsumer(tenantID string, workers, prefetch int, chain *processor.Chain) {
+	var tc *tenantConsumer
+	tc = newTenantConsumer(tenantID, prefetch, func(ctx context.Context, workerID int, d *broker.Delivery) {
+		m.handleDelivery(ctx, tenantID, tc.chain.Load(), workerID, d)
+	})
+	tc.chain.Store(chain)
+	m.consumers[tenantID] = tc
+
+	tc.resize(workers)
+	go m.startConsumer(tc)
+}
+
+// resubscribeDelay is how long a consumer waits before subscribing again after its
+// subscription failed while the connection itself was up.
+const resubscribeDelay = time.Second
+
+// pumpResult tells the subscription loop why the fan-in loop returned.
+type pumpResult int
+
+const (
+	// pumpStopped means the consumer was stopped or aborted.
+	pumpStopped pumpResult = iota
+	// pumpRenew means the prefetch changed and the subscription must be renewed.
+	pumpRenew
+	// pumpLost means the subscription or connection went away.
+	pumpLost
+)
+
+// startConsumer subscribes to the tenant queue and fans deliveries in to the workers
+// until the consumer is stopped. A lost subscription is re-established as soon as
+// the broker is back. The subscription is only closed after the workers are done, so
+// everything they finished can still be acknowledged.
+func (m *Manager) startConsumer(tc *tenantConsumer) {
+	defer close(tc.done)
+
+	for {
+		sub, err := m.Broker.Subscribe(tc.tenantID)
+		if err != nil {
+			m.Log.Error().Err(err).Str("tenant_id", tc.tenantID).Msg("Failed to subscribe")
+			if !m.awaitConnection(tc) {
+				break
+			}
+			continue
+		}
+
+		if !m.subscribe(tc, sub) {
+			tc.closeWorkers()
+			if err := sub.Close(); err != nil {
+				m.Log.Warn().Err(err).Str("tenant_id", tc.tenantID).Msg("Failed to close subscription")
+			}
+			m.Log.Info().Str("tenant_id", tc.tenantID).Msg("Consumer drained")
+			return
+		}
+
+		// Unsettled deliveries of the lost subscription are requeued by the broker
+		_ = sub.Close()
+		if !m.awaitConnection(tc) {
+			break
+		}
+		m.Log.Info().Str("tenant_id", tc.tenantID).Msg("Resubscribing consumer")
+	}
+
+	tc.closeWorkers()
+	m.Log.Info().Str("tenant_id", tc.tenantID).Msg("Consumer stopped")
+}
+
+// awaitConnection waits a moment and then until the broker is reachable. It
+// returns false when the consumer is stopped in the meantime.
+func (m *Manager) awaitConnection(tc *tenantConsumer) bool {
+	ctx, cancel := context.WithCancel(tc.ctx)
+	defer cancel()
+
+	go func() {
+		select {
+		case <-tc.stopping:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	select {
+	case <-time.After(resubscribeDelay):
+	case <-ctx.Done():
+		return false
+	}
+
+	return m.Broker.WaitReady(ctx) == nil
+}
+
+// subscribe consumes the tenant queue on sub, renewing the consume whenever the
+// prefetch changes. It returns true when the subscription was lost and has to be
+// re-established, false when the consumer stopped.
+func (m *Manager) subscribe(tc *tenantConsumer, sub broker.Subscription) bool {
+	for {
+		// Bound the number of unsettled deliveries held by this consumer
+		deliveries, err := sub.Consume(tc.currentPrefetch())
+		if err != nil {
+			m.Log.Error().Err(err).Str("tenant_id", tc.tenantID).Msg("Failed to start consuming")
+			return true
+		}
+
+		tc.running.Store(true)
+		result := m.pump(tc, sub, deliveries)
+		tc.running.Store(false)
+
+		switch result {
+		case pumpStopped:
+			return false
+		case pumpLost:
+			return true
+		}
+		m.Log.Info().Str("tenant_id", tc.tenantID).Int("prefetch", tc.currentPrefetch()).Msg("Renewing subscription with new prefetch")
+	}
+}
+
+// pump forwards deliveries to the workers until the consumer stops, the prefetch
+// changes or the subscription is lost.
+func (m *Manager) pump(tc *tenantConsumer, sub broker.Subscription, deliveries <-chan *broker.Delivery) pumpResult {
+	// drain cancels the consume and hands over what the broker already sent
+	drain := func() bool {
+		if err := sub.Cancel(); err != nil {
+			m.Log.Error().Err(err).Str("tenant_id", tc.tenantID).Msg("Failed to cancel subscription")
+			return false
+		}
+		for d := range deliveries {
+			select {
+			case tc.jobs <- d:
+			case <-tc.ctx.Done():
+				return false
+			}
+		}
+		return true
+	}
+
+	for {
+		select {
+		case <-tc.ctx.Done():
+			m.Log.Info().Str("tenant_id", tc.tenantID).Msg("Consumer aborted")
+			return pumpStopped
+
+		case <-tc.stopping:
+			m.Log.Info().Str("tenant_id", tc.tenantID).Msg("Consumer stopping, draining in-flight messages")
+			drain()
+			return pumpStopped
+
+		case <-tc.resubscribe:
+			// Deliveries already sent to the old consume stay valid on this subscription
+			if !drain() {
+				return pumpLost
+			}
+			return pumpRenew
+
+		case d, ok := <-deliveries:
+			if !ok {
+				m.Log.Warn().Str("tenant_id", tc.tenantID).Msg("Delivery channel closed")
+				return pumpLost
+			}
+			select {
+			case tc.jobs <- d:
+			case <-tc.ctx.Done():
+				// Unsettled, the broker redelivers it once the subscription closes
+				return pumpStopped
+			}
+		}
+	}
+}
+
+// handleDelivery runs a delivery through the tenant's processor chain and acknowledges
+// it. Deliveries the chain asks to retry go through the retry queues with backoff until
+// the retry policy is exhausted, after which they are dead-lettered. Deliveries the
+// chain rejects are dead-lettered at once.
+func (m *Manager) handleDelivery(ctx context.Context, tenantID string, chain *processor.Chain, workerID int, d *broker.Delivery) {
+	messageID, err := uuid.Parse(d.ID)
+	if err != nil {
+		// Published without a message ID, e.g. by another client; store it under a new one
+		messageID = uuid.New()
+	}
+	tenantUUID, _ := uuid.Parse(tenantID)
+
+	log := m.Log.With().
+		Str("worker", fmt.Sprint(workerID)).
+		Str("tenant_id", tenantID).
+		Str("msg_id", messageID.String()).
+		Logger()
+
+	log.Info().Msg("Processing message")
+
+	createdAt := d.Timestamp
+	if createdAt.IsZero() {
+		createdAt = time.Now()
+	}
+
+	attempt := d.Attempts + 1
+	processed, action, err := m.processMessage(ctx, chain, &processor.Delivery{
+		Message: &domain.Message{
+			ID:        messageID,
+			TenantID:  tenantUUID,
+			Payload:   d.Body,
+			Priority:  d.Priority,
+			CreatedAt: createdAt,
+		},
+		Attempt: attempt,
+	})
+	if action == processor.Ack {
+		if !processed {
+			log.Info().Msg("Message already processed, skipping redelivery")
+		}
+		if err := d.Ack(); err != nil {
+			log.Error().Err(err).Msg("Failed to ack message")
+		}
+		return
+	}
+
+	if ctx.Err() != nil {
+		// Interrupted by shutdown, the message itself is fine
+		if err := d.Nack(); err != nil {
+			log.Error().Err(err).Msg("Failed to nack message")
+		}
+		return
+	}
+
+	status := domain.MessageStatusQueued
+	cause := err
+	if action == processor.Retry && attempt < m.retry.MaxAttempts {
+		log.Warn().Err(err).Int("attempt", attempt).Msg("Failed to process message, scheduling retry")
+		err = d.Retry(m.retry.Backoff(attempt))
+	} else {
+		log.Error().Err(err).Int("attempt", attempt).Msg("Failed to process message, dead-lettering")
+		status = domain.MessageStatusFailed
+		err = d.DeadLetter(err)
+	}
+
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to reroute message, requeueing")
+		if err := d.Nack(); err != nil {
+			log.Error().Err(err).Msg("Failed to nack message")
+		}
+		return
+	}
+
+	// The storage failure that got us here may prevent this too; the broker holds the truth
+	if err := m.msgRepo.UpdateMessageStatus(ctx, tenantUUID, messageID, status, cause.Error()); err != nil {
+		log.Warn().Err(err).Str("status", status).Msg("Failed to record message status")
+	}
+}
+
+// processMessage moves a message to processing, runs it through the chain and marks it
+// processed once the chain acks it. It returns false when the message was already
+// processed by an earlier delivery, together with the action the delivery calls for.
+func (m *Manager) processMessage(ctx context.Context, chain *processor.Chain, d *processor.Delivery) (bool, processor.Action, error) {
+	msg := d.Message
+	started, err := m.msgRepo.BeginProcessing(ctx, msg)
+	if err != nil {
+		return false, processor.StorageAction(err), err
+	}
+	if !started {
+		return false, processor.Ack, nil
+	}
+
+	if action, err := chain.Run(ctx, d); action != processor.Ack {
+		return false, action, err
+	}
+
+	if err := m.msgRepo.UpdateMessageStatus(ctx, msg.TenantID, msg.ID, domain.MessageStatusProcessed, ""); err != nil {
+		return false, processor.StorageAction(err), err
+	}
+
+	// The message is processed either way; stream clients can catch up by cursor
+	if err := m.msgRepo.NotifyProcessed(ctx, msg.TenantID, msg.ID); err != nil {
+		m.Log.Warn().Err(err).Str("msg_id", msg.ID.String()).Msg("Failed to announce processed message")
+	}
+	return true, processor.Ack, nil
+}
@@ -0,0 +1,251 @@
+package tenant_test
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/fekalegi/multi-tenant-system/internal/broker"
+	"github.com/fekalegi/multi-tenant-system/internal/domain"
+	"github.com/fekalegi/multi-tenant-system/internal/processor"
+	"github.com/fekalegi/multi-tenant-system/internal/tenant"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+// publish stores a message and publishes it, as the outbox relay would.
+func (h *harness) publish(id uuid.UUID) {
+	h.publishPriority(id, 0)
+}
+
+// publishPriority is publish for a message of the given priority.
+func (h *harness) publishPriority(id uuid.UUID, priority uint8) {
+	h.insert(id, priority, 0)
+	err := h.broker.Publish(context.Background(), h.tenantID, broker.Message{
+		ID:       id.String(),
+		Body:     []byte(`{"n": 1}`),
+		Priority: priority,
+	})
+	require.NoError(h.t, err)
+}
+
+// redeliver publishes a message again without storing it.
+func (h *harness) redeliver(id uuid.UUID) {
+	err := h.broker.Publish(context.Background(), h.tenantID, broker.Message{
+		ID:   id.String(),
+		Body: []byte(`{"n": 1}`),
+	})
+	require.NoError(h.t, err)
+}
+
+func TestConsumer_When_ChainAcks_Then_MessageIsProcessed(t *testing.T) {
+	h := newHarness(t, ack)
+
+	id := uuid.New()
+	h.publish(id)
+
+	msg := h.awaitStatus(id, domain.MessageStatusProcessed)
+	require.Equal(t, 1, msg.Attempts)
+	require.NotNil(t, msg.ProcessedAt)
+}
+
+func TestConsumer_When_ChainFailsOnce_Then_MessageIsRetried(t *testing.T) {
+	h := newHarness(t, func(_ context.Context, d *processor.Delivery) (processor.Action, error) {
+		if d.Attempt == 1 {
+			return processor.Retry, errors.New("temporarily unavailable")
+		}
+		return processor.Ack, nil
+	})
+
+	id := uuid.New()
+	h.publish(id)
+
+	msg := h.awaitStatus(id, domain.MessageStatusProcessed)
+	require.Equal(t, 2, msg.Attempts)
+	require.EqualValues(t, 2, h.calls.Load())
+}
+
+func TestConsumer_When_RetriesAreExhausted_Then_MessageIsDeadLettered(t *testing.T) {
+	h := newHarness(t, func(context.Context, *processor.Delivery) (processor.Action, error) {
+		return processor.Retry, errors.New("always failing")
+	})
+
+	id := uuid.New()
+	h.publish(id)
+
+	msg := h.awaitStatus(id, domain.MessageStatusFailed)
+	require.Equal(t, 3, msg.Attempts)
+	require.Contains(t, msg.LastError, "always failing")
+
+	dead, err := h.manager.GetDeadLetter(h.tenantID, id.String())
+	require.NoError(t, err)
+	require.Equal(t, 3, dead.Attempts)
+}
+
+func TestConsumer_When_ChainRejects_Then_MessageIsDeadLetteredAtOnce(t *testing.T) {
+	h := newHarness(t, func(context.Context, *processor.Delivery) (processor.Action, error) {
+		return processor.DeadLetter, errors.New("malformed")
+	})
+
+	id := uuid.New()
+	h.publish(id)
+
+	msg := h.awaitStatus(id, domain.MessageStatusFailed)
+	require.Equal(t, 1, msg.Attempts)
+	require.EqualValues(t, 1, h.calls.Load())
+}
+
+func TestConsumer_When_ProcessedMessageIsRedelivered_Then_ItIsSkipped(t *testing.T) {
+	h := newHarness(t, ack)
+
+	id := uuid.New()
+	h.publish(id)
+	h.awaitStatus(id, domain.MessageStatusProcessed)
+
+	// With one worker and a prefetch of one, the redelivery is settled before the next message
+	h.redeliver(id)
+	next := uuid.New()
+	h.publish(next)
+	h.awaitStatus(next, domain.MessageStatusProcessed)
+
+	require.EqualValues(t, 2, h.calls.Load())
+	msg, err := h.messages.GetMessage(context.Background(), uuid.MustParse(h.tenantID), id)
+	require.NoError(t, err)
+	require.Equal(t, 1, msg.Attempts)
+}
+
+func TestConsumer_When_MessagesWait_Then_HigherPriorityIsProcessedFirst(t *testing.T) {
+	release := make(chan struct{})
+	processed := make(chan uuid.UUID, 4)
+	h := newHarness(t, func(_ context.Context, d *processor.Delivery) (processor.Action, error) {
+		<-release
+		processed <- d.Message.ID
+		return processor.Ack, nil
+	})
+
+	// The only worker holds the only prefetched message while the others queue up
+	blocker := uuid.New()
+	h.publish(blocker)
+	h.awaitStatus(blocker, domain.MessageStatusProcessing)
+
+	low, high, medium := uuid.New(), uuid.New(), uuid.New()
+	h.publishPriority(low, 1)
+	h.publishPriority(high, domain.MaxMessagePriority)
+	h.publishPriority(medium, 5)
+
+	close(release)
+	for _, want := range []uuid.UUID{blocker, high, medium, low} {
+		select {
+		case got := <-processed:
+			require.Equal(t, want, got)
+		case <-time.After(5 * time.Second):
+			t.Fatal("message should be processed")
+		}
+	}
+	require.Equal(t, domain.MaxMessagePriority, h.awaitStatus(high, domain.MessageStatusProcessed).Priority)
+}
+
+func TestConsumer_When_PoolIsResized_Then_InFlightMessagesFinish(t *testing.T) {
+	release := make(chan struct{})
+	var running atomic.Int32
+	h := newHarness(t, func(ctx context.Context, _ *processor.Delivery) (processor.Action, error) {
+		running.Add(1)
+		defer running.Add(-1)
+		select {
+		case <-release:
+			return processor.Ack, nil
+		case <-ctx.Done():
+			return processor.Retry, ctx.Err()
+		}
+	})
+	t.Cleanup(func() {
+		select {
+		case <-release:
+		default:
+			close(release)
+		}
+	})
+	ctx := context.Background()
+
+	cfg, err := h.manager.UpdateConcurrency(ctx, h.tenantID, domain.ConcurrencyConfig{Workers: 3, Prefetch: 3})
+	require.NoError(t, err)
+	require.Equal(t, domain.ConcurrencyConfig{Workers: 3, Prefetch: 3}, cfg)
+
+	ids := []uuid.UUID{uuid.New(), uuid.New(), uuid.New()}
+	for _, id := range ids {
+		h.publish(id)
+	}
+	require.Eventually(t, func() bool { return running.Load() == 3 }, 5*time.Second, 10*time.Millisecond, "three workers should process at once")
+
+	// Shrinking retires workers only once they finished their message
+	cfg, err = h.manager.UpdateConcurrency(ctx, h.tenantID, domain.ConcurrencyConfig{Workers: 1})
+	require.NoError(t, err)
+	require.Equal(t, domain.ConcurrencyConfig{Workers: 1, Prefetch: 3}, cfg)
+	require.EqualValues(t, 3, running.Load())
+
+	close(release)
+	for _, id := range ids {
+		msg := h.awaitStatus(id, domain.MessageStatusProcessed)
+		require.Equal(t, 1, msg.Attempts, "in-flight messages should not be redelivered")
+	}
+
+	info, err := h.manager.GetTenant(ctx, h.tenantID)
+	require.NoError(t, err)
+	require.Equal(t, 1, info.Runtime.ActiveWorkers)
+	require.Equal(t, 1, info.Runtime.QueueConsumers, "the subscription should be kept")
+}
+
+func TestConsumer_When_DrainTimesOut_Then_TenantIsReportedAndMessageRequeued(t *testing.T) {
+	started := make(chan struct{}, 1)
+	h := newHarness(t, func(ctx context.Context, _ *processor.Delivery) (processor.Action, error) {
+		started <- struct{}{}
+		<-ctx.Done()
+		return processor.Retry, ctx.Err()
+	})
+
+	id := uuid.New()
+	h.publish(id)
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	err := h.manager.ShutdownConsumers(ctx)
+
+	var drainErr *tenant.DrainError
+	require.ErrorAs(t, err, &drainErr)
+	require.Equal(t, []string{h.tenantID}, drainErr.Tenants)
+
+	// Aborted without counting an attempt, the message waits for the next consumer
+	stats, err := h.broker.Inspect(context.Background(), h.tenantID)
+	require.NoError(t, err)
+	require.Equal(t, 1, stats.Messages)
+	require.Equal(t, 0, stats.Consumers)
+	dead, err := h.broker.DeadLetters().List(h.tenantID, 10)
+	require.NoError(t, err)
+	require.Empty(t, dead)
+}
+
+func TestConsumer_When_DrainFinishesInTime_Then_ShutdownSucceeds(t *testing.T) {
+	release := make(chan struct{})
+	h := newHarness(t, func(context.Context, *processor.Delivery) (processor.Action, error) {
+		<-release
+		return processor.Ack, nil
+	})
+
+	id := uuid.New()
+	h.publish(id)
+	h.awaitStatus(id, domain.MessageStatusProcessing)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	time.AfterFunc(50*time.Millisecond, func() { close(release) })
+	require.NoError(t, h.manager.ShutdownConsumers(ctx))
+
+	// The in-flight message was finished, not requeued
+	h.awaitStatus(id, domain.MessageStatusProcessed)
+	stats, err := h.broker.Inspect(context.Background(), h.tenantID)
+	require.NoError(t, err)
+	require.Equal(t, 0, stats.Messages)
+}
@@ -0,0 +1,45 @@
+package tenant
+
+import (
+	"context"
+
+	"github.com/fekalegi/multi-tenant-system/internal/domain"
+)
+
+// GetProcessors returns the processor chain of a tenant.
+func (m *Manager) GetProcessors(ctx context.Context, tenantID string) ([]domain.ProcessorConfig, error) {
+	t, err := m.tenantRepo.GetTenant(ctx, tenantID)
+	if err != nil {
+		return nil, err
+	}
+	if len(t.Processors) == 0 {
+		return domain.DefaultProcessors(), nil
+	}
+	return t.Processors, nil
+}
+
+// UpdateProcessors replaces the processor chain of a tenant and returns the chain now in
+// use. The workers switch to the new chain with their next message; errors building it
+// wrap processor.ErrInvalidConfig.
+func (m *Manager) UpdateProcessors(ctx context.Context, tenantID string, processors []domain.ProcessorConfig) ([]domain.ProcessorConfig, error) {
+	chain, err := m.processors.Build(processors)
+	if err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	tc, ok := m.consumers[tenantID]
+	if !ok {
+		return nil, domain.ErrTenantNotFound
+	}
+
+	if err := m.tenantRepo.UpdateTenantProcessors(ctx, tenantID, processors); err != nil {
+		return nil, err
+	}
+
+	tc.chain.Store(chain)
+	m.Log.Info().Str("tenant_id", tenantID).Int("processors", len(processors)).Msg("Processor chain updated")
+	return chain.Configs(), nil
+}
@@ -0,0 +1,193 @@
+package tenant
+
+import (
+	"bytes"
+	"context"
+	"expvar"
+	"sync"
+	"time"
+
+	"github.com/fekalegi/multi-tenant-system/internal/domain"
+)
+
+// Retention metrics, published on /debug/vars.
+var (
+	// retentionRemoved counts the messages removed per tenant and in "total"
+	retentionRemoved = expvar.NewMap("retention_rows_removed")
+	retentionRuns    = expvar.NewInt("retention_runs")
+	retentionErrors  = expvar.NewInt("retention_errors")
+)
+
+// defaultRetentionBatch is the batch size used when none is configured.
+const defaultRetentionBatch = 1000
+
+// RetentionOptions controls the retention job.
+type RetentionOptions struct {
+	// Interval is how often every tenant's policy is applied
+	Interval time.Duration
+	// BatchSize bounds the messages removed per statement, so no run holds locks long
+	BatchSize int
+}
+
+// retentionStats keeps the outcome of the retention runs per tenant.
+type retentionStats struct {
+	mu     sync.Mutex
+	tenant map[string]*domain.RetentionStats
+}
+
+func (s *retentionStats) get(tenantID string) domain.RetentionStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if st, ok := s.tenant[tenantID]; ok {
+		return *st
+	}
+	return domain.RetentionStats{}
+}
+
+func (s *retentionStats) record(tenantID string, removed int64, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.tenant == nil {
+		s.tenant = make(map[string]*domain.RetentionStats)
+	}
+	st, ok := s.tenant[tenantID]
+	if !ok {
+		st = &domain.RetentionStats{}
+		s.tenant[tenantID] = st
+	}
+
+	now := time.Now()
+	st.LastRunAt = &now
+	st.LastRemoved = removed
+	st.TotalRemoved += removed
+	st.LastError = ""
+	if err != nil {
+		st.LastError = err.Error()
+	}
+}
+
+// forget drops the stats of a deleted tenant, including its published metric.
+func (s *retentionStats) forget(tenantID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.tenant, tenantID)
+	retentionRemoved.Delete(tenantID)
+}
+
+// GetRetention returns the retention policy of a tenant and how it was applied so far.
+func (m *Manager) GetRetention(ctx context.Context, tenantID string) (domain.RetentionPolicy, domain.RetentionStats, error) {
+	t, err := m.tenantRepo.GetTenant(ctx, tenantID)
+	if err != nil {
+		return domain.RetentionPolicy{}, domain.RetentionStats{}, err
+	}
+	return t.Retention, m.retentionStats.get(tenantID), nil
+}
+
+// UpdateRetention replaces the retention policy of a tenant. It applies from the next
+// run of the retention job.
+func (m *Manager) UpdateRetention(ctx context.Context, tenantID string, policy domain.RetentionPolicy) error {
+	if err := m.tenantRepo.UpdateTenantRetention(ctx, tenantID, policy); err != nil {
+		return err
+	}
+	m.Log.Info().Str("tenant_id", tenantID).Dur("max_age", policy.MaxAge).Int("max_rows", policy.MaxRows).Msg("Retention policy updated")
+	return nil
+}
+
+// RunRetention applies the retention policy of every tenant each interval until ctx is
+// done. Several instances may run it side by side, they only delete the same rows.
+func (m *Manager) RunRetention(ctx context.Context, opts RetentionOptions) {
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = defaultRetentionBatch
+	}
+
+	ticker := time.NewTicker(opts.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		tenants, _, err := m.tenantRepo.ListTenants(ctx, domain.TenantFilter{})
+		if err != nil {
+			if ctx.Err() == nil {
+				retentionErrors.Add(1)
+				m.Log.Warn().Err(err).Msg("Failed to list tenants for retention")
+			}
+			continue
+		}
+
+		retentionRuns.Add(1)
+		for _, t := range tenants {
+			if t.Status != domain.TenantStatusActive || !t.Retention.Enabled() {
+				continue
+			}
+			m.applyRetention(ctx, t, opts.BatchSize)
+			if ctx.Err() != nil {
+				return
+			}
+		}
+	}
+}
+
+// applyRetention removes the messages of a tenant its policy no longer keeps, one
+// batch at a time.
+func (m *Manager) applyRetention(ctx context.Context, t *domain.Tenant, batchSize int) {
+	id := t.ID.String()
+	log := m.Log.With().Str("tenant_id", id).Logger()
+
+	var removed int64
+	err := func() error {
+		var through *domain.MessagePosition
+		if t.Retention.MaxAge > 0 {
+			// The nil UUID sorts first, so this keeps everything created at the cutoff
+			through = &domain.MessagePosition{At: time.Now().Add(-t.Retention.MaxAge)}
+		}
+		if t.Retention.MaxRows > 0 {
+			pos, err := m.msgRepo.RetentionCutoff(ctx, t.ID, t.Retention.MaxRows)
+			if err != nil {
+				return err
+			}
+			if pos != nil && (through == nil || positionBefore(*through, *pos)) {
+				through = pos
+			}
+		}
+		if through == nil {
+			return nil
+		}
+
+		for {
+			n, err := m.msgRepo.PurgeMessages(ctx, t.ID, *through, batchSize)
+			removed += n
+			retentionRemoved.Add(id, n)
+			retentionRemoved.Add("total", n)
+			if err != nil || n < int64(batchSize) {
+				return err
+			}
+		}
+	}()
+
+	if ctx.Err() != nil {
+		return
+	}
+	m.retentionStats.record(id, removed, err)
+	if err != nil {
+		retentionErrors.Add(1)
+		log.Warn().Err(err).Int64("removed", removed).Msg("Failed to apply retention policy")
+		return
+	}
+	if removed > 0 {
+		log.Info().Int64("removed", removed).Msg("Expired messages removed")
+	}
+}
+
+func positionBefore(a, b domain.MessagePosition) bool {
+	if !a.At.Equal(b.At) {
+		return a.At.Before(b.At)
+	}
+	return bytes.Compare(a.ID[:], b.ID[:]) < 0
+}
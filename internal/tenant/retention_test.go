@@ -0,0 +1,133 @@
+package tenant_test
+
+import (
+	"context"
+	"expvar"
+	"testing"
+	"time"
+
+	"github.com/fekalegi/multi-tenant-system/internal/domain"
+	"github.com/fekalegi/multi-tenant-system/internal/tenant"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+// newRetentionHarness returns a harness whose tenant has the given retention policy.
+func newRetentionHarness(t *testing.T, policy domain.RetentionPolicy) *harness {
+	h := newHarness(t, ack)
+	require.NoError(t, h.manager.UpdateRetention(context.Background(), h.tenantID, policy))
+	return h
+}
+
+// store adds a message created age ago with the given status.
+func (h *harness) store(age time.Duration, status string) uuid.UUID {
+	id := uuid.New()
+	h.insert(id, 0, age)
+	if status != domain.MessageStatusQueued {
+		require.NoError(h.t, h.messages.UpdateMessageStatus(context.Background(), uuid.MustParse(h.tenantID), id, status, ""))
+	}
+	return id
+}
+
+// run runs the retention job until it applied the policy once.
+func (h *harness) run(batchSize int) domain.RetentionStats {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		h.manager.RunRetention(ctx, tenant.RetentionOptions{Interval: 10 * time.Millisecond, BatchSize: batchSize})
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	var stats domain.RetentionStats
+	require.Eventually(h.t, func() bool {
+		_, s, err := h.manager.GetRetention(context.Background(), h.tenantID)
+		stats = s
+		return err == nil && s.LastRunAt != nil
+	}, 5*time.Second, 10*time.Millisecond, "the retention job should run")
+	return stats
+}
+
+func (h *harness) remaining() []uuid.UUID {
+	page, _, err := h.messages.GetMessages(context.Background(), domain.MessageFilter{TenantID: uuid.MustParse(h.tenantID)}, domain.MessagePage{Limit: 100})
+	require.NoError(h.t, err)
+	ids := make([]uuid.UUID, len(page))
+	for i, m := range page {
+		ids[i] = m.ID
+	}
+	return ids
+}
+
+func TestRetention_When_MoreExpireThanOneBatch_Then_EveryBatchIsRemoved(t *testing.T) {
+	h := newRetentionHarness(t, domain.RetentionPolicy{MaxAge: time.Hour})
+	for range 5 {
+		h.store(2*time.Hour, domain.MessageStatusProcessed)
+	}
+	kept := h.store(time.Minute, domain.MessageStatusProcessed)
+
+	stats := h.run(2)
+	require.EqualValues(t, 5, stats.TotalRemoved, "batches should be removed until one comes up short")
+	require.Empty(t, stats.LastError)
+	require.Equal(t, []uuid.UUID{kept}, h.remaining())
+}
+
+func TestRetention_When_MessagesAreUnsettled_Then_TheyAreKept(t *testing.T) {
+	h := newRetentionHarness(t, domain.RetentionPolicy{MaxAge: time.Hour})
+	queued := h.store(3*time.Hour, domain.MessageStatusQueued)
+	processing := h.store(2*time.Hour, domain.MessageStatusProcessing)
+	h.store(2*time.Hour, domain.MessageStatusFailed)
+
+	stats := h.run(10)
+	require.EqualValues(t, 1, stats.TotalRemoved)
+	require.Equal(t, []uuid.UUID{queued, processing}, h.remaining())
+}
+
+func TestRetention_When_PolicyLimitsApply_Then_TheStricterCutoffWins(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy domain.RetentionPolicy
+		// kept are the indexes of the messages, oldest first, that survive
+		kept []int
+	}{
+		{name: "rows only", policy: domain.RetentionPolicy{MaxRows: 3}, kept: []int{2, 3, 4}},
+		{name: "age only", policy: domain.RetentionPolicy{MaxAge: 150 * time.Minute}, kept: []int{2, 3, 4}},
+		{name: "age stricter than rows", policy: domain.RetentionPolicy{MaxAge: 90 * time.Minute, MaxRows: 3}, kept: []int{3, 4}},
+		{name: "rows stricter than age", policy: domain.RetentionPolicy{MaxAge: 10 * time.Hour, MaxRows: 1}, kept: []int{4}},
+		{name: "nothing beyond the limits", policy: domain.RetentionPolicy{MaxAge: 10 * time.Hour, MaxRows: 5}, kept: []int{0, 1, 2, 3, 4}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newRetentionHarness(t, tt.policy)
+
+			// Created 4h, 3h, 2h, 1h and 1m ago
+			var ids []uuid.UUID
+			for _, age := range []time.Duration{4 * time.Hour, 3 * time.Hour, 2 * time.Hour, time.Hour, time.Minute} {
+				ids = append(ids, h.store(age, domain.MessageStatusProcessed))
+			}
+
+			stats := h.run(10)
+
+			var kept []uuid.UUID
+			for _, i := range tt.kept {
+				kept = append(kept, ids[i])
+			}
+			require.Equal(t, kept, h.remaining())
+			require.EqualValues(t, len(ids)-len(kept), stats.TotalRemoved)
+		})
+	}
+}
+
+func TestRetention_When_TenantIsDeleted_Then_ItsMetricIsDropped(t *testing.T) {
+	h := newRetentionHarness(t, domain.RetentionPolicy{MaxAge: time.Hour})
+	h.store(2*time.Hour, domain.MessageStatusProcessed)
+	h.run(10)
+
+	removed := expvar.Get("retention_rows_removed").(*expvar.Map)
+	require.NotNil(t, removed.Get(h.tenantID))
+
+	require.NoError(t, h.manager.DeleteTenant(context.Background(), h.tenantID))
+	require.Nil(t, removed.Get(h.tenantID))
+}
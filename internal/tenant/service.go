@@ -4,118 +4,236 @@ import (
 	"context"
 	"fmt"
 	"github.com/fekalegi/multi-tenant-system/internal/domain"
+	"github.com/fekalegi/multi-tenant-system/internal/processor"
 	message2 "github.com/fekalegi/multi-tenant-system/internal/repository/postgresql"
 	"github.com/google/uuid"
-	"github.com/streadway/amqp"
 	"os/signal"
+	"sort"
+	"strings"
 	"sync"
 	"syscall"
 	"time"
 
-	"github.com/fekalegi/multi-tenant-system/internal/rabbitmq"
-	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/fekalegi/multi-tenant-system/internal/broker"
 	"github.com/rs/zerolog"
 )
 
 type Manager struct {
-	mu         sync.RWMutex
-	consumers  map[string]*tenantConsumer
-	Rmq        *rabbitmq.Connection
-	db         *pgxpool.Pool
-	Log        zerolog.Logger
-	defaultWkr int
-	tenantRepo message2.TenantRepository
-	msgRepo    message2.MessageRepository
+	mu              sync.RWMutex
+	consumers       map[string]*tenantConsumer
+	Broker          broker.Broker
+	Log             zerolog.Logger
+	defaultWkr      int
+	defaultPrefetch int
+	retry           broker.RetryPolicy
+	tenantRepo      message2.TenantRepository
+	msgRepo         message2.MessageRepository
+	processors      *processor.Registry
+	retentionStats  retentionStats
 }
 
-type tenantConsumer struct {
-	cancelFunc context.CancelFunc
-	workers    int
+// NewTenantService creates the tenant manager. Tenant queues live on the broker, the
+// tenant registry and the messages in the repositories, and tenant processor chains
+// are built from the processor types of the registry.
+func NewTenantService(b broker.Broker, tenants message2.TenantRepository, messages message2.MessageRepository, log zerolog.Logger, defaultWkr, defaultPrefetch int, retry broker.RetryPolicy, processors *processor.Registry) *Manager {
+	m := &Manager{
+		consumers:       make(map[string]*tenantConsumer),
+		Broker:          b,
+		Log:             log,
+		defaultWkr:      defaultWkr,
+		defaultPrefetch: defaultPrefetch,
+		retry:           retry,
+		msgRepo:         messages,
+		tenantRepo:      tenants,
+		processors:      processors,
+	}
+
+	// Consumers resubscribe on their own once the connection is back, but a broker
+	// that lost its state also needs the tenant queues declared again
+	b.OnReconnect(m.recoverTopology)
+
+	return m
 }
 
-func NewTenantService(rmq *rabbitmq.Connection, db *pgxpool.Pool, log zerolog.Logger, defaultWkr int) *Manager {
-	return &Manager{
-		consumers:  make(map[string]*tenantConsumer),
-		Rmq:        rmq,
-		db:         db,
-		Log:        log,
-		defaultWkr: defaultWkr,
-		msgRepo:    message2.NewMessageRepository(db),
-		tenantRepo: message2.NewTenantRepository(db),
+// CreateTenant registers a tenant and starts its consumer with the given processor
+// chain. Zero values in cfg fall back to the configured defaults, an empty chain to
+// the default chain.
+func (m *Manager) CreateTenant(ctx context.Context, id string, name string, cfg domain.ConcurrencyConfig, processors []domain.ProcessorConfig) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if len(processors) == 0 {
+		processors = domain.DefaultProcessors()
+	}
+	chain, err := m.processors.Build(processors)
+	if err != nil {
+		return err
+	}
+
+	if cfg.Workers <= 0 {
+		cfg.Workers = m.defaultWkr
+	}
+	if cfg.Prefetch <= 0 {
+		cfg.Prefetch = m.defaultPrefetch
+	}
+
+	tenantUUID, err := uuid.Parse(id)
+	if err != nil {
+		return fmt.Errorf("invalid tenant id: %w", err)
 	}
-}
 
-func (m *Manager) CreateTenant(ctx context.Context, id string, name string) error {
-	err := m.tenantRepo.CreatePartitionForTenant(ctx, id)
+	err = m.tenantRepo.CreatePartitionForTenant(ctx, id)
 	if err != nil {
 		m.Log.Error().Err(err).Str("tenant_id", id).Msg("Failed to create tenant")
 		return err
 	}
 
-	// Queue name
-	queueName := fmt.Sprintf("tenant_%s_queue", id)
+	if err := m.Broker.DeclareTenantQueue(ctx, id); err != nil {
+		m.undoCreate(id, false)
+		return err
+	}
 
-	// Create queue
-	ch, err := m.Rmq.Channel()
+	// Persist tenant so it survives restarts
+	now := time.Now()
+	err = m.tenantRepo.SaveTenant(ctx, &domain.Tenant{
+		ID:         tenantUUID,
+		Name:       name,
+		Workers:    cfg.Workers,
+		Prefetch:   cfg.Prefetch,
+		Status:     domain.TenantStatusActive,
+		Processors: processors,
+		CreatedAt:  now,
+		UpdatedAt:  now,
+	})
 	if err != nil {
-		return fmt.Errorf("failed to open channel: %w", err)
+		m.Log.Error().Err(err).Str("tenant_id", id).Msg("Failed to persist tenant")
+		m.undoCreate(id, true)
+		return err
 	}
-	defer ch.Close()
 
-	_, err = ch.QueueDeclare(
-		queueName,
-		true,  // durable
-		false, // autoDelete
-		false, // exclusive
-		false,
-		nil,
-	)
+	m.runConsumer(id, cfg.Workers, cfg.Prefetch, chain)
+	m.Log.Info().Str("tenant_id", id).Str("name", name).Msg("Tenant created and consumer started")
+
+	return nil
+}
+
+// undoCreate removes the partition and, when it was declared, the queue of a tenant
+// whose creation failed, so nothing is left behind that no registry entry refers to.
+// It uses its own context, the creation's may be what failed.
+func (m *Manager) undoCreate(id string, queueDeclared bool) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if queueDeclared {
+		if err := m.Broker.DeleteTenantQueue(ctx, id); err != nil {
+			m.Log.Error().Err(err).Str("tenant_id", id).Msg("Failed to delete queue of tenant whose creation failed")
+		}
+	}
+	if err := m.tenantRepo.DeletePartitionForTenant(ctx, id); err != nil {
+		m.Log.Error().Err(err).Str("tenant_id", id).Msg("Failed to drop partition of tenant whose creation failed")
+	}
+}
+
+// RestoreTenants reloads the tenant registry and restarts each tenant's consumer
+// with its saved concurrency. It must be called before the HTTP server accepts traffic.
+func (m *Manager) RestoreTenants(ctx context.Context) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	tenants, _, err := m.tenantRepo.ListTenants(ctx, domain.TenantFilter{})
 	if err != nil {
-		return fmt.Errorf("queue declare failed: %w", err)
+		return err
 	}
 
-	// Context for cancel
-	ctxConsumer, cancel := context.WithCancel(context.Background())
+	for _, t := range tenants {
+		id := t.ID.String()
+
+		if t.Status == domain.TenantStatusDeleting {
+			// A previous deletion was interrupted, finish it
+			if err := m.removeTenant(ctx, id); err != nil {
+				m.Log.Error().Err(err).Str("tenant_id", id).Msg("Failed to finish tenant deletion")
+			}
+			continue
+		}
+
+		if err := m.Broker.DeclareTenantQueue(ctx, id); err != nil {
+			return fmt.Errorf("restore tenant %s: %w", id, err)
+		}
+
+		workers, prefetch := t.Workers, t.Prefetch
+		if workers <= 0 {
+			workers = m.defaultWkr
+		}
+		if prefetch <= 0 {
+			prefetch = m.defaultPrefetch
+		}
 
-	// Start consumer goroutine
-	go m.startConsumer(ctxConsumer, id, queueName, m.defaultWkr)
+		processors := t.Processors
+		if len(processors) == 0 {
+			processors = domain.DefaultProcessors()
+		}
+		chain, err := m.processors.Build(processors)
+		if err != nil {
+			return fmt.Errorf("restore tenant %s: %w", id, err)
+		}
 
-	// Track tenant
-	m.consumers[id] = &tenantConsumer{
-		cancelFunc: cancel,
-		workers:    m.defaultWkr,
+		m.runConsumer(id, workers, prefetch, chain)
+		m.Log.Info().Str("tenant_id", id).Int("workers", workers).Int("prefetch", prefetch).Msg("Tenant consumer restored")
 	}
-	m.Log.Info().Str("tenant_id", id).Str("name", name).Msg("Tenant created and consumer started")
 
+	m.Log.Info().Int("tenants", len(tenants)).Msg("Tenant registry restored")
 	return nil
 }
 
+// deleteTimeout bounds draining and removing a deleted tenant. It does not depend on
+// the caller's context, so a client giving up does not leave the deletion half done.
+const deleteTimeout = 30 * time.Second
+
+// DeleteTenant drains the tenant's consumer and removes its queue, partition and
+// registry entry. Deleting a tenant whose deletion was interrupted finishes it.
 func (m *Manager) DeleteTenant(ctx context.Context, id string) error {
 	m.mu.Lock()
-	defer m.mu.Unlock()
-
 	consumer, ok := m.consumers[id]
+	if ok {
+		if err := m.tenantRepo.UpdateTenantStatus(ctx, id, domain.TenantStatusDeleting); err != nil {
+			m.mu.Unlock()
+			return err
+		}
+		// Gone for everyone else before it drains, without holding them up meanwhile
+		delete(m.consumers, id)
+	}
+	m.mu.Unlock()
+
 	if !ok {
-		return fmt.Errorf("tenant not found")
+		t, err := m.tenantRepo.GetTenant(ctx, id)
+		if err != nil {
+			return err
+		}
+		if t.Status != domain.TenantStatusDeleting {
+			return domain.ErrTenantNotFound
+		}
 	}
 
-	// Signal shutdown
-	consumer.cancelFunc()
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), deleteTimeout)
+	defer cancel()
 
-	// Delete queue
-	ch, err := m.Rmq.Channel()
-	if err != nil {
-		return fmt.Errorf("failed to open channel: %w", err)
+	if consumer != nil {
+		// Let the consumer finish what it already received
+		if err := consumer.shutdown(ctx); err != nil {
+			m.Log.Warn().Err(err).Str("tenant_id", id).Msg("Consumer did not drain before deletion")
+		}
 	}
-	defer ch.Close()
+	return m.removeTenant(ctx, id)
+}
 
-	queueName := fmt.Sprintf("tenant_%s_queue", id)
-	_, err = ch.QueueDelete(queueName, false, false, false)
+// removeTenant deletes the queue, the partition and the registry entry of a tenant
+// whose consumer has already been stopped.
+func (m *Manager) removeTenant(ctx context.Context, id string) error {
+	// Delete queues
+	err := m.Broker.DeleteTenantQueue(ctx, id)
 	if err != nil {
-		return fmt.Errorf("queue delete failed: %w", err)
+		return err
 	}
-
-	delete(m.consumers, id)
 	m.Log.Info().Str("tenant_id", id).Msg("Tenant consumer stopped and queue deleted")
 
 	err = m.tenantRepo.DeletePartitionForTenant(ctx, id)
@@ -124,98 +242,157 @@ func (m *Manager) DeleteTenant(ctx context.Context, id string) error {
 		return err
 	}
 	m.Log.Info().Str("tenant_id", id).Msg("Partition for the tenat has dropped")
-	return nil
+
+	m.retentionStats.forget(id)
+	return m.tenantRepo.DeleteTenant(ctx, id)
 }
 
-func (m *Manager) UpdateConcurrency(tenantID string, newWorkerCount int) error {
+// UpdateConcurrency resizes the worker pool of a tenant's consumer in place and, when
+// cfg.Prefetch is set, changes its prefetch. It returns the settings now in effect.
+func (m *Manager) UpdateConcurrency(ctx context.Context, tenantID string, cfg domain.ConcurrencyConfig) (domain.ConcurrencyConfig, error) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
 	tc, ok := m.consumers[tenantID]
 	if !ok {
-		return fmt.Errorf("tenant not found")
+		return domain.ConcurrencyConfig{}, domain.ErrTenantNotFound
 	}
 
-	// Replace the consumer with new config
-	tc.cancelFunc()
-	m.Log.Info().Str("tenant_id", tenantID).Msg("Restarting consumer with new concurrency")
+	if cfg.Prefetch <= 0 {
+		cfg.Prefetch = tc.currentPrefetch()
+	}
 
-	ctx, cancel := context.WithCancel(context.Background())
-	m.consumers[tenantID] = &tenantConsumer{
-		cancelFunc: cancel,
-		workers:    newWorkerCount,
+	if err := m.tenantRepo.UpdateTenantConcurrency(ctx, tenantID, cfg.Workers, cfg.Prefetch); err != nil {
+		return domain.ConcurrencyConfig{}, err
 	}
 
-	go m.startConsumer(ctx, tenantID, fmt.Sprintf("tenant_%s_queue", tenantID), newWorkerCount)
-	return nil
+	tc.resize(cfg.Workers)
+	tc.setPrefetch(cfg.Prefetch)
+	m.Log.Info().Str("tenant_id", tenantID).Int("workers", cfg.Workers).Int("prefetch", cfg.Prefetch).Msg("Consumer concurrency updated")
+
+	return domain.ConcurrencyConfig{Workers: tc.workerCount(), Prefetch: tc.currentPrefetch()}, nil
 }
 
-func (m *Manager) startConsumer(ctx context.Context, tenantID, queue string, workers int) {
-	ch, err := m.Rmq.Channel()
+// ListTenants returns the registered tenants matching the filter with their runtime
+// state, together with the total number of matches.
+func (m *Manager) ListTenants(ctx context.Context, filter domain.TenantFilter) ([]*domain.TenantInfo, int, error) {
+	tenants, total, err := m.tenantRepo.ListTenants(ctx, filter)
 	if err != nil {
-		m.Log.Error().Err(err).Msg("Failed to open channel")
-		return
+		return nil, 0, err
 	}
 
-	msgs, err := ch.Consume(
-		queue,
-		"consumer-"+tenantID,
-		true,  // auto-ack
-		false, // exclusive
-		false,
-		false,
-		nil,
-	)
+	infos := make([]*domain.TenantInfo, 0, len(tenants))
+	for _, t := range tenants {
+		infos = append(infos, &domain.TenantInfo{
+			Tenant:  *t,
+			Runtime: m.runtime(ctx, t.ID.String()),
+		})
+	}
+	return infos, total, nil
+}
+
+// GetTenant returns a registered tenant with its runtime state.
+func (m *Manager) GetTenant(ctx context.Context, tenantID string) (*domain.TenantInfo, error) {
+	t, err := m.tenantRepo.GetTenant(ctx, tenantID)
 	if err != nil {
-		m.Log.Error().Err(err).Msg("Failed to start consuming")
-		return
+		return nil, err
 	}
 
-	// Worker pool
-	jobs := make(chan amqp.Delivery, 100)
+	return &domain.TenantInfo{
+		Tenant:  *t,
+		Runtime: m.runtime(ctx, tenantID),
+	}, nil
+}
 
-	// Start N workers
-	for i := 0; i < workers; i++ {
-		go func(workerID int) {
-			for {
-				select {
-				case msg := <-jobs:
+// runtime reports whether the tenant's consumer is running and inspects its queue.
+func (m *Manager) runtime(ctx context.Context, tenantID string) domain.TenantRuntime {
+	var rt domain.TenantRuntime
 
-					messageID := uuid.New()
-					tenantUUID, _ := uuid.Parse(tenantID)
+	m.mu.RLock()
+	if tc, ok := m.consumers[tenantID]; ok {
+		rt.ConsumerRunning = tc.running.Load()
+		rt.ActiveWorkers = tc.workerCount()
+	}
+	m.mu.RUnlock()
 
-					m.Log.Info().
-						Str("worker", fmt.Sprint(workerID)).
-						Str("tenant_id", tenantID).
-						Str("msg_id", messageID.String()).
-						Msg("Processing message")
+	q, err := m.Broker.Inspect(ctx, tenantID)
+	if err != nil {
+		m.Log.Warn().Err(err).Str("tenant_id", tenantID).Msg("Failed to inspect tenant queue")
+		return rt
+	}
 
-					_ = m.msgRepo.InsertMessage(ctx, &domain.Message{
-						ID:        messageID,
-						TenantID:  tenantUUID,
-						Payload:   msg.Body,
-						CreatedAt: time.Now(),
-					})
+	rt.QueueDepth = q.Messages
+	rt.QueueConsumers = q.Consumers
+	return rt
+}
 
-				case <-ctx.Done():
-					return
-				}
-			}
-		}(i)
-	}
-
-	// Fan in RabbitMQ deliveries into jobs
-	for {
-		select {
-		case <-ctx.Done():
-			ch.Close()
-			close(jobs)
-			m.Log.Info().Str("tenant_id", tenantID).Msg("Consumer shutdown")
-			return
-		case msg := <-msgs:
-			jobs <- msg
+// ListDeadLetters returns up to limit dead-lettered messages of a tenant.
+func (m *Manager) ListDeadLetters(tenantID string, limit int) ([]*domain.DeadLetter, error) {
+	if !m.hasTenant(tenantID) {
+		return nil, domain.ErrTenantNotFound
+	}
+	return m.Broker.DeadLetters().List(tenantID, limit)
+}
+
+// GetDeadLetter returns a single dead-lettered message of a tenant.
+func (m *Manager) GetDeadLetter(tenantID, messageID string) (*domain.DeadLetter, error) {
+	if !m.hasTenant(tenantID) {
+		return nil, domain.ErrTenantNotFound
+	}
+	return m.Broker.DeadLetters().Get(tenantID, messageID)
+}
+
+// DeleteDeadLetter discards a dead-lettered message of a tenant.
+func (m *Manager) DeleteDeadLetter(tenantID, messageID string) error {
+	if !m.hasTenant(tenantID) {
+		return domain.ErrTenantNotFound
+	}
+	return m.Broker.DeadLetters().Delete(tenantID, messageID)
+}
+
+// ReplayDeadLetter moves a dead-lettered message back onto the tenant queue.
+func (m *Manager) ReplayDeadLetter(tenantID, messageID string) error {
+	if !m.hasTenant(tenantID) {
+		return domain.ErrTenantNotFound
+	}
+	m.Log.Info().Str("tenant_id", tenantID).Str("msg_id", messageID).Msg("Replaying dead-lettered message")
+	return m.Broker.DeadLetters().Replay(tenantID, messageID)
+}
+
+// ReplayDeadLetters moves every dead-lettered message of a tenant back onto the tenant queue.
+func (m *Manager) ReplayDeadLetters(tenantID string) (int, error) {
+	if !m.hasTenant(tenantID) {
+		return 0, domain.ErrTenantNotFound
+	}
+	replayed, err := m.Broker.DeadLetters().ReplayAll(tenantID)
+	m.Log.Info().Str("tenant_id", tenantID).Int("replayed", replayed).Msg("Replayed dead-lettered messages")
+	return replayed, err
+}
+
+func (m *Manager) hasTenant(tenantID string) bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	_, ok := m.consumers[tenantID]
+	return ok
+}
+
+// recoverTopology re-declares the queues of every registered tenant after the broker
+// connection was re-established.
+func (m *Manager) recoverTopology() {
+	m.mu.RLock()
+	tenantIDs := make([]string, 0, len(m.consumers))
+	for id := range m.consumers {
+		tenantIDs = append(tenantIDs, id)
+	}
+	m.mu.RUnlock()
+
+	for _, id := range tenantIDs {
+		if err := m.Broker.DeclareTenantQueue(context.Background(), id); err != nil {
+			m.Log.Error().Err(err).Str("tenant_id", id).Msg("Failed to re-declare tenant queues after reconnect")
 		}
 	}
+	m.Log.Info().Int("tenants", len(tenantIDs)).Msg("Tenant queues re-declared after reconnect")
 }
 
 func (m *Manager) ListenAndShutdown(timeout time.Duration) {
@@ -228,40 +405,37 @@ func (m *Manager) ListenAndShutdown(timeout time.Duration) {
 	shutdownCtx, cancel := context.WithTimeout(context.Background(), timeout)
 	defer cancel()
 
-	m.mu.Lock()
-	defer m.mu.Unlock()
-
-	var wg sync.WaitGroup
-
-	for tenantID, consumer := range m.consumers {
-		wg.Add(1)
-
-		go func(id string, c *tenantConsumer) {
-			defer wg.Done()
-
-			// Cancel the context
-			c.cancelFunc()
-			m.Log.Info().Str("tenant_id", id).Msg("Consumer cancelled")
-
-			select {
-			case <-shutdownCtx.Done():
-				m.Log.Warn().Str("tenant_id", id).Msg("Shutdown context expired before cleanup")
-			case <-time.After(500 * time.Millisecond):
-			}
-		}(tenantID, consumer)
+	if err := m.ShutdownConsumers(shutdownCtx); err != nil {
+		m.Log.Warn().Err(err).Msg("Tenant consumers did not shut down cleanly")
+		return
 	}
-
-	wg.Wait()
 	m.Log.Info().Msg("All tenant consumers shutdown cleanly")
 }
 
-func (m *Manager) ShutdownConsumers(ctx context.Context) {
+// DrainError lists the tenants whose consumers did not finish their in-flight
+// messages before the shutdown deadline. Their unfinished messages are requeued.
+type DrainError struct {
+	Tenants []string
+}
+
+func (e *DrainError) Error() string {
+	return fmt.Sprintf("consumers of %d tenant(s) failed to drain: %s", len(e.Tenants), strings.Join(e.Tenants, ", "))
+}
+
+// ShutdownConsumers stops every tenant consumer and blocks until they have finished
+// their in-flight messages or ctx expires. Consumers still busy at the deadline are
+// aborted and reported in a *DrainError.
+func (m *Manager) ShutdownConsumers(ctx context.Context) error {
 	m.Log.Info().Msg("Shutting down all tenant consumers...")
 
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
-	var wg sync.WaitGroup
+	var (
+		wg     sync.WaitGroup
+		failMu sync.Mutex
+		failed []string
+	)
 
 	for tenantID, consumer := range m.consumers {
 		wg.Add(1)
@@ -269,17 +443,26 @@ func (m *Manager) ShutdownConsumers(ctx context.Context) {
 		go func(id string, c *tenantConsumer) {
 			defer wg.Done()
 
-			// Cancel the consumer's personal context to stop its work
-			c.cancelFunc()
-
 			m.Log.Info().Str("tenant_id", id).Msg("Consumer shutdown process initiated")
 
-			// You can add more sophisticated waiting logic here if needed,
-			// but for now, cancelling is the main action.
+			if err := c.shutdown(ctx); err != nil {
+				m.Log.Warn().Err(err).Str("tenant_id", id).Msg("Consumer did not drain before the deadline")
+
+				failMu.Lock()
+				failed = append(failed, id)
+				failMu.Unlock()
+			}
 		}(tenantID, consumer)
 	}
 
-	// Wait for all the shutdown goroutines to finish
+	// Wait for every consumer to drain or be aborted
 	wg.Wait()
-	m.Log.Info().Msg("All tenant consumers have been signaled to shut down.")
+
+	if len(failed) > 0 {
+		sort.Strings(failed)
+		return &DrainError{Tenants: failed}
+	}
+
+	m.Log.Info().Msg("All tenant consumers have drained.")
+	return nil
 }
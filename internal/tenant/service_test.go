@@ -0,0 +1,248 @@
+package tenant_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/fekalegi/multi-tenant-system/internal/broker"
+	"github.com/fekalegi/multi-tenant-system/internal/domain"
+	"github.com/fekalegi/multi-tenant-system/internal/processor"
+	"github.com/fekalegi/multi-tenant-system/internal/repository/memory"
+	message2 "github.com/fekalegi/multi-tenant-system/internal/repository/postgresql"
+	"github.com/fekalegi/multi-tenant-system/internal/tenant"
+	"github.com/google/uuid"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/require"
+)
+
+// failingSave is a tenant repository whose SaveTenant fails.
+type failingSave struct {
+	message2.TenantRepository
+}
+
+func (failingSave) SaveTenant(context.Context, *domain.Tenant) error {
+	return errors.New("registry unavailable")
+}
+
+// ack is a "test" processor acking every message.
+func ack(context.Context, *processor.Delivery) (processor.Action, error) {
+	return processor.Ack, nil
+}
+
+// newManager returns a tenant manager on b and the given repositories, whose chains
+// run the "test" processor type as process.
+func newManager(t *testing.T, b broker.Broker, tenants message2.TenantRepository, messages message2.MessageRepository, process processor.Func) *tenant.Manager {
+	processors := processor.NewRegistry(processor.Dependencies{Messages: messages, Log: zerolog.Nop()})
+	processors.Register("test", func(json.RawMessage, processor.Dependencies) (processor.Processor, error) {
+		return process, nil
+	})
+
+	retry := broker.RetryPolicy{
+		MaxAttempts:    3,
+		InitialBackoff: 10 * time.Millisecond,
+		MaxBackoff:     50 * time.Millisecond,
+	}
+	m := tenant.NewTenantService(b, tenants, messages, zerolog.Nop(), 1, 1, retry, processors)
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		require.NoError(t, m.ShutdownConsumers(ctx))
+	})
+	return m
+}
+
+// harness is one tenant of a manager on the in-memory broker and repositories. Its
+// chain is the "test" processor, which counts its calls.
+type harness struct {
+	t        *testing.T
+	manager  *tenant.Manager
+	broker   *broker.Memory
+	messages message2.MessageRepository
+	tenantID string
+
+	calls atomic.Int32
+}
+
+func newHarness(t *testing.T, process processor.Func) *harness {
+	h := &harness{t: t, broker: broker.NewMemory(), tenantID: uuid.NewString()}
+	t.Cleanup(h.broker.Close)
+
+	store := memory.NewStore()
+	h.messages = memory.NewMessageRepository(store)
+	h.manager = newManager(t, h.broker, memory.NewTenantRepository(store), h.messages, func(ctx context.Context, d *processor.Delivery) (processor.Action, error) {
+		h.calls.Add(1)
+		return process(ctx, d)
+	})
+
+	err := h.manager.CreateTenant(context.Background(), h.tenantID, "harness-test", domain.ConcurrencyConfig{}, []domain.ProcessorConfig{{Type: "test"}})
+	require.NoError(t, err)
+	return h
+}
+
+// insert stores a message of the harness's tenant created age ago.
+func (h *harness) insert(id uuid.UUID, priority uint8, age time.Duration) {
+	msg := &domain.Message{
+		ID:        id,
+		TenantID:  uuid.MustParse(h.tenantID),
+		Payload:   json.RawMessage(`{"n": 1}`),
+		Priority:  priority,
+		CreatedAt: time.Now().Add(-age),
+	}
+	require.NoError(h.t, h.messages.InsertMessage(context.Background(), msg))
+}
+
+// awaitStatus waits for a message to reach status and returns it.
+func (h *harness) awaitStatus(id uuid.UUID, status string) *domain.Message {
+	var msg *domain.Message
+	require.Eventually(h.t, func() bool {
+		m, err := h.messages.GetMessage(context.Background(), uuid.MustParse(h.tenantID), id)
+		if err != nil || m.Status != status {
+			return false
+		}
+		msg = m
+		return true
+	}, 5*time.Second, 10*time.Millisecond, "message should become %s", status)
+	return msg
+}
+
+func TestCreateTenant_When_SaveFails_Then_PartitionAndQueueAreRemoved(t *testing.T) {
+	ctx := context.Background()
+	b := broker.NewMemory()
+	store := memory.NewStore()
+	tenants := memory.NewTenantRepository(store)
+	m := newManager(t, b, failingSave{tenants}, memory.NewMessageRepository(store), ack)
+
+	id := uuid.NewString()
+	err := m.CreateTenant(ctx, id, "unsaved", domain.ConcurrencyConfig{}, []domain.ProcessorConfig{{Type: "test"}})
+	require.Error(t, err)
+
+	// The partition is gone, so it can be created again
+	require.NoError(t, tenants.CreatePartitionForTenant(ctx, id))
+	require.ErrorIs(t, b.Publish(ctx, id, broker.Message{ID: "m", Body: []byte(`{}`)}), broker.ErrUnroutable)
+}
+
+func TestRestoreTenants_When_Restarted_Then_ConsumersResumeAndDeletionsFinish(t *testing.T) {
+	ctx := context.Background()
+	b := broker.NewMemory()
+	store := memory.NewStore()
+	tenants := memory.NewTenantRepository(store)
+	messages := memory.NewMessageRepository(store)
+	chain := []domain.ProcessorConfig{{Type: "test"}}
+
+	before := newManager(t, b, tenants, messages, ack)
+	kept, deleting := uuid.NewString(), uuid.NewString()
+	require.NoError(t, before.CreateTenant(ctx, kept, "kept", domain.ConcurrencyConfig{Workers: 2, Prefetch: 4}, chain))
+	require.NoError(t, before.CreateTenant(ctx, deleting, "deleting", domain.ConcurrencyConfig{}, chain))
+	require.NoError(t, before.ShutdownConsumers(ctx))
+
+	// The instance died while deleting the second tenant
+	require.NoError(t, tenants.UpdateTenantStatus(ctx, deleting, domain.TenantStatusDeleting))
+
+	after := newManager(t, b, tenants, messages, ack)
+	require.NoError(t, after.RestoreTenants(ctx))
+
+	info, err := after.GetTenant(ctx, kept)
+	require.NoError(t, err)
+	require.Equal(t, 4, info.Prefetch)
+	require.Eventually(t, func() bool {
+		info, err := after.GetTenant(ctx, kept)
+		return err == nil && info.Runtime.ConsumerRunning && info.Runtime.ActiveWorkers == 2
+	}, 5*time.Second, 10*time.Millisecond, "consumer should be restored with its saved workers")
+
+	_, err = after.GetTenant(ctx, deleting)
+	require.ErrorIs(t, err, domain.ErrTenantNotFound)
+	require.ErrorIs(t, b.Publish(ctx, deleting, broker.Message{ID: "m", Body: []byte(`{}`)}), broker.ErrUnroutable)
+
+	// The restored consumer processes what is published to the tenant
+	id := uuid.New()
+	require.NoError(t, messages.InsertMessage(ctx, &domain.Message{ID: id, TenantID: uuid.MustParse(kept), Payload: json.RawMessage(`{}`), CreatedAt: time.Now()}))
+	require.NoError(t, b.Publish(ctx, kept, broker.Message{ID: id.String(), Body: []byte(`{}`)}))
+	require.Eventually(t, func() bool {
+		msg, err := messages.GetMessage(ctx, uuid.MustParse(kept), id)
+		return err == nil && msg.Status == domain.MessageStatusProcessed
+	}, 5*time.Second, 10*time.Millisecond, "message should be processed by the restored consumer")
+}
+
+func TestDeleteTenant_When_DeletionWasInterrupted_Then_RepeatingItFinishes(t *testing.T) {
+	ctx := context.Background()
+	b := broker.NewMemory()
+	store := memory.NewStore()
+	tenants := memory.NewTenantRepository(store)
+	messages := memory.NewMessageRepository(store)
+
+	before := newManager(t, b, tenants, messages, ack)
+	id := uuid.NewString()
+	require.NoError(t, before.CreateTenant(ctx, id, "deleting", domain.ConcurrencyConfig{}, []domain.ProcessorConfig{{Type: "test"}}))
+	require.NoError(t, before.ShutdownConsumers(ctx))
+
+	// Left marked for deletion, without a consumer, by a deletion that gave up
+	require.NoError(t, tenants.UpdateTenantStatus(ctx, id, domain.TenantStatusDeleting))
+	after := newManager(t, b, tenants, messages, ack)
+
+	require.NoError(t, after.DeleteTenant(ctx, id))
+	_, err := tenants.GetTenant(ctx, id)
+	require.ErrorIs(t, err, domain.ErrTenantNotFound)
+	require.ErrorIs(t, b.Publish(ctx, id, broker.Message{ID: "m", Body: []byte(`{}`)}), broker.ErrUnroutable)
+	require.ErrorIs(t, after.DeleteTenant(ctx, id), domain.ErrTenantNotFound)
+}
+
+func TestDeleteTenant_When_ConsumerDrains_Then_TheTenantIsGoneAtOnceAndTheRequestMayEnd(t *testing.T) {
+	release := make(chan struct{})
+	h := newHarness(t, func(context.Context, *processor.Delivery) (processor.Action, error) {
+		<-release
+		return processor.Ack, nil
+	})
+	t.Cleanup(func() {
+		select {
+		case <-release:
+		default:
+			close(release)
+		}
+	})
+	id := uuid.New()
+	h.publish(id)
+	h.awaitStatus(id, domain.MessageStatusProcessing)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	deleted := make(chan error, 1)
+	go func() { deleted <- h.manager.DeleteTenant(ctx, h.tenantID) }()
+
+	// Other calls are not held up by the drain
+	require.Eventually(t, func() bool {
+		_, err := h.manager.ListDeadLetters(h.tenantID, 10)
+		return errors.Is(err, domain.ErrTenantNotFound)
+	}, 5*time.Second, 10*time.Millisecond, "the tenant should be gone while it drains")
+	info, err := h.manager.GetTenant(context.Background(), h.tenantID)
+	require.NoError(t, err)
+	require.Equal(t, domain.TenantStatusDeleting, info.Status)
+
+	// The client gives up, the deletion goes on
+	cancel()
+	close(release)
+	require.NoError(t, <-deleted)
+	_, err = h.manager.GetTenant(context.Background(), h.tenantID)
+	require.ErrorIs(t, err, domain.ErrTenantNotFound)
+}
+
+func TestRestoreTenants_When_ConcurrencyIsUnset_Then_DefaultsApply(t *testing.T) {
+	ctx := context.Background()
+	store := memory.NewStore()
+	tenants := memory.NewTenantRepository(store)
+	messages := memory.NewMessageRepository(store)
+
+	// Registered by the migration for a partition older than the registry
+	id := uuid.New()
+	require.NoError(t, tenants.CreatePartitionForTenant(ctx, id.String()))
+	require.NoError(t, tenants.SaveTenant(ctx, &domain.Tenant{ID: id, Name: id.String(), Status: domain.TenantStatusActive, CreatedAt: time.Now()}))
+
+	m := newManager(t, broker.NewMemory(), tenants, messages, ack)
+	require.NoError(t, m.RestoreTenants(ctx))
+	require.Eventually(t, func() bool {
+		info, err := m.GetTenant(ctx, id.String())
+		return err == nil && info.Runtime.ConsumerRunning && info.Runtime.ActiveWorkers == 1
+	}, 5*time.Second, 10*time.Millisecond, "consumer should run with the default workers")
+}
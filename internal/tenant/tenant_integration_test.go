@@ -5,25 +5,37 @@ package tenant_test
 import (
 	"bytes"
 	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	message2 "github.com/fekalegi/multi-tenant-system/internal/repository/postgresql"
+	"io"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	// --- Your Project's Packages ---
+	"github.com/fekalegi/multi-tenant-system/api/dto"
 	"github.com/fekalegi/multi-tenant-system/config"
 	"github.com/fekalegi/multi-tenant-system/db"
+	"github.com/fekalegi/multi-tenant-system/internal/auth"
+	"github.com/fekalegi/multi-tenant-system/internal/broker"
+	"github.com/fekalegi/multi-tenant-system/internal/domain"
 	"github.com/fekalegi/multi-tenant-system/internal/message"
+	"github.com/fekalegi/multi-tenant-system/internal/processor"
 	"github.com/fekalegi/multi-tenant-system/internal/rabbitmq"
 	"github.com/fekalegi/multi-tenant-system/internal/server"
 	"github.com/fekalegi/multi-tenant-system/internal/tenant"
 	"github.com/fekalegi/multi-tenant-system/pkg/logger" // Adjusted path based on your structure
 
 	// --- External Dependencies ---
+	"github.com/google/uuid"
 	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/labstack/echo/v4"
 	"github.com/ory/dockertest/v3"
@@ -37,8 +49,14 @@ type IntegrationTestSuite struct {
 	suite.Suite
 	echoServer *echo.Echo
 	dbPool     *pgxpool.Pool
+	jwtManager *auth.JWTManager
 	tenantID   string
 	log        zerolog.Logger
+
+	rmqResource *dockertest.Resource
+	rmqConn     *rabbitmq.Connection
+
+	stopScheduler context.CancelFunc
 }
 
 // SetupSuite runs once before all tests in the suite to set up the environment.
@@ -65,6 +83,7 @@ func (s *IntegrationTestSuite) SetupSuite() {
 		config.RestartPolicy = docker.RestartPolicy{Name: "no"}
 	})
 	require.NoError(s.T(), err, "Could not start RabbitMQ resource")
+	s.rmqResource = rmqResource
 
 	s.T().Cleanup(func() {
 		s.log.Info().Msg("Purging test containers...")
@@ -92,35 +111,104 @@ func (s *IntegrationTestSuite) SetupSuite() {
 	require.NoError(s.T(), db.RunMigrations(s.dbPool), "Could not run migrations")
 
 	// --- Assemble the Application Stack (mirroring your main.go) ---
-	cfg := &config.Config{ /* Populate if needed */ }
-	rmqConn := rabbitmq.NewConnection(rabbitmqURL, s.log)
+	cfg := &config.Config{
+		JWTConfig:   config.JWTConfig{AdminSecret: "integration-test-admin-secret"},
+		MaxWorkers:  8,
+		MaxPrefetch: 100,
+	}
+	s.rmqConn, err = rabbitmq.NewConnection(rabbitmqURL, rabbitmq.ReconnectPolicy{
+		InitialDelay: time.Second,
+		MaxDelay:     5 * time.Second,
+	}, s.log)
+	require.NoError(s.T(), err, "Could not connect to RabbitMQ")
+
+	publisher := rabbitmq.NewPublisher(s.rmqConn, rabbitmq.PublisherOptions{
+		PoolSize:       2,
+		Wait:           time.Second,
+		ConfirmTimeout: 5 * time.Second,
+	}, s.log)
+
+	retry := broker.RetryPolicy{
+		MaxAttempts:    3,
+		InitialBackoff: 100 * time.Millisecond,
+		MaxBackoff:     time.Second,
+	}
+	b := rabbitmq.NewBroker(s.rmqConn, publisher, retry, s.log)
 
-	tenantManager := tenant.NewTenantService(rmqConn, s.dbPool, s.log, 3) // Using your constructor
-
-	publisher := rabbitmq.NewPublisher(rmqConn, s.log)
 	messageRepo := message2.NewMessageRepository(s.dbPool)
-	messageService := message.NewService(publisher, messageRepo)
+	processors := processor.NewRegistry(processor.Dependencies{
+		Messages:   messageRepo,
+		Forwarder:  b,
+		Deliveries: messageRepo,
+		Webhook: processor.WebhookDefaults{
+			Timeout: time.Second,
+			Retry: broker.RetryPolicy{
+				MaxAttempts:    2,
+				InitialBackoff: 100 * time.Millisecond,
+				MaxBackoff:     time.Second,
+			},
+		},
+		Log: s.log,
+	})
 
-	srv := server.NewServer(cfg, tenantManager, messageService, s.log)
+	tenantManager := tenant.NewTenantService(b, message2.NewTenantRepository(s.dbPool), messageRepo, s.log, 3, 10, retry, processors) // Using your constructor
+	messageService := message.NewService(b, messageRepo, "integration-test-cursor-secret", s.log)
+
+	var schedulerCtx context.Context
+	schedulerCtx, s.stopScheduler = context.WithCancel(context.Background())
+	go messageService.RunScheduler(schedulerCtx, message.SchedulerOptions{
+		Interval:   100 * time.Millisecond,
+		RetryDelay: time.Second,
+	})
+	go messageService.RunOutboxRelay(schedulerCtx, message.OutboxOptions{
+		Interval:   100 * time.Millisecond,
+		BatchSize:  100,
+		Lease:      time.Minute,
+		RetryDelay: time.Second,
+		Retention:  time.Hour,
+	})
+
+	s.jwtManager = auth.NewJWTManager("integration-test-secret", time.Hour)
+
+	srv := server.NewServer(cfg, tenantManager, messageService, s.jwtManager, s.log)
 	s.echoServer = srv.GetEcho()
 }
 
 // TearDownSuite runs once after all tests in the suite.
 func (s *IntegrationTestSuite) TearDownSuite() {
+	s.stopScheduler()
 	s.dbPool.Close()
 }
 
+// authorize signs the request with a token scoped to the tenant, or to every tenant for admins.
+func (s *IntegrationTestSuite) authorize(req *http.Request, tenantID string, platformAdmin bool) {
+	token, err := s.jwtManager.Generate("integration-test-user", tenantID, platformAdmin)
+	require.NoError(s.T(), err)
+	req.Header.Set(echo.HeaderAuthorization, "Bearer "+token)
+}
+
 // TestTenantLifecycle runs the main test sequence.
 func (s *IntegrationTestSuite) TestTenantLifecycle() {
 	s.Run("1_When_CreateTenantIsCalled_Then_PartitionAndQueueAreCreated", s.testCreateTenant)
 	s.Run("2_When_MessageIsPublished_Then_ItIsConsumedAndStored", s.testPublishAndConsumeMessage)
-	s.Run("3_When_DeleteTenantIsCalled_Then_PartitionIsDropped", s.testDeleteTenant)
+	s.Run("3_When_MessageIsFetchedAndDeleted_Then_ItIsGone", s.testGetAndDeleteMessage)
+	s.Run("4_When_AnotherTenantPublishes_Then_ItIsForbidden", s.testPublishToForeignTenant)
+	s.Run("5_When_BatchIsPublished_Then_ValidItemsAreConsumedAndStored", s.testPublishBatch)
+	s.Run("6_When_ConnectionIsLost_Then_QueuesAreRedeclaredAndConsumersRecover", s.testReconnect)
+	s.Run("7_When_MessageIsDelayed_Then_ItIsPublishedWhenDue", s.testScheduleMessage)
+	s.Run("8_When_SchemaIsRegistered_Then_MismatchingPayloadsAreRejected", s.testSchemaValidation)
+	s.Run("9_When_ProcessorChainIsReplaced_Then_MessagesAreTransformed", s.testProcessorChain)
+	s.Run("10_When_WebhookIsConfigured_Then_MessagesArePushedAndLogged", s.testWebhookDelivery)
+	s.Run("11_When_AdminAccessIsNotProven_Then_ItIsForbidden", s.testAdminAccess)
+	s.Run("12_When_ConcurrencyExceedsTheLimits_Then_ItIsRejected", s.testConcurrencyLimits)
+	s.Run("13_When_DeleteTenantIsCalled_Then_PartitionIsDropped", s.testDeleteTenant)
 }
 
 func (s *IntegrationTestSuite) testCreateTenant() {
 	body := bytes.NewBufferString(`{"name": "integration-test-tenant"}`)
 	req := httptest.NewRequest(http.MethodPost, "/api/tenants", body)
 	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	s.authorize(req, "", true)
 	rec := httptest.NewRecorder()
 
 	s.echoServer.ServeHTTP(rec, req)
@@ -137,32 +225,526 @@ func (s *IntegrationTestSuite) testCreateTenant() {
 	partitionExists, err := s.checkPartitionExists(s.tenantID)
 	require.NoError(s.T(), err)
 	require.True(s.T(), partitionExists, "Database partition for the new tenant should exist")
+
+	// LIKE wildcards in the name filter match literally
+	for name, matches := range map[string]int{"INTEGRATION-test": 1, "integration%tenant": 0, "integration_test": 0} {
+		req = httptest.NewRequest(http.MethodGet, "/api/tenants?name="+url.QueryEscape(name), nil)
+		s.authorize(req, "", true)
+		rec = httptest.NewRecorder()
+
+		s.echoServer.ServeHTTP(rec, req)
+
+		require.Equal(s.T(), http.StatusOK, rec.Code)
+		var list dto.ListTenantsResponse
+		require.NoError(s.T(), json.Unmarshal(rec.Body.Bytes(), &list))
+		require.Equal(s.T(), matches, list.Total, "tenants named like %q", name)
+	}
+
+	// A page past the last match still reports the total
+	req = httptest.NewRequest(http.MethodGet, "/api/tenants?name=integration-test&offset=5", nil)
+	s.authorize(req, "", true)
+	rec = httptest.NewRecorder()
+	s.echoServer.ServeHTTP(rec, req)
+	require.Equal(s.T(), http.StatusOK, rec.Code)
+	var page dto.ListTenantsResponse
+	require.NoError(s.T(), json.Unmarshal(rec.Body.Bytes(), &page))
+	require.Empty(s.T(), page.Data)
+	require.Equal(s.T(), 1, page.Total)
+
+	// An ID that is not a UUID is a bad request, not a database error
+	for _, path := range []string{"/api/tenants/not-a-uuid", "/api/tenants/not-a-uuid/config/retention"} {
+		req = httptest.NewRequest(http.MethodGet, path, nil)
+		s.authorize(req, "", true)
+		rec = httptest.NewRecorder()
+		s.echoServer.ServeHTTP(rec, req)
+		require.Equal(s.T(), http.StatusBadRequest, rec.Code, path)
+	}
 }
 
 func (s *IntegrationTestSuite) testPublishAndConsumeMessage() {
 	require.NotEmpty(s.T(), s.tenantID, "testCreateTenant must run first to get a tenantID")
 
 	msgBody := bytes.NewBufferString(`{"data": "hello from integration test"}`)
-	req := httptest.NewRequest(http.MethodPost, fmt.Sprintf("/api/messages/%s", s.tenantID), msgBody)
+	req := httptest.NewRequest(http.MethodPost, fmt.Sprintf("/api/messages/%s?priority=5", s.tenantID), msgBody)
 	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	s.authorize(req, s.tenantID, false)
 	rec := httptest.NewRecorder()
 
 	s.echoServer.ServeHTTP(rec, req)
 
 	require.Equal(s.T(), http.StatusOK, rec.Code)
 
+	var resp dto.PublishMessageResponse
+	require.NoError(s.T(), json.Unmarshal(rec.Body.Bytes(), &resp))
+	require.NotEmpty(s.T(), resp.ID)
+
 	// Verification: The message consumer runs in the background. We need to poll the DB.
+	var status string
+	require.Eventually(s.T(), func() bool {
+		err := s.dbPool.QueryRow(context.Background(), "SELECT status FROM messages WHERE tenant_id = $1 AND id = $2", s.tenantID, resp.ID).Scan(&status)
+		return err == nil && status == domain.MessageStatusProcessed
+	}, 5*time.Second, 200*time.Millisecond, "Message should be consumed and marked processed")
+
+	// The consumer updates the published row instead of storing a copy
 	var messageCount int
+	err := s.dbPool.QueryRow(context.Background(), "SELECT COUNT(*) FROM messages WHERE tenant_id = $1", s.tenantID).Scan(&messageCount)
+	require.NoError(s.T(), err)
+	require.Equal(s.T(), 1, messageCount)
+
+	// The outbox entry the message was relayed from is marked sent
+	require.Eventually(s.T(), func() bool {
+		var sent bool
+		err := s.dbPool.QueryRow(context.Background(), "SELECT sent_at IS NOT NULL FROM outbox WHERE tenant_id = $1 AND message_id = $2", s.tenantID, resp.ID).Scan(&sent)
+		return err == nil && sent
+	}, 5*time.Second, 200*time.Millisecond, "Outbox entry should be marked sent")
+
+	// The stored payload is queryable through the message filters
+	query := url.Values{}
+	query.Set("status", domain.MessageStatusProcessed)
+	query.Set("payload.data", "hello from integration test")
+	query.Set("priority", "5")
+	query.Set("limit", "10")
+	req = httptest.NewRequest(http.MethodGet, "/api/messages?"+query.Encode(), nil)
+	s.authorize(req, s.tenantID, false)
+	rec = httptest.NewRecorder()
+
+	s.echoServer.ServeHTTP(rec, req)
+
+	require.Equal(s.T(), http.StatusOK, rec.Code)
+	var list dto.GetMessagesResponse
+	require.NoError(s.T(), json.Unmarshal(rec.Body.Bytes(), &list))
+	require.Len(s.T(), list.Data, 1)
+	require.Equal(s.T(), resp.ID, list.Data[0].ID.String())
+	require.JSONEq(s.T(), `{"data": "hello from integration test"}`, string(list.Data[0].Payload))
+	require.EqualValues(s.T(), 5, list.Data[0].Priority)
+}
+
+func (s *IntegrationTestSuite) testGetAndDeleteMessage() {
+	require.NotEmpty(s.T(), s.tenantID, "testCreateTenant must run first to get a tenantID")
+
+	req := httptest.NewRequest(http.MethodPost, fmt.Sprintf("/api/messages/%s", s.tenantID), bytes.NewBufferString(`{"data": "to be deleted"}`))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	s.authorize(req, s.tenantID, false)
+	rec := httptest.NewRecorder()
+
+	s.echoServer.ServeHTTP(rec, req)
+
+	require.Equal(s.T(), http.StatusOK, rec.Code)
+	var resp dto.PublishMessageResponse
+	require.NoError(s.T(), json.Unmarshal(rec.Body.Bytes(), &resp))
+	path := fmt.Sprintf("/api/tenants/%s/messages/%s", s.tenantID, resp.ID)
+
+	// send performs a request on the message as callerTenantID
+	send := func(method, path, callerTenantID string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		s.authorize(req, callerTenantID, false)
+		rec := httptest.NewRecorder()
+		s.echoServer.ServeHTTP(rec, req)
+		return rec
+	}
+
+	var msg domain.Message
+	require.Eventually(s.T(), func() bool {
+		rec := send(http.MethodGet, path, s.tenantID)
+		return rec.Code == http.StatusOK && json.Unmarshal(rec.Body.Bytes(), &msg) == nil && msg.Status == domain.MessageStatusProcessed
+	}, 5*time.Second, 200*time.Millisecond, "Message should be fetched once processed")
+	require.Equal(s.T(), resp.ID, msg.ID.String())
+	require.JSONEq(s.T(), `{"data": "to be deleted"}`, string(msg.Payload))
+
+	require.Equal(s.T(), http.StatusForbidden, send(http.MethodGet, path, uuid.NewString()).Code)
+	require.Equal(s.T(), http.StatusForbidden, send(http.MethodDelete, path, uuid.NewString()).Code)
+	require.Equal(s.T(), http.StatusNotFound, send(http.MethodGet, fmt.Sprintf("/api/tenants/%s/messages/%s", s.tenantID, uuid.New()), s.tenantID).Code)
+	require.Equal(s.T(), http.StatusBadRequest, send(http.MethodGet, fmt.Sprintf("/api/tenants/%s/messages/not-a-uuid", s.tenantID), s.tenantID).Code)
+
+	require.Equal(s.T(), http.StatusNoContent, send(http.MethodDelete, path, s.tenantID).Code)
+	require.Equal(s.T(), http.StatusNotFound, send(http.MethodGet, path, s.tenantID).Code)
+	require.Equal(s.T(), http.StatusNotFound, send(http.MethodDelete, path, s.tenantID).Code)
+}
+
+func (s *IntegrationTestSuite) testPublishToForeignTenant() {
+	require.NotEmpty(s.T(), s.tenantID, "testCreateTenant must run first to get a tenantID")
+
+	msgBody := bytes.NewBufferString(`{"data": "not yours"}`)
+	req := httptest.NewRequest(http.MethodPost, fmt.Sprintf("/api/messages/%s", s.tenantID), msgBody)
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	s.authorize(req, uuid.New().String(), false)
+	rec := httptest.NewRecorder()
+
+	s.echoServer.ServeHTTP(rec, req)
+
+	require.Equal(s.T(), http.StatusForbidden, rec.Code)
+
+	// Nothing is stored for a tenant that does not exist
+	msgBody = bytes.NewBufferString(`{"data": "nobody's"}`)
+	req = httptest.NewRequest(http.MethodPost, fmt.Sprintf("/api/messages/%s", uuid.New()), msgBody)
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	s.authorize(req, s.tenantID, true)
+	rec = httptest.NewRecorder()
+
+	s.echoServer.ServeHTTP(rec, req)
+
+	require.Equal(s.T(), http.StatusNotFound, rec.Code)
+}
+
+func (s *IntegrationTestSuite) testPublishBatch() {
+	require.NotEmpty(s.T(), s.tenantID, "testCreateTenant must run first to get a tenantID")
+
+	body := bytes.NewBufferString("{\"n\": 1}\nnot json\n{\"n\": 2}\n")
+	req := httptest.NewRequest(http.MethodPost, fmt.Sprintf("/api/messages/%s/batch", s.tenantID), body)
+	req.Header.Set(echo.HeaderContentType, "application/x-ndjson")
+	s.authorize(req, s.tenantID, false)
+	rec := httptest.NewRecorder()
+
+	s.echoServer.ServeHTTP(rec, req)
+
+	require.Equal(s.T(), http.StatusOK, rec.Code)
+	var resp dto.PublishBatchResponse
+	require.NoError(s.T(), json.Unmarshal(rec.Body.Bytes(), &resp))
+	require.Equal(s.T(), 2, resp.Accepted)
+	require.Equal(s.T(), 1, resp.Rejected)
+	require.Equal(s.T(), dto.BatchItemRejected, resp.Results[1].Status)
+
+	for _, i := range []int{0, 2} {
+		id := resp.Results[i].ID
+		require.Eventually(s.T(), func() bool {
+			var status string
+			err := s.dbPool.QueryRow(context.Background(), "SELECT status FROM messages WHERE tenant_id = $1 AND id = $2", s.tenantID, id).Scan(&status)
+			return err == nil && status == domain.MessageStatusProcessed
+		}, 5*time.Second, 200*time.Millisecond, "Batch message should be consumed and marked processed")
+	}
+
+	// Batches over the limits are refused without publishing any of them
+	tooMany := "[" + strings.Repeat(`{"n": 0},`, 1000) + `{"n": 0}]`
+	tooBig := `[{"data": "` + strings.Repeat("x", 16<<20) + `"}]`
+	for _, body := range []string{tooMany, tooBig} {
+		req = httptest.NewRequest(http.MethodPost, fmt.Sprintf("/api/messages/%s/batch", s.tenantID), strings.NewReader(body))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		s.authorize(req, s.tenantID, false)
+		rec = httptest.NewRecorder()
+
+		s.echoServer.ServeHTTP(rec, req)
+
+		require.Equal(s.T(), http.StatusRequestEntityTooLarge, rec.Code)
+	}
+	var zeros int
+	err := s.dbPool.QueryRow(context.Background(), "SELECT COUNT(*) FROM messages WHERE tenant_id = $1 AND payload->>'n' = '0'", s.tenantID).Scan(&zeros)
+	require.NoError(s.T(), err)
+	require.Zero(s.T(), zeros)
+}
+
+func (s *IntegrationTestSuite) testReconnect() {
+	require.NotEmpty(s.T(), s.tenantID, "testCreateTenant must run first to get a tenantID")
+
+	// The broker comes back without the tenant queue, as a replaced node would
+	ch, err := s.rmqConn.Channel()
+	require.NoError(s.T(), err)
+	_, err = ch.QueueDelete(rabbitmq.TenantQueue(s.tenantID), false, false, false)
+	require.NoError(s.T(), err)
+	require.NoError(s.T(), ch.Close())
+
+	exitCode, err := s.rmqResource.Exec([]string{"rabbitmqctl", "close_all_connections", "integration test"}, dockertest.ExecOptions{})
+	require.NoError(s.T(), err)
+	require.Equal(s.T(), 0, exitCode, "Could not close the RabbitMQ connections")
+
+	// Published while reconnecting, the outbox relay retries until the queue is back
+	req := httptest.NewRequest(http.MethodPost, fmt.Sprintf("/api/messages/%s", s.tenantID), bytes.NewBufferString(`{"data": "after reconnect"}`))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	s.authorize(req, s.tenantID, false)
+	rec := httptest.NewRecorder()
+
+	s.echoServer.ServeHTTP(rec, req)
+
+	require.Equal(s.T(), http.StatusOK, rec.Code)
+	var resp dto.PublishMessageResponse
+	require.NoError(s.T(), json.Unmarshal(rec.Body.Bytes(), &resp))
+
+	require.Eventually(s.T(), func() bool {
+		var status string
+		err := s.dbPool.QueryRow(context.Background(), "SELECT status FROM messages WHERE tenant_id = $1 AND id = $2", s.tenantID, resp.ID).Scan(&status)
+		return err == nil && status == domain.MessageStatusProcessed
+	}, 30*time.Second, 200*time.Millisecond, "Message should be consumed once the consumer recovered")
+
+	req = httptest.NewRequest(http.MethodGet, fmt.Sprintf("/api/tenants/%s", s.tenantID), nil)
+	s.authorize(req, s.tenantID, false)
+	rec = httptest.NewRecorder()
+
+	s.echoServer.ServeHTTP(rec, req)
+
+	require.Equal(s.T(), http.StatusOK, rec.Code)
+	var info dto.TenantResponse
+	require.NoError(s.T(), json.Unmarshal(rec.Body.Bytes(), &info))
+	require.Equal(s.T(), 1, info.QueueConsumers, "Exactly one consumer should be subscribed again")
+}
+
+func (s *IntegrationTestSuite) testScheduleMessage() {
+	require.NotEmpty(s.T(), s.tenantID, "testCreateTenant must run first to get a tenantID")
+
+	msgBody := bytes.NewBufferString(`{"data": "later"}`)
+	req := httptest.NewRequest(http.MethodPost, fmt.Sprintf("/api/messages/%s?delay=2s", s.tenantID), msgBody)
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	s.authorize(req, s.tenantID, false)
+	rec := httptest.NewRecorder()
+
+	s.echoServer.ServeHTTP(rec, req)
+
+	require.Equal(s.T(), http.StatusAccepted, rec.Code)
+	var resp dto.PublishMessageResponse
+	require.NoError(s.T(), json.Unmarshal(rec.Body.Bytes(), &resp))
+	require.NotNil(s.T(), resp.DeliverAt)
+
+	// Pending until due
+	req = httptest.NewRequest(http.MethodGet, fmt.Sprintf("/api/tenants/%s/scheduled-messages", s.tenantID), nil)
+	s.authorize(req, s.tenantID, false)
+	rec = httptest.NewRecorder()
+
+	s.echoServer.ServeHTTP(rec, req)
+
+	require.Equal(s.T(), http.StatusOK, rec.Code)
+	var list dto.ListScheduledMessagesResponse
+	require.NoError(s.T(), json.Unmarshal(rec.Body.Bytes(), &list))
+	require.Equal(s.T(), 1, list.Total)
+	require.Equal(s.T(), resp.ID, list.Data[0].ID.String())
+
+	// Published under the same ID once due
 	require.Eventually(s.T(), func() bool {
-		err := s.dbPool.QueryRow(context.Background(), "SELECT COUNT(*) FROM messages WHERE tenant_id = $1", s.tenantID).Scan(&messageCount)
-		return err == nil && messageCount > 0
-	}, 5*time.Second, 200*time.Millisecond, "Message should be consumed and saved to the database")
+		var status string
+		err := s.dbPool.QueryRow(context.Background(), "SELECT status FROM messages WHERE tenant_id = $1 AND id = $2", s.tenantID, resp.ID).Scan(&status)
+		return err == nil && status == domain.MessageStatusProcessed
+	}, 10*time.Second, 200*time.Millisecond, "Scheduled message should be published and processed when due")
+}
+
+func (s *IntegrationTestSuite) testSchemaValidation() {
+	require.NotEmpty(s.T(), s.tenantID, "testCreateTenant must run first to get a tenantID")
+
+	schema := bytes.NewBufferString(`{"type": "object", "required": ["order_id"], "properties": {"order_id": {"type": "integer"}}}`)
+	req := httptest.NewRequest(http.MethodPost, fmt.Sprintf("/api/tenants/%s/schemas", s.tenantID), schema)
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	s.authorize(req, s.tenantID, false)
+	rec := httptest.NewRecorder()
+
+	s.echoServer.ServeHTTP(rec, req)
+
+	require.Equal(s.T(), http.StatusCreated, rec.Code)
+	var registered domain.MessageSchema
+	require.NoError(s.T(), json.Unmarshal(rec.Body.Bytes(), &registered))
+	require.Equal(s.T(), 1, registered.Version)
+
+	req = httptest.NewRequest(http.MethodPost, fmt.Sprintf("/api/messages/%s", s.tenantID), bytes.NewBufferString(`{"order_id": "abc"}`))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	s.authorize(req, s.tenantID, false)
+	rec = httptest.NewRecorder()
+
+	s.echoServer.ServeHTTP(rec, req)
+
+	require.Equal(s.T(), http.StatusUnprocessableEntity, rec.Code)
+	var invalid dto.SchemaValidationErrorResponse
+	require.NoError(s.T(), json.Unmarshal(rec.Body.Bytes(), &invalid))
+	require.Equal(s.T(), 1, invalid.SchemaVersion)
+	require.NotEmpty(s.T(), invalid.Violations)
+
+	req = httptest.NewRequest(http.MethodPost, fmt.Sprintf("/api/messages/%s", s.tenantID), bytes.NewBufferString(`{"order_id": 42}`))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	s.authorize(req, s.tenantID, false)
+	rec = httptest.NewRecorder()
+
+	s.echoServer.ServeHTTP(rec, req)
+
+	require.Equal(s.T(), http.StatusOK, rec.Code)
+	var resp dto.PublishMessageResponse
+	require.NoError(s.T(), json.Unmarshal(rec.Body.Bytes(), &resp))
+
+	var version *int
+	err := s.dbPool.QueryRow(context.Background(), "SELECT schema_version FROM messages WHERE tenant_id = $1 AND id = $2", s.tenantID, resp.ID).Scan(&version)
+	require.NoError(s.T(), err)
+	require.NotNil(s.T(), version)
+	require.Equal(s.T(), 1, *version)
+}
+
+func (s *IntegrationTestSuite) testProcessorChain() {
+	require.NotEmpty(s.T(), s.tenantID, "testCreateTenant must run first to get a tenantID")
+
+	chain := bytes.NewBufferString(`{"processors": [{"type": "transform", "options": {"remove": ["secret"], "set": {"meta.source": "chain"}}}, {"type": "store"}]}`)
+	req := httptest.NewRequest(http.MethodPut, fmt.Sprintf("/api/tenants/%s/config/processors", s.tenantID), chain)
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	s.authorize(req, s.tenantID, false)
+	rec := httptest.NewRecorder()
+
+	s.echoServer.ServeHTTP(rec, req)
+
+	require.Equal(s.T(), http.StatusForbidden, rec.Code, "Only platform admins may change processor chains")
+
+	req = httptest.NewRequest(http.MethodPut, fmt.Sprintf("/api/tenants/%s/config/processors", s.tenantID), bytes.NewBufferString(chain.String()))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	s.authorize(req, "", true)
+	rec = httptest.NewRecorder()
+
+	s.echoServer.ServeHTTP(rec, req)
+
+	require.Equal(s.T(), http.StatusOK, rec.Code)
+
+	req = httptest.NewRequest(http.MethodPost, fmt.Sprintf("/api/messages/%s", s.tenantID), bytes.NewBufferString(`{"order_id": 7, "secret": "hunter2"}`))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	s.authorize(req, s.tenantID, false)
+	rec = httptest.NewRecorder()
+
+	s.echoServer.ServeHTTP(rec, req)
+
+	require.Equal(s.T(), http.StatusOK, rec.Code)
+	var resp dto.PublishMessageResponse
+	require.NoError(s.T(), json.Unmarshal(rec.Body.Bytes(), &resp))
+
+	require.Eventually(s.T(), func() bool {
+		var (
+			status  string
+			payload map[string]any
+		)
+		err := s.dbPool.QueryRow(context.Background(), "SELECT status, payload FROM messages WHERE tenant_id = $1 AND id = $2", s.tenantID, resp.ID).Scan(&status, &payload)
+		if err != nil || status != domain.MessageStatusProcessed {
+			return false
+		}
+		_, hasSecret := payload["secret"]
+		meta, _ := payload["meta"].(map[string]any)
+		return !hasSecret && meta["source"] == "chain"
+	}, 5*time.Second, 200*time.Millisecond, "Message should be stored as transformed by the chain")
+}
+
+func (s *IntegrationTestSuite) testWebhookDelivery() {
+	require.NotEmpty(s.T(), s.tenantID, "testCreateTenant must run first to get a tenantID")
+
+	const secret = "integration-webhook-secret"
+	received := make(chan *http.Request, 1)
+	var calls atomic.Int32
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The first request fails so the delivery is retried
+		if calls.Add(1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write([]byte(r.Header.Get(processor.HeaderWebhookTimestamp) + "." + string(body)))
+		if r.Header.Get(processor.HeaderWebhookSignature) != "sha256="+hex.EncodeToString(mac.Sum(nil)) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		received <- r
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer hook.Close()
+
+	chain := fmt.Sprintf(`{"processors": [{"type": "store"}, {"type": "webhook", "options": {"url": %q, "secret": %q}}]}`, hook.URL, secret)
+	req := httptest.NewRequest(http.MethodPut, fmt.Sprintf("/api/tenants/%s/config/processors", s.tenantID), bytes.NewBufferString(chain))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	s.authorize(req, "", true)
+	rec := httptest.NewRecorder()
+
+	s.echoServer.ServeHTTP(rec, req)
+
+	require.Equal(s.T(), http.StatusOK, rec.Code)
+	require.NotContains(s.T(), rec.Body.String(), secret)
+	require.Contains(s.T(), rec.Body.String(), `"secret_set":true`)
+
+	// The secret is not given away when the chain or the tenant is read back
+	for _, path := range []string{"/api/tenants/" + s.tenantID + "/config/processors", "/api/tenants/" + s.tenantID, "/api/tenants"} {
+		req = httptest.NewRequest(http.MethodGet, path, nil)
+		s.authorize(req, "", true)
+		rec = httptest.NewRecorder()
+
+		s.echoServer.ServeHTTP(rec, req)
+
+		require.Equal(s.T(), http.StatusOK, rec.Code, path)
+		require.NotContains(s.T(), rec.Body.String(), secret, path)
+		require.Contains(s.T(), rec.Body.String(), `"secret_set":true`, path)
+	}
+
+	req = httptest.NewRequest(http.MethodPost, fmt.Sprintf("/api/messages/%s", s.tenantID), bytes.NewBufferString(`{"order_id": 8}`))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	s.authorize(req, s.tenantID, false)
+	rec = httptest.NewRecorder()
+
+	s.echoServer.ServeHTTP(rec, req)
+
+	require.Equal(s.T(), http.StatusOK, rec.Code)
+	var resp dto.PublishMessageResponse
+	require.NoError(s.T(), json.Unmarshal(rec.Body.Bytes(), &resp))
+
+	select {
+	case r := <-received:
+		require.Equal(s.T(), resp.ID, r.Header.Get(processor.HeaderWebhookID))
+	case <-time.After(10 * time.Second):
+		s.T().Fatal("Webhook should receive the signed message")
+	}
+
+	require.Eventually(s.T(), func() bool {
+		req := httptest.NewRequest(http.MethodGet, fmt.Sprintf("/api/tenants/%s/messages/%s/deliveries", s.tenantID, resp.ID), nil)
+		s.authorize(req, s.tenantID, false)
+		rec := httptest.NewRecorder()
+		s.echoServer.ServeHTTP(rec, req)
+
+		var list dto.ListWebhookDeliveriesResponse
+		if rec.Code != http.StatusOK || json.Unmarshal(rec.Body.Bytes(), &list) != nil || len(list.Data) != 2 {
+			return false
+		}
+		return list.Data[0].StatusCode == http.StatusServiceUnavailable && list.Data[1].StatusCode == http.StatusNoContent
+	}, 5*time.Second, 200*time.Millisecond, "Both delivery attempts should be logged")
+}
+
+func (s *IntegrationTestSuite) testAdminAccess() {
+	for body, status := range map[string]int{
+		`{"user_id": "ops-1", "platform_admin": true}`:                                                  http.StatusForbidden,
+		`{"user_id": "ops-1", "platform_admin": true, "admin_secret": "guessed"}`:                       http.StatusForbidden,
+		`{"user_id": "ops-1", "platform_admin": true, "admin_secret": "integration-test-admin-secret"}`: http.StatusOK,
+		`{"user_id": "user-1", "tenant_id": "` + s.tenantID + `"}`:                                      http.StatusOK,
+	} {
+		req := httptest.NewRequest(http.MethodPost, "/api/login", bytes.NewBufferString(body))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		rec := httptest.NewRecorder()
+
+		s.echoServer.ServeHTTP(rec, req)
+
+		require.Equal(s.T(), status, rec.Code, body)
+	}
+
+	// Metrics are for platform admins only
+	for _, admin := range []bool{false, true} {
+		req := httptest.NewRequest(http.MethodGet, "/debug/vars", nil)
+		s.authorize(req, s.tenantID, admin)
+		rec := httptest.NewRecorder()
+
+		s.echoServer.ServeHTTP(rec, req)
+
+		if admin {
+			require.Equal(s.T(), http.StatusOK, rec.Code)
+		} else {
+			require.Equal(s.T(), http.StatusForbidden, rec.Code)
+		}
+	}
+}
+
+func (s *IntegrationTestSuite) testConcurrencyLimits() {
+	require.NotEmpty(s.T(), s.tenantID, "testCreateTenant must run first to get a tenantID")
+
+	for body, status := range map[string]int{
+		`{"workers": 9}`:                  http.StatusBadRequest,
+		`{"workers": 2, "prefetch": 101}`: http.StatusBadRequest,
+		`{"workers": 8, "prefetch": 100}`: http.StatusOK,
+	} {
+		req := httptest.NewRequest(http.MethodPut, fmt.Sprintf("/api/tenants/%s/config/concurrency", s.tenantID), bytes.NewBufferString(body))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		s.authorize(req, s.tenantID, false)
+		rec := httptest.NewRecorder()
+
+		s.echoServer.ServeHTTP(rec, req)
+
+		require.Equal(s.T(), status, rec.Code, body)
+	}
 }
 
 func (s *IntegrationTestSuite) testDeleteTenant() {
 	require.NotEmpty(s.T(), s.tenantID, "testCreateTenant must run first to get a tenantID")
 
 	req := httptest.NewRequest(http.MethodDelete, fmt.Sprintf("/api/tenants/%s", s.tenantID), nil)
+	s.authorize(req, s.tenantID, false)
 	rec := httptest.NewRecorder()
 
 	s.echoServer.ServeHTTP(rec, req)
@@ -183,6 +765,58 @@ func (s *IntegrationTestSuite) checkPartitionExists(tenantID string) (bool, erro
 	return exists, err
 }
 
+// TestMigrationRegistersTenantsOfOldPartitions checks that partitions created before the
+// tenant registry existed get a tenant row, so their consumers are restored.
+func (s *IntegrationTestSuite) TestMigrationRegistersTenantsOfOldPartitions() {
+	ctx := context.Background()
+	tenantID := uuid.NewString()
+	partition := fmt.Sprintf("messages_tenant_%s", strings.ReplaceAll(tenantID, "-", "_"))
+
+	_, err := s.dbPool.Exec(ctx, fmt.Sprintf("CREATE TABLE %s PARTITION OF messages FOR VALUES IN ('%s')", partition, tenantID))
+	require.NoError(s.T(), err)
+	s.T().Cleanup(func() {
+		_, _ = s.dbPool.Exec(ctx, "DROP TABLE IF EXISTS "+partition)
+		_, _ = s.dbPool.Exec(ctx, "DELETE FROM tenants WHERE id = $1", tenantID)
+	})
+
+	// As on the first startup after the upgrade
+	_, err = s.dbPool.Exec(ctx, "DELETE FROM schema_migrations WHERE version = 'register_partition_tenants'")
+	require.NoError(s.T(), err)
+	require.NoError(s.T(), db.RunMigrations(s.dbPool))
+
+	t, err := message2.NewTenantRepository(s.dbPool).GetTenant(ctx, tenantID)
+	require.NoError(s.T(), err)
+	require.Equal(s.T(), domain.TenantStatusActive, t.Status)
+	require.Zero(s.T(), t.Workers, "The configured default applies")
+}
+
+func (s *IntegrationTestSuite) TestProcessedSequenceIsOnlyDrawnForStoredMessages() {
+	ctx := context.Background()
+	tenants := message2.NewTenantRepository(s.dbPool)
+	messages := message2.NewMessageRepository(s.dbPool)
+
+	tenantID := uuid.New()
+	require.NoError(s.T(), tenants.CreatePartitionForTenant(ctx, tenantID.String()))
+	require.NoError(s.T(), tenants.SaveTenant(ctx, &domain.Tenant{ID: tenantID, Name: "sequence", Status: domain.TenantStatusActive, CreatedAt: time.Now()}))
+	s.T().Cleanup(func() {
+		_ = tenants.DeletePartitionForTenant(ctx, tenantID.String())
+		_ = tenants.DeleteTenant(ctx, tenantID.String())
+	})
+
+	err := messages.UpdateMessageStatus(ctx, tenantID, uuid.New(), domain.MessageStatusProcessed, "")
+	require.ErrorIs(s.T(), err, domain.ErrMessageNotFound)
+
+	id := uuid.New()
+	started, err := messages.BeginProcessing(ctx, &domain.Message{ID: id, TenantID: tenantID, Payload: json.RawMessage(`{}`), CreatedAt: time.Now()})
+	require.NoError(s.T(), err)
+	require.True(s.T(), started)
+	require.NoError(s.T(), messages.UpdateMessageStatus(ctx, tenantID, id, domain.MessageStatusProcessed, ""))
+
+	msg, err := messages.GetMessage(ctx, tenantID, id)
+	require.NoError(s.T(), err)
+	require.EqualValues(s.T(), 1, msg.ProcessedSeq, "The missing message should not have used up a number")
+}
+
 // TestIntegrationTestSuite is the entry point for running the test suite.
 func TestIntegrationTestSuite(t *testing.T) {
 	suite.Run(t, new(IntegrationTestSuite))